@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	kv "github.com/dsapoetra/key-value-go/pkg/store"
+)
+
+// -admin serves the operator's endpoints over HTTP: the profiles of
+// net/http/pprof under /debug/pprof/, for CPU, heap, goroutine and mutex
+// contention profiles of a misbehaving instance, and the store's metrics
+// under /metrics. Profiles expose the process's memory, so the listener
+// only accepts requests bearing the secret of -admin-secret-file as
+// "Authorization: Bearer <secret>", and without one it may only listen on
+// a loopback address. It also changes the node's role, which no client of
+// the server may: POST /promote makes a follower take writes, and POST
+// /raft/add?id=<id>&addr=<addr> and /raft/remove?id=<id> change the members
+// of a Raft group on its leader. With -backup-root it also takes backups of
+// the running store, POST /backup?name=<name>, into that directory alone.
+// -metrics serves /metrics alone, under the same rule, since the metrics
+// describe the store's traffic.
+
+// adminMux returns the handler of the admin listener for store, accepting
+// only requests bearing secret unless it is empty, and taking backups into
+// backupRoot unless it is empty
+func adminMux(store *kv.Store, secret, backupRoot string) http.Handler {
+	mux := http.NewServeMux()
+	if backupRoot != "" {
+		mux.Handle("POST /backup", backupHandler(store, backupRoot))
+	}
+	mux.HandleFunc("POST /promote", func(w http.ResponseWriter, r *http.Request) {
+		if err := store.Promote(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		fmt.Fprintln(w, "OK")
+	})
+	mux.Handle("POST /raft/{op}", raftHandler(store))
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/metrics", store.MetricsHandler())
+	return requireSecret(store, secret, mux)
+}
+
+// requireSecret returns h accepting only requests bearing secret, or h
+// itself if secret is empty
+func requireSecret(store *kv.Store, secret string, h http.Handler) http.Handler {
+	if secret == "" {
+		return h
+	}
+	want := []byte("Bearer " + secret)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			store.Logger().Warn("rejected an admin request without the secret", "remote", r.RemoteAddr, "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="key-value-go admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// backupHandler writes a backup of store to the directory of the name the
+// request gives in root, replying with the sequence number it restores to
+func backupHandler(store *kv.Store, root string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.FormValue("name")
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			http.Error(w, "name must be a directory name within the backup root", http.StatusBadRequest)
+			return
+		}
+		m, err := store.BackupDir(filepath.Join(root, name))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(w, m.Seq)
+	})
+}
+
+// raftHandler adds a voter to or removes a server from the Raft group of
+// store, as the request's op, add or remove, says
+func raftHandler(store *kv.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		node := store.RaftNode()
+		if node == nil {
+			http.Error(w, "store is not running in raft mode", http.StatusConflict)
+			return
+		}
+		id, addr := r.FormValue("id"), r.FormValue("addr")
+		var err error
+		switch {
+		case r.PathValue("op") == "add" && id != "" && addr != "":
+			err = node.AddVoter(id, addr)
+		case r.PathValue("op") == "remove" && id != "":
+			err = node.RemoveServer(id)
+		default:
+			http.Error(w, "usage: POST /raft/add?id=<id>&addr=<addr> or /raft/remove?id=<id>", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		fmt.Fprintln(w, "OK")
+	})
+}
+
+// listenAdmin starts the admin listener on addr, sampling one in
+// mutexFraction mutex contention events for the mutex profile and taking
+// backups into backupRoot, and returns it to be closed on exit
+func listenAdmin(store *kv.Store, addr, secret, backupRoot string, mutexFraction int) (net.Listener, error) {
+	if secret == "" && !isLoopback(addr) {
+		return nil, fmt.Errorf("-admin on %s, which isn't a loopback address, needs -admin-secret-file", addr)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	runtime.SetMutexProfileFraction(mutexFraction)
+	go http.Serve(ln, adminMux(store, secret, backupRoot))
+	store.Logger().Info("serving the admin endpoints", "addr", ln.Addr().String(), "secret", secret != "")
+	return ln, nil
+}
+
+// listenMetrics starts the metrics listener on addr, accepting only
+// requests bearing secret unless it is empty, and returns it to be closed
+// on exit
+func listenMetrics(store *kv.Store, addr, secret string) (net.Listener, error) {
+	if secret == "" && !isLoopback(addr) {
+		return nil, fmt.Errorf("-metrics on %s, which isn't a loopback address, needs -admin-secret-file", addr)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", store.MetricsHandler())
+	go http.Serve(ln, requireSecret(store, secret, mux))
+	return ln, nil
+}
+
+// isLoopback reports whether addr, a host:port, names a loopback host
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	kv "github.com/dsapoetra/key-value-go/pkg/store"
+)
+
+// TestAdminBackup takes a backup through the admin listener, which needs
+// the secret and keeps it within the backup root
+func TestAdminBackup(t *testing.T) {
+	store := kv.NewStore()
+	if err := store.Put("user1", [][]string{{"name", "ann"}}); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	srv := httptest.NewServer(adminMux(store, "s3cret", root))
+	defer srv.Close()
+
+	post := func(name, auth string) int {
+		t.Helper()
+		req, err := http.NewRequest("POST", srv.URL+"/backup?name="+name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := post("monday", ""); code != http.StatusUnauthorized {
+		t.Errorf("without the secret: %d", code)
+	}
+	for _, name := range []string{"", "..", "..%2Fescaped", "a%2Fb"} {
+		if code := post(name, "s3cret"); code != http.StatusBadRequest {
+			t.Errorf("name %q: %d", name, code)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(root), "escaped")); !os.IsNotExist(err) {
+		t.Error("a backup landed outside the root")
+	}
+	if code := post("monday", "s3cret"); code != http.StatusOK {
+		t.Fatalf("backup: %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(root, "monday", "manifest.json")); err != nil {
+		t.Error(err)
+	}
+
+	// Without a root, there are no backups to take
+	srv2 := httptest.NewServer(adminMux(store, "", ""))
+	defer srv2.Close()
+	resp, err := http.Post(srv2.URL+"/backup?name=tuesday", "", strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("without -backup-root: %d", resp.StatusCode)
+	}
+}
+
+// TestAdminPromote promotes a follower through the admin listener, and
+// refuses Raft membership changes to a store not running Raft
+func TestAdminPromote(t *testing.T) {
+	store := kv.NewStore()
+	if _, err := store.Follow("127.0.0.1:1"); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	srv := httptest.NewServer(adminMux(store, "", ""))
+	defer srv.Close()
+
+	post := func(path string) int {
+		t.Helper()
+		resp, err := http.Post(srv.URL+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if err := store.Put("user1", [][]string{{"name", "ann"}}); err == nil {
+		t.Fatal("a follower took a write")
+	}
+	if code := post("/promote"); code != http.StatusOK {
+		t.Fatalf("promote: %d", code)
+	}
+	if err := store.Put("user1", [][]string{{"name", "ann"}}); err != nil {
+		t.Errorf("put after promote: %v", err)
+	}
+	if code := post("/promote"); code != http.StatusConflict {
+		t.Errorf("promote of a leader: %d", code)
+	}
+	if code := post("/raft/add?id=n2&addr=127.0.0.1:7101"); code != http.StatusConflict {
+		t.Errorf("raft add without raft: %d", code)
+	}
+}
+
+// TestListenMetrics refuses to serve the metrics off a loopback address
+// without a secret, and checks the secret is required when given
+func TestListenMetrics(t *testing.T) {
+	store := kv.NewStore()
+	if ln, err := listenMetrics(store, "0.0.0.0:0", ""); err == nil {
+		ln.Close()
+		t.Fatal("listened on 0.0.0.0 without a secret")
+	}
+
+	ln, err := listenMetrics(store, "127.0.0.1:0", "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	for auth, want := range map[string]int{"": http.StatusUnauthorized, "guess": http.StatusUnauthorized, "s3cret": http.StatusOK} {
+		req, err := http.NewRequest("GET", "http://"+ln.Addr().String()+"/metrics", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("with secret %q: %d, want %d", auth, resp.StatusCode, want)
+		}
+	}
+}
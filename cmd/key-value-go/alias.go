@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// An alias names the first words of a command: after alias su=search user,
+// su alice is search user alice. A command's first word is expanded once,
+// before it runs, so an alias can take the name of the command it expands
+// to, as in alias keys=keys --all. Aliases are saved in the config file,
+// under aliases, and work in every mode the CLI runs in.
+
+// aliases maps alias names to the command words they stand for, as a CLI
+// line. The CLI runs one command at a time, so it needs no lock.
+var aliases = map[string]string{}
+
+// aliasPath is the config file aliases are saved to
+var aliasPath string
+
+// readAliases reads the aliases of the config file at path, if any
+func readAliases(path string) (map[string]string, error) {
+	doc := struct {
+		Aliases map[string]string `yaml:"aliases"`
+	}{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if doc.Aliases == nil {
+		doc.Aliases = map[string]string{}
+	}
+	for name, expansion := range doc.Aliases {
+		if err := checkAlias(name, expansion); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return doc.Aliases, nil
+}
+
+// checkAlias checks that name can be an alias for expansion
+func checkAlias(name, expansion string) error {
+	if name == "" || strings.ContainsFunc(name, func(r rune) bool { return r == '"' || r == '=' || r == '#' || r <= ' ' }) {
+		return fmt.Errorf("bad alias name %q", name)
+	}
+	if name == "alias" || name == "unalias" {
+		return fmt.Errorf("%s can't be an alias", name)
+	}
+	words, _, err := splitCommandLine(expansion)
+	if err != nil {
+		return fmt.Errorf("alias %s: %w", name, err)
+	}
+	if len(words) == 0 {
+		return fmt.Errorf("alias %s: needs a command", name)
+	}
+	return nil
+}
+
+// expandAlias replaces the first word of parts by the words of its alias
+func expandAlias(parts []string, quoted []bool) ([]string, []bool) {
+	expansion, ok := aliases[parts[0]]
+	if !ok || quoted[0] {
+		return parts, quoted
+	}
+	words, wordsQuoted, _ := splitCommandLine(expansion) // checked by checkAlias
+	return append(words, parts[1:]...), append(wordsQuoted, quoted[1:]...)
+}
+
+// aliasCommand runs alias and unalias: alias lists the aliases, alias
+// name=command words defines one and unalias name removes it, saving the
+// aliases to the config file
+func aliasCommand(out io.Writer, parts []string, quoted []bool) int {
+	if parts[0] == "unalias" {
+		if len(parts) != 2 {
+			fmt.Fprintln(out, "Error: Incorrect number of parameters")
+			fmt.Fprintln(out, "Usage: unalias <name>")
+			return exitUsage
+		}
+		if _, ok := aliases[parts[1]]; !ok {
+			fmt.Fprintf(out, "Error: no alias %s\n", parts[1])
+			return exitFailed
+		}
+		delete(aliases, parts[1])
+		if err := saveAliases(aliasPath); err != nil {
+			return commandError(out, err)
+		}
+		fmt.Fprintf(out, "Success: Removed alias %s\n", parts[1])
+		return exitOK
+	}
+
+	if len(parts) == 1 {
+		names := make([]string, 0, len(aliases))
+		for name := range aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			fmt.Fprintln(out, "No aliases defined")
+		}
+		for _, name := range names {
+			fmt.Fprintf(out, "%s=%s\n", name, aliases[name])
+		}
+		return exitOK
+	}
+
+	// The words after alias are the line name=command words, quotes kept
+	words := make([]string, len(parts)-1)
+	for i, part := range parts[1:] {
+		if quoted[i+1] {
+			part = strconv.Quote(part)
+		}
+		words[i] = part
+	}
+	name, expansion, ok := strings.Cut(strings.Join(words, " "), "=")
+	if !ok || quoted[1] {
+		fmt.Fprintln(out, "Error: Incorrect parameters")
+		fmt.Fprintln(out, "Usage: alias [<name>=<command words>]")
+		return exitUsage
+	}
+	// As in a shell, the command can be quoted as one word
+	expansion = strings.TrimSpace(expansion)
+	if words, wordsQuoted, err := splitCommandLine(expansion); err == nil && len(words) == 1 && wordsQuoted[0] {
+		expansion = words[0]
+	}
+	if err := checkAlias(name, expansion); err != nil {
+		fmt.Fprintln(out, "Error:", err)
+		return exitUsage
+	}
+	aliases[name] = expansion
+	if err := saveAliases(aliasPath); err != nil {
+		return commandError(out, err)
+	}
+	fmt.Fprintf(out, "Success: Alias %s saved to %s\n", name, aliasPath)
+	return exitOK
+}
+
+// saveAliases writes the aliases to the config file at path, keeping its
+// other settings and comments
+func saveAliases(path string) error {
+	if path == "" {
+		return errors.New("no config file to save aliases to; give one with -config")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	var doc yaml.Node
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: not a mapping of settings", path)
+	}
+
+	var value yaml.Node
+	if err := value.Encode(aliases); err != nil {
+		return err
+	}
+	found := false
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "aliases" {
+			continue
+		}
+		found = true
+		if len(aliases) == 0 {
+			root.Content = append(root.Content[:i], root.Content[i+2:]...)
+		} else {
+			root.Content[i+1] = &value
+		}
+		break
+	}
+	if !found && len(aliases) > 0 {
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "aliases"}, &value)
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	enc.Close()
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	kv "github.com/dsapoetra/key-value-go/pkg/store"
+)
+
+// Batch mode runs the CLI without a terminal, as in cat ops.txt | kv: there
+// is no menu and no prompt, and each input line gets exactly one line of
+// output, a JSON object, so another program can read the results back:
+//
+//	{"line":1,"status":"ok","output":"Success: Put operation completed"}
+//	{"line":2,"status":"ok","output":"name: John","result":{"name":"John"}}
+//	{"line":3,"status":"not_found","output":"No entry found for key: nobody"}
+
+// batchStatuses names the CLI exit statuses in batch results
+var batchStatuses = map[int]string{
+	exitOK:       "ok",
+	exitFailed:   "failed",
+	exitUsage:    "usage",
+	exitNotFound: "not_found",
+	exitType:     "type_error",
+	exitIO:       "io_error",
+}
+
+// batchResult is the outcome of an input line in batch mode
+type batchResult struct {
+	Line   int         `json:"line"`
+	Status string      `json:"status"`           // a name from batchStatuses, or skipped for a blank line, a comment or a line of a putjson entry
+	Output string      `json:"output,omitempty"` // what the command printed, as the CLI prints it
+	Result interface{} `json:"result,omitempty"` // the entry read by get, or the keys listed by keys or search
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// runBatch runs the CLI commands read from r in batch mode, writing a
+// result per line to w, until the end of r or an exit command. Failing
+// commands don't stop it; its status is that of the first that fails.
+func runBatch(store *kv.Store, r io.Reader, w io.Writer) int {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	status := exitOK
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxCommandLine)
+	for n := 1; scanner.Scan(); n++ {
+		result := batchResult{Line: n}
+		var buf bytes.Buffer
+		continued := 0 // the lines that continue the command's
+		line, err := joinContinuedLines(scanner.Text(), func() (string, bool) {
+			if !scanner.Scan() {
+				return "", false
+			}
+			continued++
+			return scanner.Text(), true
+		})
+		var parts []string
+		var quoted []bool
+		if err == nil {
+			parts, quoted, err = splitCommandLine(line)
+		}
+		lineStatus := exitOK
+		if err == nil && len(parts) > 0 {
+			parts, quoted = continueCommand(parts, quoted, func() (string, bool) {
+				if !scanner.Scan() {
+					return "", false
+				}
+				continued++
+				return scanner.Text(), true
+			})
+		}
+		switch {
+		case err != nil:
+			fmt.Fprintln(&buf, "Error:", err)
+			lineStatus = exitUsage
+		case len(parts) == 0 || strings.HasPrefix(parts[0], "#") && !quoted[0]:
+			result.Status = "skipped"
+		case parts[0] == "exit":
+		default:
+			out := &cliOutput{Writer: &buf, batch: true}
+			lineStatus = runCommand(out, store, parts, quoted)
+			result.Result = out.result
+		}
+		if result.Status == "" {
+			result.Status = batchStatuses[lineStatus]
+		}
+		result.Output = strings.TrimSuffix(buf.String(), "\n")
+		if err := enc.Encode(result); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return exitIO
+		}
+		for ; continued > 0; continued-- {
+			n++
+			enc.Encode(batchResult{Line: n, Status: "skipped"})
+		}
+		if status == exitOK {
+			status = lineStatus
+		}
+		if err == nil && len(parts) > 0 && parts[0] == "exit" {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: reading input:", err)
+		return exitIO
+	}
+	return status
+}
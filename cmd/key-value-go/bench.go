@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kv "github.com/dsapoetra/key-value-go/pkg/store"
+)
+
+// bench runs a synthetic workload against the store, with its durability,
+// log and replication as configured, and reports the throughput and the
+// latency percentiles, so that settings can be compared on the machine
+// they will run on. bench put writes entries under the bench: prefix, with
+// float attributes named bench_attr0, bench_attr1 and so on; bench get and
+// bench search then read them back.
+
+// benchPrefix starts the keys bench put writes
+const benchPrefix = "bench:"
+
+// benchOptions are the options of a bench command
+type benchOptions struct {
+	ops      int // how many operations to run
+	parallel int // how many goroutines run them
+	attrs    int // how many attributes each put writes
+}
+
+// parseBenchArgs parses the words after bench put, get or search: the
+// number of operations, then --parallel and --attrs, as "--flag value" or
+// "--flag=value"
+func parseBenchArgs(args []string) (benchOptions, error) {
+	opts := benchOptions{parallel: 1, attrs: 3}
+	if len(args) == 0 {
+		return opts, errors.New("bench needs a number of operations")
+	}
+	ops, err := strconv.Atoi(args[0])
+	if err != nil || ops <= 0 {
+		return opts, fmt.Errorf("bad number of operations %q", args[0])
+	}
+	opts.ops = ops
+	for i := 1; i < len(args); i++ {
+		name, value, inline := strings.Cut(args[i], "=")
+		if name != "--parallel" && name != "--attrs" {
+			return opts, fmt.Errorf("unknown bench option %s", args[i])
+		}
+		if !inline {
+			if i+1 == len(args) {
+				return opts, fmt.Errorf("%s needs a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return opts, fmt.Errorf("bad %s %q", name, value)
+		}
+		if name == "--parallel" {
+			opts.parallel = n
+		} else {
+			opts.attrs = n
+		}
+	}
+	return opts, nil
+}
+
+// benchResult is what a bench run measured
+type benchResult struct {
+	ops       int
+	elapsed   time.Duration
+	latencies []time.Duration // one per operation, sorted
+}
+
+// percentile returns the latency p percent of the operations took at most
+func (r benchResult) percentile(p float64) time.Duration {
+	i := int(float64(len(r.latencies))*p/100+0.5) - 1
+	return r.latencies[min(max(i, 0), len(r.latencies)-1)]
+}
+
+// runBench runs op for the operations 0 to opts.ops-1 on opts.parallel
+// goroutines, timing each, and stops at the first error
+func runBench(opts benchOptions, op func(i int) error) (benchResult, error) {
+	latencies := make([]time.Duration, opts.ops)
+	var next atomic.Int64
+	var failed atomic.Pointer[error]
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < min(opts.parallel, opts.ops); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for failed.Load() == nil {
+				i := int(next.Add(1) - 1)
+				if i >= opts.ops {
+					return
+				}
+				opStart := time.Now()
+				if err := op(i); err != nil {
+					failed.CompareAndSwap(nil, &err)
+					return
+				}
+				latencies[i] = time.Since(opStart)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := failed.Load(); err != nil {
+		return benchResult{}, *err
+	}
+	slices.Sort(latencies)
+	return benchResult{ops: opts.ops, elapsed: time.Since(start), latencies: latencies}, nil
+}
+
+// benchCommand runs bench put, get or search
+func benchCommand(out *cliOutput, store *kv.Store, parts []string) int {
+	var opts benchOptions
+	err := errors.New("bench needs put, get or search")
+	if len(parts) > 1 && (parts[1] == "put" || parts[1] == "get" || parts[1] == "search") {
+		opts, err = parseBenchArgs(parts[2:])
+	}
+	if err != nil {
+		fmt.Fprintln(out, "Error:", err)
+		fmt.Fprintln(out, "Usage: bench put|get|search <operations> [--parallel <n>] [--attrs <n>]")
+		return exitUsage
+	}
+
+	var op func(i int) error
+	switch parts[1] {
+	case "put":
+		if dryRun != nil {
+			fmt.Fprintln(out, "Error: bench put", errDryRun)
+			return exitFailed
+		}
+		op = func(i int) error {
+			attributes := make([][]string, opts.attrs)
+			for a := range attributes {
+				attributes[a] = []string{"bench_attr" + strconv.Itoa(a), strconv.Itoa((i + a) % 1000)}
+			}
+			return store.Put(benchPrefix+strconv.Itoa(i), attributes)
+		}
+	case "get":
+		keys, _, _ := store.KeysMatchingCount(benchPrefix + "*")
+		if len(keys) == 0 {
+			fmt.Fprintln(out, "Error: no bench entries to get; run bench put first")
+			return exitFailed
+		}
+		op = func(int) error {
+			store.Get(keys[rand.IntN(len(keys))])
+			return nil
+		}
+	case "search":
+		op = func(int) error {
+			store.Search("bench_attr0", strconv.Itoa(rand.IntN(1000)))
+			return nil
+		}
+	}
+
+	result, err := runBench(opts, op)
+	if err != nil {
+		return commandError(out, err)
+	}
+	if parts[1] == "put" {
+		out.affected = result.ops
+	}
+	printBenchResult(out, parts[1], opts, result)
+	return exitOK
+}
+
+// printBenchResult prints the throughput and latencies of a bench run
+func printBenchResult(out io.Writer, name string, opts benchOptions, r benchResult) {
+	workers := "1 worker"
+	if opts.parallel > 1 {
+		workers = fmt.Sprintf("%d workers", opts.parallel)
+	}
+	fmt.Fprintf(out, "bench %s: %d ops in %s on %s, %.0f ops/s\n",
+		name, r.ops, r.elapsed.Round(time.Millisecond), workers, float64(r.ops)/r.elapsed.Seconds())
+	// Three significant digits are plenty for a latency
+	round := func(d time.Duration) time.Duration {
+		step := time.Duration(1)
+		for d >= 1000*step {
+			step *= 10
+		}
+		return d.Round(step)
+	}
+	fmt.Fprintf(out, "latency: p50 %s, p90 %s, p99 %s, p99.9 %s, max %s\n",
+		round(r.percentile(50)), round(r.percentile(90)), round(r.percentile(99)), round(r.percentile(99.9)), round(r.latencies[len(r.latencies)-1]))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	kv "github.com/dsapoetra/key-value-go/pkg/store"
+)
+
+// The CLI colors its output when writing to a terminal: keys in cyan,
+// attribute names in blue, values by type, Error lines in red and Success
+// lines in green. -no-color, the NO_COLOR environment variable or a dumb
+// terminal turn it off, and pipes, files and batch mode never get color.
+
+// ANSI color codes. Each is the same length, so tabwriter, which counts
+// them as text, still aligns columns colored alike.
+const (
+	colorReset   = "\x1b[0m"
+	colorBold    = "\x1b[01m"
+	colorRed     = "\x1b[31m"
+	colorGreen   = "\x1b[32m"
+	colorYellow  = "\x1b[33m"
+	colorBlue    = "\x1b[34m"
+	colorMagenta = "\x1b[35m"
+	colorCyan    = "\x1b[36m"
+	colorGray    = "\x1b[90m"
+)
+
+// colorOutput enables colors. It is set at startup, before any command runs.
+var colorOutput bool
+
+// useColor reports whether output to f should be colored
+func useColor(f *os.File, noColor bool) bool {
+	return !noColor && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" && isTerminal(f)
+}
+
+// paint returns s in color, if colors are enabled
+func paint(color, s string) string {
+	if !colorOutput {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// paintValue returns value as kv.FormatValue formats it, colored by its type
+func paintValue(value interface{}) string {
+	color := colorGreen
+	switch value.(type) {
+	case float64:
+		color = colorYellow
+	case bool:
+		color = colorMagenta
+	}
+	return paint(color, kv.FormatValue(value))
+}
+
+// colorWriter colors the lines written to w that start with Error: red and
+// those that start with Success: green. Commands print each line with one
+// call, so a line is never split between writes.
+type colorWriter struct {
+	w io.Writer
+}
+
+var (
+	errorPrefix   = []byte("Error:")
+	successPrefix = []byte("Success:")
+)
+
+func (c colorWriter) Write(p []byte) (int, error) {
+	var out []byte
+	for rest := p; len(rest) > 0; {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+		}
+		rest = rest[len(line):]
+		text := bytes.TrimSuffix(line, []byte("\n"))
+		switch {
+		case bytes.HasPrefix(text, errorPrefix):
+			out = append(append(append(out, colorRed...), text...), colorReset...)
+		case bytes.HasPrefix(text, successPrefix):
+			out = append(append(append(out, colorGreen...), text...), colorReset...)
+		default:
+			out = append(out, text...)
+		}
+		out = append(out, line[len(text):]...)
+	}
+	if _, err := c.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	kv "github.com/dsapoetra/key-value-go/pkg/store"
+)
+
+// cliCommands lists the CLI's commands, for completion
+var cliCommands = []string{
+	"backup", "bench", "delete", "diff", "dryrun", "dump", "exit", "export", "flush", "format", "get", "help", "import",
+	"info", "keys", "latency", "mget", "monitor", "promote", "put", "putjson", "quota", "raft", "replication", "restore", "role", "search", "set", "show", "source", "stats", "time", "unset", "use", "watch",
+}
+
+// cliCompleter returns the completer of the interactive CLI, which
+// completes command names, then the keys, attribute names and keywords
+// each command takes. Keys and attribute names are read from store as Tab
+// is pressed, so they are always current.
+func cliCompleter(store *kv.Store) func(line string) (int, []string) {
+	return func(line string) (int, []string) {
+		// The word being completed runs from the last space to the cursor
+		start := strings.LastIndexFunc(line, unicode.IsSpace) + 1
+		word := line[start:]
+		if strings.HasPrefix(word, `"`) {
+			return 0, nil
+		}
+		parts, _, err := splitCommandLine(line[:start])
+		if err != nil {
+			return 0, nil // the cursor is inside a quoted string
+		}
+		if len(parts) > 0 && parts[0] == "time" {
+			parts = parts[1:] // time runs the command after it
+		}
+
+		var choices []string
+		arg := len(parts) // the index of the word among the command's words
+		switch {
+		case arg == 0:
+			choices = append([]string{"alias", "unalias"}, cliCommands...)
+			for name := range aliases {
+				choices = append(choices, name)
+			}
+		case strings.HasPrefix(word, "$"):
+			choices = variableNames()
+		case parts[len(parts)-1] == "where" && (parts[0] == "export" || parts[0] == "dump"):
+			choices = store.AttributeNames()
+		default:
+			choices = commandArguments(store, parts)
+		}
+
+		var candidates []string
+		for _, choice := range choices {
+			if strings.HasPrefix(choice, word) {
+				candidates = append(candidates, choice)
+			}
+		}
+		return utf8.RuneCountInString(line[:start]), candidates
+	}
+}
+
+// commandArguments returns the choices for the argument following parts
+func commandArguments(store *kv.Store, parts []string) []string {
+	arg, previous := len(parts), parts[len(parts)-1]
+	switch parts[0] {
+	case "help":
+		if arg == 1 {
+			return helpCommands()
+		}
+	case "get", "delete", "putjson":
+		if arg == 1 {
+			return keyspace(store).Keys()
+		}
+	case "watch":
+		if arg == 1 {
+			return store.Keys()
+		}
+	case "mget":
+		return keyspace(store).Keys()
+	case "put":
+		switch {
+		case arg == 1:
+			return keyspace(store).Keys()
+		case arg%2 == 0:
+			return keyspace(store).AttributeNames()
+		}
+	case "search":
+		if arg == 1 {
+			return keyspace(store).AttributeNames()
+		}
+	case "use":
+		if arg == 1 {
+			return append([]string{defaultNamespace}, store.Namespaces()...)
+		}
+	case "export":
+		switch arg {
+		case 1:
+			return kv.ExportFormats
+		case 3:
+			return store.Keys()
+		}
+	case "dump":
+		switch arg {
+		case 1:
+			return []string{"script"}
+		case 3:
+			return store.Keys()
+		}
+	case "import":
+		switch {
+		case arg == 1:
+			return append(kv.ImportFormats[:len(kv.ImportFormats):len(kv.ImportFormats)], "redis", "etcd", "consul")
+		case previous == "--on-conflict":
+			return kv.ConflictStrategies
+		case arg >= 3:
+			return []string{"--bulk", "--on-conflict", "--key-column", "--db", "--trim-prefix"}
+		}
+	case "format":
+		if arg == 1 {
+			return outputFormats
+		}
+	case "dryrun":
+		if arg == 1 {
+			return []string{"on", "off"}
+		}
+	case "bench":
+		switch {
+		case arg == 1:
+			return []string{"put", "get", "search"}
+		case arg >= 3:
+			return []string{"--parallel", "--attrs"}
+		}
+	case "raft":
+		if arg == 1 {
+			return []string{"add", "remove"}
+		}
+	case "info":
+		if arg == 1 {
+			return kv.InfoSections
+		}
+	case "quota":
+		switch {
+		case arg == 1:
+			return []string{"set"}
+		case arg == 2:
+			return store.Namespaces()
+		case arg%2 == 1:
+			return []string{"keys", "memory"}
+		}
+	case "stats":
+		if arg == 1 {
+			return []string{"reset", "attributes"}
+		}
+	case "latency":
+		if arg == 1 {
+			return []string{"reset"}
+		}
+	case "source":
+		switch {
+		case arg == 2:
+			return []string{"--on-error"}
+		case arg == 3 && previous == "--on-error":
+			return []string{"stop", "continue"}
+		}
+	}
+	return nil
+}
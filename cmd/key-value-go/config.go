@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Every flag can also be given as an environment variable, KV_ and its name
+// in capitals with underscores for dashes (KV_LOG, KV_REPL_CERT), or as a
+// setting of the same name in a YAML config file named by -config or
+// KV_CONFIG, or ~/.key-value-go.yaml if it exists. A flag on the command
+// line wins over the environment, which wins over the file, which wins over
+// the default.
+
+// defaultConfigPath returns the config file in the user's home directory,
+// read if it exists when no other is named, or "" if there is no home
+func defaultConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".key-value-go.yaml")
+}
+
+// perRunFlags are the flags that say what a single run does, which neither
+// the environment nor the config file set
+var perRunFlags = map[string]bool{"c": true, "file": true, "config": true}
+
+// settingEnv returns the environment variable that sets the flag name
+func settingEnv(name string) string {
+	return "KV_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// noteSettingEnvs adds each flag's environment variable to its usage, so
+// -help lists them
+func noteSettingEnvs(fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if !perRunFlags[f.Name] {
+			f.Usage += " (" + settingEnv(f.Name) + ")"
+		}
+	})
+}
+
+// loadSettings sets the flags of fs not given on the command line from the
+// environment, then from the config file at path, if any. fs must have
+// been parsed.
+func loadSettings(fs *flag.FlagSet, path string) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	file, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	for name := range file {
+		if name == "aliases" {
+			continue // read by readAliases
+		}
+		if f := fs.Lookup(name); f == nil || perRunFlags[name] {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+	}
+
+	var failed error
+	fs.VisitAll(func(f *flag.Flag) {
+		if failed != nil || given[f.Name] || perRunFlags[f.Name] {
+			return
+		}
+		if value := os.Getenv(settingEnv(f.Name)); value != "" {
+			if err := fs.Set(f.Name, value); err != nil {
+				failed = fmt.Errorf("%s: %w", settingEnv(f.Name), err)
+			}
+			return
+		}
+		if value, ok := file[f.Name]; ok {
+			if err := fs.Set(f.Name, value); err != nil {
+				failed = fmt.Errorf("%s: %s: %w", path, f.Name, err)
+			}
+		}
+	})
+	return failed
+}
+
+// readConfigFile reads the settings of the YAML config file at path, by
+// flag name, as the strings the flags parse. A list is joined with commas,
+// as flags such as -cluster-nodes take it. Names can use underscores for
+// dashes.
+func readConfigFile(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	names := make([]string, 0, len(doc))
+	for name := range doc {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	settings := make(map[string]string, len(doc))
+	for _, name := range names {
+		var value string
+		switch v := doc[name].(type) {
+		case nil:
+			continue
+		case map[string]interface{}:
+			if name == "aliases" {
+				continue
+			}
+			return nil, fmt.Errorf("%s: setting %q must be a value or a list", path, name)
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			value = strings.Join(items, ",")
+		default:
+			value = fmt.Sprint(v)
+		}
+		settings[strings.ReplaceAll(name, "_", "-")] = value
+	}
+	return settings, nil
+}
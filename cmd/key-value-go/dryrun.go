@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+
+	kv "github.com/dsapoetra/key-value-go/pkg/store"
+)
+
+// In a dry run, the CLI's commands that write to the store check their
+// writes, attribute types included, and print what would change instead of
+// making the change. The run remembers the puts and deletes it skipped, so
+// a script that puts age 30 and then age thirty fails on its second line
+// as it would for real. Imports and restores are checked against the store
+// as it is. -dry-run starts the CLI in a dry run and dryrun on|off toggles
+// it.
+
+// dryRunState holds the writes a dry run skipped
+type dryRunState struct {
+	types   map[string]kv.AttributeMetadata   // the attribute types the skipped puts would register
+	entries map[string]map[string]interface{} // the entries the skipped writes would leave, nil for a deleted key
+}
+
+// dryRun is the CLI's dry run, or nil outside one. The CLI runs one command
+// at a time, so it needs no lock.
+var dryRun *dryRunState
+
+// newDryRun starts a dry run with no skipped writes
+func newDryRun() *dryRunState {
+	return &dryRunState{types: make(map[string]kv.AttributeMetadata), entries: make(map[string]map[string]interface{})}
+}
+
+// keys returns the keys the skipped writes would leave in the store
+func (d *dryRunState) keys(store *kv.Store) []string {
+	var keys []string
+	for _, key := range store.Keys() {
+		if attrs, ok := d.entries[key]; !ok || attrs != nil {
+			keys = append(keys, key)
+		}
+	}
+	for key, attrs := range d.entries {
+		if attrs != nil && store.Get(key) == nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// entry returns the entry at key as the skipped writes would leave it
+func (d *dryRunState) entry(store *kv.Store, key string) map[string]interface{} {
+	if attrs, ok := d.entries[key]; ok {
+		return attrs
+	}
+	return store.Get(key)
+}
+
+// put checks the put of attrs at key, printing how it would change the
+// entry
+func (d *dryRunState) put(out io.Writer, store *kv.Store, key string, attrs map[string]interface{}) int {
+	if err := store.Writable(); err != nil {
+		return commandError(out, err)
+	}
+	pending := make(map[string]kv.AttributeMetadata, len(d.types))
+	for attrKey, metadata := range d.types {
+		pending[attrKey] = metadata
+	}
+	if err := store.CheckValues(key, attrs, pending); err != nil {
+		return commandError(out, err)
+	}
+
+	old := d.entry(store, key)
+	d.types, d.entries[key] = pending, attrs
+	switch deltas := kv.DiffAttributes(old, attrs); {
+	case old == nil:
+		pairs := make([]string, 0, len(attrs))
+		for _, attrKey := range slices.Sorted(maps.Keys(attrs)) {
+			pairs = append(pairs, attrKey+": "+kv.FormatValue(attrs[attrKey]))
+		}
+		fmt.Fprintf(out, "Dry run: would add %s with %s\n", key, strings.Join(pairs, ", "))
+	case len(deltas) == 0:
+		fmt.Fprintf(out, "Dry run: would leave %s unchanged\n", key)
+	default:
+		changes := make([]string, len(deltas))
+		for i, delta := range deltas {
+			changes[i] = formatDelta(delta)
+		}
+		fmt.Fprintf(out, "Dry run: would modify %s, %s\n", key, strings.Join(changes, ", "))
+	}
+	return exitOK
+}
+
+// delete checks the delete of key, printing whether it would remove an
+// entry
+func (d *dryRunState) delete(out io.Writer, store *kv.Store, key string) int {
+	if err := store.Writable(); err != nil {
+		return commandError(out, err)
+	}
+	if d.entry(store, key) == nil {
+		fmt.Fprintf(out, "Dry run: would leave %s absent\n", key)
+		return exitOK
+	}
+	d.entries[key] = nil
+	fmt.Fprintf(out, "Dry run: would delete %s\n", key)
+	return exitOK
+}
+
+// deleteKeys checks the delete of keys, as flush and delete --pattern
+// make, printing how many entries it would remove
+func (d *dryRunState) deleteKeys(out io.Writer, store *kv.Store, keys []string) int {
+	if err := store.Writable(); err != nil {
+		return commandError(out, err)
+	}
+	n := 0
+	for _, key := range keys {
+		if d.entry(store, key) != nil {
+			d.entries[key] = nil
+			n++
+		}
+	}
+	fmt.Fprintf(out, "Dry run: would delete %s\n", counted(n, "entry", "entries"))
+	return exitOK
+}
+
+// restore checks the restore of the backup at path, printing how it would
+// change the store
+func (d *dryRunState) restore(out io.Writer, store *kv.Store, path string) int {
+	if err := store.Writable(); err != nil {
+		return commandError(out, err)
+	}
+	diff, err := store.RestoreDiff(path)
+	if err != nil {
+		return commandError(out, err)
+	}
+	if diff.Empty() {
+		fmt.Fprintf(out, "Dry run: restoring %s would change nothing\n", path)
+		return exitOK
+	}
+	fmt.Fprintf(out, "Dry run: restoring %s would add %d, remove %d and modify %s, and change %s\n",
+		path, len(diff.Added), len(diff.Removed), counted(len(diff.Modified), "key", "keys"), counted(len(diff.Types), "attribute type", "attribute types"))
+	printDiffLines(out, diff)
+	return exitOK
+}
+
+// dryRunCommand runs dryrun, showing whether the CLI is in a dry run or
+// starting or ending one
+func dryRunCommand(out io.Writer, parts []string) int {
+	switch {
+	case len(parts) == 1:
+	case len(parts) == 2 && parts[1] == "on":
+		if dryRun == nil {
+			dryRun = newDryRun()
+		}
+	case len(parts) == 2 && parts[1] == "off":
+		if dryRun != nil && len(dryRun.entries) > 0 {
+			fmt.Fprintf(out, "Discarded the dry run's writes to %s\n", counted(len(dryRun.entries), "key", "keys"))
+		}
+		dryRun = nil
+	default:
+		fmt.Fprintln(out, "Error: Incorrect parameters")
+		fmt.Fprintln(out, "Usage: dryrun [on|off]")
+		return exitUsage
+	}
+	if dryRun != nil {
+		fmt.Fprintln(out, "Dry run: on, writes are checked but not made")
+	} else {
+		fmt.Fprintln(out, "Dry run: off")
+	}
+	return exitOK
+}
+
+// errDryRun is returned for commands a dry run can't check
+var errDryRun = errors.New("can't run in a dry run; turn it off with dryrun off")
+
+// counted returns n with the noun it counts, one for 1 and many otherwise
+func counted(n int, one, many string) string {
+	if n == 1 {
+		return "1 " + one
+	}
+	return fmt.Sprintf("%d %s", n, many)
+}
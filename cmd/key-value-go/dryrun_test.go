@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	kv "github.com/dsapoetra/key-value-go/pkg/store"
+)
+
+// TestDryRunDeleteKeys checks a dry run's deletes count the entries they
+// would remove, in the singular for one, remember the ones already
+// removed and leave the store as it is
+func TestDryRunDeleteKeys(t *testing.T) {
+	store := kv.NewStore()
+	for _, key := range []string{"a", "b", "c"} {
+		if err := store.Put(key, [][]string{{"n", "1"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := newDryRun()
+	for _, tc := range []struct {
+		keys []string
+		want string
+	}{
+		{[]string{"a", "missing"}, "Dry run: would delete 1 entry\n"},
+		{[]string{"a", "b", "c"}, "Dry run: would delete 2 entries\n"},
+		{[]string{"a", "b"}, "Dry run: would delete 0 entries\n"},
+	} {
+		var out bytes.Buffer
+		if status := d.deleteKeys(&out, store, tc.keys); status != exitOK {
+			t.Fatalf("deleteKeys(%v) exited %d", tc.keys, status)
+		}
+		if out.String() != tc.want {
+			t.Errorf("deleteKeys(%v) printed %q, want %q", tc.keys, out.String(), tc.want)
+		}
+	}
+	if n := len(store.Keys()); n != 3 {
+		t.Errorf("the store holds %d keys after the dry run, want 3", n)
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// help alone prints the menu of every command; help <command> prints the
+// topic of one: its syntax, what it does, examples and the errors it can
+// print, with what each means.
+
+// helpTopic is what help <command> prints about a command
+type helpTopic struct {
+	usage    []string    // the command's syntax, one form a line
+	about    string      // what the command does
+	examples []string    // command lines to try
+	errors   [][2]string // the errors the command prints, and what each means
+}
+
+// incorrectParameters explains the error every command prints for words
+// that don't fit its syntax
+var incorrectParameters = [2]string{"Incorrect number of parameters", "the words don't fit the syntax above; the exit status is 2"}
+
+// helpTopics holds the topic of each CLI command, by name
+var helpTopics = map[string]helpTopic{
+	"put": {
+		usage: []string{"put <key> <attribute1> <value1> [<attribute2> <value2> ...]"},
+		about: "Writes the entry at key, replacing any entry there. Each value's type is inferred: true and false are bools, numbers are floats, and anything else is a string. Quote a value to keep it a string, or to give it spaces. A long put can go on over several lines, after a trailing backslash or between a last word ( and a line that is only ).",
+		examples: []string{
+			"put user1 name John age 30",
+			`put user2 name "Ann Lee" zip "02134" active true`,
+			"put user3 (\n    name Bo\n    age 25\n  )",
+		},
+		errors: [][2]string{
+			incorrectParameters,
+			{"Data Type Error", "an attribute already holds values of another type in the store; the exit status is 4"},
+			{"READONLY store is a follower", "writes go to the leader, not to a follower"},
+			{"QUOTA namespace over its quota", "the put would take the key's namespace over its quota of keys or memory; see quota. The exit status is 1"},
+		},
+	},
+	"putjson": {
+		usage: []string{"putjson <key> <JSON object>", "putjson <key>, then the object on the lines after it, up to a blank line"},
+		about: "Writes the entry at key from a JSON object, whose strings, numbers and bools become the entry's attributes. The object is taken as is, so its values need no CLI quoting.",
+		examples: []string{
+			`putjson user1 {"name": "John Smith", "age": 30, "zip": "02134"}`,
+		},
+		errors: [][2]string{
+			incorrectParameters,
+			{"bad JSON entry", "the object isn't valid JSON; the exit status is 2"},
+			{"want a string, number or bool", "an attribute holds a list, an object or null"},
+			{"Data Type Error", "an attribute already holds values of another type in the store; the exit status is 4"},
+		},
+	},
+	"get": {
+		usage:    []string{"get <key> [--all]"},
+		about:    "Prints the attributes of the entry at key, in the output format. In the interactive CLI an entry with more attributes than fit on the screen is cut short unless given --all.",
+		examples: []string{"get user1", "get user1 --all"},
+		errors: [][2]string{
+			incorrectParameters,
+			{"No entry found for key", "there is no entry at key; the exit status is 3"},
+		},
+	},
+	"mget": {
+		usage:    []string{"mget <key1> [<key2> ...] [--all]"},
+		about:    "Prints the entries at several keys, as a table in the order given, read as of one moment so no write lands between them, then the keys that have no entry. In json it prints an object of the entries by key, with null for the missing keys. It succeeds as long as one key has an entry.",
+		examples: []string{"mget user1 user2 user3"},
+		errors: [][2]string{
+			incorrectParameters,
+			{"No entries found", "none of the keys has an entry; the exit status is 3"},
+		},
+	},
+	"delete": {
+		usage:    []string{"delete <key>", "delete --pattern <key pattern> [--force]"},
+		about:    "Removes the entry at key. Deleting a key with no entry succeeds and does nothing. With --pattern it removes every entry whose key matches the glob pattern, in one atomic write, once you confirm; --force skips the question, and is needed outside the interactive CLI.",
+		examples: []string{"delete user1", "delete --pattern session:*"},
+		errors: [][2]string{
+			incorrectParameters,
+			{"add --force to go ahead", "delete --pattern has no one to ask outside the interactive CLI; the exit status is 2"},
+			{"Cancelled", "you didn't confirm, so nothing was deleted"},
+			{"READONLY store is a follower", "writes go to the leader, not to a follower"},
+		},
+	},
+	"flush": {
+		usage:    []string{"flush [--force]"},
+		about:    "Removes every entry, or every entry of the namespace the CLI is in, in one atomic write, once you confirm; --force skips the question, and is needed outside the interactive CLI. Attribute types stay registered.",
+		examples: []string{"flush", "flush --force"},
+		errors: [][2]string{
+			incorrectParameters,
+			{"add --force to go ahead", "flush has no one to ask outside the interactive CLI; the exit status is 2"},
+			{"Cancelled", "you didn't confirm, so nothing was deleted"},
+		},
+	},
+	"search": {
+		usage:    []string{"search <attribute> <value> [--all]"},
+		about:    "Lists the keys of the entries whose attribute has value, compared as the attribute's type. A quoted value is compared as a string, as put stores it.",
+		examples: []string{"search age 30", `search zip "02134"`},
+		errors: [][2]string{
+			incorrectParameters,
+			{"No matching entries found", "no entry has that value, which is not an error"},
+		},
+	},
+	"keys": {
+		usage:    []string{"keys [--all]"},
+		about:    "Lists every key in the store, or the names in the namespace the CLI is in, in sorted order.",
+		examples: []string{"keys", "keys --all"},
+	},
+	"show": {
+		usage:    []string{"show <key pattern> [--all]"},
+		about:    "Prints the entries whose keys match the glob pattern as a table, a row per key and a column per attribute, leaving blank the attributes an entry lacks. In the json output format it prints an object of the entries by key.",
+		examples: []string{"show user*", `show "order:2026-*"`},
+		errors: [][2]string{
+			incorrectParameters,
+			{"bad key pattern", "the pattern isn't a valid glob, as with an unclosed ["},
+			{"No matching entries found", "no key matches the pattern, which is not an error"},
+		},
+	},
+	"format": {
+		usage:    []string{"format [json|table|plain]"},
+		about:    "Shows how get, search and keys print their results, or sets it: plain text, a line of JSON, or a table with a header.",
+		examples: []string{"format table"},
+		errors: [][2]string{
+			incorrectParameters,
+			{"unknown output format", "the format isn't json, table or plain"},
+		},
+	},
+	"role": {
+		usage: []string{"role"},
+		about: "Shows whether the store is a leader, a follower and of which leader, or a Raft node and its state.",
+	},
+	"promote": {
+		usage: []string{"promote"},
+		about: "Makes a follower stop following its leader and accept writes, as when failing over by hand.",
+		errors: [][2]string{
+			{"store is not following a leader", "the store already accepts writes"},
+		},
+	},
+	"replication": {
+		usage: []string{"replication"},
+		about: "Shows the replication offsets of the store and its followers, and how far each lags.",
+	},
+	"info": {
+		usage:    []string{"info [<section>]"},
+		about:    "Summarizes the store's runtime state as name:value lines in sections, as Redis INFO does: server, the build, process and uptime; memory, the entries' estimated memory, its limit and the Go heap; persistence, the write log, durability and unsaved writes; replication, as the replication command prints it; and keyspace, the entry and attribute counts. Each section starts with a # Name line. Name a section to print only it; all, or no section, prints every one.",
+		examples: []string{"info", "info memory", "info persistence"},
+		errors: [][2]string{
+			incorrectParameters,
+			{"unknown info section", "the section isn't server, memory, persistence, replication, keyspace or all; the exit status is 2"},
+		},
+	},
+	"stats": {
+		usage:    []string{"stats [reset]", "stats attributes [<attribute>]"},
+		about:    "Shows the store's entry and attribute counts, its memory, and the puts, gets and their hits and misses, deletes, searches and type errors since startup as name:value lines. stats reset starts the operation counts over. stats attributes scans the entries for a line per attribute: its type, the entries holding it, its approximate number of distinct values and, for floats, its minimum, maximum and mean, to tell which attributes are worth indexing. stats attributes <attribute> adds its five most frequent values as top:value=count lines and, for floats, a histogram of ten equal-width buckets as [from,to):count lines.",
+		examples: []string{"stats", "stats reset", "stats attributes", "stats attributes age"},
+		errors: [][2]string{
+			incorrectParameters,
+			{"No entry has attribute <attribute>", "no entry holds the attribute; the exit status is 1"},
+		},
+	},
+	"latency": {
+		usage:    []string{"latency [<command> | reset]"},
+		about:    "Shows how long each command run since startup took: its calls, mean, 50th, 90th, 99th and 99.9th percentiles and maximum, as command:name=value lines, read from a histogram accurate to 1/16 of the latency. latency <command> adds that command's histogram, a <bound:count line for each bucket of calls that took under bound. latency reset forgets every latency recorded.",
+		examples: []string{"latency", "latency search", "latency reset"},
+		errors: [][2]string{
+			incorrectParameters,
+			{"No latency recorded for <command>", "the command hasn't run since startup or the last latency reset; the exit status is 1"},
+		},
+	},
+	"backup": {
+		usage:    []string{"backup <dir>"},
+		about:    "Writes a consistent backup of the store, with a manifest, to dir, which must not exist yet.",
+		examples: []string{"backup backups/monday"},
+		errors: [][2]string{
+			incorrectParameters,
+			{"already exists", "dir already exists; back up to a new directory"},
+		},
+	},
+	"restore": {
+		usage:    []string{"restore <dir> [--force]"},
+		about:    "Replaces the store's contents with the backup in dir, after checking it against its manifest. If the store has entries, it asks first; --force skips the question, and is needed outside the interactive CLI.",
+		examples: []string{"restore backups/monday"},
+		errors: [][2]string{
+			incorrectParameters,
+			{"no such file or directory", "there is no backup at dir; the exit status is 5"},
+			{"add --force to go ahead", "restore over entries has no one to ask outside the interactive CLI; the exit status is 2"},
+		},
+	},
+	"diff": {
+		usage:    []string{"diff <backup> [<backup>]"},
+		about:    "Lists the keys added, removed and modified from a backup to another, or to the store.",
+		examples: []string{"diff backups/monday", "diff backups/monday backups/tuesday"},
+		errors:   [][2]string{incorrectParameters},
+	},
+	"export": {
+		usage:    []string{"export json|yaml|protobuf|gob|msgpack|parquet|sqlite <file> [<key pattern>] [where <attribute> <value>]"},
+		about:    "Writes the entries and attribute types to file, all of them, or those whose keys match the glob pattern and whose attribute has value.",
+		examples: []string{"export json users.json", "export yaml paris.yaml user:* where city Paris"},
+		errors: [][2]string{
+			{"Incorrect parameters", "the words don't fit the syntax above; the exit status is 2"},
+			{"unknown export format", "the format isn't one of those above"},
+			{"no such file or directory", "file's directory doesn't exist; the exit status is 5"},
+		},
+	},
+	"dump": {
+		usage:    []string{"dump script <file> [<key pattern>] [where <attribute> <value>]"},
+		about:    "Writes the entries as put commands that rebuild them, types included, when fed to the CLI, taking the same filters as export.",
+		examples: []string{"dump script dump.txt user:*"},
+		errors: [][2]string{
+			{"Incorrect parameters", "the words don't fit the syntax above; the exit status is 2"},
+		},
+	},
+	"import": {
+		usage: []string{
+			"import json|yaml|protobuf|gob|msgpack <file>",
+			"import csv <file> [--key-column <column>]",
+			"import rdb <file> [--db <n>]",
+			"import redis <host:port> [--db <n>]",
+			"import etcd|consul <host:port> [<prefix>] [--trim-prefix]",
+		},
+		about: "Loads entries from a file, or from another store. --bulk loads a large file in bulk-load mode, and --on-conflict overwrite|skip|merge|abort chooses what happens to keys the store already has; overwrite is the default.",
+		examples: []string{
+			"import json users.json",
+			"import csv users.csv --key-column id --on-conflict skip",
+			"import redis localhost:6379 --db 2",
+		},
+		errors: [][2]string{
+			{"Incorrect parameters", "the words don't fit the syntax above; the exit status is 2"},
+			{"Data Type Error", "an imported attribute holds values of another type in the store; the exit status is 4"},
+			{"no such file or directory", "there is no file at file; the exit status is 5"},
+		},
+	},
+	"raft": {
+		usage:    []string{"raft add <id> <addr>", "raft remove <id>"},
+		about:    "Adds a node to the Raft cluster, or removes one. Only the leader changes the membership.",
+		examples: []string{"raft add node4 10.0.0.4:7000"},
+		errors: [][2]string{
+			{"Incorrect parameters", "the words don't fit the syntax above; the exit status is 2"},
+			{"Store is not running in Raft mode", "the store wasn't started with -raft-id"},
+			{"NOTLEADER", "this node isn't the leader; run the command on the leader"},
+		},
+	},
+	"monitor": {
+		usage:    []string{"monitor <addr>"},
+		about:    "Prints every command run on the server at addr, by any client, as it completes, until Ctrl+C: its start time in Unix seconds, the client's address, the command's words, how long it took and the error it replied, if any.",
+		examples: []string{"monitor localhost:6380"},
+		errors: [][2]string{
+			incorrectParameters,
+			{"monitor runs until interrupted and needs a terminal", "monitor can't run from -c, -file or batch mode"},
+		},
+	},
+	"watch": {
+		usage:    []string{"watch <key|pattern>"},
+		about:    "Prints each change to key, or to every key matching the glob pattern, with a timestamp, until Ctrl+C.",
+		examples: []string{"watch user1", "watch sde_*"},
+		errors: [][2]string{
+			incorrectParameters,
+			{"watch runs until interrupted and needs a terminal", "watch can't run from -c, -file or batch mode"},
+		},
+	},
+	"source": {
+		usage:    []string{"source <file> [--on-error stop|continue]"},
+		about:    "Runs the commands in file line by line, skipping blank lines and # comments, until its end or an exit line. It stops at the first failing command unless given --on-error continue, and fails as that command did.",
+		examples: []string{"source setup.txt", "source setup.txt --on-error continue"},
+		errors: [][2]string{
+			{"Incorrect parameters", "the words don't fit the syntax above; the exit status is 2"},
+			{"line N failed", "the command on line N failed, with the error printed before"},
+			{"scripts nested more than 16 deep", "scripts source each other, likely in a loop"},
+		},
+	},
+	"bench": {
+		usage:    []string{"bench put|get|search <operations> [--parallel <n>] [--attrs <n>]"},
+		about:    "Runs a synthetic workload against the store, as it is configured, and prints the throughput and the p50, p90, p99, p99.9 and max latencies. bench put writes entries under bench: with --attrs float attributes, 3 by default, named bench_attr0 and up; bench get reads them back at random and bench search searches bench_attr0. --parallel runs the operations on that many goroutines, 1 by default. The bench: entries are left in the store.",
+		examples: []string{"bench put 100000 --parallel 8 --attrs 5", "bench get 100000 --parallel 8", "bench search 100"},
+		errors: [][2]string{
+			{"bad number of operations", "the number isn't a positive integer; the exit status is 2"},
+			{"no bench entries to get", "bench get needs bench put to have run first"},
+			{"Data Type Error", "the store already has a bench_attr attribute of another type; the exit status is 4"},
+		},
+	},
+	"dryrun": {
+		usage:    []string{"dryrun [on|off]"},
+		about:    "Shows whether the CLI is in a dry run, or starts or ends one. In a dry run put, putjson, delete, flush, import, restore and promote check what they would write, attribute types included, and print what would change without changing it. Later commands are checked as if the skipped puts and deletes had been made, while reads still show the store as it is. -dry-run starts the CLI in a dry run.",
+		examples: []string{"dryrun on", "source migrate.txt", "dryrun off"},
+		errors: [][2]string{
+			{"Incorrect parameters", "the word after dryrun isn't on or off; the exit status is 2"},
+			{"can't run in a dry run", "bench put and raft change the store or the cluster with no way to check them first"},
+		},
+	},
+	"use": {
+		usage:    []string{"use", "use <namespace>"},
+		about:    "Lists the namespaces, marking the one the CLI is in, or switches to one, which the prompt names. A key namespace/name, such as tenantA/user1, is in that namespace, and its attribute types are registered for the namespace alone, so another can type the same attribute differently. In a namespace put, putjson, get, mget, delete, flush, search, keys and show take and list the names of its entries, without the namespace; commands on the whole store, such as export, backup and watch, ignore it. use default goes back to the default namespace, which sees every key as it is.",
+		examples: []string{"use tenantA", "put user1 age 30", "use default", "get tenantA/user1"},
+		errors: [][2]string{
+			incorrectParameters,
+			{"A namespace name can't be empty or hold /", "the name holds the separator between a key's namespace and its name; the exit status is 2"},
+		},
+	},
+	"quota": {
+		usage:    []string{"quota", "quota set <namespace> [keys <n>] [memory <bytes>]"},
+		about:    "Lists each namespace holding entries or with a quota as a line of its keys and estimated memory, measured as for -max-memory, against its limits; 0 is no limit. quota set replaces a namespace's quota: a write that would take the namespace over either limit then fails, while deletes and writes that shrink it still succeed. The limits left out are lifted, so quota set with no limits removes the quota. Quotas last until the CLI exits; -quota-keys and -quota-memory set them at startup.",
+		examples: []string{"quota", "quota set tenantA keys 1000 memory 1048576", "quota set tenantA"},
+		errors: [][2]string{
+			incorrectParameters,
+			{"The default namespace can't have a quota", "quotas limit named namespaces; -max-memory limits the whole store. The exit status is 2"},
+			{"a quota needs a namespace name", "the namespace is empty or holds /; the exit status is 2"},
+			{"unexpected word in quota", "a limit isn't keys <n> or memory <bytes>, or isn't a whole number; the exit status is 2"},
+		},
+	},
+	"alias": {
+		usage:    []string{"alias", "alias <name>=<command words>"},
+		about:    "Lists the aliases, or names the first words of a command: a command starting with the alias runs those words, followed by its own. Aliases are saved in the config file.",
+		examples: []string{"alias su=search user", `alias wide="format table"`},
+		errors: [][2]string{
+			{"Incorrect parameters", "there is no = after the name; the exit status is 2"},
+			{"bad alias name", "the name has a space, a quote, = or #"},
+		},
+	},
+	"set": {
+		usage:    []string{"set", "set $<name> <value>"},
+		about:    "Lists the variables, or sets one for the session. An unquoted word that is $name, or holds ${name}, takes the variable's value. $_ is the key of the last command that succeeded: the key a put, putjson, get or delete named, or the first key keys, search or show found.",
+		examples: []string{"set $k user1", "get $k", "put user:${k} name Ann", "search name Ann", "delete $_"},
+		errors: [][2]string{
+			incorrectParameters,
+			{"undefined variable", "a command used a variable that isn't set; quote a word to keep a $ as is; the exit status is 2"},
+			{"bad variable name", "a name has letters, digits and underscores, and doesn't start with a digit"},
+		},
+	},
+	"unset": {
+		usage:    []string{"unset $<name>"},
+		about:    "Removes a variable.",
+		examples: []string{"unset $k"},
+		errors: [][2]string{
+			incorrectParameters,
+			{"no variable", "there is no variable of that name"},
+		},
+	},
+	"unalias": {
+		usage:    []string{"unalias <name>"},
+		about:    "Removes an alias, from the config file too.",
+		examples: []string{"unalias su"},
+		errors: [][2]string{
+			incorrectParameters,
+			{"no alias", "there is no alias of that name"},
+		},
+	},
+	"time": {
+		usage:    []string{"time <command>"},
+		about:    "Runs the command, then prints how long it took by the wall clock and how many entries it examined, such as every entry for search. -timing does the same for every command.",
+		examples: []string{"time search age 30", "time show user*"},
+		errors:   [][2]string{incorrectParameters},
+	},
+	"help": {
+		usage:    []string{"help [<command>]"},
+		about:    "Prints the menu of every command, or the syntax, examples and errors of one.",
+		examples: []string{"help put"},
+	},
+	"exit": {
+		usage: []string{"exit"},
+		about: "Exits the program, or ends a script run by -file or source.",
+	},
+}
+
+// printHelp prints the help topic of the command named name, and returns
+// whether there is one. An alias's topic is that of the command it stands
+// for.
+func printHelp(out io.Writer, name string) bool {
+	if expansion, ok := aliases[name]; ok {
+		fmt.Fprintf(out, "%s is an alias for %s\n\n", name, expansion)
+		words, _, _ := splitCommandLine(expansion)
+		name = words[0]
+	}
+	topic, ok := helpTopics[name]
+	if !ok {
+		return false
+	}
+	fmt.Fprintln(out, "Usage:")
+	for _, usage := range topic.usage {
+		fmt.Fprintln(out, " ", usage)
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, topic.about)
+	if len(topic.examples) > 0 {
+		fmt.Fprintln(out, "\nExamples:")
+		for _, example := range topic.examples {
+			fmt.Fprintln(out, " ", example)
+		}
+	}
+	if len(topic.errors) > 0 {
+		fmt.Fprintln(out, "\nErrors:")
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		for _, e := range topic.errors {
+			fmt.Fprintf(tw, "  %s\t%s\n", e[0], e[1])
+		}
+		tw.Flush()
+	}
+	return true
+}
+
+// helpCommands returns the commands help has a topic for, sorted
+func helpCommands() []string {
+	names := make([]string, 0, len(helpTopics))
+	for name := range helpTopics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// helpCommand runs help, printing the menu or the topic of a command
+func helpCommand(out io.Writer, parts []string) int {
+	switch {
+	case len(parts) == 1:
+		displayMenu(out)
+	case len(parts) > 2:
+		fmt.Fprintln(out, "Error: Incorrect number of parameters")
+		fmt.Fprintln(out, "Usage: help [<command>]")
+		return exitUsage
+	case !printHelp(out, parts[1]):
+		fmt.Fprintf(out, "Error: no help for %s; it isn't a command\n", parts[1])
+		fmt.Fprintln(out, "Commands:", strings.Join(helpCommands(), ", "))
+		return exitUsage
+	}
+	return exitOK
+}
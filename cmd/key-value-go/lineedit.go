@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	kv "github.com/dsapoetra/key-value-go/pkg/store"
+	"golang.org/x/term"
+)
+
+// The interactive CLI reads commands with a small line editor: the arrow
+// keys move through the line and through earlier commands, Ctrl-R searches
+// them, and the usual Emacs keys edit the line. Commands are kept in a
+// history file, so they carry over to the next session. A line longer than
+// the terminal is wide scrolls sideways rather than wrapping. Tab completes
+// the word before the cursor.
+
+// defaultHistorySize is how many commands the history keeps by default
+const defaultHistorySize = 1000
+
+// errInterrupted is returned by ReadLine when the user presses Ctrl-C
+var errInterrupted = errors.New("interrupted")
+
+// defaultHistoryPath returns the history file in the user's home directory,
+// or "" if there is none
+func defaultHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".kv_history")
+}
+
+// Keys read from escape sequences, which have no rune of their own
+const (
+	keyUnknown = -iota - 1
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyDelete
+	keyWordLeft
+	keyWordRight
+)
+
+// ctrl returns the rune a control key chord sends
+func ctrl(r rune) rune {
+	return r & 0x1f
+}
+
+// lineEditor reads lines from a terminal with editing and history
+type lineEditor struct {
+	in          *bufio.Reader
+	inFd, outFd int
+	out         *os.File
+	history     []string
+	historyPath string // "" keeps the history in memory only
+	historySize int    // the most commands the history keeps; 0 keeps none
+
+	// complete returns the rune index where the word ending line starts and
+	// the words it can be completed to. Nil disables completion.
+	complete func(line string) (int, []string)
+}
+
+// newLineEditor returns an editor reading from in and echoing to out, with
+// the last historySize commands saved in the file at historyPath
+func newLineEditor(in, out *os.File, historyPath string, historySize int) *lineEditor {
+	e := &lineEditor{
+		in:          bufio.NewReader(in),
+		inFd:        int(in.Fd()),
+		outFd:       int(out.Fd()),
+		out:         out,
+		historyPath: historyPath,
+		historySize: historySize,
+	}
+	e.loadHistory()
+	return e
+}
+
+// loadHistory reads the history file, trimming it to the last historySize
+// commands. The history is a convenience, so a file that can't be read or
+// written leaves it empty or unsaved instead of failing.
+func (e *lineEditor) loadHistory() {
+	if e.historyPath == "" || e.historySize == 0 {
+		return
+	}
+	data, err := os.ReadFile(e.historyPath)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if len(e.history) > e.historySize {
+		e.history = e.history[len(e.history)-e.historySize:]
+		os.WriteFile(e.historyPath, []byte(strings.Join(e.history, "\n")+"\n"), 0o600)
+	}
+}
+
+// addHistory appends line to the history, unless it is blank, repeats the
+// last command, or starts with a space, which as in a shell keeps a command
+// carrying a secret out of the history
+func (e *lineEditor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" || strings.HasPrefix(line, " ") || e.historySize == 0 ||
+		len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > e.historySize {
+		e.history = e.history[1:]
+	}
+	if e.historyPath == "" {
+		return
+	}
+	if file, err := os.OpenFile(e.historyPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600); err == nil {
+		fmt.Fprintln(file, line)
+		file.Close()
+	}
+}
+
+// ReadLine shows prompt and reads a line, returning io.EOF for Ctrl-D on
+// an empty line and errInterrupted for Ctrl-C. If the terminal can't be put
+// in raw mode, it reads a plain line.
+func (e *lineEditor) ReadLine(prompt string) (string, error) {
+	return e.readLine(prompt, true)
+}
+
+// Ask is ReadLine for the answer to a question, which is left out of the
+// history
+func (e *lineEditor) Ask(prompt string) (string, error) {
+	return e.readLine(prompt, false)
+}
+
+// readLine is ReadLine, adding the line to the history if record
+func (e *lineEditor) readLine(prompt string, record bool) (string, error) {
+	state, err := term.MakeRaw(e.inFd)
+	if err != nil {
+		fmt.Fprint(e.out, prompt)
+		line, err := e.in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if record {
+			e.addHistory(line)
+		}
+		return line, nil
+	}
+	defer term.Restore(e.inFd, state)
+
+	l := &editLine{prompt: prompt}
+	hist := len(e.history) // the history entry shown; len(e.history) is the line being typed
+	var draft []rune
+	var pending rune
+	for {
+		e.render(l)
+		key := pending
+		pending = 0
+		if key == 0 {
+			if key, err = e.readKey(); err != nil {
+				return "", err
+			}
+		}
+
+		switch key {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			line := string(l.buf)
+			if record {
+				e.addHistory(line)
+			}
+			return line, nil
+		case ctrl('C'):
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case ctrl('D'):
+			if len(l.buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			l.delete(l.pos, l.pos+1)
+		case keyDelete:
+			l.delete(l.pos, l.pos+1)
+		case 127, ctrl('H'):
+			l.delete(l.pos-1, l.pos)
+		case ctrl('A'), keyHome:
+			l.pos = 0
+		case ctrl('E'), keyEnd:
+			l.pos = len(l.buf)
+		case ctrl('B'), keyLeft:
+			l.pos = max(l.pos-1, 0)
+		case ctrl('F'), keyRight:
+			l.pos = min(l.pos+1, len(l.buf))
+		case keyWordLeft:
+			l.pos = l.wordStart()
+		case keyWordRight:
+			l.pos = l.wordEnd()
+		case ctrl('K'):
+			l.delete(l.pos, len(l.buf))
+		case ctrl('U'):
+			l.delete(0, l.pos)
+		case ctrl('W'):
+			l.delete(l.wordStart(), l.pos)
+		case ctrl('L'):
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case ctrl('P'), keyUp:
+			if hist > 0 {
+				if hist == len(e.history) {
+					draft = append(draft[:0], l.buf...)
+				}
+				hist--
+				l.set([]rune(e.history[hist]))
+			}
+		case ctrl('N'), keyDown:
+			if hist < len(e.history) {
+				hist++
+				if hist == len(e.history) {
+					l.set(append([]rune(nil), draft...))
+				} else {
+					l.set([]rune(e.history[hist]))
+				}
+			}
+		case ctrl('R'):
+			if pending, err = e.search(l); err != nil {
+				return "", err
+			}
+		case '\t':
+			e.completeWord(l)
+		default:
+			if key >= ' ' && key != 127 {
+				l.insert(key)
+			}
+		}
+	}
+}
+
+// search runs a reverse incremental search of the history, started by
+// Ctrl-R: typing narrows it, Ctrl-R again finds an older match and Ctrl-G
+// puts the line back as it was. Any other key leaves the match in the line
+// and is returned for ReadLine to handle, so Enter runs the match.
+func (e *lineEditor) search(l *editLine) (rune, error) {
+	prompt, original, originalPos := l.prompt, append([]rune(nil), l.buf...), l.pos
+	defer func() { l.prompt = prompt }()
+
+	var query []rune
+	at := len(e.history) // the matching entry, or len(e.history) for none yet
+	failed := false
+	find := func(from int) {
+		q := string(query)
+		for i := min(from, len(e.history)-1); i >= 0; i-- {
+			if col := strings.Index(e.history[i], q); col >= 0 {
+				at, failed = i, false
+				l.set([]rune(e.history[i]))
+				l.pos = utf8.RuneCountInString(e.history[i][:col])
+				return
+			}
+		}
+		failed = true
+	}
+	for {
+		label := "reverse-i-search"
+		if failed {
+			label = "failed " + label
+		}
+		l.prompt = fmt.Sprintf("(%s)`%s': ", label, string(query))
+		e.render(l)
+
+		key, err := e.readKey()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case key == ctrl('R'):
+			find(at - 1)
+		case key == ctrl('G'):
+			l.set(original)
+			l.pos = originalPos
+			return 0, nil
+		case key == 127 || key == ctrl('H'):
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				find(len(e.history) - 1)
+			}
+		case key >= ' ':
+			query = append(query, key)
+			find(at)
+		default:
+			return key, nil
+		}
+	}
+}
+
+// maxListedCompletions caps the completions listed for an ambiguous word
+const maxListedCompletions = 100
+
+// completeWord completes the word before the cursor: to the one candidate
+// and a space, or as far as all candidates agree, listing them if that
+// adds nothing
+func (e *lineEditor) completeWord(l *editLine) {
+	if e.complete == nil {
+		return
+	}
+	start, candidates := e.complete(string(l.buf[:l.pos]))
+	word := string(l.buf[start:l.pos])
+	if len(candidates) == 0 {
+		fmt.Fprint(e.out, "\a")
+		return
+	}
+	replace := func(with string) {
+		l.delete(start, l.pos)
+		for _, r := range with {
+			l.insert(r)
+		}
+	}
+	if len(candidates) == 1 {
+		replace(kv.ScriptWord(candidates[0]) + " ")
+		return
+	}
+	prefix := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+	if len(prefix) > len(word) && kv.ScriptWord(prefix) == prefix {
+		replace(prefix)
+		return
+	}
+
+	// List the candidates in columns below the line
+	width, _, err := term.GetSize(e.outFd)
+	if err != nil || width <= 0 {
+		width = 80
+	}
+	shown := candidates[:min(len(candidates), maxListedCompletions)]
+	column := 0
+	for _, c := range shown {
+		column = max(column, utf8.RuneCountInString(c)+2)
+	}
+	perRow := max(width/column, 1)
+	var b strings.Builder
+	b.WriteString("\r\n")
+	for i, c := range shown {
+		b.WriteString(c)
+		if (i+1)%perRow == 0 || i == len(shown)-1 {
+			b.WriteString("\x1b[K\r\n")
+		} else {
+			b.WriteString(strings.Repeat(" ", column-utf8.RuneCountInString(c)))
+		}
+	}
+	if len(candidates) > len(shown) {
+		fmt.Fprintf(&b, "... and %d more\r\n", len(candidates)-len(shown))
+	}
+	io.WriteString(e.out, b.String())
+}
+
+// readKey reads a key press, decoding the escape sequences of the arrow,
+// Home, End and Delete keys
+func (e *lineEditor) readKey() (rune, error) {
+	r, _, err := e.in.ReadRune()
+	if err != nil || r != 0x1b {
+		return r, err
+	}
+	next, _, err := e.in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	switch next {
+	case 'b':
+		return keyWordLeft, nil // Alt-B
+	case 'f':
+		return keyWordRight, nil // Alt-F
+	case '[', 'O':
+	default:
+		return keyUnknown, nil
+	}
+	// A control sequence: parameters, then a final byte from @ to ~
+	var seq []byte
+	for {
+		b, err := e.in.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		seq = append(seq, b)
+		if b >= 0x40 && b <= 0x7e {
+			break
+		}
+	}
+	switch string(seq) {
+	case "A":
+		return keyUp, nil
+	case "B":
+		return keyDown, nil
+	case "C":
+		return keyRight, nil
+	case "D":
+		return keyLeft, nil
+	case "H", "1~", "7~":
+		return keyHome, nil
+	case "F", "4~", "8~":
+		return keyEnd, nil
+	case "3~":
+		return keyDelete, nil
+	case "1;5D", "1;3D":
+		return keyWordLeft, nil
+	case "1;5C", "1;3C":
+		return keyWordRight, nil
+	}
+	return keyUnknown, nil
+}
+
+// render redraws the prompt and the part of the line around the cursor
+// that fits the terminal
+func (e *lineEditor) render(l *editLine) {
+	width, _, err := term.GetSize(e.outFd)
+	if err != nil || width <= 0 {
+		width = 80
+	}
+	prompt := []rune(l.prompt)
+	room := max(width-len(prompt)-1, 10)
+	if l.pos < l.offset {
+		l.offset = l.pos
+	} else if l.pos > l.offset+room {
+		l.offset = l.pos - room
+	}
+	l.offset = min(l.offset, max(len(l.buf)-room, 0))
+
+	visible := l.buf[l.offset:min(len(l.buf), l.offset+room)]
+	var b strings.Builder
+	b.WriteString("\r" + l.prompt + string(visible) + "\x1b[K\r")
+	if col := len(prompt) + l.pos - l.offset; col > 0 {
+		fmt.Fprintf(&b, "\x1b[%dC", col)
+	}
+	io.WriteString(e.out, b.String())
+}
+
+// editLine is the line being edited
+type editLine struct {
+	prompt string
+	buf    []rune
+	pos    int // the cursor, as an index into buf
+	offset int // the first rune shown, when the line is wider than the terminal
+}
+
+// set replaces the line, moving the cursor to its end
+func (l *editLine) set(buf []rune) {
+	l.buf, l.pos = buf, len(buf)
+}
+
+func (l *editLine) insert(r rune) {
+	l.buf = append(l.buf, 0)
+	copy(l.buf[l.pos+1:], l.buf[l.pos:])
+	l.buf[l.pos] = r
+	l.pos++
+}
+
+// delete removes buf[from:to], clamped to the line
+func (l *editLine) delete(from, to int) {
+	from, to = max(from, 0), min(to, len(l.buf))
+	if from >= to {
+		return
+	}
+	l.buf = append(l.buf[:from], l.buf[to:]...)
+	if l.pos > to {
+		l.pos -= to - from
+	} else if l.pos > from {
+		l.pos = from
+	}
+}
+
+// wordStart returns the start of the word before the cursor
+func (l *editLine) wordStart() int {
+	i := l.pos
+	for i > 0 && unicode.IsSpace(l.buf[i-1]) {
+		i--
+	}
+	for i > 0 && !unicode.IsSpace(l.buf[i-1]) {
+		i--
+	}
+	return i
+}
+
+// wordEnd returns the end of the word after the cursor
+func (l *editLine) wordEnd() int {
+	i := l.pos
+	for i < len(l.buf) && unicode.IsSpace(l.buf[i]) {
+		i++
+	}
+	for i < len(l.buf) && !unicode.IsSpace(l.buf[i]) {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// The store logs its events, such as replaying its write log, evictions
+// and replication trouble, through slog. -log-level picks the least severe
+// level written to stderr, by default info for a server and warn for the
+// CLI, whose own output is on stdout, and -log-format picks text or JSON
+// lines.
+
+// logFormats lists the formats of -log-format
+var logFormats = []string{"text", "json"}
+
+// newLogger returns a logger writing records at level and above to w in
+// format, one of logFormats
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q; want debug, info, warn or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q; want one of %s", format, strings.Join(logFormats, ", "))
+}
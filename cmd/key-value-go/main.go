@@ -0,0 +1,1890 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	kv "github.com/dsapoetra/key-value-go/pkg/store"
+)
+
+// parseExportFilter parses the filter after an export's file name: an
+// optional key pattern, then optionally "where <attribute> <value>". quoted
+// reports which args were quoted.
+func parseExportFilter(args []string, quoted []bool) (kv.ExportFilter, error) {
+	var filter kv.ExportFilter
+	if len(args) > 0 && args[0] != "where" {
+		filter.Keys, args, quoted = args[0], args[1:], quoted[1:]
+	}
+	switch {
+	case len(args) == 0:
+	case len(args) == 3 && args[0] == "where":
+		filter.WhereAttr, filter.WhereValue, filter.WhereString = args[1], args[2], quoted[2]
+	default:
+		return kv.ExportFilter{}, errors.New("bad export filter")
+	}
+	return filter, nil
+}
+
+// parseImportFlags removes the --key-column, --db and --on-conflict options,
+// as "--flag value" or "--flag=value", and --bulk and --trim-prefix from args
+// into opts
+func parseImportFlags(args []string, opts *kv.ImportOptions) ([]string, error) {
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--bulk" {
+			opts.Bulk = true
+			continue
+		}
+		if args[i] == "--trim-prefix" {
+			opts.TrimPrefix = true
+			continue
+		}
+		name, value, inline := strings.Cut(args[i], "=")
+		if name != "--key-column" && name != "--db" && name != "--on-conflict" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !inline {
+			if i+1 == len(args) {
+				return nil, fmt.Errorf("%s needs a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		switch name {
+		case "--key-column":
+			opts.KeyColumn = value
+		case "--on-conflict":
+			strategy, err := kv.ParseConflictStrategy(value)
+			if err != nil {
+				return nil, err
+			}
+			opts.OnConflict = strategy
+		default:
+			db, err := strconv.Atoi(value)
+			if err != nil || db < 0 {
+				return nil, fmt.Errorf("bad database %q", value)
+			}
+			opts.DB = db
+		}
+	}
+	return rest, nil
+}
+
+// maxReportedRowErrors caps the skipped rows, and the keys of each
+// conflict bucket, the CLI lists after an import
+const maxReportedRowErrors = 20
+
+// printImportReport describes the outcome of an import from path
+func printImportReport(out io.Writer, report *kv.ImportReport, path string) {
+	switch {
+	case dryRun != nil:
+		fmt.Fprintf(out, "Dry run: would import %s from %s\n", counted(report.Imported, "entry", "entries"), path)
+	case report.Bulk != nil:
+		fmt.Fprintf(out, "Success: Imported %d entries from %s in %s (%.0f entries/s)\n",
+			report.Imported, path, report.Bulk.Elapsed.Round(time.Millisecond), report.Bulk.Rate())
+	default:
+		fmt.Fprintf(out, "Success: Imported %d entries from %s\n", report.Imported, path)
+	}
+	if len(report.Overwritten)+len(report.Merged)+len(report.Kept) > 0 {
+		fmt.Fprintf(out, "Added %d, overwrote %d, merged %d and kept %d existing entries\n",
+			len(report.Added), len(report.Overwritten), len(report.Merged), len(report.Kept))
+		printKeyBucket(out, "Overwritten", report.Overwritten)
+		printKeyBucket(out, "Merged", report.Merged)
+		printKeyBucket(out, "Kept", report.Kept)
+	}
+	if len(report.Errors) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "Skipped %d entries:\n", len(report.Errors))
+	for i, rowErr := range report.Errors {
+		if i == maxReportedRowErrors {
+			fmt.Fprintf(out, "  ... and %d more\n", len(report.Errors)-i)
+			break
+		}
+		fmt.Fprintln(out, "  "+rowErr.Error())
+	}
+}
+
+// printDiff lists the differences of d, a line per key: + for added, - for
+// removed and ~ for modified keys, with each changed attribute
+func printDiff(out io.Writer, d *kv.StoreDiff) {
+	if d.Empty() {
+		fmt.Fprintln(out, "Success: No differences")
+		return
+	}
+	fmt.Fprintf(out, "Success: %d added, %d removed and %d modified keys, %d attribute types changed\n",
+		len(d.Added), len(d.Removed), len(d.Modified), len(d.Types))
+	printDiffLines(out, d)
+}
+
+// formatDelta formats an attribute's change, with "-" standing for no value
+func formatDelta(a kv.AttributeDelta) string {
+	side := func(v interface{}) string {
+		if v == nil {
+			return "-"
+		}
+		return kv.FormatValue(v)
+	}
+	return fmt.Sprintf("%s: %s -> %s", a.Attr, side(a.Old), side(a.New))
+}
+
+// printDiffLines is printDiff without the summary line
+func printDiffLines(out io.Writer, d *kv.StoreDiff) {
+	for _, t := range d.Types {
+		fmt.Fprintln(out, "type "+formatDelta(t))
+	}
+	for _, key := range d.Added {
+		fmt.Fprintln(out, "+ "+key)
+	}
+	for _, key := range d.Removed {
+		fmt.Fprintln(out, "- "+key)
+	}
+	for _, m := range d.Modified {
+		changes := make([]string, len(m.Attrs))
+		for i, a := range m.Attrs {
+			changes[i] = formatDelta(a)
+		}
+		fmt.Fprintf(out, "~ %s %s\n", m.Key, strings.Join(changes, ", "))
+	}
+}
+
+// printKeyBucket lists the keys an import put in the bucket named label
+func printKeyBucket(out io.Writer, label string, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	line := strings.Join(keys[:min(len(keys), maxReportedRowErrors)], ", ")
+	if len(keys) > maxReportedRowErrors {
+		line += fmt.Sprintf(" ... and %d more", len(keys)-maxReportedRowErrors)
+	}
+	fmt.Fprintf(out, "  %s: %s\n", label, line)
+}
+
+// watchChanges prints every change to target, a key or a path.Match
+// pattern, as it is applied, until the user interrupts it
+func watchChanges(store *kv.Store, target string) error {
+	subscribe := func() (<-chan kv.ChangeEvent, error) {
+		if strings.ContainsAny(target, `*?[\`) {
+			return store.SubscribePattern(target)
+		}
+		return store.Subscribe(target), nil
+	}
+	events, err := subscribe()
+	if err != nil {
+		return err
+	}
+	defer func() { store.Unsubscribe(events) }()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	fmt.Printf("Watching %s, press Ctrl+C to stop\n", target)
+	for {
+		select {
+		case <-interrupt:
+			fmt.Println("Stopped watching")
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				// Fell too far behind and was dropped; subscribe again
+				fmt.Println("Warning: changes were missed while catching up")
+				if events, err = subscribe(); err != nil {
+					return err
+				}
+				continue
+			}
+			stamp := time.Now().Format("2006-01-02 15:04:05.000")
+			if event.Op == "delete" {
+				fmt.Printf("%s delete %s\n", stamp, event.Key)
+			} else {
+				fmt.Printf("%s put %s (version %d) %s\n", stamp, event.Key, event.Version, kv.FormatAttributes(event.New))
+			}
+		}
+	}
+}
+
+// monitorServer prints every command the server at addr runs, as MONITOR
+// reports it, until the user interrupts it
+func monitorServer(addr string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Printf("Monitoring %s, press Ctrl+C to stop\n", addr)
+	if err := kv.MonitorServer(ctx, addr, func(line string) { fmt.Println(line) }); err != nil {
+		return err
+	}
+	fmt.Println("Stopped monitoring")
+	return nil
+}
+
+// splitCommandLine splits a CLI line into words at whitespace, as
+// strings.Fields does, except that a word starting with a double quote is a
+// Go string literal, which can hold spaces, escapes or nothing at all. The
+// entry of a putjson, after its key, is the rest of the line as is.
+// quoted reports which words were quoted.
+func splitCommandLine(line string) (words []string, quoted []bool, err error) {
+	for i := 0; i < len(line); {
+		if len(words) == 2 && words[0] == "putjson" && !quoted[0] {
+			if rest := strings.TrimSpace(line[i:]); rest != "" {
+				words, quoted = append(words, rest), append(quoted, false)
+			}
+			break
+		}
+		r, size := utf8.DecodeRuneInString(line[i:])
+		if unicode.IsSpace(r) {
+			i += size
+			continue
+		}
+		if r != '"' {
+			end := strings.IndexFunc(line[i:], unicode.IsSpace)
+			if end < 0 {
+				end = len(line) - i
+			}
+			words, quoted = append(words, line[i:i+end]), append(quoted, false)
+			i += end
+			continue
+		}
+
+		end := i + 1
+		for end < len(line) && line[end] != '"' {
+			if line[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(line) {
+			return nil, nil, errors.New("unterminated quoted string")
+		}
+		end++
+		word, err := strconv.Unquote(line[i:end])
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad quoted string %s", line[i:end])
+		}
+		if next, _ := utf8.DecodeRuneInString(line[end:]); end < len(line) && !unicode.IsSpace(next) {
+			return nil, nil, fmt.Errorf("quoted string %s must be followed by a space", line[i:end])
+		}
+		words, quoted = append(words, word), append(quoted, true)
+		i = end
+	}
+	return words, quoted, nil
+}
+
+// typedAttributes types alternating attribute names and values as put
+// does, except that quoted values are always strings
+func typedAttributes(fields []string, quoted []bool) map[string]interface{} {
+	attrs := make(map[string]interface{})
+	for i := 0; i+1 < len(fields); i += 2 {
+		if quoted[i+1] {
+			attrs[fields[i]] = fields[i+1]
+		} else {
+			_, attrs[fields[i]], _ = kv.DetermineType(fields[i+1])
+		}
+	}
+	return attrs
+}
+
+// joinContinuedLines joins line with the lines that continue it into one
+// CLI line. A line ending in an unquoted backslash goes on on the next, as
+// in a shell, and a line whose last word is ( goes on up to a line that is
+// only ), its lines joined by spaces and blank and comment lines inside
+// skipped. next returns the next line, or false at the end of the input.
+func joinContinuedLines(line string, next func() (string, bool)) (string, error) {
+	for {
+		head, ok := strings.CutSuffix(line, `\`)
+		if !ok {
+			break
+		}
+		if words, quoted, err := splitCommandLine(head); err != nil || len(words) > 0 && strings.HasPrefix(words[0], "#") && !quoted[0] {
+			break // a backslash in an open quoted string or a comment
+		}
+		more, ok := next()
+		if !ok {
+			return "", errors.New(`line continues with \ past the end of the input`)
+		}
+		line = head + more
+	}
+
+	words, quoted, err := splitCommandLine(line)
+	if err != nil || len(words) < 2 || words[len(words)-1] != "(" || quoted[len(quoted)-1] || strings.HasPrefix(words[0], "#") {
+		return line, nil
+	}
+	block := []string{strings.TrimSuffix(strings.TrimRightFunc(line, unicode.IsSpace), "(")}
+	for {
+		more, ok := next()
+		if !ok {
+			return "", errors.New("unterminated ( block, end it with a line that is only )")
+		}
+		more = strings.TrimSpace(more)
+		if more == ")" {
+			break
+		}
+		if more != "" && !strings.HasPrefix(more, "#") {
+			block = append(block, more)
+		}
+	}
+	return strings.Join(block, " "), nil
+}
+
+// continueCommand completes a command that goes on over the next lines, as
+// a putjson given only a key does with its entry, up to a blank line. next
+// returns the next line, or false at the end of the input.
+func continueCommand(parts []string, quoted []bool, next func() (string, bool)) ([]string, []bool) {
+	if len(parts) != 2 || parts[0] != "putjson" || quoted[0] || next == nil {
+		return parts, quoted
+	}
+	var body []string
+	for {
+		line, ok := next()
+		if !ok || strings.TrimSpace(line) == "" {
+			break
+		}
+		body = append(body, line)
+	}
+	if len(body) == 0 {
+		return parts, quoted
+	}
+	return append(parts, strings.Join(body, "\n")), append(quoted, false)
+}
+
+// parseJSONEntry parses the entry of a putjson, a JSON object of attribute
+// names and their string, number or bool values
+func parseJSONEntry(text string) (map[string]interface{}, error) {
+	if !strings.HasPrefix(strings.TrimSpace(text), "{") {
+		return nil, errors.New("bad JSON entry: want an object")
+	}
+	var attrs map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &attrs); err != nil {
+		return nil, fmt.Errorf("bad JSON entry: %w", err)
+	}
+	if len(attrs) == 0 {
+		return nil, errors.New("the entry needs at least one attribute")
+	}
+	for _, attrKey := range slices.Sorted(maps.Keys(attrs)) {
+		switch attrs[attrKey].(type) {
+		case string, float64, bool:
+		default:
+			return nil, fmt.Errorf("attribute %q: want a string, number or bool, not %s", attrKey, jsonKind(attrs[attrKey]))
+		}
+	}
+	return attrs, nil
+}
+
+// jsonKind names the kind of a decoded JSON value that isn't a scalar
+func jsonKind(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case []interface{}:
+		return "an array"
+	}
+	return "an object"
+}
+
+func displayMenu(out io.Writer) {
+	fmt.Fprintln(out, "\nAvailable Commands:")
+	fmt.Fprintln(out, "1. put <key> <attribute1> <value1> [<attribute2> <value2> ...]")
+	fmt.Fprintln(out, "   Example: put user1 name John age 30")
+	fmt.Fprintln(out, "   putjson <key> <JSON object>")
+	fmt.Fprintln(out, "   Example: putjson user1 {\"name\": \"John Smith\", \"age\": 30}")
+	fmt.Fprintln(out, "2. get <key>")
+	fmt.Fprintln(out, "   Example: get user1")
+	fmt.Fprintln(out, "   mget <key1> [<key2> ...]")
+	fmt.Fprintln(out, "   Example: mget user1 user2, the entries as of one moment, and the keys with none")
+	fmt.Fprintln(out, "3. delete <key>")
+	fmt.Fprintln(out, "   Example: delete user1")
+	fmt.Fprintln(out, "   delete --pattern <key pattern> | flush")
+	fmt.Fprintln(out, "   Delete the entries matching a pattern, or all of them, once confirmed or given --force")
+	fmt.Fprintln(out, "4. search <attribute> <value>")
+	fmt.Fprintln(out, "   Example: search age 30")
+	fmt.Fprintln(out, "5. keys")
+	fmt.Fprintln(out, "   Lists all keys in the store")
+	fmt.Fprintln(out, "   show <key pattern>")
+	fmt.Fprintln(out, "   Example: show user*, a table of the matching entries, a column per attribute")
+	fmt.Fprintln(out, "   Add --all to get, mget, search, keys or show to print every result, not just a screenful")
+	fmt.Fprintln(out, "6. role")
+	fmt.Fprintln(out, "   Show whether this store is a leader or a follower")
+	fmt.Fprintln(out, "7. promote")
+	fmt.Fprintln(out, "   Stop following the leader and accept writes")
+	fmt.Fprintln(out, "8. replication")
+	fmt.Fprintln(out, "   Show replication offsets and lag")
+	fmt.Fprintln(out, "   info [<section>]")
+	fmt.Fprintln(out, "   Show the server, memory, persistence, replication and keyspace state, or one section of it")
+	fmt.Fprintln(out, "   stats [reset | attributes [<attribute>]]")
+	fmt.Fprintln(out, "   Show the entry counts and the operations made since startup, or start the counts over")
+	fmt.Fprintln(out, "   Or show each attribute's distinct values, or one attribute's most frequent values and histogram")
+	fmt.Fprintln(out, "   latency [<command> | reset]")
+	fmt.Fprintln(out, "   Show each command's latency percentiles, or one command's histogram, or start them over")
+	fmt.Fprintln(out, "9. backup <dir> | restore <dir>")
+	fmt.Fprintln(out, "   Write a backup of the store to a new directory, or replace the store's contents with one")
+	fmt.Fprintln(out, "   diff <backup> [<backup>]")
+	fmt.Fprintln(out, "   List the keys added, removed and modified from a backup to another, or to the store")
+	fmt.Fprintln(out, "10. export json|yaml|protobuf|gob|msgpack|parquet|sqlite <file> [<key pattern>] [where <attribute> <value>]")
+	fmt.Fprintln(out, "   import json|yaml|protobuf|gob|msgpack <file> | import csv <file> [--key-column <column>]")
+	fmt.Fprintln(out, "   Write the entries and attribute types to a file, or load them from one")
+	fmt.Fprintln(out, "   import rdb <file> [--db <n>] | import redis <host:port> [--db <n>]")
+	fmt.Fprintln(out, "   import etcd|consul <host:port> [<prefix>] [--trim-prefix]")
+	fmt.Fprintln(out, "   Add --bulk to any import to load a large file in bulk-load mode")
+	fmt.Fprintln(out, "   Add --on-conflict overwrite|skip|merge|abort to choose what happens to existing keys")
+	fmt.Fprintln(out, "   Example: import csv users.csv --key-column id")
+	fmt.Fprintln(out, "   dump script <file> [<key pattern>] [where <attribute> <value>]")
+	fmt.Fprintln(out, "   Write the entries as put commands that rebuild them when fed to the CLI")
+	fmt.Fprintln(out, "11. raft add <id> <addr> | raft remove <id>")
+	fmt.Fprintln(out, "   Change the Raft cluster's membership (leader only)")
+	fmt.Fprintln(out, "12. watch <key|pattern>")
+	fmt.Fprintln(out, "   Print each change to a key, or to keys matching a pattern, until Ctrl+C")
+	fmt.Fprintln(out, "   Example: watch user*")
+	fmt.Fprintln(out, "   monitor <addr>")
+	fmt.Fprintln(out, "   Print every command a server runs, with its client and duration, until Ctrl+C")
+	fmt.Fprintln(out, "13. source <file> [--on-error stop|continue]")
+	fmt.Fprintln(out, "   Run the commands in a file line by line, stopping at the first failure by default")
+	fmt.Fprintln(out, "14. format [json|table|plain]")
+	fmt.Fprintln(out, "   Show or set how get, search and keys print their results")
+	fmt.Fprintln(out, "   time <command>")
+	fmt.Fprintln(out, "   Run a command and print how long it took and the entries it examined")
+	fmt.Fprintln(out, "   bench put|get|search <operations> [--parallel <n>] [--attrs <n>]")
+	fmt.Fprintln(out, "   Run a synthetic workload on bench: keys and report throughput and latency percentiles")
+	fmt.Fprintln(out, "   dryrun [on|off]")
+	fmt.Fprintln(out, "   Check writes and print what they would change, without making them")
+	fmt.Fprintln(out, "   use [<namespace>]")
+	fmt.Fprintln(out, "   List the namespaces, or run the data commands in one: after use tenantA, get user1 reads tenantA/user1")
+	fmt.Fprintln(out, "   quota [set <namespace> [keys <n>] [memory <bytes>]]")
+	fmt.Fprintln(out, "   List each namespace's keys and memory against its quota, or limit what a namespace may hold")
+	fmt.Fprintln(out, "   alias [<name>=<command words>] | unalias <name>")
+	fmt.Fprintln(out, "   List aliases, or name the first words of a command, as in alias su=search user")
+	fmt.Fprintln(out, "   set [$<name> <value>] | unset $<name>")
+	fmt.Fprintln(out, "   List variables, or set one for later commands to use as $name; $_ is the last key")
+	fmt.Fprintln(out, "15. help [<command>]")
+	fmt.Fprintln(out, "   Display this menu, or the syntax, examples and errors of a command")
+	fmt.Fprintln(out, "16. exit")
+	fmt.Fprintln(out, "   Exit the program")
+}
+
+func main() {
+	listen := flag.String("listen", "", "serve clients over RESP on this address instead of starting the interactive CLI")
+	commandLine := flag.String("c", "", "run this CLI command, quoted as in the CLI, and exit with its status instead of starting the interactive CLI")
+	scriptPath := flag.String("file", "", "run the CLI commands in this file line by line and exit with the status of the first that fails instead of starting the interactive CLI")
+	onError := flag.String("on-error", "stop", "with -file, whether to stop or continue after a failing command")
+	output := flag.String("output", "plain", "print the results of get, search and keys as plain text, json or a table")
+	flag.BoolVar(&quiet, "q", false, "print results and errors only, without Success lines, the banner or the menu")
+	dryRunFlag := flag.Bool("dry-run", false, "check the CLI's writes, types included, and print what they would change without making them")
+	flag.BoolVar(&timing, "timing", false, "print each CLI command's wall-clock duration and the entries it examined after its output")
+	flag.BoolVar(&verbose, "v", false, "report each CLI command's parsed words, status, duration and entries touched on stderr")
+	noColor := flag.Bool("no-color", false, "never color the CLI's output, which is colored by default when writing to a terminal")
+	historyPath := flag.String("history", defaultHistoryPath(), "keep the interactive CLI's command history in this file; empty to keep none")
+	historySize := flag.Int("history-size", defaultHistorySize, "keep this many of the interactive CLI's last commands in the history; 0 to keep none")
+	logPath := flag.String("log", "", "persist writes to this append-only log file, replaying it at startup")
+	durabilityName := flag.String("durability", "logged", "default write durability with -log: memory, logged or fsync")
+	maxMemory := flag.Int64("max-memory", 0, "cap the store's approximate memory at this many bytes (0 for no limit)")
+	evictionName := flag.String("eviction", "noeviction", "what a store over -max-memory does: noeviction, lru or random")
+	quotaKeys := flag.String("quota-keys", "", "comma-separated namespace=n list limiting each namespace to n keys, such as tenantA=1000")
+	quotaMemory := flag.String("quota-memory", "", "comma-separated namespace=bytes list capping each namespace's approximate memory, such as tenantA=1048576")
+	logLevel := flag.String("log-level", "", "log the store's events at this level and above to stderr: debug, info, warn or error (default info with -listen, warn otherwise)")
+	logFormat := flag.String("log-format", "text", "format of the store's log lines: "+strings.Join(logFormats, " or "))
+	otlpEndpoint := flag.String("otlp-endpoint", "", "export traces of the store's operations and the server's commands over OTLP/HTTP to this collector, such as localhost:4318")
+	traceRatio := flag.Float64("trace-ratio", 1, "with -otlp-endpoint, share of traces to sample, from 0 to 1")
+	metricsAddr := flag.String("metrics", "", "serve the store's counters and command latency histograms to Prometheus at /metrics on this address, a loopback one unless -admin-secret-file is given")
+	adminAddr := flag.String("admin", "", "serve pprof profiles under /debug/pprof/ and the metrics under /metrics on this address, a loopback one unless -admin-secret-file is given")
+	adminSecretFile := flag.String("admin-secret-file", "", "with -admin or -metrics, require requests to bear the secret in this file as \"Authorization: Bearer <secret>\"")
+	backupRoot := flag.String("backup-root", "", "with -admin, take backups of the running store requested with POST /backup?name=<name> into this directory")
+	mutexFraction := flag.Int("mutex-profile-fraction", 100, "with -admin, sample one in this many mutex contention events for the mutex profile; 0 to sample none")
+	codecName := flag.String("codec", "json", "encoding of a new -log file and of the -follow stream: "+strings.Join(kv.Codecs, ", "))
+	batchWrites := flag.Bool("batch-writes", false, "in server mode, apply puts in batches that share one log flush")
+	databases := flag.Int("databases", 16, "in server mode, how many numbered databases select offers; database n keeps its keys in namespace dbn")
+	replicate := flag.String("replicate", "", "accept replication followers on this address")
+	follow := flag.String("follow", "", "replicate from the leader whose -replicate listener is at this address")
+	seed := flag.String("seed", "", "with -follow, load this backup directory or file into a new follower first, so it only fetches later writes from the leader")
+	replCert := flag.String("repl-cert", "", "secure replication links with mutual TLS using this PEM certificate")
+	replKey := flag.String("repl-key", "", "with -repl-cert, the certificate's PEM private key")
+	replCA := flag.String("repl-ca", "", "with -repl-cert, PEM certificate of the CA that signs every node's certificate")
+	replSecretFile := flag.String("repl-secret-file", "", "require replication peers to prove they know the secret in this file")
+	raftID := flag.String("raft-id", "", "run as a member of a Raft cluster with this node ID")
+	raftAddr := flag.String("raft-addr", "", "with -raft-id, address for Raft traffic between nodes")
+	raftDir := flag.String("raft-dir", "", "with -raft-id, directory for the Raft log and snapshots")
+	syncFrom := flag.String("sync-from", "", "copy writes from the store whose -replicate listener is at this address")
+	syncKeys := flag.String("sync-keys", "", "with -sync-from, comma-separated key patterns to copy, such as user:*")
+	syncOffset := flag.String("sync-offset", "", "with -sync-from, file that records how far the copy has got (default: the -log path plus .sync)")
+	triggersPath := flag.String("triggers", "", "register the triggers in this file, one per line as in the trigger set command")
+	webhookURL := flag.String("webhook", "", "POST every change as JSON to this HTTP endpoint")
+	webhookKeys := flag.String("webhook-keys", "", "with -webhook, comma-separated key patterns to send changes for, such as user:*")
+	connectorURL := flag.String("connector", "", "publish every change to a Kafka topic or NATS subject, given as kafka://host:port/topic or nats://host:port/subject")
+	connectorFormat := flag.String("connector-format", "json", "with -connector, how changes are serialized: "+strings.Join(kv.ConnectorFormats, ", "))
+	connectorKeys := flag.String("connector-keys", "", "with -connector, comma-separated key patterns to publish changes for, such as user:*")
+	auditPath := flag.String("audit", "", "append a JSON line for every write, naming the client that made it in server mode, to this file")
+	auditMaxBytes := flag.Int64("audit-max-bytes", 100<<20, "with -audit, rotate the file once it reaches this many bytes; 0 never to rotate it")
+	auditKeep := flag.Int("audit-keep", 5, "with -audit, keep this many rotated files, named by the -audit path plus .1, .2 and so on")
+	failoverID := flag.String("failover-id", "", "with -log and -replicate, take part in automatic failover as the node with this ID")
+	failoverPeers := flag.String("failover-peers", "", "with -failover-id, comma-separated id=addr list of every node's -replicate address")
+	clusterID := flag.String("cluster-id", "", "in server mode, partition keys across a cluster as the node with this ID")
+	clusterNodes := flag.String("cluster-nodes", "", "with -cluster-id, comma-separated id=addr list of this node and any others to join, by -listen address")
+	clusterSecretFile := flag.String("cluster-secret-file", "", "with -cluster-id, require other nodes to prove they know the secret in this file before they gossip, move keys or forward commands, needed unless -listen is a loopback address; with -proxy-nodes, prove to the nodes that the proxy knows it")
+	hintLimit := flag.Int("hint-limit", 10000, "with -cluster-id, writes this node queues for each unreachable node and hands off when it returns; 0 to fail them instead")
+	rebalanceRate := flag.Int("rebalance-rate", 1000, "with -cluster-id, keys per second this node moves to their new owners after the ring changes; 0 for no limit")
+	raftPeers := flag.String("raft-peers", "", "with -raft-id, comma-separated id=addr list to bootstrap a new cluster")
+	proxyNodes := flag.String("proxy-nodes", "", "with -listen, hold no data and route commands to these shard nodes, a comma-separated id=addr list of -listen addresses")
+	crdtNode := flag.String("crdt-node", "", "with -replicate, accept writes as a multi-master node with this name")
+	crdtMerge := flag.String("crdt", "lww", "with -crdt-node, how concurrent writes merge: lww (whole entries) or attr (each attribute)")
+	crdtPeers := flag.String("crdt-peers", "", "with -crdt-node, comma-separated -replicate addresses of the other nodes")
+	configPath := flag.String("config", "", "read settings for the flags not given from this YAML file, by flag name; KV_CONFIG names it too")
+	noteSettingEnvs(flag.CommandLine)
+	flag.Parse()
+	if *configPath == "" {
+		*configPath = os.Getenv("KV_CONFIG")
+	}
+	aliasPath = *configPath
+	if aliasPath == "" {
+		aliasPath = defaultConfigPath()
+		if _, err := os.Stat(aliasPath); err == nil {
+			*configPath = aliasPath
+		}
+	}
+	if err := loadSettings(flag.CommandLine, *configPath); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitUsage)
+	}
+	var err error
+	if aliases, err = readAliases(*configPath); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitUsage)
+	}
+
+	// A command given as -c or as the arguments runs once; its exit status
+	// is set once the deferred cleanup has run
+	var words []string
+	var quoted []bool
+	commandSet := false
+	flag.Visit(func(f *flag.Flag) { commandSet = commandSet || f.Name == "c" })
+	switch {
+	case commandSet && flag.NArg() > 0:
+		fmt.Fprintln(os.Stderr, "Error: give the command either with -c or as arguments")
+		os.Exit(exitUsage)
+	case commandSet:
+		var err error
+		if words, quoted, err = splitCommandLine(*commandLine); err == nil && len(words) == 0 {
+			err = errors.New("-c needs a command")
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(exitUsage)
+		}
+	case flag.NArg() > 0:
+		// The shell has already split and unquoted the arguments
+		words, quoted = flag.Args(), make([]bool, flag.NArg())
+	}
+	keepGoing, err := parseOnError(*onError)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitUsage)
+	}
+	if outputFormat, err = parseOutputFormat(*output); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitUsage)
+	}
+	if quiet && verbose {
+		fmt.Fprintln(os.Stderr, "Error: -q and -v can't be combined")
+		os.Exit(exitUsage)
+	}
+	if *historySize < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -history-size can't be negative")
+		os.Exit(exitUsage)
+	}
+	var stdout io.Writer = os.Stdout
+	if colorOutput = useColor(os.Stdout, *noColor); colorOutput {
+		stdout = colorWriter{os.Stdout}
+	}
+	if quiet {
+		stdout = quietWriter{stdout}
+	}
+	if *scriptPath != "" && words != nil {
+		fmt.Fprintln(os.Stderr, "Error: -file can't be combined with a command to run")
+		os.Exit(exitUsage)
+	}
+	if (words != nil || *scriptPath != "") && (*listen != "" || *proxyNodes != "") {
+		fmt.Fprintln(os.Stderr, "Error: a command to run can't be combined with -listen or -proxy-nodes")
+		os.Exit(exitUsage)
+	}
+	if *dryRunFlag {
+		if *listen != "" || *proxyNodes != "" {
+			fmt.Fprintln(os.Stderr, "Error: -dry-run is for the CLI and can't be combined with -listen or -proxy-nodes")
+			os.Exit(exitUsage)
+		}
+		dryRun = newDryRun()
+	}
+	// From here on a failure sets status and returns, so that the deferred
+	// cleanup, closing the store among it, runs before the exit
+	status := exitOK
+	defer func() {
+		if status != exitOK {
+			os.Exit(status)
+		}
+	}()
+
+	if *proxyNodes != "" {
+		if *listen == "" {
+			fmt.Fprintln(os.Stderr, "Error: -proxy-nodes needs -listen")
+			status = exitUsage
+			return
+		}
+		var secret string
+		if *clusterSecretFile != "" {
+			data, err := os.ReadFile(*clusterSecretFile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				status = exitUsage
+				return
+			}
+			if secret = strings.TrimSpace(string(data)); secret == "" {
+				fmt.Fprintln(os.Stderr, "Error:", *clusterSecretFile, "is empty")
+				status = exitUsage
+				return
+			}
+		}
+		proxy, err := kv.NewProxy(strings.Split(*proxyNodes, ","), secret)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			status = exitUsage
+			return
+		}
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			proxy.Close()
+		}()
+
+		fmt.Printf("Proxying on %s\n", *listen)
+		if err := proxy.ListenAndServe(*listen); err != nil && !errors.Is(err, net.ErrClosed) {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			status = exitFailed
+			return
+		}
+		return
+	}
+
+	var opts []kv.Option
+	if *maxMemory > 0 {
+		policy, err := kv.ParseEvictionPolicy(*evictionName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			status = exitUsage
+			return
+		}
+		opts = append(opts, kv.WithMaxMemory(*maxMemory, policy))
+	}
+	quotas, err := quotaOptions(*quotaKeys, *quotaMemory)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		status = exitUsage
+		return
+	}
+	opts = append(opts, quotas...)
+	codec, err := kv.ParseCodec(*codecName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		status = exitUsage
+		return
+	}
+	opts = append(opts, kv.WithCodec(codec))
+	if *logLevel == "" {
+		*logLevel = "warn"
+		if *listen != "" {
+			*logLevel = "info"
+		}
+	}
+	logger, err := newLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		status = exitUsage
+		return
+	}
+	opts = append(opts, kv.WithLogger(logger))
+	if *otlpEndpoint != "" {
+		tp, err := newTracerProvider(*otlpEndpoint, *traceRatio)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			status = exitUsage
+			return
+		}
+		defer tp.Shutdown(context.Background())
+		opts = append(opts, kv.WithTracerProvider(tp))
+	}
+	store := kv.NewStore(opts...)
+	if *logPath != "" {
+		durability, err := kv.ParseDurability(*durabilityName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			status = exitUsage
+			return
+		}
+		if store, err = kv.OpenStore(*logPath, append(opts, kv.WithDurability(durability))...); err != nil {
+			status = commandError(os.Stderr, err)
+			return
+		}
+	}
+	defer store.Close()
+
+	if *raftID != "" {
+		if *logPath != "" || *replicate != "" || *follow != "" {
+			fmt.Fprintln(os.Stderr, "Error: -raft-id can't be combined with -log, -replicate or -follow")
+			status = exitUsage
+			return
+		}
+		var peers []string
+		if *raftPeers != "" {
+			peers = strings.Split(*raftPeers, ",")
+		}
+		node, err := store.StartRaft(kv.RaftConfig{NodeID: *raftID, Addr: *raftAddr, Dir: *raftDir, Peers: peers})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			status = exitFailed
+			return
+		}
+		defer node.Shutdown()
+	}
+
+	if *replCert != "" || *replSecretFile != "" {
+		var tlsConfig *tls.Config
+		if *replCert != "" {
+			var err error
+			if tlsConfig, err = kv.LoadReplicationTLS(*replCert, *replKey, *replCA); err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				status = exitUsage
+				return
+			}
+		}
+		var secret string
+		if *replSecretFile != "" {
+			data, err := os.ReadFile(*replSecretFile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				status = exitUsage
+				return
+			}
+			if secret = strings.TrimSpace(string(data)); secret == "" {
+				fmt.Fprintln(os.Stderr, "Error:", *replSecretFile, "is empty")
+				status = exitUsage
+				return
+			}
+		}
+		store.SecureReplication(tlsConfig, secret)
+	}
+
+	if *replicate != "" {
+		leader := kv.NewLeader(store)
+		leader.ClientAddr = *listen
+		defer leader.Close()
+		go func() {
+			if err := leader.ListenAndServe(*replicate); err != nil && !errors.Is(err, net.ErrClosed) {
+				fmt.Fprintln(os.Stderr, "Error: replication listener:", err)
+			}
+		}()
+	}
+	if *seed != "" {
+		if *follow == "" {
+			fmt.Fprintln(os.Stderr, "Error: -seed needs -follow")
+			status = exitUsage
+			return
+		}
+		// A follower restarted with the same flags already has the data
+		if store.Seq() == 0 {
+			seq, err := store.SeedFromBackup(*seed)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				status = exitFailed
+				return
+			}
+			fmt.Fprintf(os.Stderr, "Seeded from %s at sequence %d\n", *seed, seq)
+		}
+	}
+	if *follow != "" {
+		if _, err := store.Follow(*follow); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			status = exitFailed
+			return
+		}
+	}
+
+	if *syncFrom != "" {
+		var patterns []string
+		if *syncKeys != "" {
+			patterns = strings.Split(*syncKeys, ",")
+		}
+		offsetPath := *syncOffset
+		if offsetPath == "" && *logPath != "" {
+			offsetPath = *logPath + ".sync"
+		}
+		link, err := store.StartSync(*syncFrom, patterns, offsetPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			status = exitUsage
+			return
+		}
+		defer link.Close()
+	}
+
+	if *triggersPath != "" {
+		if err := store.LoadTriggers(*triggersPath); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			status = exitUsage
+			return
+		}
+	}
+
+	if *auditPath != "" {
+		audit, err := store.StartAudit(*auditPath, kv.AuditOptions{MaxBytes: *auditMaxBytes, Keep: *auditKeep})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			status = exitUsage
+			return
+		}
+		defer audit.Close()
+	}
+
+	var adminSecret string
+	if *adminSecretFile != "" {
+		data, err := os.ReadFile(*adminSecretFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			status = exitUsage
+			return
+		}
+		if adminSecret = strings.TrimSpace(string(data)); adminSecret == "" {
+			fmt.Fprintln(os.Stderr, "Error:", *adminSecretFile, "is empty")
+			status = exitUsage
+			return
+		}
+	}
+
+	if *metricsAddr != "" {
+		ln, err := listenMetrics(store, *metricsAddr, adminSecret)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			status = exitUsage
+			return
+		}
+		defer ln.Close()
+	}
+
+	if *adminAddr != "" {
+		ln, err := listenAdmin(store, *adminAddr, adminSecret, *backupRoot, *mutexFraction)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			status = exitUsage
+			return
+		}
+		defer ln.Close()
+	}
+
+	if *webhookURL != "" {
+		var patterns []string
+		if *webhookKeys != "" {
+			patterns = strings.Split(*webhookKeys, ",")
+		}
+		webhook, err := store.StartWebhook(*webhookURL, patterns)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			status = exitUsage
+			return
+		}
+		defer webhook.Close()
+	}
+
+	if *connectorURL != "" {
+		var patterns []string
+		if *connectorKeys != "" {
+			patterns = strings.Split(*connectorKeys, ",")
+		}
+		connector, err := store.StartConnector(*connectorURL, *connectorFormat, patterns)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			status = exitUsage
+			return
+		}
+		defer connector.Close()
+	}
+
+	if *crdtNode != "" {
+		if *replicate == "" || *follow != "" || *raftID != "" {
+			fmt.Fprintln(os.Stderr, "Error: -crdt-node needs -replicate and can't be combined with -follow or -raft-id")
+			status = exitUsage
+			return
+		}
+		mode, err := kv.ParseCRDTMode(*crdtMerge)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			status = exitUsage
+			return
+		}
+		if err := store.EnableCRDT(*crdtNode, mode); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			status = exitUsage
+			return
+		}
+		if *crdtPeers != "" {
+			for _, peer := range strings.Split(*crdtPeers, ",") {
+				offsetPath := ""
+				if *logPath != "" {
+					offsetPath = *logPath + ".crdt-" + strings.NewReplacer(":", "_", "/", "_").Replace(peer)
+				}
+				link, err := store.StartSync(peer, nil, offsetPath)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "Error:", err)
+					status = exitUsage
+					return
+				}
+				defer link.Close()
+			}
+		}
+	}
+
+	if *failoverID != "" {
+		if *logPath == "" || *replicate == "" {
+			fmt.Fprintln(os.Stderr, "Error: -failover-id needs -log and -replicate")
+			status = exitUsage
+			return
+		}
+		failover, err := store.StartFailover(*failoverID, strings.Split(*failoverPeers, ","))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			status = exitFailed
+			return
+		}
+		defer failover.Close()
+	}
+
+	if *listen != "" {
+		if *databases < 1 {
+			fmt.Fprintln(os.Stderr, "Error: -databases must be at least 1")
+			status = exitUsage
+			return
+		}
+		srv := kv.NewServer(store)
+		srv.BatchWrites = *batchWrites
+		srv.Databases = *databases
+		if *clusterID != "" {
+			var secret string
+			if *clusterSecretFile != "" {
+				data, err := os.ReadFile(*clusterSecretFile)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "Error:", err)
+					status = exitUsage
+					return
+				}
+				if secret = strings.TrimSpace(string(data)); secret == "" {
+					fmt.Fprintln(os.Stderr, "Error:", *clusterSecretFile, "is empty")
+					status = exitUsage
+					return
+				}
+			} else if !isLoopback(*listen) {
+				fmt.Fprintf(os.Stderr, "Error: -cluster-id on %s, which isn't a loopback address, needs -cluster-secret-file\n", *listen)
+				status = exitUsage
+				return
+			}
+			cluster, err := kv.NewCluster(*clusterID, strings.Split(*clusterNodes, ","), secret)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				status = exitUsage
+				return
+			}
+			defer cluster.Close()
+			cluster.StartRebalance(store, *rebalanceRate)
+			if *hintLimit > 0 {
+				cluster.EnableHints(*hintLimit)
+			}
+			srv.Cluster = cluster
+		}
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			srv.Close()
+		}()
+
+		fmt.Printf("Serving on %s\n", *listen)
+		if err := srv.ListenAndServe(*listen); err != nil && !errors.Is(err, net.ErrClosed) {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			status = exitFailed
+			return
+		}
+		return
+	}
+
+	if *scriptPath != "" {
+		status = runScript(&cliOutput{Writer: stdout}, store, *scriptPath, keepGoing)
+		return
+	}
+	if words != nil {
+		status = runCommand(&cliOutput{Writer: stdout}, store, words, quoted)
+		return
+	}
+	if !isTerminal(os.Stdin) {
+		colorOutput = false
+		status = runBatch(store, os.Stdin, os.Stdout)
+		return
+	}
+
+	editor := newLineEditor(os.Stdin, os.Stdout, *historyPath, *historySize)
+	editor.complete = cliCompleter(store)
+	confirm = func(question string) bool {
+		answer, err := editor.Ask(question)
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return err == nil && (answer == "y" || answer == "yes")
+	}
+
+	if !quiet {
+		fmt.Println("Welcome to the Key-Value Store CLI")
+		displayMenu(os.Stdout)
+	}
+
+	for {
+		line, err := editor.ReadLine(cliPrompt(store))
+		if err == errInterrupted {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		line, err = joinContinuedLines(line, func() (string, bool) {
+			line, err := editor.ReadLine("... ")
+			return line, err == nil
+		})
+		if err != nil {
+			fmt.Fprintln(stdout, "Error:", err)
+			continue
+		}
+		parts, quoted, err := splitCommandLine(line)
+		if err != nil {
+			fmt.Fprintln(stdout, "Error:", err)
+			continue
+		}
+		if len(parts) == 0 {
+			if !quiet {
+				displayMenu(os.Stdout)
+			}
+			continue
+		}
+		if strings.HasPrefix(parts[0], "#") && !quoted[0] {
+			// A comment, as in dump scripts
+			continue
+		}
+		parts, quoted = continueCommand(parts, quoted, func() (string, bool) {
+			line, err := editor.ReadLine("... ")
+			return line, err == nil
+		})
+
+		if parts[0] == "exit" {
+			if !quiet {
+				fmt.Println("Goodbye!")
+			}
+			return
+		}
+		runCommand(&cliOutput{Writer: stdout, limit: terminalLimit(os.Stdout)}, store, parts, quoted)
+	}
+}
+
+// defaultNamespace names the store's keyspace in the prompt
+const defaultNamespace = "default"
+
+// cliPrompt returns the interactive CLI's prompt, kv[default]> , naming the
+// namespace commands run in, with a * after it while the store holds writes
+// a restart would lose, and dry-run in a dry run
+func cliPrompt(store *kv.Store) string {
+	dirty := ""
+	if store.Unsaved() {
+		dirty = "*"
+	}
+	if dryRun != nil {
+		dirty += " dry-run"
+	}
+	return "kv[" + namespaceName() + dirty + "]> "
+}
+
+// CLI exit statuses of a command run from the command line
+const (
+	exitOK       = 0
+	exitFailed   = 1 // the command failed, printing an error
+	exitUsage    = 2 // the command was malformed or unknown
+	exitNotFound = 3 // get found no entry for the key
+	exitType     = 4 // a value had another type than its attribute's
+	exitIO       = 5 // a file, the write log or the network failed
+)
+
+// commandError prints the error a command failed with and returns the exit
+// status it calls for
+func commandError(out io.Writer, err error) int {
+	fmt.Fprintln(out, "Error:", err)
+	var pathErr *os.PathError
+	var linkErr *os.LinkError
+	var syscallErr *os.SyscallError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, kv.ErrTypeMismatch):
+		return exitType
+	case errors.Is(err, kv.ErrValidation):
+		return exitUsage
+	case errors.As(err, &pathErr), errors.As(err, &linkErr), errors.As(err, &syscallErr), errors.As(err, &netErr):
+		return exitIO
+	}
+	return exitFailed
+}
+
+// cliOutput is where a CLI command writes its outcome: text for the user,
+// and for the commands that read the store, their result as data, which
+// batch mode reports as JSON
+type cliOutput struct {
+	io.Writer
+	result   interface{}
+	batch    bool // the command is run in batch mode, without a terminal
+	affected int  // the entries the command wrote or read, for -v, or -1 if it doesn't say
+	examined int  // the entries the command looked at, for time and -timing, or -1 if it doesn't say
+	limit    int  // how many results get, search and keys print, unless given --all; 0 for all
+}
+
+// runCommand executes the CLI command in words, quoted as reported by
+// splitCommandLine, printing its outcome, and returns its exit status
+func runCommand(out *cliOutput, store *kv.Store, parts []string, quoted []bool) int {
+	out.affected, out.examined = -1, -1
+	parts, quoted = expandAlias(parts, quoted)
+	timed := timing
+	if parts[0] == "time" && !quoted[0] {
+		if len(parts) == 1 {
+			fmt.Fprintln(out, "Error: Incorrect number of parameters")
+			fmt.Fprintln(out, "Usage: time <command>")
+			return exitUsage
+		}
+		parts, quoted = expandAlias(parts[1:], quoted[1:])
+		timed = true
+	}
+	parts, err := substituteVariables(parts, quoted)
+	if err != nil {
+		fmt.Fprintln(out, "Error:", err)
+		return exitUsage
+	}
+	start := time.Now()
+	status := execCommand(out, store, parts, quoted)
+	elapsed := time.Since(start)
+	if slices.Contains(cliCommands, parts[0]) {
+		store.RecordLatency(parts[0], elapsed)
+	}
+	if status == exitOK {
+		rememberLastKey(out, parts)
+	}
+	if timed {
+		printTiming(out, elapsed, out.examined)
+	}
+	if verbose {
+		reportCommand(parts, status, elapsed, out.affected)
+	}
+	return status
+}
+
+// execCommand is runCommand without time and the -v report
+func execCommand(out *cliOutput, store *kv.Store, parts []string, quoted []bool) int {
+	command := parts[0]
+	if last := len(parts) - 1; last > 0 && parts[last] == "--all" && !quoted[last] && (command == "get" || command == "mget" || command == "search" || command == "keys" || command == "show") {
+		parts, quoted, out.limit = parts[:last], quoted[:last], 0
+	}
+
+	switch command {
+	case "put":
+		if len(parts) < 4 || len(parts)%2 != 0 {
+			fmt.Fprintln(out, "Error: Incorrect number of parameters")
+			fmt.Fprintln(out, "Usage: put <key> <attribute1> <value1> [<attribute2> <value2> ...]")
+			return exitUsage
+		}
+		key := parts[1]
+		ks := keyspace(store)
+		if dryRun != nil {
+			return dryRun.put(out, store, ks.Key(key), typedAttributes(parts[2:], quoted[2:]))
+		}
+		var err error
+		if slices.Contains(quoted[2:], true) {
+			err = ks.PutValues(key, typedAttributes(parts[2:], quoted[2:]))
+		} else {
+			err = ks.Put(key, kv.AttributePairs(parts[2:]))
+		}
+		if err != nil {
+			return commandError(out, err)
+		}
+		out.affected, out.examined = 1, 1
+		fmt.Fprintln(out, "Success: Put operation completed")
+
+	case "putjson":
+		if len(parts) != 3 {
+			fmt.Fprintln(out, "Error: Incorrect number of parameters")
+			fmt.Fprintln(out, "Usage: putjson <key> <JSON object>, or putjson <key> and the object on the lines after it, up to a blank line")
+			return exitUsage
+		}
+		attrs, err := parseJSONEntry(parts[2])
+		if err != nil {
+			fmt.Fprintln(out, "Error:", err)
+			return exitUsage
+		}
+		ks := keyspace(store)
+		if dryRun != nil {
+			return dryRun.put(out, store, ks.Key(parts[1]), attrs)
+		}
+		if err := ks.PutValues(parts[1], attrs); err != nil {
+			return commandError(out, err)
+		}
+		out.affected, out.examined = 1, 1
+		fmt.Fprintln(out, "Success: Put operation completed")
+
+	case "get":
+		if len(parts) != 2 {
+			fmt.Fprintln(out, "Error: Incorrect number of parameters")
+			fmt.Fprintln(out, "Usage: get <key> [--all]")
+			return exitUsage
+		}
+		key := parts[1]
+		value := keyspace(store).Get(key)
+		out.examined = 1
+		if value == nil {
+			if outputFormat == "json" {
+				fmt.Fprintln(out, "null")
+			} else {
+				fmt.Fprintf(out, "No entry found for key: %s\n", key)
+			}
+			out.affected = 0
+			return exitNotFound
+		}
+		out.result, out.affected = value, 1
+		printEntry(out, value)
+
+	case "mget":
+		if len(parts) < 2 {
+			fmt.Fprintln(out, "Error: Incorrect number of parameters")
+			fmt.Fprintln(out, "Usage: mget <key1> [<key2> ...] [--all]")
+			return exitUsage
+		}
+		keys := parts[1:]
+		entries, missing := keyspace(store).MGet(keys)
+		out.affected, out.examined = len(entries), len(keys)
+		printMGet(out, keys, entries, missing)
+		if len(entries) == 0 {
+			return exitNotFound
+		}
+
+	case "delete":
+		parts, quoted, force := cutFlag(parts, quoted, "--force")
+		if len(parts) == 3 && parts[1] == "--pattern" && !quoted[1] {
+			return deleteKeys(out, store, parts[2], force)
+		}
+		if len(parts) != 2 {
+			fmt.Fprintln(out, "Error: Incorrect number of parameters")
+			fmt.Fprintln(out, "Usage: delete <key> | delete --pattern <key pattern> [--force]")
+			return exitUsage
+		}
+		key := parts[1]
+		ks := keyspace(store)
+		if dryRun != nil {
+			return dryRun.delete(out, store, ks.Key(key))
+		}
+		existed := ks.Get(key) != nil
+		if err := ks.Delete(key); err != nil {
+			return commandError(out, err)
+		}
+		if out.affected = 0; existed {
+			out.affected = 1
+		}
+		out.examined = 1
+		fmt.Fprintln(out, "Success: Delete operation completed")
+
+	case "search":
+		if len(parts) != 3 {
+			fmt.Fprintln(out, "Error: Incorrect number of parameters")
+			fmt.Fprintln(out, "Usage: search <attribute> <value> [--all]")
+			return exitUsage
+		}
+		attrKey := parts[1]
+		var value interface{} = parts[2]
+		if !quoted[2] {
+			_, value, _ = kv.DetermineType(parts[2])
+		}
+		ks := keyspace(store)
+		results, examined, _ := ks.SearchValueCount(context.Background(), attrKey, value)
+		out.result, out.affected, out.examined = results, len(results), examined
+		printKeys(out, ks, results, "Found keys:", "No matching entries found", true)
+
+	case "keys":
+		ks := keyspace(store)
+		keys := ks.Keys()
+		out.result, out.affected, out.examined = keys, len(keys), len(keys)
+		printKeys(out, ks, keys, "All keys:", "Store is empty", false)
+
+	case "show":
+		if len(parts) != 2 {
+			fmt.Fprintln(out, "Error: Incorrect number of parameters")
+			fmt.Fprintln(out, "Usage: show <key pattern> [--all]")
+			return exitUsage
+		}
+		ks := keyspace(store)
+		keys, examined, err := ks.KeysMatchingCount(parts[1])
+		if err != nil {
+			fmt.Fprintln(out, "Error:", err)
+			return exitUsage
+		}
+		out.affected, out.examined = len(keys), examined
+		printEntries(out, ks, keys)
+
+	case "format":
+		if len(parts) > 2 {
+			fmt.Fprintln(out, "Error: Incorrect number of parameters")
+			fmt.Fprintln(out, "Usage: format [json|table|plain]")
+			return exitUsage
+		}
+		if len(parts) == 2 {
+			format, err := parseOutputFormat(parts[1])
+			if err != nil {
+				fmt.Fprintln(out, "Error:", err)
+				return exitUsage
+			}
+			outputFormat = format
+		}
+		fmt.Fprintf(out, "Output format: %s\n", outputFormat)
+
+	case "promote":
+		if dryRun != nil {
+			addr, following := store.LeaderAddr()
+			if !following {
+				fmt.Fprintln(out, "Error: store is not following a leader")
+				return exitFailed
+			}
+			fmt.Fprintf(out, "Dry run: would stop following %s and accept writes\n", addr)
+			return exitOK
+		}
+		if err := store.Promote(); err != nil {
+			return commandError(out, err)
+		}
+		fmt.Fprintln(out, "Success: Store promoted to leader")
+
+	case "role":
+		if node := store.RaftNode(); node != nil {
+			if addr, known := node.Leader(); known {
+				fmt.Fprintf(out, "Raft %s, leader at %s\n", node.State(), addr)
+			} else {
+				fmt.Fprintf(out, "Raft %s, no leader\n", node.State())
+			}
+		} else if addr, following := store.LeaderAddr(); following {
+			fmt.Fprintf(out, "Follower of %s\n", addr)
+		} else {
+			fmt.Fprintln(out, "Leader")
+		}
+
+	case "replication":
+		fmt.Fprintln(out, strings.Join(store.ReplicationInfo(), "\n"))
+
+	case "info":
+		if len(parts) > 2 {
+			fmt.Fprintln(out, "Error: Incorrect number of parameters")
+			fmt.Fprintln(out, "Usage: info [<section>]")
+			return exitUsage
+		}
+		section := ""
+		if len(parts) == 2 {
+			section = parts[1]
+		}
+		lines, err := store.Info(section)
+		if err != nil {
+			fmt.Fprintf(out, "Error: %v\n", err)
+			return exitUsage
+		}
+		fmt.Fprintln(out, strings.Join(lines, "\n"))
+
+	case "quota":
+		return quotaCommand(out, store, parts)
+
+	case "stats":
+		switch {
+		case len(parts) == 1:
+			fmt.Fprintln(out, strings.Join(store.Stats().Info(), "\n"))
+		case len(parts) == 2 && parts[1] == "reset":
+			store.ResetStats()
+			fmt.Fprintln(out, "Success: Operation counters reset")
+		case (len(parts) == 2 || len(parts) == 3) && parts[1] == "attributes":
+			stats, _ := store.AttributeStats(context.Background())
+			if len(parts) == 2 {
+				for _, st := range stats {
+					fmt.Fprintln(out, st.Info())
+				}
+				break
+			}
+			i := slices.IndexFunc(stats, func(st kv.AttributeStats) bool { return st.Name == parts[2] })
+			if i < 0 {
+				fmt.Fprintf(out, "Error: No entry has attribute %s\n", parts[2])
+				return exitFailed
+			}
+			fmt.Fprintln(out, stats[i].Info())
+			for _, line := range stats[i].Details() {
+				fmt.Fprintln(out, line)
+			}
+		default:
+			fmt.Fprintln(out, "Error: Incorrect parameters")
+			fmt.Fprintln(out, "Usage: stats [reset | attributes [<attribute>]]")
+			return exitUsage
+		}
+
+	case "latency":
+		switch {
+		case len(parts) == 1:
+			for _, st := range store.Latency() {
+				fmt.Fprintln(out, st.Info())
+			}
+		case len(parts) == 2 && parts[1] == "reset":
+			store.ResetLatency()
+			fmt.Fprintln(out, "Success: Latency histograms reset")
+		case len(parts) == 2:
+			st, buckets, found := store.CommandLatency(parts[1])
+			if !found {
+				fmt.Fprintf(out, "Error: No latency recorded for %s\n", parts[1])
+				return exitFailed
+			}
+			fmt.Fprintln(out, st.Info())
+			for _, b := range buckets {
+				fmt.Fprintln(out, b.Info())
+			}
+		default:
+			fmt.Fprintln(out, "Error: Incorrect parameters")
+			fmt.Fprintln(out, "Usage: latency [<command> | reset]")
+			return exitUsage
+		}
+
+	case "backup":
+		if len(parts) != 2 {
+			fmt.Fprintln(out, "Error: Incorrect number of parameters")
+			fmt.Fprintln(out, "Usage: backup <dir>")
+			return exitUsage
+		}
+		m, err := store.BackupDir(parts[1])
+		if err != nil {
+			return commandError(out, err)
+		}
+		fmt.Fprintf(out, "Success: Backup at sequence %d written to %s\n", m.Seq, parts[1])
+
+	case "restore":
+		parts, _, force := cutFlag(parts, quoted, "--force")
+		if len(parts) != 2 {
+			fmt.Fprintln(out, "Error: Incorrect number of parameters")
+			fmt.Fprintln(out, "Usage: restore <dir> [--force]")
+			return exitUsage
+		}
+		if dryRun != nil {
+			return dryRun.restore(out, store, parts[1])
+		}
+		if n := len(store.Keys()); n > 0 {
+			if status := confirmDestructive(out, force, fmt.Sprintf("replace the store's %d entries with the backup", n)); status != exitOK {
+				return status
+			}
+		}
+		m, err := store.Restore(parts[1])
+		if err != nil {
+			return commandError(out, err)
+		}
+		fmt.Fprintf(out, "Success: Restored %s, taken %s, at sequence %d\n", parts[1], m.Created.Local().Format(time.DateTime), m.Seq)
+
+	case "diff":
+		if len(parts) != 2 && len(parts) != 3 {
+			fmt.Fprintln(out, "Error: Incorrect number of parameters")
+			fmt.Fprintln(out, "Usage: diff <backup> [<backup>]")
+			return exitUsage
+		}
+		var d *kv.StoreDiff
+		var err error
+		if len(parts) == 3 {
+			d, err = kv.DiffBackups(parts[1], parts[2])
+		} else {
+			d, err = store.DiffBackup(parts[1])
+		}
+		if err != nil {
+			return commandError(out, err)
+		}
+		printDiff(out, d)
+
+	case "export":
+		filter, err := parseExportFilter(parts[min(3, len(parts)):], quoted[min(3, len(parts)):])
+		if len(parts) < 3 || err != nil {
+			fmt.Fprintln(out, "Error: Incorrect parameters")
+			fmt.Fprintln(out, "Usage: export json|yaml|protobuf|gob|msgpack|parquet|sqlite <file> [<key pattern>] [where <attribute> <value>]")
+			return exitUsage
+		}
+		n, err := store.ExportFile(parts[2], parts[1], filter)
+		if err != nil {
+			return commandError(out, err)
+		}
+		out.affected = n
+		fmt.Fprintf(out, "Success: Exported %d entries to %s\n", n, parts[2])
+
+	case "dump":
+		filter, err := parseExportFilter(parts[min(3, len(parts)):], quoted[min(3, len(parts)):])
+		if len(parts) < 3 || parts[1] != "script" || err != nil {
+			fmt.Fprintln(out, "Error: Incorrect parameters")
+			fmt.Fprintln(out, "Usage: dump script <file> [<key pattern>] [where <attribute> <value>]")
+			return exitUsage
+		}
+		n, err := store.DumpScriptFile(parts[2], filter)
+		if err != nil {
+			return commandError(out, err)
+		}
+		out.affected = n
+		fmt.Fprintf(out, "Success: Dumped %d entries to %s\n", n, parts[2])
+
+	case "import":
+		opts := kv.ImportOptions{DryRun: dryRun != nil}
+		args, err := parseImportFlags(parts[1:], &opts)
+		kvImport := len(args) > 0 && (args[0] == "etcd" || args[0] == "consul")
+		if err != nil || len(args) != 2 && !(kvImport && len(args) == 3) {
+			fmt.Fprintln(out, "Error: Incorrect parameters")
+			fmt.Fprintln(out, "Usage: import json|yaml|protobuf|gob|msgpack <file> | import csv <file> [--key-column <column>] | import rdb <file> [--db <n>] | import redis <host:port> [--db <n>] | import etcd|consul <host:port> [<prefix>] [--trim-prefix], each with optional --bulk and --on-conflict overwrite|skip|merge|abort")
+			return exitUsage
+		}
+		var report *kv.ImportReport
+		source := args[1]
+		redact := func() {
+			if u, parseErr := url.Parse(source); parseErr == nil && u.User != nil {
+				source = u.Redacted()
+			}
+		}
+		switch args[0] {
+		case "redis":
+			report, err = store.ImportRedis(source, opts)
+			redact()
+		case "etcd", "consul":
+			prefix := ""
+			if len(args) == 3 {
+				prefix = args[2]
+			}
+			if args[0] == "etcd" {
+				report, err = store.ImportEtcd(source, prefix, opts)
+			} else {
+				opts.Token = os.Getenv("CONSUL_HTTP_TOKEN")
+				report, err = store.ImportConsul(source, prefix, opts)
+			}
+			redact()
+		default:
+			report, err = store.ImportFile(source, args[0], opts)
+		}
+		if err != nil {
+			return commandError(out, err)
+		}
+		if dryRun == nil {
+			out.affected = report.Imported
+		}
+		printImportReport(out, report, source)
+
+	case "raft":
+		node := store.RaftNode()
+		if node == nil {
+			fmt.Fprintln(out, "Error: Store is not running in Raft mode")
+			return exitFailed
+		}
+		if dryRun != nil {
+			fmt.Fprintln(out, "Error: raft", errDryRun)
+			return exitFailed
+		}
+		var err error
+		switch {
+		case len(parts) == 4 && parts[1] == "add":
+			err = node.AddVoter(parts[2], parts[3])
+		case len(parts) == 3 && parts[1] == "remove":
+			err = node.RemoveServer(parts[2])
+		default:
+			fmt.Fprintln(out, "Error: Incorrect parameters")
+			fmt.Fprintln(out, "Usage: raft add <id> <addr> | raft remove <id>")
+			return exitUsage
+		}
+		if err != nil {
+			return commandError(out, err)
+		}
+		fmt.Fprintln(out, "Success: Cluster membership updated")
+
+	case "watch":
+		if len(parts) != 2 {
+			fmt.Fprintln(out, "Error: Incorrect number of parameters")
+			fmt.Fprintln(out, "Usage: watch <key|pattern>")
+			return exitUsage
+		}
+		if out.batch {
+			fmt.Fprintln(out, "Error: watch runs until interrupted and needs a terminal")
+			return exitFailed
+		}
+		if err := watchChanges(store, parts[1]); err != nil {
+			return commandError(out, err)
+		}
+
+	case "monitor":
+		if len(parts) != 2 {
+			fmt.Fprintln(out, "Error: Incorrect number of parameters")
+			fmt.Fprintln(out, "Usage: monitor <addr>")
+			return exitUsage
+		}
+		if out.batch {
+			fmt.Fprintln(out, "Error: monitor runs until interrupted and needs a terminal")
+			return exitFailed
+		}
+		if err := monitorServer(parts[1]); err != nil {
+			return commandError(out, err)
+		}
+
+	case "source":
+		keepGoing, valid := false, len(parts) == 2
+		if len(parts) == 4 && parts[2] == "--on-error" {
+			var err error
+			keepGoing, err = parseOnError(parts[3])
+			valid = err == nil
+		}
+		if !valid {
+			fmt.Fprintln(out, "Error: Incorrect parameters")
+			fmt.Fprintln(out, "Usage: source <file> [--on-error stop|continue]")
+			return exitUsage
+		}
+		return runScript(out, store, parts[1], keepGoing)
+
+	case "flush":
+		parts, _, force := cutFlag(parts, quoted, "--force")
+		if len(parts) != 1 {
+			fmt.Fprintln(out, "Error: Incorrect number of parameters")
+			fmt.Fprintln(out, "Usage: flush [--force]")
+			return exitUsage
+		}
+		return deleteKeys(out, store, "*", force)
+
+	case "bench":
+		return benchCommand(out, store, parts)
+
+	case "dryrun":
+		return dryRunCommand(out, parts)
+
+	case "alias", "unalias":
+		return aliasCommand(out, parts, quoted)
+
+	case "set", "unset":
+		return setCommand(out, parts, quoted)
+
+	case "help":
+		return helpCommand(out, parts)
+
+	case "use":
+		return useCommand(out, store, parts)
+
+	default:
+		fmt.Fprintf(out, "Unknown command: %s\n", command)
+		fmt.Fprintln(out, "Type 'help' to see available commands")
+		return exitUsage
+	}
+	return exitOK
+}
+
+// cutFlag removes the unquoted word flag from parts, reporting whether it
+// was there
+func cutFlag(parts []string, quoted []bool, flag string) ([]string, []bool, bool) {
+	for i := 1; i < len(parts); i++ {
+		if parts[i] == flag && !quoted[i] {
+			return slices.Delete(slices.Clone(parts), i, i+1), slices.Delete(slices.Clone(quoted), i, i+1), true
+		}
+	}
+	return parts, quoted, false
+}
+
+// confirm asks the user a yes or no question in the interactive CLI. It is
+// nil elsewhere, where commands that delete or replace many entries need
+// --force instead.
+var confirm func(question string) bool
+
+// confirmDestructive returns exitOK if a command may go ahead and do what,
+// a change to many entries: given --force, or once the user agrees.
+// Otherwise it prints why not and returns the command's status.
+func confirmDestructive(out io.Writer, force bool, what string) int {
+	switch {
+	case force:
+		return exitOK
+	case confirm == nil:
+		fmt.Fprintf(out, "Error: this would %s; add --force to go ahead\n", what)
+		return exitUsage
+	case !confirm("This would " + what + ". Go ahead? [y/N] "):
+		fmt.Fprintln(out, "Error: Cancelled, nothing was changed")
+		return exitFailed
+	}
+	return exitOK
+}
+
+// deleteKeys deletes the entries of the CLI's namespace whose names match
+// pattern, or all of them for *, as flush and delete --pattern do, once
+// confirmed
+func deleteKeys(out *cliOutput, store *kv.Store, pattern string, force bool) int {
+	var keys []string
+	if dryRun != nil {
+		keys = dryRun.keys(store)
+	} else {
+		keys = store.Keys()
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		fmt.Fprintf(out, "Error: bad key pattern %q: %v\n", pattern, err)
+		return exitUsage
+	}
+	ks := keyspace(store)
+	keys = slices.DeleteFunc(keys, func(key string) bool {
+		_, in := ks.Local(key)
+		return !in
+	})
+	out.examined = len(keys)
+	keys = slices.DeleteFunc(keys, func(key string) bool {
+		name, _ := ks.Local(key)
+		ok, _ := path.Match(pattern, name)
+		return !ok && pattern != "*"
+	})
+	if dryRun != nil {
+		return dryRun.deleteKeys(out, store, keys)
+	}
+	if len(keys) == 0 {
+		fmt.Fprintln(out, "Success: No entries to delete")
+		out.affected = 0
+		return exitOK
+	}
+	what := fmt.Sprintf("delete %d entries matching %s", len(keys), pattern)
+	if pattern == "*" {
+		what = fmt.Sprintf("delete all %d entries", len(keys))
+	}
+	if status := confirmDestructive(out, force, what); status != exitOK {
+		return status
+	}
+	n, err := store.DeleteKeys(keys)
+	if err != nil {
+		return commandError(out, err)
+	}
+	out.affected = n
+	fmt.Fprintf(out, "Success: Deleted %d entries\n", n)
+	return exitOK
+}
+
+// maxCommandLine caps the length of a line the CLI reads, which for a put
+// from a dump script holds a whole entry
+const maxCommandLine = 16 << 20
+
+// maxScriptDepth caps how deeply scripts can source other scripts, so one
+// that sources itself fails instead of recursing forever
+const maxScriptDepth = 16
+
+// scriptDepth is the number of scripts being run. The CLI runs one command
+// at a time, so it needs no lock.
+var scriptDepth int
+
+// parseOnError parses the --on-error option of a script, reporting whether
+// the script continues after a failing command
+func parseOnError(value string) (bool, error) {
+	switch value {
+	case "stop":
+		return false, nil
+	case "continue":
+		return true, nil
+	}
+	return false, fmt.Errorf("bad --on-error %q; want stop or continue", value)
+}
+
+// runScript runs the CLI commands in the file at path line by line,
+// skipping blank lines and # comments, until its end or an exit command. A
+// failing command stops the script unless keepGoing. Each failure is
+// reported with its line number, and the script's status is that of its
+// first failing command.
+func runScript(out *cliOutput, store *kv.Store, path string, keepGoing bool) int {
+	if scriptDepth == maxScriptDepth {
+		fmt.Fprintf(out, "Error: %s: scripts nested more than %d deep\n", path, maxScriptDepth)
+		return exitFailed
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return commandError(out, err)
+	}
+	defer file.Close()
+	scriptDepth++
+	defer func() { scriptDepth-- }()
+
+	status, commands := exitOK, 0
+	var failed []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxCommandLine)
+	for n := 1; scanner.Scan(); n++ {
+		start, first := n, scanner.Text()
+		text, err := joinContinuedLines(first, func() (string, bool) {
+			if !scanner.Scan() {
+				return "", false
+			}
+			n++
+			return scanner.Text(), true
+		})
+		var parts []string
+		var quoted []bool
+		if err == nil {
+			parts, quoted, err = splitCommandLine(text)
+		} else {
+			text = first
+		}
+		if err == nil && (len(parts) == 0 || strings.HasPrefix(parts[0], "#") && !quoted[0]) {
+			continue
+		}
+		if err == nil {
+			parts, quoted = continueCommand(parts, quoted, func() (string, bool) {
+				if !scanner.Scan() {
+					return "", false
+				}
+				n++
+				return scanner.Text(), true
+			})
+		}
+		if err == nil && parts[0] == "exit" {
+			break
+		}
+		commands++
+		lineStatus := exitUsage
+		if err != nil {
+			fmt.Fprintln(out, "Error:", err)
+		} else {
+			lineStatus = runCommand(out, store, parts, quoted)
+		}
+		if lineStatus == exitOK {
+			continue
+		}
+		fmt.Fprintf(out, "Error: %s line %d failed: %s\n", path, start, text)
+		failed = append(failed, strconv.Itoa(start))
+		if status == exitOK {
+			status = lineStatus
+		}
+		if !keepGoing {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(out, "Error: reading %s: %v\n", path, err)
+		return exitIO
+	}
+
+	if len(failed) == 0 {
+		fmt.Fprintf(out, "Success: Ran %d commands from %s\n", commands, path)
+	} else {
+		lines := "lines"
+		if len(failed) == 1 {
+			lines = "line"
+		}
+		fmt.Fprintf(out, "Error: %d of %d commands from %s failed, on %s %s\n", len(failed), commands, path, lines, strings.Join(failed, ", "))
+	}
+	return status
+}
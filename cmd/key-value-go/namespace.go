@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	kv "github.com/dsapoetra/key-value-go/pkg/store"
+)
+
+// The CLI runs its data commands in a namespace, named in the prompt:
+// after use tenantA, put user1 age 30 writes tenantA/user1, and keys,
+// search and show list only tenantA's entries, by their names within it.
+// The default namespace sees the whole store, every key as it is, so
+// tenantA/user1 can be read there too. Commands that work on the whole
+// store, such as export, backup and watch, ignore the namespace.
+
+// namespace is the namespace the CLI's data commands run in, "" for the
+// default one
+var namespace string
+
+// keyspace returns the view of store the data commands run in
+func keyspace(store *kv.Store) *kv.Namespace {
+	ns, _ := store.Namespace(namespace) // use checked the name
+	return ns
+}
+
+// namespaceName returns the name of the CLI's namespace, as the prompt and
+// use print it
+func namespaceName() string {
+	if namespace == "" {
+		return defaultNamespace
+	}
+	return namespace
+}
+
+// useCommand runs use: with a name, it switches to that namespace, and
+// without one, it lists the namespaces, marking the current one
+func useCommand(out io.Writer, store *kv.Store, parts []string) int {
+	switch len(parts) {
+	case 1:
+		for _, name := range append([]string{defaultNamespace}, store.Namespaces()...) {
+			marker := "  "
+			if name == namespaceName() {
+				marker = "* "
+			}
+			fmt.Fprintln(out, marker+name)
+		}
+		return exitOK
+	case 2:
+	default:
+		fmt.Fprintln(out, "Error: Incorrect number of parameters")
+		fmt.Fprintln(out, "Usage: use [<namespace>]")
+		return exitUsage
+	}
+
+	name := parts[1]
+	switch {
+	case name == defaultNamespace:
+		name = ""
+	case name == "" || strings.Contains(name, kv.NamespaceSeparator):
+		fmt.Fprintf(out, "Error: A namespace name can't be empty or hold %s\n", kv.NamespaceSeparator)
+		return exitUsage
+	}
+	namespace = name
+	fmt.Fprintf(out, "Success: Using namespace %s\n", namespaceName())
+	return exitOK
+}
+
+// quotaOptions returns the options setting the quotas of the -quota-keys
+// and -quota-memory flags, comma-separated namespace=limit lists
+func quotaOptions(keys, memory string) ([]kv.Option, error) {
+	quotas := make(map[string]kv.NamespaceQuota)
+	var order []string
+	for _, flag := range []struct {
+		name, list string
+		set        func(q *kv.NamespaceQuota, n int64)
+	}{
+		{"-quota-keys", keys, func(q *kv.NamespaceQuota, n int64) { q.MaxKeys = n }},
+		{"-quota-memory", memory, func(q *kv.NamespaceQuota, n int64) { q.MaxMemory = n }},
+	} {
+		if flag.list == "" {
+			continue
+		}
+		for _, item := range strings.Split(flag.list, ",") {
+			name, limit, ok := strings.Cut(strings.TrimSpace(item), "=")
+			n, err := strconv.ParseInt(limit, 10, 64)
+			if !ok || err != nil || n < 0 || name == "" || name == defaultNamespace || strings.Contains(name, kv.NamespaceSeparator) {
+				return nil, fmt.Errorf("%s: %q is not namespace=limit", flag.name, item)
+			}
+			q, seen := quotas[name]
+			if !seen {
+				order = append(order, name)
+			}
+			flag.set(&q, n)
+			quotas[name] = q
+		}
+	}
+	opts := make([]kv.Option, len(order))
+	for i, name := range order {
+		opts[i] = kv.WithNamespaceQuota(name, quotas[name])
+	}
+	return opts, nil
+}
+
+// quotaCommand runs quota: alone, it lists each namespace's usage against
+// its quota, and with set, it replaces a namespace's quota
+func quotaCommand(out io.Writer, store *kv.Store, parts []string) int {
+	switch {
+	case len(parts) == 1:
+		usage := store.NamespaceUsage()
+		if len(usage) == 0 {
+			fmt.Fprintln(out, "No namespaces hold entries or have a quota")
+			return exitOK
+		}
+		for _, u := range usage {
+			fmt.Fprintln(out, u.Info())
+		}
+		return exitOK
+	case len(parts) >= 3 && parts[1] == "set":
+	default:
+		fmt.Fprintln(out, "Error: Incorrect number of parameters")
+		fmt.Fprintln(out, "Usage: quota [set <namespace> [keys <n>] [memory <bytes>]]")
+		return exitUsage
+	}
+
+	if parts[2] == defaultNamespace {
+		fmt.Fprintln(out, "Error: The default namespace can't have a quota; -max-memory limits the whole store")
+		return exitUsage
+	}
+	q, err := kv.ParseNamespaceQuota(parts[3:])
+	if err == nil {
+		err = store.SetNamespaceQuota(parts[2], q)
+	}
+	if err != nil {
+		return commandError(out, err)
+	}
+	if q == (kv.NamespaceQuota{}) {
+		fmt.Fprintf(out, "Success: Namespace %s has no quota\n", parts[2])
+	} else {
+		fmt.Fprintf(out, "Success: Quota of namespace %s set\n", parts[2])
+	}
+	return exitOK
+}
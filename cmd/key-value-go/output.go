@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	kv "github.com/dsapoetra/key-value-go/pkg/store"
+	"golang.org/x/term"
+)
+
+// The CLI prints the results of get, search and keys in one of three
+// formats: plain, the comma-joined text it has always printed, json, for
+// scripts piping them to another program, and table, aligned columns with a
+// header for people reading many entries at once. -output sets the format
+// at startup and the format command changes it in a session.
+
+// outputFormats lists the formats the CLI prints results in
+var outputFormats = []string{"plain", "json", "table"}
+
+// outputFormat is the format the CLI prints results in. The CLI runs one
+// command at a time, so it needs no lock.
+var outputFormat = "plain"
+
+// parseOutputFormat checks that name is one of outputFormats
+func parseOutputFormat(name string) (string, error) {
+	for _, format := range outputFormats {
+		if name == format {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown output format %q; want one of %s", name, strings.Join(outputFormats, ", "))
+}
+
+// printEntry prints the attributes of the entry get found
+func printEntry(out *cliOutput, attrs map[string]interface{}) {
+	if outputFormat == "json" {
+		printJSON(out, attrs)
+		return
+	}
+	names := slices.Sorted(maps.Keys(attrs))
+	shown := out.truncate(len(names))
+	if outputFormat == "table" {
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "%s\t%s\n", paint(colorBold, "ATTRIBUTE"), paint(colorBold, "VALUE"))
+		for _, attrKey := range names[:shown] {
+			fmt.Fprintf(tw, "%s\t%s\n", paint(colorBlue, attrKey), paintValue(attrs[attrKey]))
+		}
+		tw.Flush()
+	} else {
+		pairs := make([]string, 0, shown)
+		for _, attrKey := range names[:shown] {
+			pairs = append(pairs, paint(colorBlue, attrKey)+": "+paintValue(attrs[attrKey]))
+		}
+		fmt.Fprintln(out, strings.Join(pairs, ", "))
+	}
+	out.printMore(len(names) - shown)
+}
+
+// printKeys prints the keys listed by keys, or found by search, introduced
+// in plain text by label, or saying none in plain text and tables. Tables of
+// search results show each entry's attributes too.
+func printKeys(out *cliOutput, store kv.KVStore, keys []string, label, none string, withAttributes bool) {
+	switch {
+	case outputFormat == "json":
+		if keys == nil {
+			keys = []string{}
+		}
+		printJSON(out, keys)
+		return
+	case len(keys) == 0:
+		fmt.Fprintln(out, none)
+		return
+	}
+
+	all := len(keys)
+	keys = keys[:out.truncate(all)]
+	if outputFormat == "table" {
+		printTable(out, store.Get, keys, withAttributes, paint(colorGray, "-"))
+	} else {
+		painted := make([]string, len(keys))
+		for i, key := range keys {
+			painted[i] = paint(colorCyan, key)
+		}
+		fmt.Fprintln(out, label, strings.Join(painted, ", "))
+	}
+	out.printMore(all - len(keys))
+}
+
+// printTable prints keys as a table, one row a key, with a column for each
+// attribute of their entries, as get returns them, if withAttributes,
+// writing missing in the cells of the attributes an entry lacks
+func printTable(out io.Writer, get func(key string) map[string]interface{}, keys []string, withAttributes bool, missing string) {
+	entries := make([]map[string]interface{}, len(keys))
+	var columns []string
+	if withAttributes {
+		names := make(map[string]interface{})
+		for i, key := range keys {
+			entries[i] = get(key)
+			for attrKey := range entries[i] {
+				names[attrKey] = nil
+			}
+		}
+		columns = slices.Sorted(maps.Keys(names))
+	}
+	header := []string{paint(colorBold, "KEY")}
+	for _, attrKey := range columns {
+		header = append(header, paint(colorBold, attrKey))
+	}
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for i, key := range keys {
+		row := []string{paint(colorCyan, key)}
+		for _, attrKey := range columns {
+			value, ok := entries[i][attrKey]
+			if !ok {
+				row = append(row, missing)
+				continue
+			}
+			row = append(row, paintValue(value))
+		}
+		for len(row) > 1 && row[len(row)-1] == "" {
+			row = row[:len(row)-1] // so that no padding trails the row
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	tw.Flush()
+}
+
+// printEntries prints the entries at keys, found by show, as a table with a
+// column for each attribute, leaving blank the attributes an entry lacks,
+// whatever the output format but json, which prints an object of the
+// entries by key. The entries are the command's result.
+func printEntries(out *cliOutput, store kv.KVStore, keys []string) {
+	entries := make(map[string]map[string]interface{}, len(keys))
+	for _, key := range keys {
+		if attrs := store.Get(key); attrs != nil {
+			entries[key] = attrs
+		}
+	}
+	out.result = entries
+	if outputFormat == "json" {
+		printJSON(out, entries)
+		return
+	}
+	if len(keys) == 0 {
+		fmt.Fprintln(out, "No matching entries found")
+		return
+	}
+	shown := out.truncate(len(keys))
+	printTable(out, mapGetter(entries), keys[:shown], true, "")
+	out.printMore(len(keys) - shown)
+}
+
+// printMGet prints the entries mget read at keys, as printEntries does but
+// in the order of keys, then the keys that have none. json prints the
+// object of the entries with null for the missing keys, which is also
+// the command's result.
+func printMGet(out *cliOutput, keys []string, entries map[string]map[string]interface{}, missing []string) {
+	result := make(map[string]interface{}, len(keys))
+	var found []string
+	for _, key := range keys {
+		if attrs, ok := entries[key]; ok {
+			result[key] = attrs
+			if !slices.Contains(found, key) {
+				found = append(found, key)
+			}
+		} else {
+			result[key] = nil
+		}
+	}
+	out.result = result
+	if outputFormat == "json" {
+		printJSON(out, result)
+		return
+	}
+	if len(found) == 0 {
+		fmt.Fprintln(out, "No entries found")
+	} else {
+		shown := out.truncate(len(found))
+		printTable(out, mapGetter(entries), found[:shown], true, "")
+		out.printMore(len(found) - shown)
+	}
+	if len(missing) > 0 {
+		painted := make([]string, len(missing))
+		for i, key := range missing {
+			painted[i] = paint(colorCyan, key)
+		}
+		fmt.Fprintln(out, "Missing:", strings.Join(painted, ", "))
+	}
+}
+
+// mapGetter returns a printTable getter of the entries in entries
+func mapGetter(entries map[string]map[string]interface{}) func(string) map[string]interface{} {
+	return func(key string) map[string]interface{} { return entries[key] }
+}
+
+// truncate returns how many of n results to print, at most out.limit
+func (out *cliOutput) truncate(n int) int {
+	if out.limit > 0 && n > out.limit {
+		return out.limit
+	}
+	return n
+}
+
+// printMore notes the results truncate left out
+func (out *cliOutput) printMore(n int) {
+	if n > 0 {
+		fmt.Fprintf(out, "… %d more, use --all\n", n)
+	}
+}
+
+// terminalLimit returns how many results fit on the terminal at f, leaving
+// room for the prompt, or 0 if f isn't a terminal
+func terminalLimit(f *os.File) int {
+	_, height, err := term.GetSize(int(f.Fd()))
+	if err != nil || height <= 0 {
+		return 0
+	}
+	return max(height-4, 5)
+}
+
+// printJSON prints v as a line of JSON
+func printJSON(out io.Writer, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		fmt.Fprintln(out, "Error:", err)
+		return
+	}
+	fmt.Fprintln(out, string(data))
+}
+
+// quiet and verbose set how much the CLI says besides results: -q drops
+// Success lines, the banner and the menu, and -v reports each command's
+// parsed words, status, duration and the entries it touched, on stderr so
+// that stdout still holds only the results. They are set at startup.
+var quiet, verbose bool
+
+// quietWriter drops the lines written to w that start with Success:.
+// Commands print each line with one call, so a line is never split between
+// writes.
+type quietWriter struct {
+	w io.Writer
+}
+
+func (q quietWriter) Write(p []byte) (int, error) {
+	var out []byte
+	for rest := p; len(rest) > 0; {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+		}
+		rest = rest[len(line):]
+		if !bytes.HasPrefix(line, successPrefix) {
+			out = append(out, line...)
+		}
+	}
+	if _, err := q.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// timing makes every command print its timing, as time does for one. It is
+// set at startup.
+var timing bool
+
+// printTiming prints how long a command took and the entries it examined,
+// if it says
+func printTiming(out io.Writer, elapsed time.Duration, examined int) {
+	line := "Time: " + elapsed.Round(time.Microsecond).String()
+	switch {
+	case examined == 1:
+		line += ", 1 entry examined"
+	case examined >= 0:
+		line += fmt.Sprintf(", %d entries examined", examined)
+	}
+	fmt.Fprintln(out, line)
+}
+
+// reportCommand writes the -v report of a command to stderr, once it has
+// run with status
+func reportCommand(parts []string, status int, elapsed time.Duration, affected int) {
+	words := make([]string, len(parts))
+	for i, part := range parts {
+		words[i] = kv.ScriptWord(part)
+	}
+	report := fmt.Sprintf("+ %s: %s in %s", strings.Join(words, " "), batchStatuses[status], elapsed.Round(time.Microsecond))
+	switch {
+	case affected == 1:
+		report += ", 1 entry"
+	case affected >= 0:
+		report += fmt.Sprintf(", %d entries", affected)
+	}
+	fmt.Fprintln(os.Stderr, report)
+}
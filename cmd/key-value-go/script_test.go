@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	kv "github.com/dsapoetra/key-value-go/pkg/store"
+)
+
+// TestDumpScriptReplay replays a dump script through the CLI, with entries
+// and attribute types in several namespaces
+func TestDumpScriptReplay(t *testing.T) {
+	store := kv.NewStore()
+	for key, attrs := range map[string][][]string{
+		"tenantA/u1": {{"age", "30"}, {"name", "ann lee"}},
+		"tenantB/u2": {{"age", "thirty"}},
+		"u3":         {{"age", "5"}, {"note", `say "hi"`}},
+		"tenantB/x":  {{"flag", "true"}},
+		"y":          {{"count", "1"}},
+	} {
+		if err := store.Put(key, attrs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Types left registered by deleted entries, in a namespace and not
+	for _, key := range []string{"tenantB/x", "y"} {
+		if err := store.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "dump.txt")
+	if _, err := store.DumpScriptFile(path, kv.ExportFilter{}); err != nil {
+		t.Fatal(err)
+	}
+	// Each namespace registers its leftover types under a placeholder of
+	// its own, and only those: types the entries carry need none
+	dump, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var placeholders []string
+	for _, line := range strings.Split(string(dump), "\n") {
+		if strings.Contains(line, "__dump_types__") {
+			placeholders = append(placeholders, line)
+		}
+	}
+	want := []string{
+		"put __dump_types__ count 0", "delete __dump_types__",
+		"put tenantB/__dump_types__ flag false", "delete tenantB/__dump_types__",
+	}
+	if !reflect.DeepEqual(placeholders, want) {
+		t.Errorf("placeholders = %q, want %q", placeholders, want)
+	}
+
+	replayed := kv.NewStore()
+	var out bytes.Buffer
+	if status := runScript(&cliOutput{Writer: &out, batch: true}, replayed, path, false); status != exitOK {
+		t.Fatalf("replay exited %d:\n%s\ndump:\n%s", status, out.String(), dump)
+	}
+
+	if got, want := replayed.Keys(), store.Keys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+	for _, key := range store.Keys() {
+		if got, want := replayed.Get(key), store.Get(key); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %v, want %v", key, got, want)
+		}
+	}
+	if got, want := replayed.AttributeNames(), store.AttributeNames(); !reflect.DeepEqual(got, want) {
+		t.Errorf("attribute types = %v, want %v", got, want)
+	}
+	if err := replayed.Put("tenantB/z", [][]string{{"flag", "maybe"}}); err == nil {
+		t.Error("tenantB's flag lost its bool type")
+	}
+}
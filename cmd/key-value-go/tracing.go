@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// The store traces its operations, and a server its commands, as
+// OpenTelemetry spans. -otlp-endpoint exports them in batches over
+// OTLP/HTTP to a collector such as the OpenTelemetry Collector or Jaeger,
+// as the service named by OTEL_SERVICE_NAME, key-value-go by default, and
+// -trace-ratio samples a share of the traces rather than all of them.
+
+// newTracerProvider returns a provider exporting the spans of a ratio of
+// traces to the OTLP/HTTP collector at endpoint, a URL or a host:port
+// reached over plain HTTP
+func newTracerProvider(endpoint string, ratio float64) (*sdktrace.TracerProvider, error) {
+	if ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("trace ratio %v is not between 0 and 1", ratio)
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(attribute.String("service.name", "key-value-go")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	), nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// The CLI keeps variables for the session: after set $k user1, get $k is
+// get user1. An unquoted word that is $name, or that holds ${name}, as in
+// user:${id}, takes the variable's value; a quoted word is kept as is. $_
+// holds the key of the last command that succeeded: the key a put,
+// putjson, get or delete named, or the first key keys, search or show
+// found.
+
+// variables maps variable names, without the $, to their values. The CLI
+// runs one command at a time, so it needs no lock.
+var variables = map[string]string{}
+
+// lastKeyVariable names the variable holding the last command's key
+const lastKeyVariable = "_"
+
+var (
+	variableWord      = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)$`)
+	variableReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// substituteVariables replaces the variables in the unquoted words of
+// parts by their values, leaving the words set, unset and alias take
+// variable names or command words in
+func substituteVariables(parts []string, quoted []bool) ([]string, error) {
+	from := 1
+	switch parts[0] {
+	case "set":
+		from = 2
+	case "unset", "alias":
+		return parts, nil
+	}
+	var substituted []string
+	for i := from; i < len(parts); i++ {
+		if quoted[i] {
+			continue
+		}
+		word, err := substituteWord(parts[i])
+		if err != nil {
+			return nil, err
+		}
+		if word != parts[i] && substituted == nil {
+			substituted = slices.Clone(parts)
+		}
+		if substituted != nil {
+			substituted[i] = word
+		}
+	}
+	if substituted == nil {
+		return parts, nil
+	}
+	return substituted, nil
+}
+
+// substituteWord returns word with its variables replaced by their values
+func substituteWord(word string) (string, error) {
+	if m := variableWord.FindStringSubmatch(word); m != nil {
+		value, ok := variables[m[1]]
+		if !ok {
+			return "", fmt.Errorf("undefined variable $%s", m[1])
+		}
+		return value, nil
+	}
+	var err error
+	word = variableReference.ReplaceAllStringFunc(word, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		value, ok := variables[name]
+		if !ok && err == nil {
+			err = fmt.Errorf("undefined variable ${%s}", name)
+		}
+		return value
+	})
+	return word, err
+}
+
+// rememberLastKey sets $_ from a command that succeeded
+func rememberLastKey(out *cliOutput, parts []string) {
+	switch parts[0] {
+	case "put", "putjson", "get", "delete":
+		if len(parts) > 1 && parts[1] != "--pattern" {
+			variables[lastKeyVariable] = parts[1]
+		}
+	case "keys", "search", "show":
+		var keys []string
+		switch result := out.result.(type) {
+		case []string:
+			keys = result
+		case map[string]map[string]interface{}:
+			for key := range result {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			delete(variables, lastKeyVariable)
+		} else {
+			variables[lastKeyVariable] = slices.Min(keys)
+		}
+	}
+}
+
+// variableNames returns the variables as the words that use them, for
+// completion
+func variableNames() []string {
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, "$"+name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setCommand runs set and unset: set lists the variables, set $name value
+// sets one and unset $name removes it
+func setCommand(out io.Writer, parts []string, quoted []bool) int {
+	var name string
+	if len(parts) > 1 {
+		name = parts[1]
+		if m := variableWord.FindStringSubmatch(name); m != nil && !quoted[1] {
+			name = m[1]
+		}
+	}
+	if parts[0] == "unset" {
+		if len(parts) != 2 {
+			fmt.Fprintln(out, "Error: Incorrect number of parameters")
+			fmt.Fprintln(out, "Usage: unset $<name>")
+			return exitUsage
+		}
+		if _, ok := variables[name]; !ok {
+			fmt.Fprintf(out, "Error: no variable $%s\n", name)
+			return exitFailed
+		}
+		delete(variables, name)
+		fmt.Fprintf(out, "Success: Removed variable $%s\n", name)
+		return exitOK
+	}
+
+	switch {
+	case len(parts) == 1:
+		names := variableNames()
+		if len(names) == 0 {
+			fmt.Fprintln(out, "No variables set")
+		}
+		for _, name := range names {
+			fmt.Fprintf(out, "%s=%s\n", name, strconv.Quote(variables[name[1:]]))
+		}
+		return exitOK
+	case len(parts) != 3:
+		fmt.Fprintln(out, "Error: Incorrect number of parameters")
+		fmt.Fprintln(out, "Usage: set [$<name> <value>]")
+		return exitUsage
+	case !variableWord.MatchString("$" + name):
+		fmt.Fprintf(out, "Error: bad variable name %q\n", parts[1])
+		return exitUsage
+	case name == lastKeyVariable:
+		fmt.Fprintln(out, "Error: $_ is set by the commands that name or find a key")
+		return exitUsage
+	}
+	variables[name] = parts[2]
+	fmt.Fprintf(out, "Success: Set $%s\n", name)
+	return exitOK
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"fmt"
+	"math"
+)
+
+// The typed getters read one attribute of an entry as a Go value of the
+// attribute's type, checked against the type registered for it. They
+// return ok false, and no error, when the entry or the attribute is
+// missing, and a *TypeMismatchError when the attribute is registered with
+// another type.
+
+// GetString returns the string attribute attr of the entry at key
+func (s *Store) GetString(key, attr string) (string, bool, error) {
+	return getTyped[string](s, key, attr, StringType)
+}
+
+// GetFloat returns the float attribute attr of the entry at key
+func (s *Store) GetFloat(key, attr string) (float64, bool, error) {
+	return getTyped[float64](s, key, attr, FloatType)
+}
+
+// GetBool returns the bool attribute attr of the entry at key
+func (s *Store) GetBool(key, attr string) (bool, bool, error) {
+	return getTyped[bool](s, key, attr, BoolType)
+}
+
+// GetInt returns the float attribute attr of the entry at key as an int,
+// failing if it isn't a whole number an int can hold
+func (s *Store) GetInt(key, attr string) (int, bool, error) {
+	f, ok, err := s.GetFloat(key, attr)
+	if !ok || err != nil {
+		return 0, ok, err
+	}
+	if f != math.Trunc(f) || f < math.MinInt || f >= math.MaxInt {
+		return 0, true, fmt.Errorf("%s of %s is %v, not a whole number", attr, key, f)
+	}
+	return int(f), true, nil
+}
+
+// getTyped returns attr of the entry at key, of Go type T, checking that
+// the attribute's registered type is want
+func getTyped[T any](s *Store, key, attr string, want AttributeType) (T, bool, error) {
+	var zero T
+	s.typesMutex.Lock()
+	metadata, registered := s.attributeTypes[typeKey(key, attr)]
+	s.typesMutex.Unlock()
+	if registered && metadata.dataType != want {
+		return zero, false, &TypeMismatchError{Attribute: attr, Expected: metadata.dataType, Got: want}
+	}
+	value, ok := s.Get(key)[attr]
+	if !ok {
+		return zero, false, nil
+	}
+	typed, ok := value.(T)
+	if !ok {
+		got, _ := valueType(value)
+		return zero, false, &TypeMismatchError{Attribute: attr, Expected: got, Got: want}
+	}
+	return typed, true, nil
+}
@@ -0,0 +1,281 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Attribute statistics describe the values each attribute holds over the
+// store's entries, to guide which attributes deserve indexes, by how many
+// distinct values they have and how evenly spread, and to spot unexpected
+// data, such as a placeholder string among the most frequent values. They
+// are computed by a scan, as a search is, so they describe the entries as
+// they are, deletes included, in memory bounded whatever the store's size:
+// distinct values are counted with a HyperLogLog sketch, within about 2%,
+// and the most frequent values found with the Space-Saving algorithm, whose
+// counts are exact while an attribute has no more than attrTopTracked
+// distinct values and otherwise overstate by at most entries/attrTopTracked.
+// Floats also get their minimum, maximum, mean and a histogram of
+// attrHistogramBuckets equal-width buckets between the two. An attribute of
+// a namespace is reported as namespace/attribute, the name its type is
+// registered by (see namespace.go).
+
+const (
+	attrSketchBits       = 12 // log2 of the HyperLogLog's registers: 4096, for a 1.6% standard error
+	attrTopTracked       = 64 // values the Space-Saving counters track per attribute
+	attrTopShown         = 5  // most frequent values reported
+	attrHistogramBuckets = 10 // buckets of a float histogram
+)
+
+// AttributeStats describes the values of one attribute over the store's
+// entries
+type AttributeStats struct {
+	Name     string
+	Type     AttributeType
+	Entries  int // entries holding the attribute
+	Distinct int // approximate number of distinct values
+
+	// Top holds the most frequent values, most frequent first
+	Top []ValueCount
+
+	// For floats, the smallest, largest and mean values, and the histogram
+	// of the values in equal-width buckets from Min to Max
+	Min, Max, Mean float64
+	Histogram      []HistogramBucket
+}
+
+// ValueCount is a value and the number of entries holding it
+type ValueCount struct {
+	Value interface{}
+	Count int
+}
+
+// HistogramBucket counts the values from From up to To, To included for the
+// last bucket
+type HistogramBucket struct {
+	From, To float64
+	Count    int
+}
+
+// attrSketch gathers the statistics of one attribute during a scan
+type attrSketch struct {
+	stats     AttributeStats
+	registers [1 << attrSketchBits]uint8
+	counters  map[interface{}]int // the Space-Saving counters
+	sum       float64
+}
+
+// add counts value, whose hash is h
+func (a *attrSketch) add(value interface{}, h uint64) {
+	a.stats.Entries++
+
+	// HyperLogLog: the first bits pick a register, which keeps the longest
+	// run of leading zeros seen in the rest
+	i := h >> (64 - attrSketchBits)
+	rho := uint8(bits.LeadingZeros64(h<<attrSketchBits|1<<(attrSketchBits-1))) + 1
+	a.registers[i] = max(a.registers[i], rho)
+
+	// Space-Saving: a value not tracked once every counter is taken
+	// replaces the least frequent, inheriting its count
+	if _, tracked := a.counters[value]; tracked || len(a.counters) < attrTopTracked {
+		a.counters[value]++
+	} else {
+		var least interface{}
+		leastCount := math.MaxInt
+		for v, n := range a.counters {
+			if n < leastCount {
+				least, leastCount = v, n
+			}
+		}
+		delete(a.counters, least)
+		a.counters[value] = leastCount + 1
+	}
+
+	if f, ok := value.(float64); ok {
+		if a.stats.Entries == 1 {
+			a.stats.Min, a.stats.Max = f, f
+		}
+		a.stats.Min, a.stats.Max = min(a.stats.Min, f), max(a.stats.Max, f)
+		a.sum += f
+	}
+}
+
+// distinct estimates the number of distinct values added, by linear
+// counting while registers are still empty, as HyperLogLog does for small
+// cardinalities
+func (a *attrSketch) distinct() int {
+	m := float64(len(a.registers))
+	var sum float64
+	zeros := 0
+	for _, r := range a.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return min(int(math.Round(estimate)), a.stats.Entries)
+}
+
+// finish completes the statistics once every value has been added
+func (a *attrSketch) finish() AttributeStats {
+	st := a.stats
+	st.Distinct = a.distinct()
+	for v, n := range a.counters {
+		st.Top = append(st.Top, ValueCount{Value: v, Count: n})
+	}
+	sort.Slice(st.Top, func(i, j int) bool {
+		if st.Top[i].Count != st.Top[j].Count {
+			return st.Top[i].Count > st.Top[j].Count
+		}
+		return fmt.Sprint(st.Top[i].Value) < fmt.Sprint(st.Top[j].Value)
+	})
+	st.Top = st.Top[:min(len(st.Top), attrTopShown)]
+	if st.Type == FloatType && st.Entries > 0 {
+		st.Mean = a.sum / float64(st.Entries)
+		n := attrHistogramBuckets
+		if st.Min == st.Max {
+			n = 1
+		}
+		width := (st.Max - st.Min) / float64(n)
+		st.Histogram = make([]HistogramBucket, n)
+		for i := range st.Histogram {
+			st.Histogram[i] = HistogramBucket{From: st.Min + float64(i)*width, To: st.Min + float64(i+1)*width}
+		}
+		st.Histogram[n-1].To = st.Max
+	}
+	return st
+}
+
+// bucketOf returns the histogram bucket of f
+func (st *AttributeStats) bucketOf(f float64) int {
+	if len(st.Histogram) == 1 {
+		return 0
+	}
+	i := int((f - st.Min) / (st.Max - st.Min) * float64(len(st.Histogram)))
+	return min(max(i, 0), len(st.Histogram)-1)
+}
+
+// AttributeStats returns the statistics of every attribute over the
+// store's entries, by name, as of one point in time. The scan stops
+// promptly once ctx is done, returning ctx's error.
+func (s *Store) AttributeStats(ctx context.Context) (stats []AttributeStats, err error) {
+	ctx, span := s.startSpan(ctx, "attribute_stats", "")
+	defer func() { endSpan(span, err) }()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	s.rlockAll()
+	defer s.runlockAll()
+	lockWaited(span, start)
+
+	seed := maphash.MakeSeed()
+	sketches := make(map[string]*attrSketch)
+	var visited int
+	var ctxErr error
+	scan := func(fn func(key string, attrs map[string]interface{})) error {
+		s.data.Range(func(k, v interface{}) bool {
+			if visited++; visited%ctxCheckInterval == 0 {
+				if ctxErr = ctx.Err(); ctxErr != nil {
+					return false
+				}
+			}
+			fn(k.(string), v.(*entry).attrs)
+			return true
+		})
+		return ctxErr
+	}
+
+	err = scan(func(key string, attrs map[string]interface{}) {
+		for attrKey, value := range attrs {
+			name := typeKey(key, attrKey)
+			a := sketches[name]
+			if a == nil {
+				t, _ := valueType(value)
+				a = &attrSketch{stats: AttributeStats{Name: name, Type: t}, counters: make(map[interface{}]int)}
+				sketches[name] = a
+			}
+			a.add(value, maphash.String(seed, fmt.Sprintf("%T:%v", value, value)))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats = make([]AttributeStats, 0, len(sketches))
+	byName := make(map[string]*AttributeStats, len(sketches))
+	for _, a := range sketches {
+		stats = append(stats, a.finish())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	for i := range stats {
+		if stats[i].Histogram != nil {
+			byName[stats[i].Name] = &stats[i]
+		}
+	}
+
+	// A second pass fills the float histograms, now that their ranges are
+	// known
+	if len(byName) > 0 {
+		err = scan(func(key string, attrs map[string]interface{}) {
+			for attrKey, value := range attrs {
+				f, isFloat := value.(float64)
+				if st := byName[typeKey(key, attrKey)]; isFloat && st != nil {
+					st.Histogram[st.bucketOf(f)].Count++
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// Info reports st as a "name:key=value ..." line, as stats attributes
+// prints it
+func (st AttributeStats) Info() string {
+	line := fmt.Sprintf("%s:type=%s entries=%d distinct=%d", st.Name, st.Type, st.Entries, st.Distinct)
+	if st.Type == FloatType && st.Entries > 0 {
+		line += fmt.Sprintf(" min=%s max=%s mean=%s", statFloat(st.Min), statFloat(st.Max), statFloat(st.Mean))
+	}
+	return line
+}
+
+// Details reports the most frequent values of st as "top:value=count"
+// lines, strings quoted, then its histogram, if any, as "[from,to):count"
+// lines, as stats attributes prints them for one attribute
+func (st AttributeStats) Details() []string {
+	var lines []string
+	for _, vc := range st.Top {
+		value := rawValue(vc.Value)
+		if _, isString := vc.Value.(string); isString {
+			value = strconv.Quote(value)
+		}
+		lines = append(lines, fmt.Sprintf("top:%s=%d", value, vc.Count))
+	}
+	for i, b := range st.Histogram {
+		end := ")"
+		if i == len(st.Histogram)-1 {
+			end = "]"
+		}
+		lines = append(lines, fmt.Sprintf("[%s,%s%s:%d", statFloat(b.From), statFloat(b.To), end, b.Count))
+	}
+	return lines
+}
+
+// statFloat formats f with up to four significant digits after the point
+func statFloat(f float64) string {
+	return strconv.FormatFloat(math.Round(f*1e4)/1e4, 'f', -1, 64)
+}
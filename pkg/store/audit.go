@@ -0,0 +1,298 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// An audit log records every write the store commits as JSON lines, one
+// per key written: when, who made it, the key, the op and the attributes
+// that differ before and after it, so "who changed this key" is answered
+// with grep rather than by replaying the write log. Who is the actor named
+// by WithActor on the write's context, the client's address for a
+// server's writes, "eviction" for evictions and "sync <addr>" for what a
+// sync link copies in; triggers' writes are audited under the actor of the
+// write that fired them. Writes are audited on the node that took them,
+// after they are applied: a follower doesn't audit what it receives from
+// its leader.
+//
+// Once the file reaches AuditOptions.MaxBytes it is rotated: path is
+// renamed path.1, an existing path.1 path.2 and so on, the oldest beyond
+// the Keep files kept is removed, and a new file is started.
+
+// AuditOptions tunes an audit log
+type AuditOptions struct {
+	// MaxBytes is the size at which the file is rotated; 0 never rotates
+	MaxBytes int64
+	// Keep is how many rotated files are kept besides the current one
+	Keep int
+}
+
+// auditRecord is one line of the audit log
+type auditRecord struct {
+	Time   time.Time              `json:"time"`
+	Who    string                 `json:"who,omitempty"`
+	Op     string                 `json:"op"` // "put" or "del"
+	Key    string                 `json:"key"`
+	Before map[string]interface{} `json:"before,omitempty"` // the attributes the write changed or removed
+	After  map[string]interface{} `json:"after,omitempty"`  // those it changed or added
+}
+
+// AuditLog writes a store's writes to an append-only file
+type AuditLog struct {
+	store *Store
+	path  string
+	opts  AuditOptions
+
+	mu      sync.Mutex
+	file    *os.File
+	buf     *bufio.Writer
+	size    int64
+	lastErr error
+}
+
+// StartAudit audits every write the store commits from now on to the file
+// at path, appending to it if it exists. A store has one audit log at a
+// time.
+func (s *Store) StartAudit(path string, opts AuditOptions) (*AuditLog, error) {
+	a := &AuditLog{store: s, path: path, opts: opts}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	if !s.audit.CompareAndSwap(nil, a) {
+		a.file.Close()
+		return nil, errors.New("the store already has an audit log")
+	}
+	s.logger.Info("started the audit log", "path", path)
+	return a, nil
+}
+
+// open opens the file at a's path for appending. Caller must hold a.mu or
+// own a.
+func (a *AuditLog) open() error {
+	file, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	a.file, a.buf, a.size = file, bufio.NewWriter(file), info.Size()
+	return nil
+}
+
+// Close stops auditing and closes the file
+func (a *AuditLog) Close() error {
+	a.store.audit.CompareAndSwap(a, nil)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+	err := a.buf.Flush()
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+	a.file = nil
+	return err
+}
+
+// Err returns the last error writing or rotating the file, if any. A write
+// the audit log fails to record still succeeds.
+func (a *AuditLog) Err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.lastErr
+}
+
+// actorKey is the context key of WithActor
+type actorKey struct{}
+
+// WithActor returns a copy of ctx naming actor, such as a user or a
+// client's address, as who makes the writes done with it, for the audit
+// log. PutCtx, DeleteCtx and ExecCtx take it.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorOf returns the actor ctx names, or ""
+func actorOf(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// setActor names actor as who makes ops
+func setActor(ops []logOp, actor string) []logOp {
+	for i := range ops {
+		ops[i].actor = actor
+	}
+	return ops
+}
+
+// auditBefore returns the store's audit log, or nil without one, with the
+// entries at the keys of ops before they are applied. Caller must hold the
+// stripes of every key in ops.
+func (s *Store) auditBefore(ops []logOp) (*AuditLog, map[string]*entry) {
+	a := s.audit.Load()
+	if a == nil {
+		return nil, nil
+	}
+	before := make(map[string]*entry, len(ops))
+	for _, op := range ops {
+		if _, seen := before[op.Key]; seen {
+			continue
+		}
+		if v, ok := s.data.Load(op.Key); ok {
+			before[op.Key] = v.(*entry)
+		} else {
+			before[op.Key] = nil
+		}
+	}
+	return a, before
+}
+
+// record audits the applied ops, given the entries before them. The
+// record's actor is the first an op names. Caller must hold the stripes of
+// every key in ops.
+func (a *AuditLog) record(ops []logOp, before map[string]*entry) {
+	var actor string
+	for _, op := range ops {
+		if op.actor != "" {
+			actor = op.actor
+			break
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return
+	}
+	// A key written more than once is audited once, for its last op
+	last := make(map[string]int, len(before))
+	for i, op := range ops {
+		last[op.Key] = i
+	}
+	for i, op := range ops {
+		key := op.Key
+		if last[key] != i {
+			continue
+		}
+
+		rec := auditRecord{Time: time.Unix(0, op.Time).UTC(), Who: actor, Op: op.Op, Key: key}
+		if rec.Op == "patch" {
+			rec.Op = "put"
+		}
+		var old, current map[string]interface{}
+		if e := before[key]; e != nil {
+			old = e.attrs
+		}
+		if v, ok := a.store.data.Load(key); ok {
+			current = v.(*entry).attrs
+		}
+		rec.Before, rec.After = attributeChanges(old, current)
+		a.write(rec)
+	}
+	if err := a.buf.Flush(); err != nil {
+		a.failed(err)
+	}
+}
+
+// write appends rec to the file, rotating it if it has reached its size
+// limit. Caller must hold a.mu.
+func (a *AuditLog) write(rec auditRecord) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		a.failed(err)
+		return
+	}
+	n, err := a.buf.Write(append(line, '\n'))
+	a.size += int64(n)
+	if err != nil {
+		a.failed(err)
+		return
+	}
+	if a.opts.MaxBytes > 0 && a.size >= a.opts.MaxBytes {
+		if err := a.rotate(); err != nil {
+			a.failed(err)
+		}
+	}
+}
+
+// rotate moves the file aside, with the files rotated before it, and
+// starts a new one. Caller must hold a.mu.
+func (a *AuditLog) rotate() error {
+	if err := a.buf.Flush(); err != nil {
+		return err
+	}
+	if err := a.file.Close(); err != nil {
+		return err
+	}
+	a.file = nil
+
+	rotated := func(i int) string { return fmt.Sprintf("%s.%d", a.path, i) }
+	if err := os.Remove(rotated(a.opts.Keep)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for i := a.opts.Keep - 1; i >= 1; i-- {
+		if err := os.Rename(rotated(i), rotated(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	var err error
+	if a.opts.Keep > 0 {
+		err = os.Rename(a.path, rotated(1))
+	} else {
+		err = os.Remove(a.path)
+	}
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+	a.store.logger.Info("rotated the audit log", "path", a.path, "keep", a.opts.Keep)
+	return nil
+}
+
+// failed records err as the audit log's last error. Caller must hold a.mu.
+func (a *AuditLog) failed(err error) {
+	a.lastErr = err
+	a.store.logger.Error("writing the audit log failed", "path", a.path, "err", err)
+}
+
+// attributeChanges returns the attributes of before that after changes or
+// lacks, and those of after that before lacks or has another value for
+func attributeChanges(before, after map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	var old, current map[string]interface{}
+	for attrKey, value := range before {
+		if v, ok := after[attrKey]; !ok || !reflect.DeepEqual(v, value) {
+			if old == nil {
+				old = make(map[string]interface{})
+			}
+			old[attrKey] = value
+		}
+	}
+	for attrKey, value := range after {
+		if v, ok := before[attrKey]; !ok || !reflect.DeepEqual(v, value) {
+			if current == nil {
+				current = make(map[string]interface{})
+			}
+			current[attrKey] = value
+		}
+	}
+	return old, current
+}
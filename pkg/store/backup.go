@@ -0,0 +1,396 @@
+package store
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A backup directory holds everything needed to bring a store back to the
+// state it was backed up at, described by a manifest:
+//
+//	manifest.json   format version, sequence numbers and the files below,
+//	                each with its size and SHA-256 checksum
+//	snapshot.jsonl  a snapshot of the store, in Backup's format
+//	log.jsonl       the write log records committed while the snapshot was
+//	                being written, in the write log's format
+//	schema.json     the attribute type registry and the triggers
+//
+// The snapshot is taken first and the log tail carries the backup up to the
+// sequence number the store had reached when the snapshot was complete, so
+// writers are only held off while the snapshot is captured. The directory is
+// written under a temporary name and renamed into place once complete.
+
+// backupFormat is the version of the backup directory layout. Restore
+// refuses backups of a newer format.
+const backupFormat = 1
+
+// Backup directory file names
+const (
+	backupManifestFile = "manifest.json"
+	backupSnapshotFile = "snapshot.jsonl"
+	backupLogFile      = "log.jsonl"
+	backupSchemaFile   = "schema.json"
+)
+
+// BackupManifest describes a backup directory
+type BackupManifest struct {
+	Format      int          `json:"format"`
+	Created     time.Time    `json:"created"`
+	Seq         uint64       `json:"seq"`          // the state the backup restores to
+	SnapshotSeq uint64       `json:"snapshot_seq"` // the snapshot's; the log tail covers the rest
+	Entries     int          `json:"entries"`      // in the snapshot
+	Records     int          `json:"records"`      // in the log tail
+	Files       []BackupFile `json:"files"`
+}
+
+// BackupFile is a file of a backup directory
+type BackupFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// backupSchema is the content of schema.json
+type backupSchema struct {
+	AttributeTypes map[string]string `json:"attribute_types"`
+	Triggers       []Trigger         `json:"triggers"`
+}
+
+// BackupDir writes a backup of the store to the directory dir, which must not
+// exist yet, and returns its manifest
+func (s *Store) BackupDir(dir string) (*BackupManifest, error) {
+	if _, err := os.Stat(dir); err == nil {
+		return nil, fmt.Errorf("%s already exists", dir)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	tmpDir := dir + ".tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		return nil, err
+	}
+	m, err := s.writeBackup(tmpDir)
+	if err == nil {
+		err = os.Rename(tmpDir, dir)
+	}
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		s.logger.Error("backup failed", "dir", dir, "err", err)
+		return nil, err
+	}
+	s.logger.Info("wrote a backup", "dir", dir, "seq", m.Seq, "entries", m.Entries)
+	return m, syncDir(filepath.Dir(dir))
+}
+
+// writeBackup writes the files of a backup to dir
+func (s *Store) writeBackup(dir string) (*BackupManifest, error) {
+	s.rlockAll()
+	st := s.captureState()
+	s.runlockAll()
+
+	m := &BackupManifest{Format: backupFormat, Created: time.Now().UTC(), SnapshotSeq: st.seq, Entries: len(st.keys)}
+	file, err := writeBackupFile(dir, backupSnapshotFile, func(w io.Writer) error {
+		_, err := st.WriteTo(w)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.Files = append(m.Files, file)
+
+	// Every record up to end is in the log file once it is flushed
+	end := s.Seq()
+	if err := s.flush(Logged); err != nil {
+		return nil, err
+	}
+	m.Seq = st.seq
+	file, err = writeBackupFile(dir, backupLogFile, func(w io.Writer) error {
+		path := s.logPath()
+		if path == "" || end == st.seq {
+			return nil
+		}
+		base, err := logBase(path)
+		if err != nil {
+			return err
+		}
+		if base > st.seq {
+			return errors.New("the write log was restarted during the backup; try again")
+		}
+		return readLogRange(path, st.seq, end, func(rec logRecord) error {
+			line, err := json.Marshal(rec)
+			if err != nil {
+				return err
+			}
+			m.Seq = rec.Seq
+			m.Records++
+			_, err = w.Write(append(line, '\n'))
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.Files = append(m.Files, file)
+
+	schema := backupSchema{AttributeTypes: make(map[string]string), Triggers: s.Triggers()}
+	if schema.Triggers == nil {
+		schema.Triggers = []Trigger{}
+	}
+	s.typesMutex.Lock()
+	for attrKey, metadata := range s.attributeTypes {
+		schema.AttributeTypes[attrKey] = metadata.dataType.String()
+	}
+	s.typesMutex.Unlock()
+	file, err = writeBackupFile(dir, backupSchemaFile, func(w io.Writer) error {
+		return writeIndentedJSON(w, schema)
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.Files = append(m.Files, file)
+
+	if _, err := writeBackupFile(dir, backupManifestFile, func(w io.Writer) error {
+		return writeIndentedJSON(w, m)
+	}); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// writeBackupFile creates the file name in dir with the content written by
+// fn, syncs it and returns its description
+func writeBackupFile(dir, name string, fn func(io.Writer) error) (BackupFile, error) {
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return BackupFile{}, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	bw := bufio.NewWriter(io.MultiWriter(f, hash))
+	cw := &countingWriter{w: bw}
+	if err := fn(cw); err != nil {
+		return BackupFile{}, fmt.Errorf("%s: %w", name, err)
+	}
+	if err := bw.Flush(); err != nil {
+		return BackupFile{}, err
+	}
+	if err := f.Sync(); err != nil {
+		return BackupFile{}, err
+	}
+	return BackupFile{Name: name, Size: cw.n, SHA256: hex.EncodeToString(hash.Sum(nil))}, f.Close()
+}
+
+// writeIndentedJSON writes v to w as indented JSON
+func writeIndentedJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// syncDir syncs the directory at path, making a rename in it durable
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// storeBackup is a backup directory read back into memory
+type storeBackup struct {
+	manifest *BackupManifest
+	snapshot *storeState
+	tail     []logRecord
+	schema   backupSchema
+}
+
+// readBackup reads and verifies the backup directory dir
+func readBackup(dir string) (*storeBackup, error) {
+	data, err := os.ReadFile(filepath.Join(dir, backupManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	b := &storeBackup{manifest: &BackupManifest{}}
+	if err := json.Unmarshal(data, b.manifest); err != nil {
+		return nil, fmt.Errorf("%s: %w", backupManifestFile, err)
+	}
+	if f := b.manifest.Format; f < 1 || f > backupFormat {
+		return nil, fmt.Errorf("backup format %d is not supported (want %d or older)", f, backupFormat)
+	}
+	files := make(map[string]BackupFile, len(b.manifest.Files))
+	for _, file := range b.manifest.Files {
+		if err := verifyBackupFile(dir, file); err != nil {
+			return nil, err
+		}
+		files[file.Name] = file
+	}
+	for _, name := range []string{backupSnapshotFile, backupLogFile, backupSchemaFile} {
+		if _, listed := files[name]; !listed {
+			return nil, fmt.Errorf("the manifest doesn't list %s", name)
+		}
+	}
+
+	f, err := os.Open(filepath.Join(dir, backupSnapshotFile))
+	if err != nil {
+		return nil, err
+	}
+	b.snapshot, err = decodeSnapshot(json.NewDecoder(bufio.NewReader(f)))
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", backupSnapshotFile, err)
+	}
+
+	f, err = os.Open(filepath.Join(dir, backupLogFile))
+	if err != nil {
+		return nil, err
+	}
+	seq := b.snapshot.seq
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var rec logRecord
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+		if err == nil && rec.Seq <= seq {
+			err = fmt.Errorf("record %d follows record %d", rec.Seq, seq)
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", backupLogFile, err)
+		}
+		seq = rec.Seq
+		b.tail = append(b.tail, rec)
+	}
+	f.Close()
+	if seq != b.manifest.Seq {
+		return nil, fmt.Errorf("%s ends at sequence %d, the manifest says %d", backupLogFile, seq, b.manifest.Seq)
+	}
+
+	data, err = os.ReadFile(filepath.Join(dir, backupSchemaFile))
+	if err == nil {
+		err = json.Unmarshal(data, &b.schema)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", backupSchemaFile, err)
+	}
+	return b, nil
+}
+
+// verifyBackupFile checks the size and checksum of a backup's file
+func verifyBackupFile(dir string, file BackupFile) error {
+	f, err := os.Open(filepath.Join(dir, file.Name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, f)
+	if err != nil {
+		return err
+	}
+	if n != file.Size || hex.EncodeToString(hash.Sum(nil)) != file.SHA256 {
+		return fmt.Errorf("%s is damaged: its size or checksum doesn't match the manifest", file.Name)
+	}
+	return nil
+}
+
+// Restore replaces the store's entire contents, its attribute types and its
+// triggers with those of the backup directory dir, written by BackupDir,
+// and restarts the write log with them. The backup is verified against its
+// manifest before anything is changed. A follower, a store serving
+// followers and a Raft node can't be restored.
+func (s *Store) Restore(dir string) (*BackupManifest, error) {
+	b, err := readBackup(dir)
+	if err != nil {
+		return nil, err
+	}
+	if s.raftNode != nil {
+		return nil, errors.New("a Raft node can't be restored; restore the backup into a new store instead")
+	}
+	if err := s.Writable(); err != nil {
+		return nil, err
+	}
+	s.logMutex.Lock()
+	serving := s.leader != nil
+	s.logMutex.Unlock()
+	if serving {
+		return nil, errors.New("a store with followers can't be restored, as they would diverge from it")
+	}
+
+	for _, t := range b.schema.Triggers {
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("%s: trigger %q: %w", backupSchemaFile, t.Name, err)
+		}
+	}
+	if err := s.installBackup(b); err != nil {
+		s.logger.Error("restore failed", "dir", dir, "err", err)
+		return nil, err
+	}
+	s.logger.Info("restored a backup", "dir", dir, "seq", b.manifest.Seq, "entries", b.manifest.Entries)
+	s.triggerMutex.Lock()
+	s.triggers = b.schema.Triggers
+	s.triggerMutex.Unlock()
+	return b.manifest, nil
+}
+
+// installBackup replaces the store's contents with the state of b, and its
+// write log with the backup's snapshot and log tail
+func (s *Store) installBackup(b *storeBackup) error {
+	unlock := s.lockAll()
+	defer unlock()
+
+	s.logMutex.Lock()
+	err := s.truncateLogLocked()
+	if err == nil && s.log != nil {
+		err = s.appendLocked(b.snapshot.record(), MemoryOnly)
+		for _, rec := range b.tail {
+			if err != nil {
+				break
+			}
+			err = s.appendLocked(rec, MemoryOnly)
+		}
+		if err == nil {
+			if err = s.log.buf.Flush(); err == nil {
+				err = s.checkSyncLocked(s.log, s.log.file.Sync())
+			}
+		}
+	}
+	s.logMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.installState(b.snapshot)
+	for _, rec := range b.tail {
+		entries := make(map[string]map[string]interface{})
+		for _, op := range rec.Ops {
+			if op.Op != "del" {
+				entries[op.Key] = op.Attrs
+			}
+		}
+		held, err := s.checkValues(entries)
+		if err != nil {
+			return fmt.Errorf("backup record %d: %w", rec.Seq, err)
+		}
+		s.applyOps(rec.Ops)
+		s.releaseTypes(held, nil)
+	}
+	s.logMutex.Lock()
+	s.advanceSeqLocked(b.manifest.Seq)
+	s.logMutex.Unlock()
+	return nil
+}
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// maxWriteBatch caps how many queued writes are applied per log flush
+const maxWriteBatch = 256
+
+// errStoreClosed is returned by PutAsync after Close
+var errStoreClosed = errors.New("store is closed")
+
+// PutFuture is the pending result of a PutAsync call
+type PutFuture struct {
+	done chan struct{}
+	err  error
+}
+
+// Done is closed once the write has been acknowledged or has failed
+func (f *PutFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the write is acknowledged and returns its error
+func (f *PutFuture) Wait() error {
+	<-f.done
+	return f.err
+}
+
+func (f *PutFuture) resolve(err error) {
+	f.err = err
+	close(f.done)
+}
+
+// asyncPut is a write queued for the batcher
+type asyncPut struct {
+	ctx        context.Context
+	key        string
+	attributes [][]string
+	future     *PutFuture
+}
+
+// batcher applies queued writes from a single goroutine. Everything it
+// gathers in one pass shares a single log flush, and a single fsync when the
+// store's durability is Fsynced, instead of paying for one per write.
+type batcher struct {
+	queue  chan asyncPut
+	wg     sync.WaitGroup
+	mu     sync.RWMutex // excludes enqueues while the queue is closed
+	closed bool
+}
+
+// PutAsync queues a put and returns immediately. The write is applied and
+// logged together with other queued writes by a dedicated goroutine, and the
+// returned future resolves once the batch has reached the store's default
+// durability. Queued writes become visible to readers as their batch is
+// applied, which may be before the batch's fsync completes.
+func (s *Store) PutAsync(key string, attributes [][]string) *PutFuture {
+	return s.putAsync(context.Background(), key, attributes)
+}
+
+// putAsync is PutAsync made by the actor ctx names
+func (s *Store) putAsync(ctx context.Context, key string, attributes [][]string) *PutFuture {
+	future := &PutFuture{done: make(chan struct{})}
+
+	b := s.startBatcher()
+	if b == nil {
+		future.resolve(errStoreClosed)
+		return future
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		future.resolve(errStoreClosed)
+		return future
+	}
+
+	b.queue <- asyncPut{ctx: ctx, key: key, attributes: attributes, future: future}
+	return future
+}
+
+// startBatcher returns the store's batcher, starting it on first use. It
+// returns nil if the store was closed before any async write.
+func (s *Store) startBatcher() *batcher {
+	s.batchOnce.Do(func() {
+		s.batch = &batcher{queue: make(chan asyncPut, 4*maxWriteBatch)}
+		s.batch.wg.Add(1)
+		go s.runBatcher(s.batch)
+	})
+	return s.batch
+}
+
+func (s *Store) runBatcher(b *batcher) {
+	defer b.wg.Done()
+
+	pending := make([]asyncPut, 0, maxWriteBatch)
+	errs := make([]error, 0, maxWriteBatch)
+	for first := range b.queue {
+		pending = append(pending[:0], first)
+	gather:
+		for len(pending) < maxWriteBatch {
+			select {
+			case next, ok := <-b.queue:
+				if !ok {
+					break gather
+				}
+				pending = append(pending, next)
+			default:
+				break gather
+			}
+		}
+
+		// Each write is buffered in the log and applied on its own, so a
+		// type error fails only that write; the batch is then flushed once.
+		errs = errs[:0]
+		for _, p := range pending {
+			errs = append(errs, s.put(p.ctx, p.key, p.attributes, MemoryOnly))
+		}
+		flushErr := s.flush(s.defaultDurability())
+
+		for i, p := range pending {
+			err := errs[i]
+			if err == nil {
+				err = flushErr
+			}
+			p.future.resolve(err)
+		}
+	}
+}
+
+// stopBatcher drains queued writes and stops the batcher, if it was started
+func (s *Store) stopBatcher() {
+	s.batchOnce.Do(func() {})
+	b := s.batch
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
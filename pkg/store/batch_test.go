@@ -0,0 +1,56 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// TestPutAsync queues writes, one of them breaking an attribute type, and
+// checks only that one fails, the rest are logged and replayed, and writes
+// after Close are refused
+func TestPutAsync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.log")
+	s, err := OpenStore(path, WithDurability(Fsynced))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("typed", [][]string{{"n", "1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	futures := make([]*PutFuture, 100)
+	for i := range futures {
+		futures[i] = s.PutAsync("k"+strconv.Itoa(i), [][]string{{"n", strconv.Itoa(i)}})
+	}
+	bad := s.PutAsync("bad", [][]string{{"n", "many"}})
+	for i, f := range futures {
+		if err := f.Wait(); err != nil {
+			t.Fatalf("put %d: %v", i, err)
+		}
+	}
+	if err := bad.Wait(); err == nil {
+		t.Error("the put breaking n's type succeeded")
+	}
+	if got := s.Get("k42")["n"]; got != 42.0 {
+		t.Errorf("k42 = %v, want 42", got)
+	}
+	s.Close()
+
+	if err := s.PutAsync("late", [][]string{{"n", "1"}}).Wait(); !errors.Is(err, errStoreClosed) {
+		t.Errorf("put after Close = %v, want errStoreClosed", err)
+	}
+
+	reopened, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if n := len(reopened.Keys()); n != len(futures)+1 {
+		t.Errorf("replayed %d keys, want %d", n, len(futures)+1)
+	}
+	if reopened.Get("bad") != nil {
+		t.Error("the failed put was replayed")
+	}
+}
@@ -0,0 +1,160 @@
+package store
+
+import (
+	"errors"
+	"maps"
+	"time"
+)
+
+// bulkChunkSize is how many writes a bulk load logs and applies as one record
+const bulkChunkSize = 10000
+
+// errBulkClosed is returned by the writes of a bulk load after Close
+var errBulkClosed = errors.New("bulk load is closed")
+
+// BulkLoader writes a large data set, such as the initial load of millions
+// of rows, much faster than individual puts. It holds every lock stripe for
+// the whole load instead of taking one per write, logs the writes in large
+// records without flushing each to the operating system, and syncs the log
+// only once, when the load is closed. Triggers don't fire for its writes.
+//
+// Other writers and lock-taking readers such as Search wait until the load
+// is closed; Get keeps working and sees the writes as each chunk of
+// bulkChunkSize is applied. The load is not atomic: a crash part way, or a
+// failed Close, leaves the chunks that were already logged.
+type BulkLoader struct {
+	s       *Store
+	unlock  func() // nil once closed
+	ops     []logOp
+	held    map[string]AttributeMetadata // new attribute types of ops
+	written int
+	start   time.Time
+	err     error // failure to log a chunk, which ends the load
+}
+
+// BulkStats describes a finished bulk load
+type BulkStats struct {
+	Entries int           // entries written
+	Elapsed time.Duration // from BulkLoad until Close returned
+}
+
+// Rate returns the load's throughput in entries per second
+func (st BulkStats) Rate() float64 {
+	if st.Elapsed <= 0 {
+		return 0
+	}
+	return float64(st.Entries) / st.Elapsed.Seconds()
+}
+
+// BulkLoad starts a bulk load, waiting for in-flight writes to finish. The
+// caller must Close the loader to make the load durable and release the
+// store. It fails on a follower and in Raft mode, where every write goes
+// through the cluster.
+func (s *Store) BulkLoad() (*BulkLoader, error) {
+	if s.raftNode != nil {
+		return nil, errors.New("bulk loads are not supported in Raft mode")
+	}
+	unlock := s.lockAll()
+	if err := s.Writable(); err != nil {
+		unlock()
+		return nil, err
+	}
+	return &BulkLoader{
+		s:      s,
+		unlock: unlock,
+		ops:    make([]logOp, 0, bulkChunkSize),
+		held:   make(map[string]AttributeMetadata),
+		start:  time.Now(),
+	}, nil
+}
+
+// Put is Store.Put as part of the load. A value that conflicts with its
+// attribute's type fails that put only; the load can go on.
+func (b *BulkLoader) Put(key string, attributes [][]string) error {
+	if b.unlock == nil {
+		return errBulkClosed
+	}
+	if b.err != nil {
+		return b.err
+	}
+
+	s := b.s
+	s.typesMutex.Lock()
+	pending := make(map[string]AttributeMetadata)
+	attrs, err := s.parseAttributes(key, attributes, pending)
+	if err == nil {
+		b.hold(pending)
+	}
+	s.typesMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return b.add(logOp{Op: "put", Key: key, Attrs: attrs})
+}
+
+// hold holds the new attribute types in pending until the chunk of the
+// write bringing them is logged. Caller must hold typesMutex.
+func (b *BulkLoader) hold(pending map[string]AttributeMetadata) {
+	maps.DeleteFunc(pending, func(attrKey string, _ AttributeMetadata) bool {
+		_, ok := b.held[attrKey]
+		return ok
+	})
+	b.s.holdTypes(pending)
+	maps.Copy(b.held, pending)
+}
+
+// add queues op, whose values have been checked, logging and applying the
+// queue once it holds a chunk
+func (b *BulkLoader) add(op logOp) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.ops = append(b.ops, op)
+	if len(b.ops) < bulkChunkSize {
+		return nil
+	}
+	return b.flushChunk()
+}
+
+// flushChunk logs the queued ops as one record, buffered, and applies them,
+// registering the attribute types they hold
+func (b *BulkLoader) flushChunk() error {
+	if len(b.ops) == 0 {
+		return nil
+	}
+	err := b.s.commitCopied(b.ops, MemoryOnly)
+	b.s.releaseTypes(b.held, err)
+	b.held = make(map[string]AttributeMetadata)
+	if err != nil {
+		b.err = err
+		return err
+	}
+	b.written += len(b.ops)
+	// The record keeps the slice, as replication may still be sending it
+	b.ops = make([]logOp, 0, bulkChunkSize)
+	return nil
+}
+
+// Close writes the remaining queued entries, waits until the whole load is
+// on stable storage and releases the store, returning the load's statistics
+func (b *BulkLoader) Close() (BulkStats, error) {
+	if b.unlock == nil {
+		return BulkStats{}, errBulkClosed
+	}
+	defer func() {
+		b.unlock()
+		b.unlock = nil
+	}()
+
+	err := b.err
+	if err == nil {
+		err = b.flushChunk()
+	}
+	// Types declared by an import whose entries all went in earlier chunks
+	// or that had none
+	b.s.releaseTypes(b.held, err)
+	if syncErr := b.s.Sync(); err == nil {
+		err = syncErr
+	}
+	return BulkStats{Entries: b.written, Elapsed: time.Since(b.start)}, err
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// A Cache fronts a slower system of record, such as a database, with a
+// store. A Get of a key the store has no entry for loads it with the
+// cache's Loader and keeps what it returns, and, with a Sink, every write
+// goes to the sink first and only reaches the store once the sink has
+// taken it, so the store never holds what the system of record rejected.
+// Writes and loads of a key are serialized by the cache, so the two sides
+// see a key's writes in the same order. Keys, Search and the other scans
+// cover the entries the store holds, not the system of record.
+
+// Loader returns the entry at key in the system of record, as typed
+// values, or nil and no error if it has none
+type Loader func(ctx context.Context, key string) (map[string]interface{}, error)
+
+// Sink writes the entry at key to the system of record, or deletes it for
+// nil attrs
+type Sink func(ctx context.Context, key string, attrs map[string]interface{}) error
+
+// Cache is a store in read-through, and optionally write-through, mode. It
+// has every method of its Store; those of KVStore that read or write
+// entries go through its Loader and Sink.
+type Cache struct {
+	*Store
+	load  Loader
+	sink  Sink
+	locks [defaultLockStripes]sync.Mutex
+}
+
+var _ KVStore = (*Cache)(nil)
+
+// NewCache returns s as a cache loading its misses with load and, unless
+// sink is nil, writing through to sink
+func NewCache(s *Store, load Loader, sink Sink) *Cache {
+	return &Cache{Store: s, load: load, sink: sink}
+}
+
+// lockKeys locks the cache's locks of keys, in order, returning the
+// matching unlock function
+func (c *Cache) lockKeys(keys []string) func() {
+	indexes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		indexes = append(indexes, c.stripeIndex(key)%len(c.locks))
+	}
+	slices.Sort(indexes)
+	indexes = slices.Compact(indexes)
+
+	for _, i := range indexes {
+		c.locks[i].Lock()
+	}
+	return func() {
+		for j := len(indexes) - 1; j >= 0; j-- {
+			c.locks[indexes[j]].Unlock()
+		}
+	}
+}
+
+// Get is GetCtx without a context, returning nil if loading the entry fails
+func (c *Cache) Get(key string) map[string]interface{} {
+	attrs, _ := c.GetCtx(context.Background(), key)
+	return attrs
+}
+
+// GetCtx returns the entry at key, loading it and keeping it in the store
+// if the store has none. It returns nil and no error if the system of
+// record has none either.
+func (c *Cache) GetCtx(ctx context.Context, key string) (map[string]interface{}, error) {
+	if attrs, err := c.Store.GetCtx(ctx, key); attrs != nil || err != nil {
+		return attrs, err
+	}
+	unlock := c.lockKeys([]string{key})
+	defer unlock()
+	return c.loadLocked(ctx, key)
+}
+
+// loadLocked loads the entry at key into the store unless another Get did
+// first. Caller must hold key's cache lock.
+func (c *Cache) loadLocked(ctx context.Context, key string) (map[string]interface{}, error) {
+	if attrs := c.lookup(key); attrs != nil {
+		return attrs, nil
+	}
+	attrs, err := c.load(ctx, key)
+	if attrs == nil || err != nil {
+		return nil, err
+	}
+	if err := c.Store.PutValues(key, attrs); err != nil {
+		return nil, err
+	}
+	return c.lookup(key), nil
+}
+
+// MGet is the store's MGet, then loads the keys it found missing, one at a
+// time: loaded entries are not of the same point in time as the others
+func (c *Cache) MGet(keys []string) (map[string]map[string]interface{}, []string) {
+	entries, missing := c.Store.MGet(keys)
+	var still []string
+	for _, key := range missing {
+		if attrs := c.Get(key); attrs != nil {
+			entries[key] = attrs
+		} else {
+			still = append(still, key)
+		}
+	}
+	return entries, still
+}
+
+// Put writes the entry at key, typed as the store's Put types it, to the
+// sink, if any, and then to the store
+func (c *Cache) Put(key string, attributes [][]string) error {
+	return c.PutCtx(context.Background(), key, attributes)
+}
+
+// PutCtx is Put honoring ctx, which is passed to the sink
+func (c *Cache) PutCtx(ctx context.Context, key string, attributes [][]string) error {
+	attrs, err := c.typeAttributes(key, attributes)
+	if err != nil {
+		return err
+	}
+	return c.write(ctx, key, attrs)
+}
+
+// PutValues writes the entry at key from typed values to the sink, if any,
+// and then to the store
+func (c *Cache) PutValues(key string, attrs map[string]interface{}) error {
+	if err := c.CheckValues(key, attrs, make(map[string]AttributeMetadata)); err != nil {
+		return err
+	}
+	return c.write(context.Background(), key, attrs)
+}
+
+// write writes attrs at key to the sink, if any, and then to the store.
+// Without a sink it still takes key's cache lock, so that a load of key
+// running at the same time can't overwrite the write.
+func (c *Cache) write(ctx context.Context, key string, attrs map[string]interface{}) error {
+	unlock := c.lockKeys([]string{key})
+	defer unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.sink != nil {
+		if err := c.Writable(); err != nil {
+			return err
+		}
+		if err := c.sink(ctx, key, attrs); err != nil {
+			return err
+		}
+	}
+	return c.Store.PutValues(key, attrs)
+}
+
+// Delete removes the entry at key from the sink, if any, and then from the
+// store
+func (c *Cache) Delete(key string) error {
+	_, err := c.DeleteKeys([]string{key})
+	return err
+}
+
+// DeleteKeys removes the entries at keys from the sink, if any, one at a
+// time, and then from the store, as one write, returning how many the store had. If
+// the sink fails on a key, the store is left as it was, though the sink
+// keeps the deletes it made before.
+func (c *Cache) DeleteKeys(keys []string) (int, error) {
+	unlock := c.lockKeys(keys)
+	defer unlock()
+
+	if c.sink != nil {
+		if err := c.Writable(); err != nil {
+			return 0, err
+		}
+		for _, key := range keys {
+			if err := c.sink(context.Background(), key, nil); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return c.Store.DeleteKeys(keys)
+}
+
+// typeAttributes types attributes as a put of them at key does, checking
+// them against the registered types without registering new ones
+func (s *Store) typeAttributes(key string, attributes [][]string) (map[string]interface{}, error) {
+	s.typesMutex.Lock()
+	defer s.typesMutex.Unlock()
+
+	return s.parseAttributes(key, attributes, make(map[string]AttributeMetadata))
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+)
+
+// ErrVersionConflict is returned by PutIfVersion when the key's version no
+// longer matches and no ConflictResolver is set
+var ErrVersionConflict = errors.New("version conflict")
+
+// Entry is a stored value together with its metadata. A key's version
+// starts at 1 when it is created and increases by one with every put;
+// deleting the key resets it, as it does CreatedAt.
+type Entry struct {
+	Attributes map[string]interface{}
+	Version    uint64
+	CreatedAt  time.Time // when the key was first put since it last had no entry
+	UpdatedAt  time.Time // when it was last put
+}
+
+// ConflictResolver decides the outcome when a versioned write collides with
+// a newer entry, or when replicas disagree. It receives the entry currently
+// stored and the incoming one, whose Version is the version the writer
+// expected, and returns the attributes to store: either side to pick a
+// winner (last-writer-wins) or a combination of both (field-level merge).
+// Returning an error rejects the write with that error.
+//
+// The resolver runs while the key is locked and must not call back into the
+// store for the same key.
+type ConflictResolver func(key string, current, incoming Entry) (map[string]interface{}, error)
+
+// SetConflictResolver installs r for colliding versioned writes; nil restores
+// the default of failing them with ErrVersionConflict
+func (s *Store) SetConflictResolver(r ConflictResolver) {
+	s.logMutex.Lock()
+	defer s.logMutex.Unlock()
+
+	s.resolver = r
+}
+
+// GetEntry returns the entry stored under key, with its metadata. Its
+// Attributes map is a copy the caller may keep or modify.
+func (s *Store) GetEntry(key string) (Entry, bool) {
+	value, exists := s.data.Load(key)
+	if !exists {
+		return Entry{}, false
+	}
+	e := value.(*entry)
+	return Entry{
+		Attributes: maps.Clone(e.attrs),
+		Version:    e.version,
+		CreatedAt:  time.Unix(0, e.created),
+		UpdatedAt:  time.Unix(0, e.updated),
+	}, true
+}
+
+// PutIfVersion writes attributes only if key is currently at version, where
+// version 0 means the key must not exist. On a mismatch the conflict
+// resolver, if any, picks what gets written; otherwise ErrVersionConflict is
+// returned and nothing changes, matching ErrKeyNotFound too if the key
+// doesn't exist.
+func (s *Store) PutIfVersion(key string, attributes [][]string, version uint64) error {
+	return s.putIfVersion(context.Background(), key, attributes, version)
+}
+
+// putIfVersion is PutIfVersion made by the actor ctx names
+func (s *Store) putIfVersion(ctx context.Context, key string, attributes [][]string, version uint64) (err error) {
+	_, span := s.startSpan(ctx, "cas", key)
+	defer func() {
+		s.ops.put(err)
+		endSpan(span, err)
+	}()
+	start := time.Now()
+	stripe := s.stripeFor(key)
+	stripe.Lock()
+	defer stripe.Unlock()
+	lockWaited(span, start)
+
+	if err := s.Writable(); err != nil {
+		return err
+	}
+
+	s.typesMutex.Lock()
+	newData, err := s.parseAttributes(key, attributes, make(map[string]AttributeMetadata))
+	s.typesMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.logMutex.Lock()
+	resolver := s.resolver
+	s.logMutex.Unlock()
+
+	current, exists := s.GetEntry(key)
+	if current.Version != version {
+		if resolver == nil && !exists {
+			return fmt.Errorf("%w: %w %q", ErrVersionConflict, ErrKeyNotFound, key)
+		}
+		if resolver == nil {
+			return ErrVersionConflict
+		}
+		winner, err := resolver(key, current, Entry{Attributes: newData, Version: version})
+		if err != nil {
+			return err
+		}
+		if winner == nil {
+			return fmt.Errorf("conflict resolver returned no attributes for %q", key)
+		}
+		newData = make(map[string]interface{}, len(winner))
+		for k, v := range winner {
+			newData[k] = v
+		}
+	}
+
+	held, err := s.checkValues(map[string]map[string]interface{}{key: newData})
+	if err != nil {
+		return err
+	}
+	err = s.commit([]logOp{{Op: "put", Key: key, Attrs: newData, actor: actorOf(ctx)}}, s.defaultDurability())
+	s.releaseTypes(held, err)
+	return err
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"errors"
+	"maps"
+	"reflect"
+	"testing"
+)
+
+// TestPutIfVersion creates and updates a key at its expected versions and
+// checks a stale version changes nothing
+func TestPutIfVersion(t *testing.T) {
+	s := NewStore()
+	if err := s.PutIfVersion("k", [][]string{{"n", "1"}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutIfVersion("k", [][]string{{"n", "2"}}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutIfVersion("k", [][]string{{"n", "3"}}, 1); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("put at a stale version = %v, want ErrVersionConflict", err)
+	}
+	if err := s.PutIfVersion("k", [][]string{{"n", "3"}}, 0); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("create of an existing key = %v, want ErrVersionConflict", err)
+	}
+	if e, _ := s.GetEntry("k"); e.Version != 2 || e.Attributes["n"] != 2.0 {
+		t.Errorf("k = %+v, want version 2 with n 2", e)
+	}
+	if err := s.PutIfVersion("missing", [][]string{{"n", "1"}}, 1); !errors.Is(err, ErrVersionConflict) || !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("put of a missing key at version 1 = %v, want ErrVersionConflict and ErrKeyNotFound", err)
+	}
+}
+
+// TestConflictResolver merges colliding writes field by field, and checks
+// a resolver's error, or its returning nothing, rejects the write
+func TestConflictResolver(t *testing.T) {
+	errRefused := errors.New("refused")
+	var resolver ConflictResolver = func(key string, current, incoming Entry) (map[string]interface{}, error) {
+		if incoming.Attributes["refuse"] == true {
+			return nil, errRefused
+		}
+		if incoming.Attributes["empty"] == true {
+			return nil, nil
+		}
+		merged := maps.Clone(current.Attributes)
+		maps.Copy(merged, incoming.Attributes)
+		return merged, nil
+	}
+	s := NewStore(WithConflictResolver(resolver))
+	if err := s.Put("k", [][]string{{"a", "1"}, {"b", "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("k", [][]string{{"a", "2"}, {"b", "1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Written against version 1, not knowing a became 2
+	if err := s.PutIfVersion("k", [][]string{{"b", "3"}}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.Get("k"), map[string]interface{}{"a": 2.0, "b": 3.0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("k = %v, want %v", got, want)
+	}
+	if err := s.PutIfVersion("k", [][]string{{"refuse", "true"}}, 1); !errors.Is(err, errRefused) {
+		t.Errorf("refused put = %v, want the resolver's error", err)
+	}
+	if err := s.PutIfVersion("k", [][]string{{"empty", "true"}}, 1); err == nil {
+		t.Error("put the resolver returned nothing for succeeded")
+	}
+	if e, _ := s.GetEntry("k"); e.Version != 3 {
+		t.Errorf("version = %d after rejected writes, want 3", e.Version)
+	}
+
+	// Without a resolver, the collision fails again
+	s.SetConflictResolver(nil)
+	if err := s.PutIfVersion("k", [][]string{{"b", "4"}}, 1); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("put without a resolver = %v, want ErrVersionConflict", err)
+	}
+}
@@ -0,0 +1,232 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+)
+
+// The change feed exposes the write log to consumers that track every
+// mutation of the store, such as caches and indexes. Each committed write is
+// a batch numbered with the store's sequence number, so sequence numbers
+// serve as offsets: a consumer remembers the last batch it processed and
+// resumes after it, even across restarts, for as long as the write log
+// reaches back that far. Batches come from the log file, then from the
+// commits made while the consumer reads.
+
+// ErrFeedTruncated is returned for offsets older than the oldest change the
+// store's write log still holds
+var ErrFeedTruncated = errors.New("changes after that offset are no longer in the write log")
+
+// Change is one mutation of a batch
+type Change struct {
+	Op         string                 `json:"op"` // "put", "delete", or "patch" in multi-master mode
+	Key        string                 `json:"key"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"` // the entry after a put
+	Version    uint64                 `json:"version,omitempty"`    // the entry's version, in snapshot batches
+}
+
+// ChangeBatch is the changes of one committed write, applied atomically
+type ChangeBatch struct {
+	Seq     uint64   `json:"seq"`
+	Changes []Change `json:"changes"`
+
+	// Snapshot marks a batch holding the store's entire state at Seq in
+	// place of the history before it, as the first batch read from offset 0
+	// of a compacted log
+	Snapshot bool `json:"snapshot,omitempty"`
+}
+
+// ReadChanges returns up to limit batches committed after offset from, in
+// order, or all of them if limit is 0. Reading from the last batch's Seq
+// continues where it stopped.
+func (s *Store) ReadChanges(from uint64, limit int) ([]ChangeBatch, error) {
+	batches := []ChangeBatch{}
+	err := s.ReplayChanges(from, func(batch ChangeBatch) error {
+		if limit > 0 && len(batches) == limit {
+			return errStopReplay
+		}
+		batches = append(batches, batch)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopReplay) {
+		return nil, err
+	}
+	return batches, nil
+}
+
+// ReplayChanges calls fn, in order, for every batch committed after offset
+// from up to now, stopping at the first error fn returns
+func (s *Store) ReplayChanges(from uint64, fn func(ChangeBatch) error) error {
+	records, seq, err := s.subscribeRecords()
+	if err != nil {
+		return err
+	}
+	s.unsubscribeRecords(records)
+
+	return s.replayChanges(from, seq, fn)
+}
+
+// TailChanges calls fn, in order, for every batch committed after offset
+// from, then for each batch as it is committed, until ctx is done or fn
+// returns an error, which TailChanges returns.
+func (s *Store) TailChanges(ctx context.Context, from uint64, fn func(ChangeBatch) error) error {
+	for {
+		records, seq, err := s.subscribeRecords()
+		if err != nil {
+			return err
+		}
+		err = s.replayChanges(from, seq, func(batch ChangeBatch) error {
+			from = batch.Seq
+			return fn(batch)
+		})
+		if err == nil {
+			from = seq
+			err = s.tailRecords(ctx, records, &from, fn)
+		}
+		s.unsubscribeRecords(records)
+		if !errors.Is(err, errFeedBehind) {
+			return err
+		}
+		// Fell behind; catch up from the log again
+	}
+}
+
+// errFeedBehind is returned by tailRecords when the consumer missed records
+var errFeedBehind = errors.New("change feed consumer fell behind")
+
+// errStopReplay ends a replay early
+var errStopReplay = errors.New("stop replay")
+
+// tailRecords hands fn each record received on records after offset *last,
+// advancing *last
+func (s *Store) tailRecords(ctx context.Context, records chan logRecord, last *uint64, fn func(ChangeBatch) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case rec, ok := <-records:
+			// A closed channel or a gap, left by a snapshot installed from a
+			// leader, means records were missed
+			if !ok || rec.Seq != *last+1 {
+				return errFeedBehind
+			}
+			if err := fn(changeBatch(rec)); err != nil {
+				return err
+			}
+			*last = rec.Seq
+		}
+	}
+}
+
+// replayChanges calls fn for every batch in the write log after offset
+// from, up to offset to
+func (s *Store) replayChanges(from, to uint64, fn func(ChangeBatch) error) error {
+	if from > to {
+		return fmt.Errorf("offset %d is ahead of the store, at %d", from, to)
+	}
+	if from == to {
+		return nil
+	}
+	path := s.logPath()
+	if path == "" {
+		return fmt.Errorf("%w: the store has no write log", ErrFeedTruncated)
+	}
+	base, err := logBase(path)
+	if err != nil {
+		return err
+	}
+	if from != 0 && from < base {
+		return fmt.Errorf("%w: offset %d is before the log's start at %d", ErrFeedTruncated, from, base)
+	}
+	return readLogRange(path, from, to, func(rec logRecord) error {
+		return fn(changeBatch(rec))
+	})
+}
+
+// changeBatch converts a log record to a change batch
+func changeBatch(rec logRecord) ChangeBatch {
+	batch := ChangeBatch{Seq: rec.Seq, Changes: make([]Change, len(rec.Ops)), Snapshot: rec.Snapshot}
+	for i, op := range rec.Ops {
+		batch.Changes[i] = Change{Op: op.Op, Key: op.Key, Attributes: op.Attrs, Version: op.Version}
+		if op.Op == "del" {
+			batch.Changes[i].Op = "delete"
+		}
+	}
+	return batch
+}
+
+// ReplayLog calls fn, in order, for every batch in the write log file at
+// path, which may belong to a store that is running or stopped, stopping at
+// the first error fn returns. A compacted log starts with a snapshot batch.
+func ReplayLog(path string, fn func(ChangeBatch) error) error {
+	return readLogRange(path, 0, math.MaxUint64, func(rec logRecord) error {
+		return fn(changeBatch(rec))
+	})
+}
+
+// ApplyChanges writes batch to the store atomically, as if its changes had
+// been made here, without firing triggers. Applying a store's batches in
+// order, from offset 0, to a new store rebuilds the same entries and
+// versions; a snapshot batch can only be applied to an empty store.
+func (s *Store) ApplyChanges(batch ChangeBatch) error {
+	keys := make([]string, len(batch.Changes))
+	for i, change := range batch.Changes {
+		keys[i] = change.Key
+	}
+	unlock := s.lockKeys(keys)
+	defer unlock()
+
+	if err := s.Writable(); err != nil {
+		return err
+	}
+	if batch.Snapshot {
+		empty := s.Seq() == 0
+		s.data.Range(func(_, _ interface{}) bool {
+			empty = false
+			return false
+		})
+		if !empty {
+			return errors.New("a snapshot batch can only be applied to an empty store")
+		}
+	}
+
+	ops := make([]logOp, 0, len(batch.Changes))
+	// Each key's attributes as of the changes so far, nil once deleted
+	entries := make(map[string]map[string]interface{}, len(batch.Changes))
+	for _, change := range batch.Changes {
+		op := logOp{Key: change.Key, Version: change.Version}
+		switch change.Op {
+		case "delete":
+			op.Op = "del"
+			entries[change.Key] = nil
+		case "put":
+			op.Op, op.Attrs = "put", change.Attributes
+		case "patch":
+			current, pending := entries[change.Key]
+			if !pending {
+				current = s.lookup(change.Key)
+			}
+			op.Op, op.Attrs = "put", maps.Clone(current)
+			if op.Attrs == nil {
+				op.Attrs = make(map[string]interface{}, len(change.Attributes))
+			}
+			maps.Copy(op.Attrs, change.Attributes)
+		default:
+			return fmt.Errorf("unknown change op %q for key %q", change.Op, change.Key)
+		}
+		if op.Op == "put" {
+			entries[change.Key] = op.Attrs
+		}
+		ops = append(ops, op)
+	}
+	held, err := s.checkValues(entries)
+	if err != nil {
+		return err
+	}
+	err = s.commitCopied(ops, s.defaultDurability())
+	s.releaseTypes(held, err)
+	return err
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestChangeFeed reads a store's batches from offsets, tails them through
+// a live write and applies them to a new store, which ends up with the same
+// entries and versions
+func TestChangeFeed(t *testing.T) {
+	s, err := OpenStore(filepath.Join(t.TempDir(), "data.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Put("a", [][]string{{"n", "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("a", [][]string{{"n", "2"}}); err != nil {
+		t.Fatal(err)
+	}
+	err = s.UpdateKeys([]string{"a", "b"}, func(entries map[string]map[string]interface{}) error {
+		delete(entries, "a")
+		entries["b"] = map[string]interface{}{"n": 3.0}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := s.ReadChanges(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].Seq != 1 || all[2].Seq != 3 || len(all[2].Changes) != 2 {
+		t.Fatalf("ReadChanges(0, 0) = %+v, want batches 1 to 3, the last of two changes", all)
+	}
+	if page, err := s.ReadChanges(1, 1); err != nil || len(page) != 1 || page[0].Seq != 2 {
+		t.Errorf("ReadChanges(1, 1) = %+v, %v, want batch 2 alone", page, err)
+	}
+
+	// Tail from batch 2: the backlog, then a write made while tailing
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tailed := make(chan ChangeBatch)
+	go s.TailChanges(ctx, 2, func(batch ChangeBatch) error {
+		tailed <- batch
+		return nil
+	})
+	if batch := <-tailed; batch.Seq != 3 {
+		t.Fatalf("tailed batch %d first, want 3", batch.Seq)
+	}
+	if err := s.Put("c", [][]string{{"n", "4"}}); err != nil {
+		t.Fatal(err)
+	}
+	batch := <-tailed
+	if want := []Change{{Op: "put", Key: "c", Attributes: map[string]interface{}{"n": 4.0}}}; batch.Seq != 4 || !reflect.DeepEqual(batch.Changes, want) {
+		t.Errorf("tailed %+v, want batch 4 putting c", batch)
+	}
+	cancel()
+
+	rebuilt := NewStore()
+	if err := s.ReplayChanges(0, rebuilt.ApplyChanges); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		want, _ := s.GetEntry(key)
+		got, _ := rebuilt.GetEntry(key)
+		if !reflect.DeepEqual(got.Attributes, want.Attributes) || got.Version != want.Version {
+			t.Errorf("%s = %+v, want %+v", key, got, want)
+		}
+	}
+}
+
+// TestChangeFeedRejected checks the offsets the feed can't serve and the
+// batches a store can't apply are refused
+func TestChangeFeedRejected(t *testing.T) {
+	memory := NewStore()
+	if err := memory.Put("a", [][]string{{"n", "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := memory.ReadChanges(0, 0); !errors.Is(err, ErrFeedTruncated) {
+		t.Errorf("ReadChanges without a log = %v, want ErrFeedTruncated", err)
+	}
+	if _, err := memory.ReadChanges(5, 0); err == nil {
+		t.Error("ReadChanges accepted an offset ahead of the store")
+	}
+	if err := memory.ApplyChanges(ChangeBatch{Changes: []Change{{Op: "rename", Key: "a"}}}); err == nil {
+		t.Error("ApplyChanges accepted the op rename")
+	}
+	snapshot := ChangeBatch{Seq: 1, Snapshot: true, Changes: []Change{{Op: "put", Key: "b", Attributes: map[string]interface{}{"n": 1.0}}}}
+	if err := memory.ApplyChanges(snapshot); err == nil {
+		t.Error("a snapshot batch was applied to a store with entries")
+	}
+	if memory.Get("b") != nil {
+		t.Error("the refused snapshot was applied")
+	}
+}
@@ -0,0 +1,372 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// In cluster mode the keyspace is split across servers by consistent
+// hashing. Each node is placed on a hash ring at clusterVirtualNodes points
+// and owns the keys that hash up to each of them, so adding or removing a
+// node only moves the keys next to its points. A server handles commands
+// for keys it owns and forwards the rest to their owner; commands over all
+// keys are sent to every node and the results merged. Every node must be
+// started with a node list that includes itself; nodes learn about the rest
+// of the cluster, and about each other's health, by gossip.
+
+// clusterVirtualNodes is how many ring points each node gets, which evens
+// out the share of keys each one owns
+const clusterVirtualNodes = 256
+
+// clusterPoolSize is how many idle connections are kept per peer
+const clusterPoolSize = 8
+
+// clusterDialTimeout bounds connecting to a peer
+const clusterDialTimeout = 5 * time.Second
+
+// ringPoint is one position of a node on the hash ring
+type ringPoint struct {
+	hash uint32
+	node string
+}
+
+// Cluster is one node's view of a consistent-hash partitioned cluster
+type Cluster struct {
+	self   string
+	secret string // nodes prove they know, if set (see nodeauth.go)
+
+	mu          sync.RWMutex
+	ring        []ringPoint // sorted by hash
+	peers       map[string]*clusterPeer
+	ringChanged time.Time
+	rebalancer  *rebalancer    // set by StartRebalance
+	hints       *hintedHandoff // set by EnableHints
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewCluster creates the cluster view of node self from "id=addr" pairs
+// listing self and any other nodes to start with, and starts gossiping with
+// them. Addresses are the nodes' client listen addresses. With a secret,
+// which every node must share, nodes only take each other's internal
+// commands once the sender proved it knows it.
+func NewCluster(self string, nodes []string, secret string) (*Cluster, error) {
+	c := &Cluster{
+		self:   self,
+		secret: secret,
+		peers:  make(map[string]*clusterPeer),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	now := time.Now()
+	for _, node := range nodes {
+		id, addr, ok := strings.Cut(node, "=")
+		if !ok || id == "" || addr == "" {
+			return nil, fmt.Errorf("cluster node %q is not of the form id=addr", node)
+		}
+		if _, dup := c.peers[id]; dup {
+			return nil, fmt.Errorf("cluster node %q is listed twice", id)
+		}
+		c.peers[id] = newClusterPeer(id, addr, secret, now)
+	}
+	if _, ok := c.peers[self]; !ok {
+		return nil, fmt.Errorf("cluster node list does not include this node, %q", self)
+	}
+	// Start above any heartbeat gossiped before a restart, so peers see the
+	// node come back instead of waiting for it to catch up
+	c.peers[self].heartbeat = uint64(now.UnixMilli())
+	c.rebuildRingLocked()
+	go c.runGossip()
+	return c, nil
+}
+
+// rebuildRingLocked places every known node on the ring. Caller must hold
+// mu for writing.
+func (c *Cluster) rebuildRingLocked() {
+	ids := make([]string, 0, len(c.peers))
+	for id, p := range c.peers {
+		if !p.left {
+			ids = append(ids, id)
+		}
+	}
+	c.ring = buildRing(ids)
+	c.ringChanged = time.Now()
+	if c.rebalancer != nil {
+		c.rebalancer.kick()
+	}
+}
+
+// buildRing places nodes on a hash ring
+func buildRing(ids []string) []ringPoint {
+	ring := make([]ringPoint, 0, len(ids)*clusterVirtualNodes)
+	for _, id := range ids {
+		for i := 0; i < clusterVirtualNodes; i++ {
+			ring = append(ring, ringPoint{hash: ringHash(id + "#" + strconv.Itoa(i)), node: id})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		if ring[i].hash != ring[j].hash {
+			return ring[i].hash < ring[j].hash
+		}
+		return ring[i].node < ring[j].node
+	})
+	return ring
+}
+
+// ringHash places s on the ring. A cryptographic hash is used for its
+// even spread: cheap hashes cluster similar strings such as "n1#0" and
+// "n1#1", leaving some nodes with far more keys than others.
+func ringHash(s string) uint32 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// Owner returns the ID of the node that owns key: the first node clockwise
+// from the key's position on the ring
+func (c *Cluster) Owner(key string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return ringOwner(c.ring, key)
+}
+
+// ringOwner returns the node owning key on a non-empty ring
+func ringOwner(ring []ringPoint, key string) string {
+	h := ringHash(key)
+	i := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= h })
+	if i == len(ring) {
+		i = 0
+	}
+	return ring[i].node
+}
+
+// Owns reports whether this node owns key
+func (c *Cluster) Owns(key string) bool {
+	return c.Owner(key) == c.self
+}
+
+// Addr returns the client address of node id
+func (c *Cluster) Addr(id string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if p, ok := c.peers[id]; ok {
+		return p.addr
+	}
+	return ""
+}
+
+// others returns the peers other than this node, ordered by ID
+func (c *Cluster) others() []*clusterPeer {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	others := make([]*clusterPeer, 0, len(c.peers)-1)
+	for id, p := range c.peers {
+		if id != c.self {
+			others = append(others, p)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool { return others[i].id < others[j].id })
+	return others
+}
+
+// forward sends a command to node id for it to run locally and returns the
+// reply. Nodes gossip has found dead are not tried.
+func (c *Cluster) forward(id string, args []string) (interface{}, error) {
+	c.mu.RLock()
+	p := c.peers[id]
+	state := c.stateLocked(p, time.Now())
+	c.mu.RUnlock()
+
+	if state == nodeDead {
+		return nil, fmt.Errorf("%w node %s at %s is down", errClusterDown, p.id, p.addr)
+	}
+	return p.do(append([]string{"local"}, args...))
+}
+
+// Close stops gossiping, rebalancing and handing off hints and drops the idle connections to
+// peers
+func (c *Cluster) Close() {
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+	<-c.done
+	c.mu.RLock()
+	r, h := c.rebalancer, c.hints
+	c.mu.RUnlock()
+	if r != nil {
+		<-r.done
+	}
+	if h != nil {
+		<-h.done
+	}
+
+	for _, p := range c.others() {
+		p.closeIdle()
+	}
+}
+
+// clusterPeer is a node of the cluster and a pool of connections to it
+type clusterPeer struct {
+	id     string
+	addr   string
+	secret string // proved on each new connection, if set
+	idle   chan *peerConn
+
+	// guarded by the cluster's mu
+	heartbeat uint64    // highest heartbeat gossiped by the node
+	lastSeen  time.Time // when heartbeat last increased
+	left      bool      // the node has left the ring
+	migrating bool      // the node holds keys it is moving to their owners
+}
+
+func newClusterPeer(id, addr, secret string, now time.Time) *clusterPeer {
+	return &clusterPeer{id: id, addr: addr, secret: secret, idle: make(chan *peerConn, clusterPoolSize), lastSeen: now}
+}
+
+type peerConn struct {
+	conn net.Conn
+	r    *respReader
+	w    *respWriter
+}
+
+// do runs one command on the peer. A connection that fails is discarded.
+func (p *clusterPeer) do(args []string) (interface{}, error) {
+	pc, err := p.conn()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := pc.do(args)
+	if err != nil {
+		pc.conn.Close()
+		return nil, p.unreachable(err)
+	}
+	p.release(pc)
+	return reply, nil
+}
+
+// conn takes an idle connection to the peer, or dials a new one
+func (p *clusterPeer) conn() (*peerConn, error) {
+	select {
+	case pc := <-p.idle:
+		return pc, nil
+	default:
+	}
+	conn, err := net.DialTimeout("tcp", p.addr, clusterDialTimeout)
+	if err != nil {
+		return nil, p.unreachable(err)
+	}
+	pc := &peerConn{conn: conn, r: newRESPReader(conn), w: newRESPWriter(conn)}
+	if p.secret != "" {
+		if err := pc.authenticate(p.secret); err != nil {
+			conn.Close()
+			return nil, p.unreachable(err)
+		}
+	}
+	return pc, nil
+}
+
+// release returns a healthy connection to the idle pool
+func (p *clusterPeer) release(pc *peerConn) {
+	select {
+	case p.idle <- pc:
+	default:
+		pc.conn.Close()
+	}
+}
+
+// do sends one command over the connection and reads its reply
+func (pc *peerConn) do(args []string) (interface{}, error) {
+	pc.w.WriteStrings(args)
+	if err := pc.w.Flush(); err != nil {
+		return nil, err
+	}
+	return pc.r.ReadReply()
+}
+
+func (p *clusterPeer) unreachable(err error) error {
+	return fmt.Errorf("%w node %s at %s is unreachable: %v", errClusterDown, p.id, p.addr, err)
+}
+
+func (p *clusterPeer) closeIdle() {
+	for {
+		select {
+		case pc := <-p.idle:
+			pc.conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// errClusterDown is returned when a peer that a command needs can't be
+// reached
+var errClusterDown = errors.New("CLUSTERDOWN")
+
+// errNotOwner is returned for transactions on keys another node owns
+var errNotOwner = errors.New("CROSSSLOT")
+
+// checkOwned returns errNotOwner unless this node owns every key
+func (c *Cluster) checkOwned(keys []string) error {
+	for _, key := range keys {
+		if owner := c.Owner(key); owner != c.self {
+			return fmt.Errorf("%w key %q belongs to node %s; run transactions on that node", errNotOwner, key, owner)
+		}
+	}
+	return nil
+}
+
+// gatherStrings sends a command to every other node that may hold keys and
+// merges the string arrays they reply with into local, sorted and deduplicated
+func (c *Cluster) gatherStrings(local []string, args []string) ([]string, error) {
+	c.mu.RLock()
+	holders := make([]*clusterPeer, 0, len(c.peers))
+	for id, p := range c.peers {
+		// Nodes that have left and handed their keys over hold none
+		if id != c.self && (!p.left || p.migrating) {
+			holders = append(holders, p)
+		}
+	}
+	c.mu.RUnlock()
+	return gatherStrings(holders, local, args)
+}
+
+// gatherStrings runs a command locally on each of peers and merges the
+// string arrays they reply with into local, sorted
+func gatherStrings(peers []*clusterPeer, local []string, args []string) ([]string, error) {
+	merged := append([]string(nil), local...)
+	for _, p := range peers {
+		reply, err := p.do(append([]string{"local"}, args...))
+		if err != nil {
+			return nil, err
+		}
+		switch reply := reply.(type) {
+		case respError:
+			return nil, fmt.Errorf("node %s: %s", p.id, reply)
+		case []interface{}:
+			for _, item := range reply {
+				if s, ok := item.(string); ok {
+					merged = append(merged, s)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("node %s: unexpected reply to %s", p.id, args[0])
+		}
+	}
+	// A key being moved between nodes is briefly held by both
+	sort.Strings(merged)
+	return slices.Compact(merged), nil
+}
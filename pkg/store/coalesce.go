@@ -0,0 +1,144 @@
+package store
+
+import (
+	"time"
+)
+
+// Debounce and Batch sit between a subscription channel and a consumer that
+// can't take every event of a hot key. Both close their channel once the
+// subscription's is closed, after handing over what they hold, so a
+// consumer still stops them with Store.Unsubscribe on the original channel.
+
+// debounceSlot is one key's state in Debounce
+type debounceSlot struct {
+	event ChangeEvent
+	held  bool      // event is waiting to be sent
+	due   time.Time // when the key may be sent next
+}
+
+// Debounce passes on at most one event per key per interval from events.
+// The first change to a key is sent at once; later ones within the interval
+// are merged, and the merged event, from the attributes before the first to
+// those after the last, is sent when the interval ends. Changes arriving
+// while the consumer is busy are merged the same way, so a slow consumer
+// holds at most one event per key instead of falling behind.
+func Debounce(events <-chan ChangeEvent, interval time.Duration) <-chan ChangeEvent {
+	out := make(chan ChangeEvent)
+	go func() {
+		defer close(out)
+
+		slots := make(map[string]*debounceSlot)
+		timer := time.NewTimer(time.Hour)
+		defer timer.Stop()
+
+		for {
+			// Find the event to send next, the earliest due, and when to
+			// look again otherwise
+			now := time.Now()
+			var next *debounceSlot
+			var wake time.Time
+			for key, slot := range slots {
+				switch {
+				case slot.held && !slot.due.After(now):
+					if next == nil || slot.due.Before(next.due) {
+						next = slot
+					}
+				case !slot.held && !slot.due.After(now):
+					delete(slots, key) // quiet for a whole interval
+				case wake.IsZero() || slot.due.Before(wake):
+					wake = slot.due
+				}
+			}
+			send := chan ChangeEvent(nil)
+			var event ChangeEvent
+			if next != nil {
+				send, event = out, next.event
+			}
+			var tick <-chan time.Time
+			if !wake.IsZero() {
+				timer.Reset(time.Until(wake))
+				tick = timer.C
+			}
+
+			select {
+			case e, ok := <-events:
+				if !ok {
+					flushDebounced(out, slots)
+					return
+				}
+				slot := slots[e.Key]
+				switch {
+				case slot == nil:
+					slots[e.Key] = &debounceSlot{event: e, held: true, due: now}
+				case slot.held:
+					slot.event = mergeEvents(slot.event, e)
+				default:
+					slot.event, slot.held = e, true
+				}
+			case send <- event:
+				next.held = false
+				next.due = time.Now().Add(interval)
+			case <-tick:
+			}
+		}
+	}()
+	return out
+}
+
+// flushDebounced sends the events slots still hold, oldest due first
+func flushDebounced(out chan<- ChangeEvent, slots map[string]*debounceSlot) {
+	for {
+		var next *debounceSlot
+		for _, slot := range slots {
+			if slot.held && (next == nil || slot.due.Before(next.due)) {
+				next = slot
+			}
+		}
+		if next == nil {
+			return
+		}
+		out <- next.event
+		next.held = false
+	}
+}
+
+// mergeEvents combines two consecutive events of one key into one
+func mergeEvents(first, second ChangeEvent) ChangeEvent {
+	second.Old = first.Old
+	return second
+}
+
+// Batch passes on the events from events in slices of up to size, sending
+// a smaller one once wait has passed since its first event arrived, so a
+// consumer pays for a channel receive per batch rather than per event.
+func Batch(events <-chan ChangeEvent, size int, wait time.Duration) <-chan []ChangeEvent {
+	size = max(size, 1)
+	out := make(chan []ChangeEvent)
+	go func() {
+		defer close(out)
+
+		for {
+			first, ok := <-events
+			if !ok {
+				return
+			}
+			batch := append(make([]ChangeEvent, 0, size), first)
+			deadline := time.NewTimer(wait)
+		collect:
+			for len(batch) < size {
+				select {
+				case e, ok := <-events:
+					if !ok {
+						break collect
+					}
+					batch = append(batch, e)
+				case <-deadline.C:
+					break collect
+				}
+			}
+			deadline.Stop()
+			out <- batch
+		}
+	}()
+	return out
+}
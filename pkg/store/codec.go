@@ -0,0 +1,297 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A codec encodes the records of the write log, the replication stream
+// between a leader and its followers, and gob and msgpack exports. JSON,
+// the default, keeps the log readable with standard tools; msgpack is
+// smaller and faster to decode; gob is Go's own format, for exports read
+// by other Go programs. JSON values are written one per line, as they
+// always were, and the values of the binary codecs each after their
+// length as a uvarint.
+
+// Codec encodes and decodes the store's records and entries
+type Codec interface {
+	// Name is the codec's name, as ParseCodec takes it
+	Name() string
+	// Encode returns the encoding of v
+	Encode(v interface{}) ([]byte, error)
+	// Decode decodes data into the value v points to
+	Decode(data []byte, v interface{}) error
+}
+
+// The codecs
+var (
+	// JSONCodec encodes values as encoding/json does
+	JSONCodec Codec = jsonCodec{}
+	// GobCodec encodes values as encoding/gob does, each value on its own
+	// with its type, so any one can be decoded without those before it
+	GobCodec Codec = gobCodec{}
+	// MsgpackCodec encodes values in MessagePack (https://msgpack.org),
+	// structs as maps keyed by their fields' json names
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+// Codecs lists the names ParseCodec takes
+var Codecs = []string{"json", "gob", "msgpack"}
+
+// ParseCodec returns the codec named name, one of Codecs
+func ParseCodec(name string) (Codec, error) {
+	switch name {
+	case "json":
+		return JSONCodec, nil
+	case "gob":
+		return GobCodec, nil
+	case "msgpack":
+		return MsgpackCodec, nil
+	}
+	return nil, fmt.Errorf("unknown codec %q; want one of %s", name, strings.Join(Codecs, ", "))
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Encode(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Decode(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Decode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// maxFrame bounds the length a binary frame may claim, so a corrupt length
+// can't make readFrame allocate without limit
+const maxFrame = 1 << 30
+
+// appendFrame appends the encoding of v by c to buf, framed as c's values
+// are
+func appendFrame(buf []byte, c Codec, v interface{}) ([]byte, error) {
+	data, err := c.Encode(v)
+	if err != nil {
+		return buf, err
+	}
+	if c == JSONCodec {
+		buf = append(buf, data...)
+		return append(buf, '\n'), nil
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...), nil
+}
+
+// readFrame reads the next value framed for c from r, returning its
+// encoding and the number of bytes the frame took. It returns io.EOF at
+// the end of r and io.ErrUnexpectedEOF for a frame cut short.
+func readFrame(r *bufio.Reader, c Codec) ([]byte, int, error) {
+	if c == JSONCodec {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return line, len(line), err
+	}
+
+	var n int
+	length, err := binary.ReadUvarint(byteCounter{r, &n})
+	if err != nil {
+		if err == io.EOF && n > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, n, err
+	}
+	if length > maxFrame {
+		return nil, n, fmt.Errorf("frame of %d bytes is too long", length)
+	}
+	data := make([]byte, length)
+	read, err := io.ReadFull(r, data)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return data, n + read, err
+}
+
+// byteCounter is a ByteReader counting the bytes read through it
+type byteCounter struct {
+	r *bufio.Reader
+	n *int
+}
+
+func (b byteCounter) ReadByte() (byte, error) {
+	c, err := b.r.ReadByte()
+	if err == nil {
+		*b.n++
+	}
+	return c, err
+}
+
+// frameEncoder writes values framed for a codec to w, as a json.Encoder
+// does for JSON
+type frameEncoder struct {
+	w     io.Writer
+	codec Codec
+	buf   []byte
+}
+
+func newFrameEncoder(w io.Writer, c Codec) *frameEncoder {
+	return &frameEncoder{w: w, codec: c}
+}
+
+func (e *frameEncoder) Encode(v interface{}) error {
+	buf, err := appendFrame(e.buf[:0], e.codec, v)
+	if err != nil {
+		return err
+	}
+	e.buf = buf
+	_, err = e.w.Write(buf)
+	return err
+}
+
+// frameDecoder reads values framed for a codec, as a json.Decoder does
+// for JSON
+type frameDecoder struct {
+	r     *bufio.Reader
+	codec Codec
+}
+
+func newFrameDecoder(r io.Reader, c Codec) *frameDecoder {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &frameDecoder{r: br, codec: c}
+}
+
+func (d *frameDecoder) Decode(v interface{}) error {
+	data, _, err := readFrame(d.r, d.codec)
+	if err != nil {
+		return err
+	}
+	return d.codec.Decode(data, v)
+}
+
+// valueEncoder and valueDecoder are what json.Encoder and json.Decoder
+// have in common with frameEncoder and frameDecoder
+type (
+	valueEncoder interface{ Encode(v interface{}) error }
+	valueDecoder interface{ Decode(v interface{}) error }
+)
+
+// codecHeader starts the files written in a binary codec, naming it:
+// "KVLOG msgpack\n". JSON files have none, as they had none before there
+// were other codecs.
+const codecHeader = "KVLOG "
+
+// writeCodecHeader writes the header of a file in codec c, if it has one
+func writeCodecHeader(w io.Writer, c Codec) error {
+	if c == JSONCodec {
+		return nil
+	}
+	_, err := io.WriteString(w, codecHeader+c.Name()+"\n")
+	return err
+}
+
+// readCodecHeader reads the header of a file, returning the codec it
+// names, or JSONCodec if it has none, and the header's length
+func readCodecHeader(r *bufio.Reader) (Codec, int, error) {
+	start, err := r.Peek(len(codecHeader))
+	if err != nil || string(start) != codecHeader {
+		return JSONCodec, 0, nil
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, len(line), errors.New("the file's codec header is cut short")
+	}
+	c, err := ParseCodec(strings.TrimSuffix(strings.TrimPrefix(line, codecHeader), "\n"))
+	return c, len(line), err
+}
+
+// Gob and msgpack exports are a codec header, then a frame holding the
+// attribute types and the number of entries, then a frame per entry, so
+// they stream like the JSON export
+
+// codecExportHeader is the first frame of a gob or msgpack export
+type codecExportHeader struct {
+	AttributeTypes map[string]string `json:"attribute_types"`
+	Entries        int               `json:"entries"`
+}
+
+// codecExportEntry is an entry frame of a gob or msgpack export
+type codecExportEntry struct {
+	Key        string                 `json:"key"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// codecExportWriter returns the writer of exports in codec c
+func codecExportWriter(c Codec) exportWriter {
+	return func(w *bufio.Writer, st *storeState, keys []string) error {
+		if err := writeCodecHeader(w, c); err != nil {
+			return err
+		}
+		enc := newFrameEncoder(w, c)
+		header := codecExportHeader{AttributeTypes: make(map[string]string, len(st.types)), Entries: len(keys)}
+		for attrKey, metadata := range st.types {
+			header.AttributeTypes[attrKey] = metadata.dataType.String()
+		}
+		if err := enc.Encode(header); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := enc.Encode(codecExportEntry{Key: key, Attributes: st.entries[key].attrs}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// readCodecExport reads an export written in codec c
+func readCodecExport(r io.Reader, c Codec) (*importSet, error) {
+	br := bufio.NewReader(r)
+	named, _, err := readCodecHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if named != c {
+		return nil, fmt.Errorf("not a %s export", c.Name())
+	}
+	dec := newFrameDecoder(br, c)
+	var header codecExportHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("reading %s export: %w", c.Name(), err)
+	}
+	types, err := parseDeclaredTypes(header.AttributeTypes)
+	if err != nil {
+		return nil, err
+	}
+	set := &importSet{types: types, entries: make(map[string]map[string]interface{}, header.Entries)}
+	for i := 0; i < header.Entries; i++ {
+		var e codecExportEntry
+		if err := dec.Decode(&e); err != nil {
+			return nil, fmt.Errorf("reading %s export: %w", c.Name(), err)
+		}
+		set.entries[e.Key] = e.Attributes
+	}
+	return set, nil
+}
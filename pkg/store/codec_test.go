@@ -0,0 +1,93 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// codecValues are values of every type the codecs encode: log records,
+// plain, stamped and from a snapshot, and the frames of an export
+func codecValues() []interface{} {
+	const t = 1760400000123456789
+	return []interface{}{
+		&logRecord{Seq: 1, Ops: []logOp{
+			{Op: "put", Key: "user1", Attrs: map[string]interface{}{"name": "ann", "age": 30.0, "admin": true}, Time: t},
+		}},
+		&logRecord{Seq: 2, Ops: []logOp{
+			{Op: "del", Key: "user1", Time: t + 1},
+			{Op: "patch", Key: "tenantA/k", Attrs: map[string]interface{}{"n": -1.5, "empty": ""}, Stamp: &hlcStamp{Wall: t, Node: "node-a"}, Time: t + 2},
+		}},
+		&logRecord{Seq: 70000, Snapshot: true, Ops: []logOp{
+			{Op: "put", Key: "big", Attrs: map[string]interface{}{"text": strings.Repeat("x", 70000), "max": 1e300, "tiny": 5e-324, "neg": -4096.0}, Version: 1 << 33, Time: t, Created: t - 1},
+		}},
+		&codecExportHeader{AttributeTypes: map[string]string{"age": "float", "tenantA/n": "string"}, Entries: 2},
+		&codecExportEntry{Key: "user1", Attributes: map[string]interface{}{"name": "ann", "score": 0.0}},
+		&codecExportEntry{Key: "nothing"},
+	}
+}
+
+// TestMsgpackGolden decodes values the msgpack codec wrote before it was
+// moved onto the go-msgpack library, so logs and exports written then
+// still read back the same
+func TestMsgpackGolden(t *testing.T) {
+	golden := filepath.Join("testdata", "codec.msgpack")
+	if *update {
+		var buf []byte
+		for _, v := range codecValues() {
+			var err error
+			if buf, err = appendFrame(buf, MsgpackCodec, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(golden, buf, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(bytes.NewReader(data))
+	for i, want := range codecValues() {
+		frame, _, err := readFrame(r, MsgpackCodec)
+		if err != nil {
+			t.Fatalf("value %d: %v", i, err)
+		}
+		got := reflect.New(reflect.TypeOf(want).Elem()).Interface()
+		if err := MsgpackCodec.Decode(frame, got); err != nil {
+			t.Fatalf("value %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("value %d = %+v, want %+v", i, got, want)
+		}
+	}
+	if _, _, err := readFrame(r, MsgpackCodec); err != io.EOF {
+		t.Errorf("after the last value: %v, want EOF", err)
+	}
+}
+
+// TestCodecRoundTrip encodes and decodes the values in every codec
+func TestCodecRoundTrip(t *testing.T) {
+	for _, c := range []Codec{JSONCodec, GobCodec, MsgpackCodec} {
+		for i, want := range codecValues() {
+			data, err := c.Encode(want)
+			if err != nil {
+				t.Fatalf("%s value %d: %v", c.Name(), i, err)
+			}
+			got := reflect.New(reflect.TypeOf(want).Elem()).Interface()
+			if err := c.Decode(data, got); err != nil {
+				t.Fatalf("%s value %d: %v", c.Name(), i, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s value %d = %+v, want %+v", c.Name(), i, got, want)
+			}
+		}
+	}
+}
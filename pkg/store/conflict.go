@@ -0,0 +1,111 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConflictStrategy decides what an import does with an entry whose key the
+// store already holds
+type ConflictStrategy int
+
+const (
+	// OverwriteExisting replaces the stored entry with the imported one
+	OverwriteExisting ConflictStrategy = iota
+
+	// SkipExisting keeps the stored entry and leaves the imported one out
+	SkipExisting
+
+	// MergeExisting adds the imported attributes to the stored entry; an
+	// attribute both have takes the imported value
+	MergeExisting
+
+	// AbortOnConflict fails the import, writing nothing, if any imported key
+	// is already stored
+	AbortOnConflict
+)
+
+// ConflictStrategies lists the names of the strategies, in constant order
+var ConflictStrategies = []string{"overwrite", "skip", "merge", "abort"}
+
+func (c ConflictStrategy) String() string {
+	if c >= 0 && int(c) < len(ConflictStrategies) {
+		return ConflictStrategies[c]
+	}
+	return fmt.Sprintf("ConflictStrategy(%d)", int(c))
+}
+
+// ParseConflictStrategy returns the strategy named name
+func ParseConflictStrategy(name string) (ConflictStrategy, error) {
+	for i, strategy := range ConflictStrategies {
+		if strings.EqualFold(name, strategy) {
+			return ConflictStrategy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown conflict strategy %q; want one of %s", name, strings.Join(ConflictStrategies, ", "))
+}
+
+// ImportConflictError is returned by an import with AbortOnConflict whose
+// entries include stored keys
+type ImportConflictError struct {
+	Keys []string // the imported keys the store already holds, in order
+}
+
+func (e *ImportConflictError) Error() string {
+	const shown = 10
+	keys := strings.Join(e.Keys[:min(shown, len(e.Keys))], ", ")
+	if len(e.Keys) > shown {
+		keys += fmt.Sprintf(" and %d more", len(e.Keys)-shown)
+	}
+	return fmt.Sprintf("%d imported keys already exist, nothing was imported: %s", len(e.Keys), keys)
+}
+
+// resolveConflictsLocked applies strategy to the imported entries under keys,
+// sorting each key into report's buckets. It returns the keys to write and
+// their entries, which for merged keys hold the stored attributes too.
+// Caller must hold the stripes of keys.
+func (s *Store) resolveConflictsLocked(keys []string, entries map[string]map[string]interface{}, strategy ConflictStrategy, report *ImportReport) ([]string, map[string]map[string]interface{}, error) {
+	var existing []string
+	for _, key := range keys {
+		if _, exists := s.data.Load(key); exists {
+			existing = append(existing, key)
+		}
+	}
+	if len(existing) == 0 {
+		report.Added = keys
+		return keys, entries, nil
+	}
+	if strategy == AbortOnConflict {
+		return nil, nil, &ImportConflictError{Keys: existing}
+	}
+
+	write := make([]string, 0, len(keys))
+	resolved := make(map[string]map[string]interface{}, len(entries))
+	for _, key := range keys {
+		stored := s.lookup(key)
+		switch {
+		case stored == nil:
+			report.Added = append(report.Added, key)
+		case strategy == SkipExisting:
+			report.Kept = append(report.Kept, key)
+			continue
+		case strategy == MergeExisting:
+			merged := make(map[string]interface{}, len(stored)+len(entries[key]))
+			for attrKey, value := range stored {
+				merged[attrKey] = value
+			}
+			for attrKey, value := range entries[key] {
+				merged[attrKey] = value
+			}
+			resolved[key] = merged
+			report.Merged = append(report.Merged, key)
+			write = append(write, key)
+			continue
+		default:
+			report.Overwritten = append(report.Overwritten, key)
+		}
+		resolved[key] = entries[key]
+		write = append(write, key)
+	}
+	return write, resolved, nil
+}
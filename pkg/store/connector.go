@@ -0,0 +1,286 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A connector publishes every change to keys matching its patterns to a
+// Kafka topic or a NATS subject, one message per change, in the order the
+// changes were committed. Like a webhook it follows the change feed from the
+// moment it starts, but a connector never gives up on a change: a publish
+// that fails is retried with backoff, over a new connection, until the
+// broker takes it, so the changes behind it wait rather than being lost.
+// Only the changes the write log no longer holds are skipped.
+
+// connectorClientID identifies the store to brokers
+const connectorClientID = "key-value-go"
+
+// connectorTimeout bounds each exchange with the broker
+const connectorTimeout = 10 * time.Second
+
+// connectorBackoff is the wait before the first retry of a publish; it
+// doubles with each later one, up to connectorMaxBackoff
+const (
+	connectorBackoff    = 100 * time.Millisecond
+	connectorMaxBackoff = 30 * time.Second
+)
+
+// ConnectorFormats lists the serializations a connector supports: the JSON
+// object a webhook sends, the same object in MessagePack, and a text line in
+// the syntax of the CLI's put and delete commands
+var ConnectorFormats = []string{"json", "msgpack", "text"}
+
+// brokerPublisher sends messages to one topic or subject of a broker
+type brokerPublisher interface {
+	// publish sends value, keyed by key where the broker supports keys,
+	// and returns once the broker has accepted it. After an error the next
+	// publish starts over on a new connection.
+	publish(ctx context.Context, key string, value []byte) error
+	close()
+}
+
+// Connector publishes a store's changes to a message broker
+type Connector struct {
+	store    *Store
+	url      string
+	format   string
+	patterns []string
+	broker   brokerPublisher
+
+	mu        sync.Mutex
+	offset    uint64 // last sequence number handled
+	published uint64
+	skipped   uint64 // changes the feed could no longer provide
+	lastErr   error
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartConnector publishes every change to keys matching any of patterns,
+// or to every key if there are none, to the broker at rawURL, serialized as
+// format, one of ConnectorFormats. The URL is kafka://host:port/topic, with
+// more bootstrap brokers separated by commas, or nats://host:port/subject,
+// optionally with a user and password.
+func (s *Store) StartConnector(rawURL, format string, patterns []string) (*Connector, error) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad key pattern %q: %w", pattern, err)
+		}
+	}
+	found := false
+	for _, f := range ConnectorFormats {
+		found = found || f == format
+	}
+	if !found {
+		return nil, fmt.Errorf("unknown connector format %q; want one of %s", format, strings.Join(ConnectorFormats, ", "))
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("connector URL %q needs a host and a topic or subject, as in kafka://localhost:9092/changes", rawURL)
+	}
+	var broker brokerPublisher
+	switch u.Scheme {
+	case "kafka":
+		broker = newKafkaProducer(strings.Split(u.Host, ","), name)
+	case "nats":
+		if !validNATSSubject(name) {
+			return nil, fmt.Errorf("bad NATS subject %q", name)
+		}
+		password, _ := u.User.Password()
+		broker = &natsPublisher{addr: u.Host, subject: name, user: u.User.Username(), password: password}
+	default:
+		return nil, fmt.Errorf("unknown connector scheme %q; want kafka or nats", u.Scheme)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Connector{
+		store:    s,
+		url:      u.Redacted(),
+		format:   format,
+		patterns: patterns,
+		broker:   broker,
+		offset:   s.Seq(),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+
+	s.logMutex.Lock()
+	s.connectors = append(s.connectors, c)
+	s.logMutex.Unlock()
+
+	go c.run(ctx)
+	return c, nil
+}
+
+// Close stops the connector, abandoning a publish in progress
+func (c *Connector) Close() {
+	c.cancel()
+	<-c.done
+	c.broker.close()
+}
+
+func (c *Connector) run(ctx context.Context) {
+	defer close(c.done)
+
+	for {
+		err := c.store.TailChanges(ctx, c.Offset(), func(batch ChangeBatch) error {
+			if err := c.publishBatch(ctx, batch); err != nil {
+				return err
+			}
+			c.mu.Lock()
+			c.offset = batch.Seq
+			c.mu.Unlock()
+			return nil
+		})
+		if ctx.Err() != nil {
+			return
+		}
+
+		// The feed can't reach back to the changes not yet published; skip
+		// them
+		seq := c.store.Seq()
+		c.mu.Lock()
+		c.lastErr = fmt.Errorf("skipped changes %d to %d: %w", c.offset+1, seq, err)
+		c.store.logger.Error("connector skipped changes", "url", c.url, "from", c.offset+1, "to", seq, "err", err)
+		c.skipped += seq - c.offset
+		c.offset = seq
+		c.mu.Unlock()
+	}
+}
+
+// publishBatch publishes the changes of batch that match the connector's
+// patterns, returning an error only once ctx is done
+func (c *Connector) publishBatch(ctx context.Context, batch ChangeBatch) error {
+	for _, change := range batch.Changes {
+		if !c.matches(change.Key) {
+			continue
+		}
+		value, err := encodeChange(c.format, batch.Seq, change)
+		if err != nil {
+			return err
+		}
+
+		backoff := connectorBackoff
+		for {
+			err := c.broker.publish(ctx, change.Key, value)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			c.mu.Lock()
+			if err == nil {
+				c.published++
+			} else {
+				c.lastErr = fmt.Errorf("publishing change %d to %q: %w", batch.Seq, change.Key, err)
+				c.store.logger.Warn("connector failed to publish a change; retrying", "url", c.url, "seq", batch.Seq, "key", change.Key, "err", err)
+			}
+			c.mu.Unlock()
+			if err == nil {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, connectorMaxBackoff)
+		}
+	}
+	return nil
+}
+
+// encodeChange serializes change, from batch seq, as format
+func encodeChange(format string, seq uint64, change Change) ([]byte, error) {
+	payload := changePayload{Seq: seq, Key: change.Key, Op: change.Op, Attributes: change.Attributes}
+	switch format {
+	case "json":
+		return json.Marshal(payload)
+	case "msgpack":
+		return MsgpackCodec.Encode(payload)
+	case "text":
+		words := []string{change.Op, change.Key}
+		names := make([]string, 0, len(change.Attributes))
+		for name := range change.Attributes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			words = append(words, name, FormatValue(change.Attributes[name]))
+		}
+		return []byte(strings.Join(words, " ")), nil
+	}
+	return nil, errors.New("unknown connector format " + format)
+}
+
+// matches reports whether the connector covers key
+func (c *Connector) matches(key string) bool {
+	if len(c.patterns) == 0 {
+		return true
+	}
+	for _, pattern := range c.patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Offset returns the sequence number of the last change the connector
+// handled
+func (c *Connector) Offset() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.offset
+}
+
+// info describes the connector as a "name:value" line for ConnectorInfo
+func (c *Connector) info(i int) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	line := fmt.Sprintf("connector%d:url=%s,format=%s,offset=%d,published=%d,skipped=%d", i, c.url, c.format, c.offset, c.published, c.skipped)
+	if len(c.patterns) > 0 {
+		line += ",keys=" + strings.Join(c.patterns, " ")
+	}
+	if c.lastErr != nil {
+		line += ",last_error=" + c.lastErr.Error()
+	}
+	return line
+}
+
+// ConnectorInfo reports the store's connectors as "name:value" lines
+func (s *Store) ConnectorInfo() []string {
+	s.logMutex.Lock()
+	connectors := append([]*Connector(nil), s.connectors...)
+	s.logMutex.Unlock()
+
+	lines := []string{fmt.Sprintf("connectors:%d", len(connectors))}
+	for i, c := range connectors {
+		lines = append(lines, c.info(i))
+	}
+	return lines
+}
+
+// deadlineOnCancel makes every read and write on conn fail once ctx is done,
+// until the returned function is called
+func deadlineOnCancel(ctx context.Context, conn interface{ SetDeadline(time.Time) error }) func() bool {
+	conn.SetDeadline(time.Now().Add(connectorTimeout))
+	return context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+}
@@ -0,0 +1,236 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// In multi-master mode every node accepts writes and pulls every other
+// node's writes over sync links, so changes spread without a leader and
+// nodes converge once they have seen the same writes, in whatever order.
+// Each write carries a hybrid logical clock stamp, unique per node and
+// ordered by wall time first, and every key keeps the stamps that decide
+// which writes win:
+//
+//   - clear is the stamp of the latest put or delete of the whole entry;
+//     attributes last assigned before it are gone
+//   - attrs holds the stamp each present attribute was assigned at
+//
+// A put replaces the entry if it is newer than clear; a delete likewise
+// removes it. A patch, which is what puts become in per-attribute mode,
+// assigns each of its attributes that it is newer for, leaving the others
+// alone. Applying a write twice, or out of order, gives the same result, so
+// it doesn't matter that a write reaches a node both directly and through
+// other nodes. Writes that change nothing aren't logged, which stops them
+// from going round the mesh forever.
+//
+// Deleted keys keep their stamps as tombstones, so a delete still beats an
+// older put that arrives after it. The attribute type registry is not
+// merged: a write whose value type conflicts with the receiving node's is
+// skipped there, and shows up as skipped on its sync link.
+
+// CRDTMode selects how concurrent writes to a key merge in multi-master mode
+type CRDTMode int
+
+const (
+	// CRDTEntry keeps the entry written by the newest put or delete
+	CRDTEntry CRDTMode = iota + 1
+	// CRDTAttribute makes puts set only the attributes they name, each
+	// attribute keeping its newest value
+	CRDTAttribute
+)
+
+// ParseCRDTMode maps "lww" or "attr" to a CRDTMode
+func ParseCRDTMode(name string) (CRDTMode, error) {
+	switch strings.ToLower(name) {
+	case "lww":
+		return CRDTEntry, nil
+	case "attr":
+		return CRDTAttribute, nil
+	}
+	return 0, fmt.Errorf("unknown merge mode %q: use lww or attr", name)
+}
+
+// hlcStamp is a hybrid logical clock timestamp
+type hlcStamp struct {
+	Wall int64  `json:"wall"` // nanoseconds, at least the wall clock
+	Node string `json:"node"` // breaks ties between nodes
+}
+
+// after reports whether a is later than b; everything is later than the
+// zero stamp
+func (a hlcStamp) after(b hlcStamp) bool {
+	if a.Wall != b.Wall {
+		return a.Wall > b.Wall
+	}
+	return a.Node > b.Node
+}
+
+// crdtClock stamps a node's own writes
+type crdtClock struct {
+	node string
+	mode CRDTMode
+
+	mu   sync.Mutex
+	last int64
+}
+
+// next returns a stamp later than any this node has issued or seen
+func (c *crdtClock) next() hlcStamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	if now <= c.last {
+		now = c.last + 1
+	}
+	c.last = now
+	return hlcStamp{Wall: now, Node: c.node}
+}
+
+// observe moves the clock past a stamp received from another node, so this
+// node's next write to the same key wins over it even if clocks disagree
+func (c *crdtClock) observe(stamp hlcStamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if stamp.Wall > c.last {
+		c.last = stamp.Wall
+	}
+}
+
+// crdtMeta holds the stamps deciding which writes to a key win. It is
+// guarded by the key's stripe.
+type crdtMeta struct {
+	clear hlcStamp
+	attrs map[string]hlcStamp
+}
+
+// EnableCRDT makes the store a multi-master node named node, stamping its
+// writes so they merge with other nodes' under mode. It must be called
+// before the store is shared; connect nodes with StartSync.
+func (s *Store) EnableCRDT(node string, mode CRDTMode) error {
+	if node == "" {
+		return errors.New("multi-master mode needs a node name")
+	}
+	if s.raftNode != nil || s.follower != nil {
+		return errors.New("multi-master mode can't be combined with raft or following a leader")
+	}
+	s.crdt = &crdtClock{node: node, mode: mode}
+	return nil
+}
+
+// stampOps stamps this node's own writes before they are logged. In
+// per-attribute mode puts become patches. Caller must hold the stripes of
+// every key in ops.
+func (s *Store) stampOps(ops []logOp) {
+	for i := range ops {
+		if ops[i].Stamp != nil {
+			continue
+		}
+		stamp := s.crdt.next()
+		ops[i].Stamp = &stamp
+		if s.crdt.mode == CRDTAttribute && ops[i].Op == "put" {
+			ops[i].Op = "patch"
+		}
+	}
+}
+
+// mergeOp merges a stamped op into the store, reporting whether it changed
+// anything; with apply false it only reports. Caller must hold the key's
+// stripe.
+func (s *Store) mergeOp(op logOp, apply bool) bool {
+	stamp := *op.Stamp
+	var meta *crdtMeta
+	if v, ok := s.crdtMeta.Load(op.Key); ok {
+		meta = v.(*crdtMeta)
+	} else {
+		meta = &crdtMeta{attrs: make(map[string]hlcStamp)}
+	}
+	var current map[string]interface{}
+	if v, ok := s.data.Load(op.Key); ok {
+		current = v.(*entry).attrs
+	}
+
+	clear := meta.clear
+	stamps := make(map[string]hlcStamp, len(meta.attrs)+len(op.Attrs))
+	attrs := make(map[string]interface{}, len(current)+len(op.Attrs))
+	for attrKey, value := range current {
+		attrs[attrKey] = value
+	}
+	for attrKey, t := range meta.attrs {
+		stamps[attrKey] = t
+	}
+
+	if op.Op != "patch" {
+		if !stamp.after(clear) {
+			return false
+		}
+		clear = stamp
+		for attrKey := range attrs {
+			// Attributes written before multi-master mode have no stamp
+			if t, ok := stamps[attrKey]; !ok || !t.after(stamp) {
+				delete(stamps, attrKey)
+				delete(attrs, attrKey)
+			}
+		}
+	}
+	changed := clear != meta.clear
+	if op.Op != "del" && !clear.after(stamp) {
+		for attrKey, value := range op.Attrs {
+			if t, ok := stamps[attrKey]; ok && !stamp.after(t) {
+				continue
+			}
+			stamps[attrKey] = stamp
+			attrs[attrKey] = value
+			changed = true
+		}
+	}
+	if !changed || !apply {
+		return changed
+	}
+
+	s.crdtMeta.Store(op.Key, &crdtMeta{clear: clear, attrs: stamps})
+	if len(attrs) == 0 {
+		prev, _ := s.data.LoadAndDelete(op.Key)
+		s.changed(op.Key, prev, nil)
+	} else {
+		e := &entry{attrs: attrs, version: 1, updated: opTime(op)}
+		e.created = e.updated
+		if old, exists := s.data.Load(op.Key); exists {
+			e.version = old.(*entry).version + 1
+			e.created = old.(*entry).created
+		}
+		prev, _ := s.data.Swap(op.Key, e)
+		s.changed(op.Key, prev, e)
+	}
+	s.touch(op.Key)
+	return true
+}
+
+// winningOps returns the stamped ops, received from another node, that
+// would change the store, advancing this node's clock past each of them.
+// Unstamped ops are kept, except deletes of absent keys. Caller must hold
+// the stripes of every key in ops.
+func (s *Store) winningOps(ops []logOp) []logOp {
+	kept := make([]logOp, 0, len(ops))
+	for _, op := range ops {
+		if op.Stamp == nil {
+			if _, exists := s.data.Load(op.Key); op.Op == "del" && !exists {
+				continue
+			}
+			kept = append(kept, op)
+			continue
+		}
+		if s.crdt != nil {
+			s.crdt.observe(*op.Stamp)
+		}
+		if s.mergeOp(op, false) {
+			kept = append(kept, op)
+		}
+	}
+	return kept
+}
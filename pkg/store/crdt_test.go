@@ -0,0 +1,96 @@
+package store
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestCRDTMergeOrder applies the same stamped writes to two nodes in
+// different orders, each twice, and checks they converge, the delete
+// beating the put older than it but not the patch newer than it
+func TestCRDTMergeOrder(t *testing.T) {
+	stamp := func(wall int64) *hlcStamp { return &hlcStamp{Wall: wall, Node: "p"} }
+	put := logOp{Op: "put", Key: "k", Attrs: map[string]interface{}{"a": 1.0}, Stamp: stamp(10)}
+	del := logOp{Op: "del", Key: "k", Stamp: stamp(15)}
+	patch := logOp{Op: "patch", Key: "k", Attrs: map[string]interface{}{"b": 2.0}, Stamp: stamp(20)}
+
+	for name, order := range map[string][]logOp{
+		"in order":  {put, del, patch, put, del, patch},
+		"reversed":  {patch, del, put, patch, del, put},
+		"patch mid": {del, patch, put, put, patch, del},
+	} {
+		s := NewStore()
+		if err := s.EnableCRDT("x", CRDTAttribute); err != nil {
+			t.Fatal(err)
+		}
+		for _, op := range order {
+			if err := s.applyForeign([]logOp{op}); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		if got, want := s.Get("k"), map[string]interface{}{"b": 2.0}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: k = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// TestCRDTSync links two multi-master nodes both ways and checks writes
+// made on each, to different attributes of one key, merge on both
+func TestCRDTSync(t *testing.T) {
+	dir := t.TempDir()
+	nodes := make([]*Store, 2)
+	addrs := make([]string, 2)
+	for i, name := range []string{"a", "b"} {
+		s, err := OpenStore(filepath.Join(dir, name+".log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		if err := s.EnableCRDT(name, CRDTAttribute); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i], addrs[i] = s, startLeader(t, s)
+	}
+	for i, s := range nodes {
+		link, err := s.StartSync(addrs[1-i], nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer link.Close()
+	}
+
+	if err := nodes[0].Put("k", [][]string{{"name", "ann"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes[1].Put("k", [][]string{{"age", "30"}}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"name": "ann", "age": 30.0}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		a, b := nodes[0].Get("k"), nodes[1].Get("k")
+		if reflect.DeepEqual(a, want) && reflect.DeepEqual(b, want) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("nodes hold %v and %v, want %v on both", a, b, want)
+		}
+	}
+}
+
+// TestEnableCRDTRejected checks multi-master mode needs a node name and a
+// known merge mode
+func TestEnableCRDTRejected(t *testing.T) {
+	if err := NewStore().EnableCRDT("", CRDTEntry); err == nil {
+		t.Error("EnableCRDT accepted an empty node name")
+	}
+	if _, err := ParseCRDTMode("newest"); err == nil {
+		t.Error("ParseCRDTMode accepted newest")
+	}
+	for name, want := range map[string]CRDTMode{"lww": CRDTEntry, "ATTR": CRDTAttribute} {
+		if mode, err := ParseCRDTMode(name); err != nil || mode != want {
+			t.Errorf("ParseCRDTMode(%q) = %v, %v", name, mode, err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package store
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ImportCSV writes the rows of a CSV file, such as a spreadsheet or
+// database dump, to the store. The header row names the attributes; the
+// keyColumn column, or the first if keyColumn is empty, holds each row's key
+// and empty cells are left out of the entry. Each column has one type: the
+// attribute's registered type if it has one, otherwise the type every cell
+// of the column parses as, or string if they disagree. Rows that don't fit,
+// because a cell isn't of its column's type or the key is missing or
+// repeated, are skipped and reported; the others are written atomically.
+func (s *Store) ImportCSV(r io.Reader, keyColumn string) (*ImportReport, error) {
+	set, err := s.readCSV(r, keyColumn)
+	if err != nil {
+		return nil, err
+	}
+	return s.writeImport(set, ImportOptions{})
+}
+
+// readCSV reads and types the rows of a CSV file for ImportCSV
+func (s *Store) readCSV(r io.Reader, keyColumn string) (*importSet, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // checked per row, so one bad row doesn't end the import
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+	keyIndex := 0
+	if keyColumn != "" {
+		keyIndex = -1
+		for i, name := range header {
+			if name == keyColumn {
+				keyIndex = i
+			}
+		}
+		if keyIndex < 0 {
+			return nil, fmt.Errorf("CSV header has no %q column", keyColumn)
+		}
+	}
+	seen := make(map[string]bool, len(header))
+	for i, name := range header {
+		if name == "" && i != keyIndex {
+			return nil, fmt.Errorf("CSV column %d has no name", i+1)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("CSV header repeats column %q", name)
+		}
+		seen[name] = true
+	}
+
+	type row struct {
+		line   int
+		fields []string
+	}
+	var rows []row
+	for {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading CSV: %w", err)
+		}
+		line, _ := cr.FieldPos(0)
+		rows = append(rows, row{line: line, fields: fields})
+	}
+
+	// Each column's type: registered, or the one all its cells share.
+	// Columns without a registered type or any value register no type.
+	types := make([]AttributeType, len(header))
+	typed := make([]bool, len(header))
+	s.typesMutex.Lock()
+	for i, name := range header {
+		if metadata, exists := s.attributeTypes[name]; exists && i != keyIndex {
+			types[i], typed[i] = metadata.dataType, true
+			continue
+		}
+		inferred, first := StringType, true
+		for _, r := range rows {
+			if i >= len(r.fields) || r.fields[i] == "" {
+				continue
+			}
+			t, _, _ := DetermineType(r.fields[i])
+			if first {
+				inferred, first = t, false
+			} else if t != inferred {
+				inferred = StringType
+				break
+			}
+		}
+		types[i], typed[i] = inferred, !first
+	}
+	s.typesMutex.Unlock()
+
+	var skipped []RowError
+	entries := make(map[string]map[string]interface{}, len(rows))
+	keyLines := make(map[string]int, len(rows))
+	for _, r := range rows {
+		if len(r.fields) != len(header) {
+			skipped = append(skipped, RowError{Line: r.line, Err: fmt.Errorf("row has %d fields, the header %d", len(r.fields), len(header))})
+			continue
+		}
+		key := r.fields[keyIndex]
+		if key == "" {
+			skipped = append(skipped, RowError{Line: r.line, Err: errors.New("row has no key")})
+			continue
+		}
+		if first, dup := keyLines[key]; dup {
+			skipped = append(skipped, RowError{Line: r.line, Err: fmt.Errorf("key %q already on line %d", key, first)})
+			continue
+		}
+
+		attrs := make(map[string]interface{}, len(header)-1)
+		var rowErr error
+		for i, cell := range r.fields {
+			if i == keyIndex || cell == "" {
+				continue
+			}
+			value, err := parseCell(cell, types[i])
+			if err != nil {
+				rowErr = fmt.Errorf("column %q: %w", header[i], err)
+				break
+			}
+			attrs[header[i]] = value
+		}
+		if rowErr != nil {
+			skipped = append(skipped, RowError{Line: r.line, Err: rowErr})
+			continue
+		}
+		keyLines[key] = r.line
+		entries[key] = attrs
+	}
+
+	columnTypes := make(map[string]AttributeType, len(header))
+	for i, name := range header {
+		if i != keyIndex && typed[i] {
+			columnTypes[name] = types[i]
+		}
+	}
+	return &importSet{types: columnTypes, entries: entries, skipped: skipped}, nil
+}
+
+// parseCell converts cell to a value of type t
+func parseCell(cell string, t AttributeType) (interface{}, error) {
+	switch t {
+	case FloatType:
+		f, err := strconv.ParseFloat(cell, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a float", cell)
+		}
+		return f, nil
+	case BoolType:
+		if cell != "true" && cell != "false" {
+			return nil, fmt.Errorf("%q is not a bool", cell)
+		}
+		return cell == "true", nil
+	}
+	return cell, nil
+}
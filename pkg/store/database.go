@@ -0,0 +1,119 @@
+package store
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SELECT gives each server connection a numbered database of its own, as
+// in Redis, so test data can live beside the real data on one instance
+// without mixing with it. A database is a namespace (see namespace.go):
+// database n, for n from 1 to Databases-1, holds its keys in namespace dbn,
+// so SELECT 1 then PUT user1 writes db1/user1, and KEYS and SEARCH list
+// only database 1's keys, by their names in it. Database 0, where every
+// connection starts, is the default namespace and sees every key but those
+// of the other databases: it lists none of them and refuses to use them by
+// their store keys, so no database reaches into another. The CLI's use db1
+// sees what SELECT 1 does. Keyspace
+// notifications, changes and the commands on the whole node, such as
+// backup, ignore the database.
+
+// DatabaseNamespace returns the namespace holding the keys of database n,
+// "" for database 0
+func DatabaseNamespace(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return "db" + strconv.Itoa(n)
+}
+
+// selectDatabase runs SELECT, switching the client to the database args
+// name
+func (c *clientConn) selectDatabase(args []string) {
+	if len(args) != 2 {
+		c.rw.WriteError("ERR wrong number of arguments for 'select'")
+		return
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 0 || n >= c.srv.Databases {
+		c.rw.WriteError(fmt.Sprintf("ERR DB index is out of range, want 0 to %d", c.srv.Databases-1))
+		return
+	}
+	c.db, c.ns = n, nil
+	if n > 0 {
+		c.ns, _ = c.srv.store.Namespace(DatabaseNamespace(n))
+	}
+	c.rw.WriteSimple("OK")
+}
+
+// databaseOf returns the database holding the store key key, 0 for a key
+// in none of the others
+func (srv *Server) databaseOf(key string) int {
+	namespace, _ := SplitNamespace(key)
+	digits, ok := strings.CutPrefix(namespace, "db")
+	if !ok {
+		return 0
+	}
+	n, err := strconv.Atoi(digits)
+	if err != nil || n < 1 || n >= srv.Databases || DatabaseNamespace(n) != namespace {
+		return 0
+	}
+	return n
+}
+
+// inDatabase returns args with the keys of a single-key command or watch
+// turned into their store keys in the client's database, so the rest of
+// dispatch, cluster routing included, works on store keys. args itself is
+// left as the client sent it, for monitors. In database 0 it refuses, with
+// an error reply, keys of another database.
+func (c *clientConn) inDatabase(command string, args []string) ([]string, bool) {
+	if len(args) < 2 {
+		return args, true
+	}
+	// The keys are args[1:last]
+	last := 1
+	switch command {
+	case "put", "get", "delete", "version", "cas":
+		last = 2
+	case "watch":
+		last = len(args)
+	}
+	if c.ns == nil {
+		for _, key := range args[1:last] {
+			if n := c.srv.databaseOf(key); n != 0 {
+				c.rw.WriteError(fmt.Sprintf("ERR %s is a key of database %d, SELECT %d to use it", key, n, n))
+				return nil, false
+			}
+		}
+		return args, true
+	}
+	if last == 1 {
+		return args, true
+	}
+	args = slices.Clone(args)
+	for i := 1; i < last; i++ {
+		args[i] = c.ns.Key(args[i])
+	}
+	return args, true
+}
+
+// databaseKeys returns the names in the client's database of those of
+// keys, store keys, in it
+func (c *clientConn) databaseKeys(keys []string) []string {
+	if c.ns == nil {
+		return slices.DeleteFunc(slices.Clone(keys), func(key string) bool { return c.srv.databaseOf(key) != 0 })
+	}
+	return c.ns.local(keys)
+}
+
+// databaseKey returns the name of the store key key in the client's
+// database, for replies naming a key the client sent
+func (c *clientConn) databaseKey(key string) string {
+	if c.ns == nil {
+		return key
+	}
+	name, _ := c.ns.Local(key)
+	return name
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestDatabaseIsolation writes the same key from connections in two
+// databases and checks neither sees the other's, database 0 included
+func TestDatabaseIsolation(t *testing.T) {
+	s := NewStore()
+	addr := startServer(t, NewServer(s))
+	db0, db1 := dialServer(t, addr), dialServer(t, addr)
+	if reply := send(t, db1, "select", "1"); reply != respSimple("OK") {
+		t.Fatalf("select = %v", reply)
+	}
+	send(t, db0, "put", "user1", "name", "prod")
+	send(t, db1, "put", "user1", "name", "test")
+	send(t, db1, "put", "user2", "name", "test")
+
+	for _, tc := range []struct {
+		pc   *peerConn
+		args []string
+		want interface{}
+	}{
+		{db0, []string{"get", "user1"}, []interface{}{"name", "prod"}},
+		{db1, []string{"get", "user1"}, []interface{}{"name", "test"}},
+		{db0, []string{"keys"}, []interface{}{"user1"}},
+		{db1, []string{"keys"}, []interface{}{"user1", "user2"}},
+		{db0, []string{"search", "name", "test"}, []interface{}{}},
+		{db1, []string{"search", "name", "prod"}, []interface{}{}},
+		{db0, []string{"get", "user2"}, []interface{}(nil)},
+	} {
+		if reply := send(t, tc.pc, tc.args...); !reflect.DeepEqual(reply, tc.want) {
+			t.Errorf("%v = %#v, want %#v", tc.args, reply, tc.want)
+		}
+	}
+
+	// Database 0 can't reach database 1's keys by their store keys
+	for _, args := range [][]string{
+		{"get", "db1/user1"}, {"put", "db1/user1", "name", "x"}, {"delete", "db1/user2"}, {"watch", "a", "db1/user1"},
+	} {
+		if _, refused := send(t, db0, args...).(respError); !refused {
+			t.Errorf("%v from database 0 was accepted", args)
+		}
+	}
+	if got := s.Get("db1/user1")["name"]; got != "test" {
+		t.Errorf("db1/user1 name = %v, want test", got)
+	}
+	if s.Get("db1/user2") == nil {
+		t.Error("database 0 deleted db1/user2")
+	}
+
+	// A namespace that isn't one of the databases is database 0's
+	send(t, db0, "put", "db99/x", "name", "n")
+	if reply := send(t, db0, "keys"); !reflect.DeepEqual(reply, []interface{}{"db99/x", "user1"}) {
+		t.Errorf("keys = %#v", reply)
+	}
+}
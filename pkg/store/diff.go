@@ -0,0 +1,148 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+)
+
+// StoreDiff is the difference between two states of a store, such as a
+// backup taken before a migration and one taken after, or backups of two
+// replicas. Keys are in order.
+type StoreDiff struct {
+	Added    []string         // keys only the second state holds
+	Removed  []string         // keys only the first state holds
+	Modified []EntryDiff      // keys both hold with different attributes
+	Types    []AttributeDelta // attributes whose registered type differs, as type names
+}
+
+// EntryDiff is how the attributes of a key differ between two states
+type EntryDiff struct {
+	Key   string
+	Attrs []AttributeDelta // in attribute order
+}
+
+// AttributeDelta is an attribute's value in the first and in the second
+// state, nil where the state doesn't have it
+type AttributeDelta struct {
+	Attr     string
+	Old, New interface{}
+}
+
+// Empty reports whether the two states are the same
+func (d *StoreDiff) Empty() bool {
+	return len(d.Added)+len(d.Removed)+len(d.Modified)+len(d.Types) == 0
+}
+
+// DiffBackups compares the states of the backups at a and b, each a backup
+// directory written by BackupDir or a file written by Backup
+func DiffBackups(a, b string) (*StoreDiff, error) {
+	from, err := loadBackupState(a)
+	if err != nil {
+		return nil, err
+	}
+	to, err := loadBackupState(b)
+	if err != nil {
+		return nil, err
+	}
+	return diffStates(from, to), nil
+}
+
+// DiffBackup compares the state of the backup at path, as for DiffBackups,
+// with the store's current state
+func (s *Store) DiffBackup(path string) (*StoreDiff, error) {
+	from, err := loadBackupState(path)
+	if err != nil {
+		return nil, err
+	}
+	s.rlockAll()
+	to := s.captureState()
+	s.runlockAll()
+	return diffStates(from, to), nil
+}
+
+// RestoreDiff compares the store's current state with the backup at path,
+// the other way round from DiffBackup, giving the changes restoring the
+// backup would make
+func (s *Store) RestoreDiff(path string) (*StoreDiff, error) {
+	to, err := loadBackupState(path)
+	if err != nil {
+		return nil, err
+	}
+	s.rlockAll()
+	from := s.captureState()
+	s.runlockAll()
+	return diffStates(from, to), nil
+}
+
+// loadBackupState reads the state a backup restores to, by seeding a
+// scratch store from it
+func loadBackupState(path string) (*storeState, error) {
+	scratch := NewStore()
+	if _, err := scratch.SeedFromBackup(path); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return scratch.captureState(), nil
+}
+
+// diffStates compares two captured states
+func diffStates(from, to *storeState) *StoreDiff {
+	d := &StoreDiff{}
+	i, j := 0, 0
+	for i < len(from.keys) || j < len(to.keys) {
+		switch {
+		case j == len(to.keys) || i < len(from.keys) && from.keys[i] < to.keys[j]:
+			d.Removed = append(d.Removed, from.keys[i])
+			i++
+		case i == len(from.keys) || to.keys[j] < from.keys[i]:
+			d.Added = append(d.Added, to.keys[j])
+			j++
+		default:
+			key := to.keys[j]
+			if deltas := DiffAttributes(from.entries[key].attrs, to.entries[key].attrs); len(deltas) > 0 {
+				d.Modified = append(d.Modified, EntryDiff{Key: key, Attrs: deltas})
+			}
+			i++
+			j++
+		}
+	}
+
+	typeName := func(types map[string]AttributeMetadata, attrKey string) interface{} {
+		if metadata, exists := types[attrKey]; exists {
+			return metadata.dataType.String()
+		}
+		return nil
+	}
+	for _, attrKey := range unionKeys(from.types, to.types) {
+		if before, after := typeName(from.types, attrKey), typeName(to.types, attrKey); before != after {
+			d.Types = append(d.Types, AttributeDelta{Attr: attrKey, Old: before, New: after})
+		}
+	}
+	return d
+}
+
+// DiffAttributes returns the attributes whose values differ between from
+// and to
+func DiffAttributes(from, to map[string]interface{}) []AttributeDelta {
+	var deltas []AttributeDelta
+	for _, attrKey := range unionKeys(from, to) {
+		if before, after := from[attrKey], to[attrKey]; before != after {
+			deltas = append(deltas, AttributeDelta{Attr: attrKey, Old: before, New: after})
+		}
+	}
+	return deltas
+}
+
+// unionKeys returns the keys of a and b, in order
+func unionKeys[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, seen := a[k]; !seen {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
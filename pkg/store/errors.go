@@ -0,0 +1,60 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The errors of the store's reads and writes that callers branch on by
+// kind are matched with errors.Is against the sentinels below, whatever
+// detail the error carries; a *TypeMismatchError also says which
+// attribute and types clashed.
+
+// ErrTypeMismatch is matched by the errors of writes giving an attribute a
+// value of another type than the one it was registered with, and of the
+// typed getters, such as GetFloat, reading an attribute as another type.
+// Each is a *TypeMismatchError.
+var ErrTypeMismatch = errors.New("Data Type Error")
+
+// ErrKeyNotFound is matched by the errors of writes that need an entry at
+// their key when there is none, such as a PutIfVersion expecting a version
+// of a key that doesn't exist
+var ErrKeyNotFound = errors.New("key not found")
+
+// ErrValidation is matched by the errors of writes and definitions that
+// are malformed whatever the store holds: an attribute pair that isn't a
+// name and a value, a value of an unsupported type, an imported entry with
+// an empty key or an incomplete trigger
+var ErrValidation = errors.New("invalid input")
+
+// TypeMismatchError is the error of a write or typed read whose type
+// clashes with the type registered for an attribute
+type TypeMismatchError struct {
+	Attribute string
+	Expected  AttributeType // the type registered for the attribute
+	Got       AttributeType // the type of the value written, or the type a typed getter asked for
+}
+
+func (e *TypeMismatchError) Error() string {
+	return fmt.Sprintf("%s: %s is a %s attribute, not a %s", ErrTypeMismatch, e.Attribute, e.Expected, e.Got)
+}
+
+// Unwrap returns ErrTypeMismatch, so errors.Is matches every
+// TypeMismatchError
+func (e *TypeMismatchError) Unwrap() error {
+	return ErrTypeMismatch
+}
+
+// validationError is an error matching ErrValidation, with its own message
+type validationError struct {
+	msg string
+}
+
+func (e *validationError) Error() string { return e.msg }
+
+func (e *validationError) Unwrap() error { return ErrValidation }
+
+// invalid returns a validationError with the message format makes of args
+func invalid(format string, args ...interface{}) error {
+	return &validationError{msg: fmt.Sprintf(format, args...)}
+}
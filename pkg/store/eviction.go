@@ -0,0 +1,251 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// The store keeps an estimate of the memory its entries take: for each
+// entry, its key, its attribute names and values, and a fixed overhead for
+// the maps and bookkeeping around them. With WithMaxMemory, a write that
+// takes the estimate over the limit is followed by evictions, each a
+// delete logged and replicated like any other, unless the policy is
+// NoEviction, which instead fails the writes that follow until deletes
+// bring the store back under it. Followers and Raft nodes don't evict on
+// their own: they apply the deletes of the node that takes the writes.
+
+// EvictionPolicy picks what a store over its memory limit does
+type EvictionPolicy int
+
+const (
+	// NoEviction fails writes that add entries or attributes with
+	// ErrOutOfMemory while the store is over its limit
+	NoEviction EvictionPolicy = iota
+	// EvictLRU deletes the least recently read or written of a sample of
+	// entries, much as Redis's allkeys-lru does
+	EvictLRU
+	// EvictRandom deletes entries picked at random
+	EvictRandom
+)
+
+// String returns the policy's name: "noeviction", "lru" or "random"
+func (p EvictionPolicy) String() string {
+	switch p {
+	case NoEviction:
+		return "noeviction"
+	case EvictLRU:
+		return "lru"
+	case EvictRandom:
+		return "random"
+	}
+	return fmt.Sprintf("EvictionPolicy(%d)", int(p))
+}
+
+// ParseEvictionPolicy parses the names accepted by the -eviction flag
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	switch name {
+	case "noeviction":
+		return NoEviction, nil
+	case "lru":
+		return EvictLRU, nil
+	case "random":
+		return EvictRandom, nil
+	}
+	return 0, fmt.Errorf("unknown eviction policy %q (want noeviction, lru or random)", name)
+}
+
+// ErrOutOfMemory is returned by writes to a store over its memory limit
+// under NoEviction
+var ErrOutOfMemory = errors.New("OOM the store is over its memory limit")
+
+// evictionSample is how many entries EvictLRU compares to pick one
+const evictionSample = 5
+
+// Overheads, in bytes, of an entry and of an attribute beyond the bytes of
+// their names and values: map buckets, pointers and interface headers
+const (
+	entryOverhead     = 96
+	attributeOverhead = 48
+)
+
+// entrySize estimates the memory the entry at key takes
+func entrySize(key string, attrs map[string]interface{}) int64 {
+	size := int64(entryOverhead + len(key))
+	for name, value := range attrs {
+		size += int64(attributeOverhead + len(name))
+		switch v := value.(type) {
+		case string:
+			size += int64(len(v))
+		case float64:
+			size += 8
+		case bool:
+			size++
+		}
+	}
+	return size
+}
+
+// MemoryUsage returns the store's estimate of the memory its entries take
+func (s *Store) MemoryUsage() int64 {
+	return s.memory.Load()
+}
+
+// account updates the memory estimate for the change of the entry at key
+// from prev to next, either of which is nil for no entry
+func (s *Store) account(key string, prev, next interface{}) {
+	var delta, keys int64
+	if prev != nil {
+		delta -= entrySize(key, prev.(*entry).attrs)
+		keys--
+	}
+	if next != nil {
+		e := next.(*entry)
+		delta += entrySize(key, e.attrs)
+		keys++
+		if s.eviction == EvictLRU {
+			e.accessed.Store(time.Now().UnixNano())
+		}
+	}
+	s.memory.Add(delta)
+	s.accountNamespace(key, keys, delta)
+	if s.victims != nil {
+		switch {
+		case prev == nil && next != nil:
+			s.victims.add(key)
+		case prev != nil && next == nil:
+			s.victims.remove(key)
+		}
+	}
+}
+
+// checkMemory fails a write of ops to a store over its limit under
+// NoEviction, unless ops only delete
+func (s *Store) checkMemory(ops []logOp) error {
+	if s.maxMemory == 0 || s.eviction != NoEviction || s.memory.Load() <= s.maxMemory {
+		return nil
+	}
+	for _, op := range ops {
+		if op.Op != "del" {
+			return ErrOutOfMemory
+		}
+	}
+	return nil
+}
+
+// evict deletes entries, as the eviction policy picks them, until the
+// store is back under its memory limit. It runs after a write has been
+// applied, while the writer still holds the stripes of its keys, so it
+// skips entries whose stripes it can't take at once rather than wait for
+// them. Only one eviction runs at a time.
+func (s *Store) evict() {
+	if s.maxMemory == 0 || s.eviction == NoEviction || s.memory.Load() <= s.maxMemory ||
+		s.raftNode != nil || s.Writable() != nil || !s.evicting.CompareAndSwap(false, true) {
+		return
+	}
+	defer s.evicting.Store(false)
+
+	for misses := 0; s.memory.Load() > s.maxMemory && misses < evictionSample; {
+		key, ok := s.evictionVictim()
+		if !ok {
+			return
+		}
+		stripe := s.stripeFor(key)
+		if !stripe.TryLock() {
+			misses++
+			continue
+		}
+		var err error
+		if _, exists := s.data.Load(key); exists {
+			err = s.commitRecord([]logOp{{Op: "del", Key: key, actor: "eviction"}}, s.defaultDurability())
+			if err == nil {
+				s.logger.Debug("evicted an entry", "key", key, "policy", s.eviction.String(), "memory_bytes", s.memory.Load())
+			}
+		}
+		stripe.Unlock()
+		if err != nil {
+			s.logger.Error("eviction failed", "key", key, "err", err)
+			return
+		}
+	}
+}
+
+// evictionVictim picks the entry to evict next: the least recently used
+// of a sample for EvictLRU, any for EvictRandom. The sample is drawn from
+// victims, as a sync.Map ranges in no particular order, which isn't a
+// random one, and ranging all of it for each victim would cost every write
+// over the limit the whole store.
+func (s *Store) evictionVictim() (string, bool) {
+	size := 1
+	if s.eviction == EvictLRU {
+		size = evictionSample
+	}
+	var victim string
+	var oldest int64
+	for _, key := range s.victims.sample(size) {
+		value, ok := s.data.Load(key)
+		if !ok {
+			continue
+		}
+		if accessed := value.(*entry).accessed.Load(); victim == "" || accessed < oldest {
+			victim, oldest = key, accessed
+		}
+	}
+	return victim, victim != ""
+}
+
+// evictionKeys holds the keys of a store's entries in a slice, so that
+// eviction draws its samples in constant time. Keys join it as their entry
+// is created and leave it as it is deleted, under the key's stripe.
+type evictionKeys struct {
+	mu    sync.Mutex
+	keys  []string
+	index map[string]int // position of each key in keys
+}
+
+func newEvictionKeys() *evictionKeys {
+	return &evictionKeys{index: make(map[string]int)}
+}
+
+func (k *evictionKeys) add(key string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.index[key]; !ok {
+		k.index[key] = len(k.keys)
+		k.keys = append(k.keys, key)
+	}
+}
+
+func (k *evictionKeys) remove(key string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	i, ok := k.index[key]
+	if !ok {
+		return
+	}
+	last := len(k.keys) - 1
+	k.keys[i] = k.keys[last]
+	k.index[k.keys[i]] = i
+	k.keys = k.keys[:last]
+	delete(k.index, key)
+}
+
+// sample returns n keys drawn at random, with replacement, or none if
+// there are no keys
+func (k *evictionKeys) sample(n int) []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if len(k.keys) == 0 {
+		return nil
+	}
+	sample := make([]string, n)
+	for i := range sample {
+		sample[i] = k.keys[rand.IntN(len(k.keys))]
+	}
+	return sample
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestEvictionVictimRandom picks many victims from the same entries and
+// checks each policy draws from across them, not from the first few a
+// Range of the map happens to visit
+func TestEvictionVictimRandom(t *testing.T) {
+	const n, draws = 100, 3000
+	for _, policy := range []EvictionPolicy{EvictRandom, EvictLRU} {
+		s := NewStore(WithMaxMemory(1<<30, policy))
+		for i := 0; i < n; i++ {
+			if err := s.Put("key"+strconv.Itoa(i), [][]string{{"n", strconv.Itoa(i)}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		picked := make(map[string]int)
+		for i := 0; i < draws; i++ {
+			key, ok := s.evictionVictim()
+			if !ok {
+				t.Fatal("no victim in a store with entries")
+			}
+			picked[key]++
+		}
+		// Under EvictRandom every entry has a chance of 1/n per draw, so
+		// the odds of one never being picked are about n*e^-30. EvictLRU
+		// picks the oldest of its sample, which the newer entries rarely
+		// are, but the older half each come up several times on average.
+		want := n
+		if policy == EvictLRU {
+			want = n / 2
+		}
+		if len(picked) < want {
+			t.Errorf("%s picked %d of %d entries in %d draws, want %d or more", policy, len(picked), n, draws, want)
+		}
+	}
+}
+
+// TestEvictionUnderLoad writes far more than the memory limit holds from
+// many goroutines, and checks eviction keeps the store near its limit and
+// the sampled keys in step with the entries
+func TestEvictionUnderLoad(t *testing.T) {
+	const limit, writers, puts = 64 << 10, 8, 2000
+	for _, policy := range []EvictionPolicy{EvictRandom, EvictLRU} {
+		s := NewStore(WithMaxMemory(limit, policy))
+		var wg sync.WaitGroup
+		for w := range writers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range puts {
+					key := "w" + strconv.Itoa(w) + "/" + strconv.Itoa(i)
+					if err := s.Put(key, [][]string{{"payload", strings.Repeat("x", 100)}}); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		// The last writes may each leave the store over the limit by one
+		// entry while another eviction ran
+		if used := s.MemoryUsage(); used > limit+writers*512 {
+			t.Errorf("%s: %d bytes in use, limit %d", policy, used, limit)
+		}
+		if got, want := len(s.victims.keys), s.Len(); got != want {
+			t.Errorf("%s: %d keys to sample from, %d entries", policy, got, want)
+		}
+		for _, key := range s.victims.keys {
+			if s.Get(key) == nil {
+				t.Errorf("%s: %s is sampled from but has no entry", policy, key)
+				break
+			}
+		}
+	}
+}
+
+// BenchmarkEvictionVictim picks victims from a large store, which costs
+// the same at any size
+func BenchmarkEvictionVictim(b *testing.B) {
+	for _, n := range []int{1000, 100000} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			s := NewStore(WithMaxMemory(1<<40, EvictLRU))
+			for i := range n {
+				if err := s.Put("key"+strconv.Itoa(i), [][]string{{"n", strconv.Itoa(i)}}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ResetTimer()
+			for range b.N {
+				if _, ok := s.evictionVictim(); !ok {
+					b.Fatal("no victim")
+				}
+			}
+		})
+	}
+}
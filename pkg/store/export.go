@@ -0,0 +1,543 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Exports hold a store's entries and attribute types in a portable format,
+// for moving data between machines or inspecting it with standard tools.
+// Unlike a backup they carry no sequence number or versions: importing
+// writes the entries into the target store as new puts.
+
+// jsonExport is the document written by ExportJSON
+type jsonExport struct {
+	AttributeTypes map[string]string                 `json:"attribute_types"`
+	Entries        map[string]map[string]interface{} `json:"entries"`
+}
+
+// ExportJSON writes the store's entries and attribute types to w as one JSON
+// document: {"attribute_types": {attr: type}, "entries": {key: {attr: value}}}
+func (s *Store) ExportJSON(w io.Writer) error {
+	return s.ExportTo(w, "json")
+}
+
+// ExportTo writes the store's entries and attribute types to w in format,
+// one of ExportFormats. The entries are encoded and written one at a time,
+// so memory use doesn't grow with the size of the output; the export is a
+// consistent view of the store at the time of the call.
+func (s *Store) ExportTo(w io.Writer, format string) error {
+	_, err := s.exportTo(w, format, ExportFilter{})
+	return err
+}
+
+// exportWriter writes an export of the entries of st under keys to w
+type exportWriter func(w *bufio.Writer, st *storeState, keys []string) error
+
+// exportWriterFor returns the writer of format
+func exportWriterFor(format string) (exportWriter, error) {
+	switch format {
+	case "json":
+		return writeJSONExport, nil
+	case "yaml":
+		return writeYAMLExport, nil
+	case "parquet":
+		return writeParquetExport, nil
+	case "protobuf":
+		return writeProtobufExport, nil
+	case "gob":
+		return codecExportWriter(GobCodec), nil
+	case "msgpack":
+		return codecExportWriter(MsgpackCodec), nil
+	case "sqlite":
+		return nil, errors.New("a SQLite export can only be written to a file; use ExportFile")
+	}
+	return nil, fmt.Errorf("unknown export format %q; want one of %s", format, strings.Join(ExportFormats, ", "))
+}
+
+// exportTo streams the entries selected by filter to w in format and
+// returns how many it wrote
+func (s *Store) exportTo(w io.Writer, format string, filter ExportFilter) (int, error) {
+	write, err := exportWriterFor(format)
+	if err != nil {
+		return 0, err
+	}
+	if err := filter.validate(); err != nil {
+		return 0, err
+	}
+
+	st, keys := s.captureFiltered(filter)
+	bw := bufio.NewWriter(w)
+	if err := write(bw, st, keys); err != nil {
+		return 0, err
+	}
+	return len(keys), bw.Flush()
+}
+
+// captureFiltered captures the store, returning the keys of the entries
+// selected by filter in order. Entries are immutable, so the capture can be
+// encoded after the stripes are released.
+func (s *Store) captureFiltered(filter ExportFilter) (*storeState, []string) {
+	s.rlockAll()
+	st := s.captureState()
+	s.runlockAll()
+
+	matches := filter.matcher()
+	keys := make([]string, 0, len(st.keys))
+	for _, key := range st.keys {
+		if matches(key, st.entries[key].attrs) {
+			keys = append(keys, key)
+		}
+	}
+	return st, keys
+}
+
+// attributeNames returns the names of the captured attribute types in
+// order, namespace/attribute for a namespace's as typeKey gives them
+func (st *storeState) attributeNames() []string {
+	names := make([]string, 0, len(st.types))
+	for attrKey := range st.types {
+		names = append(names, attrKey)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// tableColumns returns the attributes of the entries under keys in order,
+// the columns of a tabular export, with the type of each: the type its
+// entries' namespaces register for it, or StringType where two register it
+// differently, its values then written as text by tableValue
+func (st *storeState) tableColumns(keys []string) ([]string, map[string]AttributeType) {
+	types := make(map[string]AttributeType)
+	for _, key := range keys {
+		for attrKey := range st.entries[key].attrs {
+			t := st.types[typeKey(key, attrKey)].dataType
+			if prev, seen := types[attrKey]; seen && prev != t {
+				t = StringType
+			}
+			types[attrKey] = t
+		}
+	}
+	names := make([]string, 0, len(types))
+	for attrKey := range types {
+		names = append(names, attrKey)
+	}
+	sort.Strings(names)
+	return names, types
+}
+
+// tableValue returns value as a column of type t holds it: as is, or as
+// text in a StringType column shared by namespaces typing it differently
+func tableValue(value interface{}, t AttributeType) interface{} {
+	if t != StringType {
+		return value
+	}
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return value
+}
+
+// tableColumnNames names the columns of a tabular export, the key column
+// first, then one per attribute. SQL engines compare names
+// case-insensitively, so a name that would clash with an earlier one gets a
+// numeric suffix.
+func tableColumnNames(attrKeys []string) []string {
+	taken := map[string]bool{"key": true}
+	names := append(make([]string, 0, len(attrKeys)+1), "key")
+	for _, attrKey := range attrKeys {
+		name := attrKey
+		for n := 2; taken[strings.ToLower(name)]; n++ {
+			name = fmt.Sprintf("%s_%d", attrKey, n)
+		}
+		taken[strings.ToLower(name)] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// writeJSONExport writes the entries under keys as the indented JSON
+// document ExportJSON describes, one entry at a time
+func writeJSONExport(w *bufio.Writer, st *storeState, keys []string) error {
+	types := make(map[string]string, len(st.types))
+	for attrKey, metadata := range st.types {
+		types[attrKey] = metadata.dataType.String()
+	}
+	typesJSON, err := json.MarshalIndent(types, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "{\n  \"attribute_types\": %s,\n  \"entries\": {", typesJSON)
+	for i, key := range keys {
+		keyJSON, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		attrsJSON, err := json.MarshalIndent(st.entries[key].attrs, "    ", "  ")
+		if err != nil {
+			return fmt.Errorf("entry %q: %w", key, err)
+		}
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		if _, err := fmt.Fprintf(w, "\n    %s: %s", keyJSON, attrsJSON); err != nil {
+			return err
+		}
+	}
+	if len(keys) > 0 {
+		w.WriteString("\n  ")
+	}
+	_, err = w.WriteString("}\n}\n")
+	return err
+}
+
+// ExportFilter selects the entries an export holds. The zero filter selects
+// them all.
+type ExportFilter struct {
+	// Keys, a path.Match pattern, keeps the entries whose key matches it
+	Keys string
+
+	// WhereAttr and WhereValue keep the entries whose attribute WhereAttr
+	// equals WhereValue, compared as Search does
+	WhereAttr, WhereValue string
+
+	// WhereString compares WhereValue as a string, as SearchValue does with
+	// a string, instead of typing it as put would
+	WhereString bool
+}
+
+// validate checks the filter's key pattern
+func (f ExportFilter) validate() error {
+	if _, err := path.Match(f.Keys, ""); err != nil {
+		return fmt.Errorf("bad key pattern %q: %w", f.Keys, err)
+	}
+	return nil
+}
+
+// matcher returns a function reporting whether an entry passes the filter
+func (f ExportFilter) matcher() func(key string, attrs map[string]interface{}) bool {
+	var expected interface{} = f.WhereValue
+	if !f.WhereString {
+		_, expected, _ = DetermineType(f.WhereValue)
+	}
+	return func(key string, attrs map[string]interface{}) bool {
+		if f.Keys != "" {
+			if ok, _ := path.Match(f.Keys, key); !ok {
+				return false
+			}
+		}
+		return f.WhereAttr == "" || attrEquals(attrs, f.WhereAttr, expected)
+	}
+}
+
+// ImportJSON writes the entries of a document in ExportJSON's format to the
+// store, registering its attribute types, and returns how many entries it
+// wrote. The import is atomic: if any value conflicts with a registered type
+// nothing is written.
+func (s *Store) ImportJSON(r io.Reader) (int, error) {
+	set, err := readJSON(r)
+	if err != nil {
+		return 0, err
+	}
+	return s.importAll(set)
+}
+
+// importAll writes set atomically, overwriting stored keys, and returns how
+// many entries it wrote
+func (s *Store) importAll(set *importSet) (int, error) {
+	report, err := s.writeImport(set, ImportOptions{})
+	if err != nil {
+		return 0, err
+	}
+	return report.Imported, nil
+}
+
+// readJSON reads a document in ExportJSON's format
+func readJSON(r io.Reader) (*importSet, error) {
+	var doc jsonExport
+	if err := json.NewDecoder(bufio.NewReader(r)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("reading JSON export: %w", err)
+	}
+	types, err := parseDeclaredTypes(doc.AttributeTypes)
+	if err != nil {
+		return nil, err
+	}
+	return &importSet{types: types, entries: doc.Entries}, nil
+}
+
+// parseDeclaredTypes parses the attribute_types section of an export
+func parseDeclaredTypes(names map[string]string) (map[string]AttributeType, error) {
+	types := make(map[string]AttributeType, len(names))
+	for attrKey, name := range names {
+		t, err := parseAttributeType(name)
+		if err != nil {
+			return nil, fmt.Errorf("type of %q: %w", attrKey, err)
+		}
+		types[attrKey] = t
+	}
+	return types, nil
+}
+
+// importSet is an import read and typed but not yet written: its entries,
+// the attribute types it declares, and the rows it skipped
+type importSet struct {
+	types   map[string]AttributeType
+	entries map[string]map[string]interface{}
+	skipped []RowError
+}
+
+// writeImport writes set to the store, atomically or, if opts.Bulk, as a
+// bulk load, resolving conflicts with stored keys by opts.OnConflict
+func (s *Store) writeImport(set *importSet, opts ImportOptions) (*ImportReport, error) {
+	report := &ImportReport{Errors: set.skipped}
+	if !opts.Bulk || opts.DryRun {
+		if err := s.importEntries(set, opts.OnConflict, opts.DryRun, report); err != nil {
+			return nil, err
+		}
+		return report, nil
+	}
+
+	keys, err := importKeys(set.entries)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.BulkLoad()
+	if err != nil {
+		return nil, err
+	}
+	keys, entries, err := s.resolveConflictsLocked(keys, set.entries, opts.OnConflict, report)
+	if err == nil {
+		s.typesMutex.Lock()
+		var pending map[string]AttributeMetadata
+		pending, err = s.checkImportLocked(set.types, entries)
+		if err == nil {
+			b.hold(pending)
+		}
+		s.typesMutex.Unlock()
+	}
+	for _, key := range keys {
+		if err != nil {
+			break
+		}
+		err = b.add(logOp{Op: "put", Key: key, Attrs: entries[key]})
+	}
+	stats, closeErr := b.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	report.Imported, report.Bulk = stats.Entries, &stats
+	return report, nil
+}
+
+// importKeys returns the keys of entries in order, rejecting an empty key
+func importKeys(entries map[string]map[string]interface{}) ([]string, error) {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		if key == "" {
+			return nil, invalid("import has an entry with an empty key")
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// importEntries registers the types of set and puts its entries, resolving
+// conflicts with stored keys by strategy, in one atomic write that doesn't
+// fire triggers, and fills in report. A dry run only checks them.
+func (s *Store) importEntries(set *importSet, strategy ConflictStrategy, dryRun bool, report *ImportReport) error {
+	keys, err := importKeys(set.entries)
+	if err != nil {
+		return err
+	}
+	unlock := s.lockKeys(keys)
+	defer unlock()
+
+	if err := s.Writable(); err != nil {
+		return err
+	}
+	keys, entries, err := s.resolveConflictsLocked(keys, set.entries, strategy, report)
+	if err != nil {
+		return err
+	}
+
+	s.typesMutex.Lock()
+	pending, err := s.checkImportLocked(set.types, entries)
+	if err == nil && !dryRun {
+		s.holdTypes(pending)
+	}
+	s.typesMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		report.Imported = len(keys)
+		return nil
+	}
+	if len(keys) == 0 {
+		// Only declared types, which need no record
+		s.releaseTypes(pending, nil)
+		return nil
+	}
+	ops := make([]logOp, 0, len(keys))
+	for _, key := range keys {
+		ops = append(ops, logOp{Op: "put", Key: key, Attrs: entries[key]})
+	}
+	err = s.commitCopied(ops, s.defaultDurability())
+	s.releaseTypes(pending, err)
+	if err != nil {
+		return err
+	}
+	report.Imported = len(ops)
+	return nil
+}
+
+// checkImportLocked validates entries and the declared types, returning the
+// attribute types they would add to the registry. Caller must hold
+// typesMutex.
+func (s *Store) checkImportLocked(types map[string]AttributeType, entries map[string]map[string]interface{}) (map[string]AttributeMetadata, error) {
+	pending, err := s.checkValuesLocked(entries)
+	if err != nil {
+		return nil, err
+	}
+	for attrKey, t := range types {
+		if err := s.checkType(attrKey, t, pending); err != nil {
+			return nil, fmt.Errorf("attribute %q: %w", attrKey, err)
+		}
+	}
+	return pending, nil
+}
+
+// ExportFormats lists the formats ExportFile writes, all of which but
+// sqlite ExportTo can stream
+var ExportFormats = []string{"json", "yaml", "protobuf", "gob", "msgpack", "parquet", "sqlite"}
+
+// ExportFile writes the entries selected by filter to the file at path in
+// format, one of ExportFormats, and returns how many it wrote
+func (s *Store) ExportFile(path, format string, filter ExportFilter) (int, error) {
+	if format == "sqlite" {
+		return s.exportSQLite(path, filter)
+	}
+	if _, err := exportWriterFor(format); err != nil {
+		return 0, err
+	}
+	if err := filter.validate(); err != nil {
+		return 0, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := s.exportTo(file, format, filter)
+	if err != nil {
+		file.Close()
+		return 0, err
+	}
+	return n, file.Close()
+}
+
+// ImportOptions tunes ImportFile
+type ImportOptions struct {
+	// KeyColumn names the CSV column holding each row's key; the first
+	// column if empty
+	KeyColumn string
+
+	// DB is the database of an RDB file or Redis server to import
+	DB int
+
+	// Bulk writes the import as a bulk load (see BulkLoad) instead of one
+	// atomic record: much faster for large imports, but a failure part way
+	// leaves what was written so far
+	Bulk bool
+
+	// TrimPrefix leaves the prefix of an etcd or Consul import out of the
+	// keys of the entries
+	TrimPrefix bool
+
+	// Token is the ACL token of a Consul import
+	Token string
+
+	// OnConflict decides what happens to imported entries whose keys are
+	// already stored; by default they overwrite them
+	OnConflict ConflictStrategy
+
+	// DryRun checks the import, conflicts and types included, and reports
+	// what it would write without writing anything
+	DryRun bool
+}
+
+// ImportReport is the outcome of ImportFile
+type ImportReport struct {
+	Imported int        // entries written
+	Errors   []RowError // rows skipped, for formats that import row by row
+	Bulk     *BulkStats // the bulk load's statistics, for bulk imports
+
+	// The written entries' keys by what happened to them, then those of
+	// the entries SkipExisting left out, each in order
+	Added, Overwritten, Merged, Kept []string
+}
+
+// RowError is a row or entry an import skipped
+type RowError struct {
+	Line int    // line of the file the row starts on, for line-based formats
+	Key  string // the entry's key, for the others
+	Err  error
+}
+
+func (e RowError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("key %q: %v", e.Key, e.Err)
+	}
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// ImportFormats lists the formats ImportFile reads
+var ImportFormats = []string{"json", "yaml", "protobuf", "gob", "msgpack", "csv", "rdb"}
+
+// ImportFile imports the file at path in format, one of ImportFormats
+func (s *Store) ImportFile(path, format string, opts ImportOptions) (*ImportReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var set *importSet
+	switch format {
+	case "json":
+		set, err = readJSON(file)
+	case "yaml":
+		set, err = s.readYAML(file)
+	case "protobuf":
+		set, err = readProtobuf(file)
+	case "gob":
+		set, err = readCodecExport(file, GobCodec)
+	case "msgpack":
+		set, err = readCodecExport(file, MsgpackCodec)
+	case "csv":
+		set, err = s.readCSV(file, opts.KeyColumn)
+	case "rdb":
+		set, err = s.readRDB(file, opts.DB)
+	default:
+		return nil, fmt.Errorf("unknown import format %q; want one of %s", format, strings.Join(ImportFormats, ", "))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.writeImport(set, opts)
+}
@@ -0,0 +1,360 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Automatic failover runs on a fixed group of replicating nodes, each with
+// a write log and a replication listener. Every failoverCheck each node
+// probes the others' status over their replication listeners. A follower
+// that hasn't heard from its leader for failoverTimeout, and can't reach it
+// either, joins an election: among the reachable followers of the same
+// epoch the one with the highest sequence number, ties going to the lowest
+// ID, promotes itself, provided it can reach a majority of the group. Every
+// promotion starts a new epoch, recorded with the sequence number it
+// started at in a history that followers copy from their leader and keep
+// beside their log. A node that finds a leader of a newer epoch follows it;
+// if it had applied records past the point where its own epoch ended, as an
+// old leader rejoining usually has, those records were never part of the
+// new leader's history, so it discards its state and resyncs from scratch.
+
+// failoverCheck is how often a node probes its peers
+const failoverCheck = time.Second
+
+// failoverTimeout is how long a follower goes without hearing from its
+// leader before it considers the leader lost
+const failoverTimeout = 3 * time.Second
+
+// failoverProbeTimeout bounds one status probe
+const failoverProbeTimeout = 500 * time.Millisecond
+
+// epochStart records the sequence number a leadership epoch started after
+type epochStart struct {
+	Epoch uint64 `json:"epoch"`
+	Start uint64 `json:"start"`
+}
+
+// Failover promotes a follower automatically when the group loses its
+// leader, and demotes stale leaders
+type Failover struct {
+	store *Store
+	self  string
+	peers map[string]string // node ID to replication address, including self
+	path  string            // where history is kept
+
+	mu      sync.Mutex
+	history []epochStart
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartFailover enables automatic failover for a store that has a write log
+// and serves replication as node self of a group given as "id=addr" pairs of
+// replication addresses, including self
+func (s *Store) StartFailover(self string, peers []string) (*Failover, error) {
+	path := s.logPath()
+	if path == "" {
+		return nil, errors.New("failover needs a write log")
+	}
+	f := &Failover{
+		store: s,
+		self:  self,
+		peers: make(map[string]string),
+		path:  path + ".epochs",
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	for _, peer := range peers {
+		id, addr, ok := strings.Cut(peer, "=")
+		if !ok || id == "" || addr == "" {
+			return nil, fmt.Errorf("failover peer %q is not of the form id=addr", peer)
+		}
+		f.peers[id] = addr
+	}
+	if _, ok := f.peers[self]; !ok {
+		return nil, fmt.Errorf("failover peer list does not include this node, %q", self)
+	}
+
+	if data, err := os.ReadFile(f.path); err == nil {
+		if err := json.Unmarshal(data, &f.history); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	s.logMutex.Lock()
+	if s.failover != nil {
+		s.logMutex.Unlock()
+		return nil, errors.New("failover is already running")
+	}
+	s.failover = f
+	s.logMutex.Unlock()
+
+	go f.run()
+	return f, nil
+}
+
+// Epoch returns the node's leadership epoch
+func (f *Failover) Epoch() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.epochLocked()
+}
+
+func (f *Failover) epochLocked() uint64 {
+	if len(f.history) == 0 {
+		return 0
+	}
+	return f.history[len(f.history)-1].Epoch
+}
+
+// setHistory replaces the epoch history and saves it
+func (f *Failover) setHistory(history []epochStart) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	tmpPath := f.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, f.path); err != nil {
+		return err
+	}
+	f.history = history
+	return nil
+}
+
+// Close stops the failover checks; the node keeps its current role
+func (f *Failover) Close() {
+	select {
+	case <-f.stop:
+	default:
+		close(f.stop)
+	}
+	<-f.done
+}
+
+func (f *Failover) run() {
+	defer close(f.done)
+
+	ticker := time.NewTicker(failoverCheck)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			if err := f.check(); err != nil {
+				f.store.logger.Error("failover check failed", "err", err)
+			}
+		}
+	}
+}
+
+// check probes the group once and changes this node's role if needed
+func (f *Failover) check() error {
+	statuses := f.probe()
+	epoch := f.Epoch()
+
+	newest, maxEpoch := "", epoch
+	for id, st := range statuses {
+		if st.Role == "leader" && st.Epoch > maxEpoch {
+			newest = id
+		}
+		if st.Epoch > maxEpoch {
+			maxEpoch = st.Epoch
+		}
+	}
+	if newest != "" {
+		return f.follow(newest, statuses[newest])
+	}
+
+	f.store.logMutex.Lock()
+	follower := f.store.follower
+	f.store.logMutex.Unlock()
+	if follower == nil || time.Since(follower.LastContact()) < failoverTimeout {
+		return nil
+	}
+	for id, st := range statuses {
+		if f.peers[id] == follower.addr && st.Role == "leader" {
+			// Only this node's stream is down; it reconnects by itself
+			return nil
+		}
+	}
+	if 2*(len(statuses)+1) <= len(f.peers) {
+		return nil
+	}
+
+	candidate, candidateSeq := f.self, f.store.Seq()
+	for id, st := range statuses {
+		if st.Role != "follower" || st.Epoch != epoch {
+			continue
+		}
+		if st.Seq > candidateSeq || st.Seq == candidateSeq && id < candidate {
+			candidate, candidateSeq = id, st.Seq
+		}
+	}
+	if candidate != f.self {
+		return nil
+	}
+	return f.promote(maxEpoch + 1)
+}
+
+// probe returns the status of every other node that answers
+func (f *Failover) probe() map[string]replStatus {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	statuses := make(map[string]replStatus)
+	for id, addr := range f.peers {
+		if id == f.self {
+			continue
+		}
+		wg.Add(1)
+		go func(id, addr string) {
+			defer wg.Done()
+			st, err := f.store.probeStatus(addr)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			statuses[id] = st
+			mu.Unlock()
+		}(id, addr)
+	}
+	wg.Wait()
+	return statuses
+}
+
+// probeStatus asks the replication listener at addr for its node's status
+func (s *Store) probeStatus(addr string) (replStatus, error) {
+	var st replStatus
+	conn, err := s.dialReplication(addr, failoverProbeTimeout)
+	if err != nil {
+		return st, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(failoverProbeTimeout))
+	dec := json.NewDecoder(conn)
+	if err := s.sendHello(json.NewEncoder(conn), dec, replHello{Status: true}); err != nil {
+		return st, err
+	}
+	err = dec.Decode(&st)
+	return st, err
+}
+
+// promote makes this node the leader of a new epoch
+func (f *Failover) promote(epoch uint64) error {
+	f.mu.Lock()
+	history := append(append([]epochStart(nil), f.history...), epochStart{Epoch: epoch, Start: f.store.Seq()})
+	f.mu.Unlock()
+
+	if err := f.setHistory(history); err != nil {
+		return err
+	}
+	f.store.logger.Warn("failover: promoted to leader", "epoch", epoch)
+	return f.store.Promote()
+}
+
+// follow makes node id, the leader of a newer epoch, this node's leader,
+// resyncing from scratch if this node applied records the leader's history
+// doesn't share
+func (f *Failover) follow(id string, st replStatus) error {
+	epoch := f.Epoch()
+	resync := false
+	for _, e := range st.History {
+		if e.Epoch > epoch {
+			resync = f.store.Seq() > e.Start
+			break
+		}
+	}
+
+	if err := f.store.switchLeader(f.peers[id], resync); err != nil {
+		return err
+	}
+	if err := f.setHistory(st.History); err != nil {
+		return err
+	}
+	f.store.logger.Warn("failover: following a new leader", "leader", id, "epoch", st.Epoch)
+	return nil
+}
+
+// replStatus returns the status this node reports to probing peers
+func (s *Store) replStatus() replStatus {
+	s.logMutex.Lock()
+	follower, failover := s.follower, s.failover
+	s.logMutex.Unlock()
+
+	st := replStatus{Seq: s.Seq(), Role: "leader"}
+	if follower != nil {
+		st.Role = "follower"
+	}
+	if failover != nil {
+		failover.mu.Lock()
+		st.Epoch = failover.epochLocked()
+		st.History = append([]epochStart(nil), failover.history...)
+		failover.mu.Unlock()
+	}
+	return st
+}
+
+// switchLeader makes the store follow the leader at addr instead of its
+// current one, if any, without becoming writable in between. With resync
+// the store is emptied first and replicates everything again.
+func (s *Store) switchLeader(addr string, resync bool) error {
+	s.readOnly.Store(true)
+	s.logMutex.Lock()
+	old := s.follower
+	s.follower = nil
+	s.logMutex.Unlock()
+	if old != nil {
+		old.close()
+	}
+
+	if resync {
+		if err := s.reset(); err != nil {
+			return err
+		}
+	}
+	_, err := s.Follow(addr)
+	return err
+}
+
+// reset empties the store, its type registry and its write log, and rewinds
+// its sequence number to zero
+func (s *Store) reset() error {
+	unlock := s.lockAll()
+	defer unlock()
+
+	s.typesMutex.Lock()
+	s.attributeTypes = make(map[string]AttributeMetadata)
+	s.typesMutex.Unlock()
+
+	s.data.Range(func(k, v interface{}) bool {
+		s.data.Delete(k)
+		s.changed(k.(string), v, nil)
+		s.touch(k.(string))
+		return true
+	})
+
+	s.logMutex.Lock()
+	defer s.logMutex.Unlock()
+	if err := s.truncateLogLocked(); err != nil {
+		return err
+	}
+	s.advanceSeqLocked(0)
+	return nil
+}
@@ -0,0 +1,217 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Cluster nodes find each other and track each other's health by gossip.
+// Every gossipInterval a node bumps its own heartbeat and swaps its member
+// table with one random peer, each side keeping the highest heartbeat it has
+// seen per node. New members therefore spread to the whole cluster in a few
+// rounds, and a node whose heartbeat stops rising is marked suspect and then
+// dead. Gossip runs over the client port as the internal command
+// "local gossip <members>", answered with the receiver's own table.
+
+// gossipInterval is how often a node gossips with a random peer
+const gossipInterval = 500 * time.Millisecond
+
+// nodeSuspectAfter and nodeDeadAfter are how long a node's heartbeat may
+// stay unchanged before it is considered suspect or dead
+const (
+	nodeSuspectAfter = 3 * time.Second
+	nodeDeadAfter    = 10 * time.Second
+)
+
+// node states reported by cluster nodes
+const (
+	nodeAlive   = "alive"
+	nodeSuspect = "suspect"
+	nodeDead    = "dead"
+	nodeLeaving = "leaving"
+	nodeLeft    = "left"
+)
+
+// gossipMember is a node's entry in a gossiped member table
+type gossipMember struct {
+	ID        string `json:"id"`
+	Addr      string `json:"addr"`
+	Heartbeat uint64 `json:"heartbeat"`
+	Left      bool   `json:"left,omitempty"`
+	Migrating bool   `json:"migrating,omitempty"`
+}
+
+// NodeInfo describes a cluster member as seen by this node
+type NodeInfo struct {
+	ID       string
+	Addr     string
+	State    string        // alive, suspect, dead, leaving or left
+	LastSeen time.Duration // since the node's heartbeat last advanced
+	Ranges   []string      // owned ring ranges, as "start-end" in hex
+}
+
+// stateLocked derives p's state from how long ago its heartbeat advanced.
+// Caller must hold mu.
+func (c *Cluster) stateLocked(p *clusterPeer, now time.Time) string {
+	age := now.Sub(p.lastSeen)
+	switch {
+	case p.left && p.migrating:
+		return nodeLeaving
+	case p.left:
+		return nodeLeft
+	case p.id == c.self || age < nodeSuspectAfter:
+		return nodeAlive
+	case age < nodeDeadAfter:
+		return nodeSuspect
+	}
+	return nodeDead
+}
+
+func (c *Cluster) runGossip() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(gossipInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.gossipOnce()
+		}
+	}
+}
+
+// gossipOnce bumps this node's heartbeat and swaps member tables with one
+// random peer. Dead peers stay candidates, so a node that comes back is
+// noticed.
+func (c *Cluster) gossipOnce() {
+	c.mu.Lock()
+	me := c.peers[c.self]
+	me.heartbeat++
+	me.lastSeen = time.Now()
+	c.mu.Unlock()
+
+	others := c.others()
+	if len(others) == 0 {
+		return
+	}
+	peer := others[rand.Intn(len(others))]
+
+	table, err := json.Marshal(c.members())
+	if err != nil {
+		return
+	}
+	reply, err := peer.do([]string{"local", "gossip", string(table)})
+	if err != nil {
+		return
+	}
+	if data, ok := reply.(string); ok {
+		var members []gossipMember
+		if json.Unmarshal([]byte(data), &members) == nil {
+			c.merge(members)
+		}
+	}
+}
+
+// members returns this node's member table
+func (c *Cluster) members() []gossipMember {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	members := make([]gossipMember, 0, len(c.peers))
+	for _, p := range c.peers {
+		members = append(members, gossipMember{ID: p.id, Addr: p.addr, Heartbeat: p.heartbeat, Left: p.left, Migrating: p.migrating})
+	}
+	return members
+}
+
+// merge folds a gossiped member table into this node's. Unknown nodes join
+// the ring and nodes that have left are taken off it, for good; a node's
+// address is fixed by the first table that names it. Flags travel with
+// the heartbeat that carries them.
+func (c *Cluster) merge(members []gossipMember) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	changed := false
+	for _, m := range members {
+		if m.ID == "" || m.Addr == "" || m.ID == c.self {
+			continue
+		}
+		p, known := c.peers[m.ID]
+		if !known {
+			p = newClusterPeer(m.ID, m.Addr, c.secret, now)
+			p.heartbeat, p.left, p.migrating = m.Heartbeat, m.Left, m.Migrating
+			c.peers[m.ID] = p
+			changed = true
+			continue
+		}
+		if m.Heartbeat > p.heartbeat {
+			p.heartbeat = m.Heartbeat
+			p.lastSeen = now
+			p.migrating = m.Migrating
+			if m.Left && !p.left {
+				p.left = true
+				changed = true
+			}
+		}
+	}
+	if changed {
+		c.rebuildRingLocked()
+	}
+}
+
+// handleGossip merges a member table received from a peer and returns this
+// node's table as the reply
+func (c *Cluster) handleGossip(data string) (string, error) {
+	var members []gossipMember
+	if err := json.Unmarshal([]byte(data), &members); err != nil {
+		return "", fmt.Errorf("bad gossip: %w", err)
+	}
+	c.merge(members)
+
+	table, err := json.Marshal(c.members())
+	return string(table), err
+}
+
+// Nodes returns every known member ordered by ID
+func (c *Cluster) Nodes() []NodeInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ranges := make(map[string][]string)
+	var start uint64
+	for i, point := range c.ring {
+		// A point owns the hashes after the previous point up to its own;
+		// runs of points owned by the same node are reported as one range.
+		if i+1 < len(c.ring) && c.ring[i+1].node == point.node {
+			continue
+		}
+		ranges[point.node] = append(ranges[point.node], fmt.Sprintf("%08x-%08x", start, point.hash))
+		start = uint64(point.hash) + 1
+	}
+	if len(c.ring) > 0 && start <= 0xffffffff {
+		// Hashes past the last point wrap around to the first one
+		first := c.ring[0].node
+		ranges[first] = append(ranges[first], fmt.Sprintf("%08x-%08x", start, uint32(0xffffffff)))
+	}
+
+	now := time.Now()
+	nodes := make([]NodeInfo, 0, len(c.peers))
+	for _, p := range c.peers {
+		nodes = append(nodes, NodeInfo{
+			ID:       p.id,
+			Addr:     p.addr,
+			State:    c.stateLocked(p, now),
+			LastSeen: now.Sub(p.lastSeen),
+			Ranges:   ranges[p.id],
+		})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
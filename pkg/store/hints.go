@@ -0,0 +1,180 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// With hinted handoff, a put or delete for a key whose owner can't be
+// reached is not failed: the node the client sent it to queues it as a hint
+// for the owner and replies OK. Once gossip shows the owner alive again, the
+// hints are delivered to it in the order they were accepted. While a node
+// has hints queued for an owner, it queues that owner's later writes behind
+// them too, so the owner sees them in order. Hints are only held in memory,
+// up to a limit per owner; beyond it, writes fail with CLUSTERDOWN as usual.
+
+// hintDeliveryInterval is how often queued hints are offered to their owners
+const hintDeliveryInterval = time.Second
+
+// hintedHandoff holds the writes queued for unreachable owners
+type hintedHandoff struct {
+	limit int // hints queued per owner at most
+
+	mu      sync.Mutex
+	queues  map[string][][]string // owner ID to queued commands, oldest first
+	handed  uint64                // hints delivered
+	dropped uint64                // hints the owner rejected
+	lastErr error
+
+	done chan struct{}
+}
+
+// EnableHints makes the node queue up to limit writes for each owner it
+// can't reach, handing them off when the owner returns
+func (c *Cluster) EnableHints(limit int) {
+	h := &hintedHandoff{
+		limit:  limit,
+		queues: make(map[string][][]string),
+		done:   make(chan struct{}),
+	}
+	c.mu.Lock()
+	c.hints = h
+	c.mu.Unlock()
+
+	go c.runHints(h)
+}
+
+// hint queues a write for owner id, reporting false if hinted handoff is off
+// or the owner's queue is full
+func (c *Cluster) hint(id string, args []string) bool {
+	c.mu.RLock()
+	h := c.hints
+	c.mu.RUnlock()
+	if h == nil {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.queues[id]) >= h.limit {
+		return false
+	}
+	h.queues[id] = append(h.queues[id], append([]string(nil), args...))
+	return true
+}
+
+// hinting reports whether writes for owner id are queued
+func (c *Cluster) hinting(id string) bool {
+	c.mu.RLock()
+	h := c.hints
+	c.mu.RUnlock()
+	if h == nil {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.queues[id]) > 0
+}
+
+func (c *Cluster) runHints(h *hintedHandoff) {
+	defer close(h.done)
+
+	ticker := time.NewTicker(hintDeliveryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.deliverHints(h)
+		}
+	}
+}
+
+// deliverHints hands every owner that is alive again its queued writes, in
+// order, stopping at an owner's first failure to reach it
+func (c *Cluster) deliverHints(h *hintedHandoff) {
+	h.mu.Lock()
+	owners := make([]string, 0, len(h.queues))
+	for id, queue := range h.queues {
+		if len(queue) > 0 {
+			owners = append(owners, id)
+		}
+	}
+	h.mu.Unlock()
+
+	now := time.Now()
+	for _, id := range owners {
+		c.mu.RLock()
+		p := c.peers[id]
+		alive := p != nil && c.stateLocked(p, now) == nodeAlive
+		c.mu.RUnlock()
+		if !alive {
+			continue
+		}
+
+		for {
+			h.mu.Lock()
+			queue := h.queues[id]
+			if len(queue) == 0 {
+				delete(h.queues, id)
+				h.lastErr = nil
+				h.mu.Unlock()
+				break
+			}
+			args := queue[0]
+			h.mu.Unlock()
+
+			reply, err := c.forward(id, args)
+			if err != nil {
+				h.mu.Lock()
+				h.lastErr = err
+				h.mu.Unlock()
+				break
+			}
+			h.mu.Lock()
+			h.queues[id] = h.queues[id][1:]
+			if reply, ok := reply.(respError); ok {
+				// Retrying a write the owner rejects would block the queue
+				h.dropped++
+				h.lastErr = fmt.Errorf("node %s rejected hinted %s of %q: %s", id, args[0], args[1], reply)
+			} else {
+				h.handed++
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// HintInfo reports hinted handoff as "name:value" lines: the totals, then
+// the hints queued for each owner
+func (c *Cluster) HintInfo() []string {
+	c.mu.RLock()
+	h := c.hints
+	c.mu.RUnlock()
+	if h == nil {
+		return []string{"enabled:0"}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	lines := []string{"enabled:1", fmt.Sprintf("limit:%d", h.limit), fmt.Sprintf("handed_off:%d", h.handed), fmt.Sprintf("dropped:%d", h.dropped)}
+	if h.lastErr != nil {
+		lines = append(lines, "last_error:"+h.lastErr.Error())
+	}
+	owners := make([]string, 0, len(h.queues))
+	for id := range h.queues {
+		owners = append(owners, id)
+	}
+	sort.Strings(owners)
+	for _, id := range owners {
+		lines = append(lines, fmt.Sprintf("queued_%s:%d", id, len(h.queues[id])))
+	}
+	return lines
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"maps"
+	"sync"
+)
+
+// Hooks are functions an embedding program registers to run on the
+// store's writes, for validation, auditing or cache invalidation, without
+// wrapping every call to the store. BeforePut hooks run on the puts made
+// through the store's write methods, imports, bulk loads and changefeed
+// batches among them, before anything is logged, and can reject them;
+// writes replicated from another node, replayed from the log or restored
+// from a backup were checked where they were made and aren't again.
+// AfterPut and AfterDelete hooks run on every change to the store's entries
+// once it is applied, whether it was written here, replicated from another
+// node, restored or evicted. Hooks run while the writer holds the key's
+// lock, so they must be quick and must not write to the store; unlike
+// triggers, they write nothing themselves.
+
+// HookKind says when a hook runs
+type HookKind int
+
+const (
+	// BeforePut hooks run before a put is logged; an error rejects it,
+	// and the write it is part of, with that error
+	BeforePut HookKind = iota
+	// AfterPut hooks run once a put is applied, with the new attributes
+	AfterPut
+	// AfterDelete hooks run once a delete is applied, with the attributes
+	// the entry had
+	AfterDelete
+)
+
+// Hook is a function run on a write of the entry at key, with a copy of its
+// attributes. The error of an AfterPut or AfterDelete hook is ignored.
+type Hook func(key string, attrs map[string]interface{}) error
+
+// hookSet holds the registered hooks of each kind
+type hookSet struct {
+	mu    sync.RWMutex
+	kinds [AfterDelete + 1][]*Hook // replaced, never modified in place
+}
+
+// RegisterHook registers fn to run on writes as kind says, after the hooks
+// of that kind registered before it. It returns a function that
+// unregisters fn.
+func (s *Store) RegisterHook(kind HookKind, fn Hook) (unregister func()) {
+	if kind < BeforePut || kind > AfterDelete {
+		panic("store: unknown hook kind")
+	}
+	h := &fn
+	s.hooks.mu.Lock()
+	hooks := s.hooks.kinds[kind]
+	s.hooks.kinds[kind] = append(hooks[:len(hooks):len(hooks)], h)
+	s.hooks.mu.Unlock()
+
+	return func() {
+		s.hooks.mu.Lock()
+		defer s.hooks.mu.Unlock()
+
+		hooks := s.hooks.kinds[kind]
+		for i := range hooks {
+			if hooks[i] == h {
+				s.hooks.kinds[kind] = append(hooks[:i:i], hooks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// hooksOf returns the hooks of kind
+func (s *Store) hooksOf(kind HookKind) []*Hook {
+	s.hooks.mu.RLock()
+	defer s.hooks.mu.RUnlock()
+
+	return s.hooks.kinds[kind]
+}
+
+// runBeforePut runs the BeforePut hooks on the puts in ops, returning the
+// first error
+func (s *Store) runBeforePut(ops []logOp) error {
+	hooks := s.hooksOf(BeforePut)
+	if len(hooks) == 0 {
+		return nil
+	}
+	for _, op := range ops {
+		if op.Op == "del" {
+			continue
+		}
+		attrs := maps.Clone(op.Attrs)
+		for _, h := range hooks {
+			if err := (*h)(op.Key, attrs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// changed records that the entry at key went from prev to next, either of
+// which is nil for no entry: it updates the memory estimate and the
+// counts, tells subscribers and runs the AfterPut or AfterDelete hooks.
+// Every change to s.data goes through it. Caller must hold key's stripe.
+func (s *Store) changed(key string, prev, next interface{}) {
+	s.notify(key, prev, next)
+	s.account(key, prev, next)
+	s.counts.add(prev, -1)
+	s.counts.add(next, 1)
+	kind, e := AfterPut, next
+	if next == nil {
+		kind, e = AfterDelete, prev
+	}
+	hooks := s.hooksOf(kind)
+	if len(hooks) == 0 || e == nil {
+		return
+	}
+	attrs := maps.Clone(e.(*entry).attrs)
+	for _, h := range hooks {
+		(*h)(key, attrs)
+	}
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// TestHookOrder checks the after hooks run once each change is applied, in
+// the order they were registered, with the new or the deleted attributes
+func TestHookOrder(t *testing.T) {
+	s := NewStore()
+	var calls []string
+	for _, name := range []string{"first", "second"} {
+		s.RegisterHook(AfterPut, func(key string, attrs map[string]interface{}) error {
+			if s.Get(key) == nil {
+				t.Errorf("%s AfterPut ran before %s was applied", name, key)
+			}
+			calls = append(calls, name+" put "+key+" "+attrs["n"].(string))
+			return errors.New("ignored")
+		})
+	}
+	unregister := s.RegisterHook(AfterDelete, func(key string, attrs map[string]interface{}) error {
+		if s.Get(key) != nil {
+			t.Errorf("AfterDelete ran before %s was deleted", key)
+		}
+		calls = append(calls, "delete "+key+" "+attrs["n"].(string))
+		return nil
+	})
+
+	if err := s.Put("k", [][]string{{"n", "one"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("k"); err != nil {
+		t.Fatal(err)
+	}
+	unregister()
+	if err := s.Put("k", [][]string{{"n", "two"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("k"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"first put k one", "second put k one", "delete k one", "first put k two", "second put k two"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+}
+
+// TestBeforePutRejects rejects puts with a BeforePut hook, through each way
+// of writing, and checks nothing of theirs is written
+func TestBeforePutRejects(t *testing.T) {
+	src := NewStore()
+	for _, key := range []string{"a", "bad"} {
+		if err := src.Put(key, [][]string{{"n", "1"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var doc bytes.Buffer
+	if err := src.ExportJSON(&doc); err != nil {
+		t.Fatal(err)
+	}
+
+	rejected := errors.New("rejected")
+	for name, write := range map[string]func(s *Store) error{
+		"put": func(s *Store) error {
+			return s.Put("bad", [][]string{{"n", "1"}})
+		},
+		"transaction": func(s *Store) error {
+			txn := s.Watch()
+			txn.Put("a", [][]string{{"n", "1"}})
+			txn.Put("bad", [][]string{{"n", "1"}})
+			return txn.Exec()
+		},
+		"import": func(s *Store) error {
+			_, err := s.ImportJSON(bytes.NewReader(doc.Bytes()))
+			return err
+		},
+		"bulk load": func(s *Store) error {
+			b, err := s.BulkLoad()
+			if err != nil {
+				return err
+			}
+			b.Put("a", [][]string{{"n", "1"}})
+			b.Put("bad", [][]string{{"n", "1"}})
+			_, err = b.Close()
+			return err
+		},
+		"changefeed": func(s *Store) error {
+			return s.ApplyChanges(ChangeBatch{Changes: []Change{
+				{Op: "put", Key: "a", Attributes: map[string]interface{}{"n": 1.0}},
+				{Op: "put", Key: "bad", Attributes: map[string]interface{}{"n": 1.0}},
+			}})
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := NewStore()
+			s.RegisterHook(BeforePut, func(key string, _ map[string]interface{}) error {
+				if key == "bad" {
+					return rejected
+				}
+				return nil
+			})
+			applied := 0
+			s.RegisterHook(AfterPut, func(string, map[string]interface{}) error {
+				applied++
+				return nil
+			})
+			if err := write(s); !errors.Is(err, rejected) {
+				t.Fatalf("err = %v, want %v", err, rejected)
+			}
+			if keys := s.Keys(); len(keys) != 0 || applied != 0 {
+				t.Errorf("keys = %v after %d AfterPut calls, want none", keys, applied)
+			}
+		})
+	}
+}
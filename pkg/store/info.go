@@ -0,0 +1,159 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Info summarizes the store's runtime state in sections of "name:value"
+// lines, as Redis INFO does, so one command tells an operator what a node
+// is, how much memory it holds, where its writes go and how far behind its
+// leader it is, and is easy to grep or split in a script. The sections are:
+//
+//   - server: the build, the process and how long it has been up
+//   - memory: the entries' estimated memory, its limit, and the Go heap
+//   - persistence: the write log, the default durability and the writes a
+//     restart would lose
+//   - replication: the node's role and offsets, as the replication command
+//     prints them
+//   - keyspace: the entry, attribute and type counts, and each namespace's
+//     usage and quota
+//
+// Each section is headed by a "# Name" line; all of them are separated by
+// blank lines.
+
+// InfoSections names the sections of Info, in the order it reports them
+var InfoSections = []string{"server", "memory", "persistence", "replication", "keyspace"}
+
+// Info returns the lines of section, one of InfoSections, or of every
+// section if section is "" or "all"
+func (s *Store) Info(section string) ([]string, error) {
+	return s.info(section, nil)
+}
+
+// info is Info with extra lines appended to the server section, for the
+// server to report its clients
+func (s *Store) info(section string, server []string) ([]string, error) {
+	section = strings.ToLower(section)
+	sections := InfoSections
+	if section != "" && section != "all" {
+		if !slices.Contains(InfoSections, section) {
+			return nil, invalid("unknown info section %q (want %s or all)", section, strings.Join(InfoSections, ", "))
+		}
+		sections = []string{section}
+	}
+
+	var lines []string
+	for i, name := range sections {
+		if i > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "# "+strings.ToUpper(name[:1])+name[1:])
+		switch name {
+		case "server":
+			lines = append(append(lines, s.serverInfo()...), server...)
+		case "memory":
+			lines = append(lines, s.memoryInfo()...)
+		case "persistence":
+			lines = append(lines, s.persistenceInfo()...)
+		case "replication":
+			lines = append(lines, s.ReplicationInfo()...)
+		case "keyspace":
+			lines = append(lines, s.keyspaceInfo()...)
+		}
+	}
+	return lines, nil
+}
+
+func (s *Store) serverInfo() []string {
+	version := "(devel)"
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" {
+		version = bi.Main.Version
+	}
+	return []string{
+		"version:" + version,
+		"go_version:" + runtime.Version(),
+		"os:" + runtime.GOOS,
+		"arch:" + runtime.GOARCH,
+		fmt.Sprintf("process_id:%d", os.Getpid()),
+		"started:" + s.started.UTC().Format(time.RFC3339),
+		fmt.Sprintf("uptime_seconds:%d", int64(time.Since(s.started).Seconds())),
+		fmt.Sprintf("goroutines:%d", runtime.NumGoroutine()),
+		fmt.Sprintf("lock_stripes:%d", len(s.stripes)),
+	}
+}
+
+func (s *Store) memoryInfo() []string {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return []string{
+		fmt.Sprintf("memory_bytes:%d", s.memory.Load()),
+		fmt.Sprintf("max_memory_bytes:%d", s.maxMemory),
+		"eviction:" + s.eviction.String(),
+		fmt.Sprintf("heap_alloc_bytes:%d", ms.HeapAlloc),
+		fmt.Sprintf("heap_sys_bytes:%d", ms.HeapSys),
+		fmt.Sprintf("sys_bytes:%d", ms.Sys),
+		fmt.Sprintf("gc_runs:%d", ms.NumGC),
+	}
+}
+
+func (s *Store) persistenceInfo() []string {
+	s.logMutex.Lock()
+	log, durability, seq := s.log, s.durability, s.seq
+	var size int64 = -1
+	var buffered int
+	if log != nil {
+		if fi, err := log.file.Stat(); err == nil {
+			size = fi.Size()
+		}
+		buffered = log.buf.Buffered()
+	}
+	raft := s.raftNode != nil
+	s.logMutex.Unlock()
+
+	lines := []string{fmt.Sprintf("log_enabled:%d", boolInt(log != nil))}
+	if log != nil {
+		lines = append(lines,
+			"log_path:"+log.path,
+			"log_codec:"+log.codec.Name(),
+			fmt.Sprintf("log_bytes:%d", size),
+			fmt.Sprintf("log_buffered_bytes:%d", buffered),
+		)
+	}
+	return append(lines,
+		"durability:"+durability.String(),
+		fmt.Sprintf("seq:%d", seq),
+		fmt.Sprintf("unsaved:%d", boolInt(s.Unsaved())),
+		fmt.Sprintf("raft:%d", boolInt(raft)),
+		fmt.Sprintf("audit_enabled:%d", boolInt(s.audit.Load() != nil)),
+	)
+}
+
+func (s *Store) keyspaceInfo() []string {
+	st := s.Stats()
+	lines := []string{
+		fmt.Sprintf("keys:%d", st.Keys),
+		fmt.Sprintf("attributes:%d", st.Attributes),
+		fmt.Sprintf("attribute_names:%d", st.AttributeNames),
+		fmt.Sprintf("strings:%d", st.Strings),
+		fmt.Sprintf("floats:%d", st.Floats),
+		fmt.Sprintf("bools:%d", st.Bools),
+	}
+	for _, u := range s.NamespaceUsage() {
+		lines = append(lines, "namespace_"+u.Info())
+	}
+	return lines
+}
+
+// boolInt returns 1 for true and 0 for false, as info lines report flags
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,395 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// kafkaProducer is a minimal Kafka producer: it finds the leaders of a
+// topic's partitions with a Metadata request and sends each message in its
+// own Produce request, acknowledged by the partition leader. Messages with
+// the same key go to the same partition, chosen as Kafka's default
+// partitioner does, so each key's changes stay in order. It speaks Produce
+// v3 and Metadata v4, which brokers from Kafka 1.0 on understand.
+type kafkaProducer struct {
+	bootstrap []string
+	topic     string
+
+	correlation int32
+	addrs       map[int32]string   // broker addresses by node id
+	conns       map[int32]net.Conn // open connections by node id
+	leaders     []int32            // leader node id of each partition
+}
+
+// Kafka API keys and the versions used
+const (
+	kafkaProduceKey      = 0
+	kafkaProduceVersion  = 3
+	kafkaMetadataKey     = 3
+	kafkaMetadataVersion = 4
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func newKafkaProducer(bootstrap []string, topic string) *kafkaProducer {
+	return &kafkaProducer{bootstrap: bootstrap, topic: topic}
+}
+
+func (k *kafkaProducer) publish(ctx context.Context, key string, value []byte) error {
+	err := k.produce(ctx, key, value)
+	if err != nil {
+		// Start over, since leaders may have moved
+		k.close()
+	}
+	return err
+}
+
+func (k *kafkaProducer) produce(ctx context.Context, key string, value []byte) error {
+	if k.leaders == nil {
+		if err := k.refreshMetadata(ctx); err != nil {
+			return err
+		}
+	}
+	partition := int32(kafkaPartition([]byte(key), len(k.leaders)))
+	conn, err := k.connect(ctx, k.leaders[partition])
+	if err != nil {
+		return err
+	}
+
+	var req kafkaEncoder
+	req.int16(-1) // no transactional id
+	req.int16(1)  // acks: the leader's
+	req.int32(int32(connectorTimeout / time.Millisecond))
+	req.int32(1)
+	req.string(k.topic)
+	req.int32(1)
+	req.int32(partition)
+	req.bytes(kafkaRecordBatch([]byte(key), value, time.Now()))
+
+	resp, err := k.roundTrip(ctx, conn, kafkaProduceKey, kafkaProduceVersion, req.buf)
+	if err != nil {
+		return err
+	}
+	for topics := resp.int32(); topics > 0; topics-- {
+		resp.string()
+		for partitions := resp.int32(); partitions > 0; partitions-- {
+			resp.int32()
+			code := resp.int16()
+			resp.int64() // base offset
+			resp.int64() // log append time
+			if resp.err == nil && code != 0 {
+				return kafkaError("producing to "+k.topic, code)
+			}
+		}
+	}
+	return resp.err
+}
+
+// refreshMetadata looks up the topic's partition leaders from the first
+// bootstrap broker that answers
+func (k *kafkaProducer) refreshMetadata(ctx context.Context) error {
+	var req kafkaEncoder
+	req.int32(1)
+	req.string(k.topic)
+	req.int8(1) // allow auto topic creation
+
+	var lastErr error
+	for _, addr := range k.bootstrap {
+		conn, err := dialContext(ctx, addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp, err := k.roundTrip(ctx, conn, kafkaMetadataKey, kafkaMetadataVersion, req.buf)
+		conn.Close()
+		if err == nil {
+			err = k.parseMetadata(resp)
+		}
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+	}
+	return lastErr
+}
+
+func (k *kafkaProducer) parseMetadata(resp *kafkaDecoder) error {
+	resp.int32() // throttle time
+	addrs := make(map[int32]string)
+	for brokers := resp.int32(); brokers > 0 && resp.err == nil; brokers-- {
+		id := resp.int32()
+		host := resp.string()
+		port := resp.int32()
+		resp.string() // rack
+		addrs[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	resp.string() // cluster id
+	resp.int32()  // controller id
+
+	var leaders []int32
+	for topics := resp.int32(); topics > 0 && resp.err == nil; topics-- {
+		code := resp.int16()
+		name := resp.string()
+		resp.int8() // is internal
+		if code != 0 && name == k.topic {
+			return kafkaError("looking up "+k.topic, code)
+		}
+		for partitions := resp.int32(); partitions > 0 && resp.err == nil; partitions-- {
+			code := resp.int16()
+			id := resp.int32()
+			leader := resp.int32()
+			resp.int32s() // replicas
+			resp.int32s() // in-sync replicas
+			if name != k.topic {
+				continue
+			}
+			if code != 0 && code != kafkaReplicaNotAvailable {
+				return kafkaError(fmt.Sprintf("looking up %s partition %d", k.topic, id), code)
+			}
+			if id < 0 || id > 1<<16 {
+				return fmt.Errorf("kafka: bad partition id %d", id)
+			}
+			for int(id) >= len(leaders) {
+				leaders = append(leaders, -1)
+			}
+			leaders[id] = leader
+		}
+	}
+	if resp.err != nil {
+		return resp.err
+	}
+	if len(leaders) == 0 {
+		return fmt.Errorf("kafka: topic %s has no partitions", k.topic)
+	}
+	for id, leader := range leaders {
+		if _, known := addrs[leader]; !known {
+			return fmt.Errorf("kafka: partition %d of %s has no leader", id, k.topic)
+		}
+	}
+	k.addrs, k.leaders = addrs, leaders
+	return nil
+}
+
+// connect returns a connection to broker id, dialing it if needed
+func (k *kafkaProducer) connect(ctx context.Context, id int32) (net.Conn, error) {
+	if conn, open := k.conns[id]; open {
+		return conn, nil
+	}
+	conn, err := dialContext(ctx, k.addrs[id])
+	if err != nil {
+		return nil, err
+	}
+	if k.conns == nil {
+		k.conns = make(map[int32]net.Conn)
+	}
+	k.conns[id] = conn
+	return conn, nil
+}
+
+// roundTrip sends a request and returns the decoder of its response body
+func (k *kafkaProducer) roundTrip(ctx context.Context, conn net.Conn, apiKey, version int16, body []byte) (*kafkaDecoder, error) {
+	stop := deadlineOnCancel(ctx, conn)
+	defer stop()
+
+	k.correlation++
+	var req kafkaEncoder
+	req.int32(0) // size, filled in below
+	req.int16(apiKey)
+	req.int16(version)
+	req.int32(k.correlation)
+	req.string(connectorClientID)
+	req.buf = append(req.buf, body...)
+	binary.BigEndian.PutUint32(req.buf, uint32(len(req.buf)-4))
+	if _, err := conn.Write(req.buf); err != nil {
+		return nil, err
+	}
+
+	var header [8]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	if size < 4 || size > 64<<20 {
+		return nil, fmt.Errorf("kafka: bad response size %d", size)
+	}
+	if id := int32(binary.BigEndian.Uint32(header[4:])); id != k.correlation {
+		return nil, fmt.Errorf("kafka: response %d to request %d", id, k.correlation)
+	}
+	resp := make([]byte, size-4)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	return &kafkaDecoder{buf: resp}, nil
+}
+
+func (k *kafkaProducer) close() {
+	for _, conn := range k.conns {
+		conn.Close()
+	}
+	k.conns, k.addrs, k.leaders = nil, nil, nil
+}
+
+func dialContext(ctx context.Context, addr string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: connectorTimeout}
+	return dialer.DialContext(ctx, "tcp", addr)
+}
+
+// kafkaReplicaNotAvailable is the partition error for a lagging replica,
+// which doesn't stop its leader from taking writes
+const kafkaReplicaNotAvailable = 9
+
+// kafkaError describes a Kafka error code
+func kafkaError(doing string, code int16) error {
+	names := map[int16]string{
+		3:  "unknown topic or partition",
+		5:  "leader not available",
+		6:  "not leader for partition",
+		7:  "request timed out",
+		10: "message too large",
+		29: "topic authorization failed",
+		87: "invalid record",
+	}
+	if name, known := names[code]; known {
+		return fmt.Errorf("kafka: %s: %s", doing, name)
+	}
+	return fmt.Errorf("kafka: %s: error code %d", doing, code)
+}
+
+// kafkaPartition picks the partition of key among n as Kafka's default
+// partitioner does, with the positive murmur2 hash of the key
+func kafkaPartition(key []byte, n int) int {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+	)
+	h := uint32(seed) ^ uint32(len(key))
+	for len(key) >= 4 {
+		k := binary.LittleEndian.Uint32(key) * m
+		k ^= k >> 24
+		h = h*m ^ k*m
+		key = key[4:]
+	}
+	switch len(key) {
+	case 3:
+		h ^= uint32(key[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(key[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(key[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int(h&0x7fffffff) % n
+}
+
+// kafkaRecordBatch encodes a record batch, in the v2 message format, holding
+// one record
+func kafkaRecordBatch(key, value []byte, at time.Time) []byte {
+	var record kafkaEncoder
+	record.int8(0)   // attributes
+	record.varint(0) // timestamp delta
+	record.varint(0) // offset delta
+	record.varint(int64(len(key)))
+	record.buf = append(record.buf, key...)
+	record.varint(int64(len(value)))
+	record.buf = append(record.buf, value...)
+	record.varint(0) // headers
+
+	// The part of the batch covered by its checksum
+	var body kafkaEncoder
+	body.int16(0) // attributes: no compression
+	body.int32(0) // last offset delta
+	body.int64(at.UnixMilli())
+	body.int64(at.UnixMilli())
+	body.int64(-1) // producer id
+	body.int16(-1) // producer epoch
+	body.int32(-1) // base sequence
+	body.int32(1)
+	body.varint(int64(len(record.buf)))
+	body.buf = append(body.buf, record.buf...)
+
+	var batch kafkaEncoder
+	batch.int64(0) // base offset
+	batch.int32(int32(4 + 1 + 4 + len(body.buf)))
+	batch.int32(-1) // partition leader epoch
+	batch.int8(2)   // magic
+	batch.int32(int32(crc32.Checksum(body.buf, castagnoli)))
+	batch.buf = append(batch.buf, body.buf...)
+	return batch.buf
+}
+
+// kafkaEncoder appends big-endian Kafka protocol fields
+type kafkaEncoder struct {
+	buf []byte
+}
+
+func (e *kafkaEncoder) int8(v int8)   { e.buf = append(e.buf, byte(v)) }
+func (e *kafkaEncoder) int16(v int16) { e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v)) }
+func (e *kafkaEncoder) int32(v int32) { e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v)) }
+func (e *kafkaEncoder) int64(v int64) { e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v)) }
+
+// varint appends v zigzag-encoded, as record fields are
+func (e *kafkaEncoder) varint(v int64) { e.buf = binary.AppendVarint(e.buf, v) }
+
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *kafkaEncoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// kafkaDecoder reads big-endian Kafka protocol fields. Errors are sticky:
+// after running out of input every field reads as zero.
+type kafkaDecoder struct {
+	buf []byte
+	err error
+}
+
+var errKafkaShort = errors.New("kafka: response too short")
+
+func (d *kafkaDecoder) take(n int) []byte {
+	if d.err != nil || n < 0 || n > len(d.buf) {
+		if d.err == nil {
+			d.err = errKafkaShort
+		}
+		return make([]byte, min(max(n, 0), 8))
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *kafkaDecoder) int8() int8   { return int8(d.take(1)[0]) }
+func (d *kafkaDecoder) int16() int16 { return int16(binary.BigEndian.Uint16(d.take(2))) }
+func (d *kafkaDecoder) int32() int32 { return int32(binary.BigEndian.Uint32(d.take(4))) }
+func (d *kafkaDecoder) int64() int64 { return int64(binary.BigEndian.Uint64(d.take(8))) }
+
+// string reads a string, returning "" for a null one
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+
+// int32s skips an array of int32s
+func (d *kafkaDecoder) int32s() {
+	if n := d.int32(); n > 0 {
+		d.take(4 * int(n))
+	}
+}
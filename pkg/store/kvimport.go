@@ -0,0 +1,252 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// etcd and Consul imports move configuration data over from those stores'
+// key/value APIs. Their keys are paths, and each key's last segment becomes
+// an attribute of the entry named by the rest: app/db/host = "db1" and
+// app/db/port = "5432" become the entry app/db with host db1 and port 5432.
+// Values are typed as put types them. Keys without a parent, values that
+// aren't text and entries whose values conflict with the store's attribute
+// types are skipped and reported.
+
+// kvImportTimeout bounds each request to etcd or Consul
+const kvImportTimeout = 30 * time.Second
+
+// etcdPageSize is the number of keys each etcd range request returns
+const etcdPageSize = 1000
+
+// ImportEtcd imports the keys under prefix from the etcd cluster member at
+// endpoint, through its JSON API (the gRPC gateway). The endpoint is
+// host:port or a URL, which can carry a user and password, and all keys are
+// read at one revision. opts.TrimPrefix leaves prefix out of entry keys.
+func (s *Store) ImportEtcd(endpoint, prefix string, opts ImportOptions) (*ImportReport, error) {
+	kvs, err := readEtcd(endpoint, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return s.writeImport(s.typePaths(kvs, prefix, opts.TrimPrefix), opts)
+}
+
+// ImportConsul imports the keys under prefix from the Consul agent at addr,
+// host:port or a URL, authenticating with opts.Token if set.
+// opts.TrimPrefix leaves prefix out of entry keys.
+func (s *Store) ImportConsul(addr, prefix string, opts ImportOptions) (*ImportReport, error) {
+	kvs, err := readConsul(addr, prefix, opts.Token)
+	if err != nil {
+		return nil, err
+	}
+	return s.writeImport(s.typePaths(kvs, prefix, opts.TrimPrefix), opts)
+}
+
+// kvPair is a key and its raw value as read from etcd or Consul
+type kvPair struct {
+	key   string
+	value []byte
+}
+
+// typePaths maps kvs to entries, each key's last path segment naming an
+// attribute, and types them as typeHashes does
+func (s *Store) typePaths(kvs []kvPair, prefix string, trim bool) *importSet {
+	hashes := make(map[string][][]string)
+	var skipped []RowError
+	for _, kv := range kvs {
+		path := kv.key
+		if trim {
+			path = strings.TrimPrefix(path, prefix)
+		}
+		i := strings.LastIndexByte(path, '/')
+		switch {
+		case strings.HasSuffix(path, "/") && len(kv.value) == 0:
+			continue // a Consul folder
+		case i <= 0 || i == len(path)-1:
+			skipped = append(skipped, RowError{Key: kv.key, Err: fmt.Errorf("%q doesn't name an entry and an attribute, as entry/attribute", path)})
+		case !utf8.Valid(kv.value):
+			skipped = append(skipped, RowError{Key: kv.key, Err: fmt.Errorf("value isn't text")})
+		default:
+			entryKey := path[:i]
+			hashes[entryKey] = append(hashes[entryKey], []string{path[i+1:], string(kv.value)})
+		}
+	}
+	set := s.typeHashes(hashes)
+	set.skipped = append(skipped, set.skipped...)
+	return set
+}
+
+// kvBaseURL returns addr as a URL, with http as the default scheme
+func kvBaseURL(addr string) (*url.URL, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return u, nil
+}
+
+// errKVNotFound is returned by kvRequest for a 404 reply, which is how
+// Consul answers for a prefix without keys
+var errKVNotFound = errors.New("not found")
+
+// kvRequest sends a request to an etcd or Consul HTTP API, decoding its JSON
+// reply into reply
+func kvRequest(client *http.Client, method string, u *url.URL, header http.Header, body, reply interface{}) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, u.String(), r)
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return errKVNotFound
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", method, u.Redacted(), resp.Status, bytes.TrimSpace(msg))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(reply); err != nil {
+		return fmt.Errorf("%s %s: %w", method, u.Redacted(), err)
+	}
+	return nil
+}
+
+// etcdRange is the reply of etcd's /v3/kv/range
+type etcdRange struct {
+	Header struct {
+		Revision int64 `json:"revision,string"`
+	} `json:"header"`
+	Kvs []struct {
+		Key   []byte `json:"key"` // base64 in JSON, decoded by encoding/json
+		Value []byte `json:"value"`
+	} `json:"kvs"`
+	More bool `json:"more"`
+}
+
+// readEtcd reads the keys under prefix from etcd, a page at a time
+func readEtcd(endpoint, prefix string) ([]kvPair, error) {
+	base, err := kvBaseURL(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: kvImportTimeout}
+	header := http.Header{"Content-Type": {"application/json"}}
+	api := func(path string) *url.URL {
+		u := *base
+		u.User = nil
+		u.Path += path
+		return &u
+	}
+
+	if user := base.User; user != nil {
+		password, _ := user.Password()
+		var auth struct {
+			Token string `json:"token"`
+		}
+		creds := map[string]string{"name": user.Username(), "password": password}
+		if err := kvRequest(client, http.MethodPost, api("/v3/auth/authenticate"), header, creds, &auth); err != nil {
+			return nil, err
+		}
+		header.Set("Authorization", auth.Token)
+	}
+
+	var kvs []kvPair
+	key, end := []byte(prefix), etcdPrefixEnd(prefix)
+	if prefix == "" {
+		key = []byte{0} // with range end "\x00", every key
+	}
+	var revision int64
+	for {
+		req := map[string]interface{}{"key": key, "range_end": end, "limit": etcdPageSize, "revision": revision}
+		var page etcdRange
+		if err := kvRequest(client, http.MethodPost, api("/v3/kv/range"), header, req, &page); err != nil {
+			return nil, err
+		}
+		if revision == 0 {
+			revision = page.Header.Revision
+		}
+		for _, kv := range page.Kvs {
+			kvs = append(kvs, kvPair{key: string(kv.Key), value: kv.Value})
+		}
+		if !page.More || len(page.Kvs) == 0 {
+			return kvs, nil
+		}
+		// Go on after the last key read
+		key = append(bytes.Clone(page.Kvs[len(page.Kvs)-1].Key), 0)
+	}
+}
+
+// etcdPrefixEnd returns the range end that selects every key starting with
+// prefix: prefix with its last byte below 0xff incremented, or "\x00", all
+// keys, if there is none
+func etcdPrefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
+
+// readConsul reads the keys under prefix from Consul
+func readConsul(addr, prefix, token string) ([]kvPair, error) {
+	u, err := kvBaseURL(addr)
+	if err != nil {
+		return nil, err
+	}
+	u.Path += "/v1/kv/" + prefix
+	u.RawQuery = "recurse=true"
+	header := http.Header{}
+	if token != "" {
+		header.Set("X-Consul-Token", token)
+	}
+
+	var reply []struct {
+		Key   string `json:"Key"`
+		Value string `json:"Value"` // base64, or null for an empty value
+	}
+	client := &http.Client{Timeout: kvImportTimeout}
+	if err := kvRequest(client, http.MethodGet, u, header, nil, &reply); err != nil && err != errKVNotFound {
+		return nil, err
+	}
+	kvs := make([]kvPair, 0, len(reply))
+	for _, kv := range reply {
+		value, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			return nil, fmt.Errorf("consul key %q: %w", kv.Key, err)
+		}
+		kvs = append(kvs, kvPair{key: kv.Key, value: value})
+	}
+	return kvs, nil
+}
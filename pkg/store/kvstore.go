@@ -0,0 +1,48 @@
+package store
+
+import "context"
+
+// KVStore is the read and write API of a Store, the part most programs
+// use. Code written against it can take a mock in its tests, or a
+// decorator that wraps a Store, such as one embedding a KVStore and adding
+// metrics, a cache or validation to the methods it overrides.
+type KVStore interface {
+	// Put writes the entry at key from attribute name and value pairs,
+	// typing each value as DetermineType does
+	Put(key string, attributes [][]string) error
+	// PutCtx is Put, giving up if ctx is done first
+	PutCtx(ctx context.Context, key string, attributes [][]string) error
+	// PutValues writes the entry at key from values already typed
+	PutValues(key string, attrs map[string]interface{}) error
+	// Get returns the entry at key, or nil if there is none
+	Get(key string) map[string]interface{}
+	// MGet returns the entries at keys, as of one point in time, and the
+	// keys that have none
+	MGet(keys []string) (map[string]map[string]interface{}, []string)
+	// GetCtx is Get, giving up if ctx is done first
+	GetCtx(ctx context.Context, key string) (map[string]interface{}, error)
+	// Delete removes the entry at key, if any
+	Delete(key string) error
+	// DeleteKeys removes the entries at keys as one write, returning how
+	// many there were
+	DeleteKeys(keys []string) (int, error)
+	// Search returns the keys, sorted, whose entries have attrKey set to
+	// attrValue, typed as Put types it
+	Search(attrKey, attrValue string) []string
+	// SearchCtx is Search, giving up if ctx is done first
+	SearchCtx(ctx context.Context, attrKey, attrValue string) ([]string, error)
+	// SearchValue is Search for a typed value
+	SearchValue(attrKey string, value interface{}) []string
+	// Keys returns every key, sorted
+	Keys() []string
+	// ForEach calls fn with each entry, under a consistent view, until fn
+	// returns false
+	ForEach(fn func(key string, attrs map[string]interface{}) bool)
+	// KeysMatching returns the keys matching a glob pattern, sorted
+	KeysMatching(pattern string) ([]string, error)
+	// AttributeNames returns the names of the attributes with a registered
+	// type, sorted
+	AttributeNames() []string
+}
+
+var _ KVStore = (*Store)(nil)
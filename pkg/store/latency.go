@@ -0,0 +1,205 @@
+package store
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// The store keeps a latency histogram per command, recorded by the server
+// for each command it runs and by the CLI through RecordLatency, so a p99
+// regression shows up as soon as it happens rather than in a benchmark.
+// The histograms are HDR-style: latencies fall into buckets spaced
+// log-linearly, 16 to each power of two nanoseconds, so any percentile read
+// from them is within 1/16 of the true value, from a nanosecond to about 18
+// minutes, in a fixed 5 KB per command however many samples are taken.
+// Recording is lock-free. Latency summarizes the histograms, and the
+// metrics endpoint exports them (see metrics.go).
+
+const (
+	latencySubBits = 4                   // log2 of the buckets per power of two
+	latencySub     = 1 << latencySubBits // the buckets per power of two
+	latencyMaxBits = 40                  // latencies of 2^40ns and longer go to the last bucket
+
+	latencyBuckets = (latencyMaxBits - latencySubBits + 1) * latencySub
+)
+
+// latencyHistogram counts a command's latencies by bucket
+type latencyHistogram struct {
+	counts [latencyBuckets]atomic.Uint64
+	calls  atomic.Uint64
+	sum    atomic.Uint64 // nanoseconds
+	max    atomic.Int64  // nanoseconds
+}
+
+// latencyBucket returns the bucket of a latency of ns nanoseconds
+func latencyBucket(ns uint64) int {
+	if ns < latencySub {
+		return int(ns)
+	}
+	exp := bits.Len64(ns) - latencySubBits - 1
+	return min(exp*latencySub+int(ns>>exp), latencyBuckets-1)
+}
+
+// latencyBucketEnd returns the latency, in nanoseconds, that bucket i
+// counts those below
+func latencyBucketEnd(i int) uint64 {
+	if i < latencySub {
+		return uint64(i) + 1
+	}
+	exp := i/latencySub - 1
+	return uint64(i-exp*latencySub+1) << exp
+}
+
+// record counts a latency of d
+func (h *latencyHistogram) record(d time.Duration) {
+	ns := uint64(max(d, 0))
+	h.counts[latencyBucket(ns)].Add(1)
+	h.calls.Add(1)
+	h.sum.Add(ns)
+	for {
+		prev := h.max.Load()
+		if int64(ns) <= prev || h.max.CompareAndSwap(prev, int64(ns)) {
+			return
+		}
+	}
+}
+
+// percentile returns the latency below which fraction q of the recorded
+// ones fall, as the end of the bucket holding it, capped at the longest
+// recorded, given their counts and total
+func (h *latencyHistogram) percentile(counts []uint64, calls uint64, q float64) time.Duration {
+	if calls == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(calls) + 0.5)
+	rank = max(rank, 1)
+	var seen uint64
+	for i, n := range counts {
+		if seen += n; seen >= rank {
+			return min(time.Duration(latencyBucketEnd(i)), time.Duration(h.max.Load()))
+		}
+	}
+	return time.Duration(h.max.Load())
+}
+
+// LatencyStats summarizes the latencies of a command
+type LatencyStats struct {
+	Command string
+	Calls   uint64
+	Mean    time.Duration
+	P50     time.Duration
+	P90     time.Duration
+	P99     time.Duration
+	P999    time.Duration // 99.9th percentile
+	Max     time.Duration
+}
+
+// LatencyBucket is one bucket of a latency histogram: the number of calls
+// that took under Below and at least the Below of the bucket before it
+type LatencyBucket struct {
+	Below time.Duration
+	Count uint64
+}
+
+// RecordLatency records that a run of command took d, for Latency. The
+// server records the commands it runs itself.
+func (s *Store) RecordLatency(command string, d time.Duration) {
+	h, ok := s.latency.Load(command)
+	if !ok {
+		h, _ = s.latency.LoadOrStore(command, new(latencyHistogram))
+	}
+	h.(*latencyHistogram).record(d)
+}
+
+// Latency returns the latency summary of every command recorded since the
+// store was made or ResetLatency last called, by command name
+func (s *Store) Latency() []LatencyStats {
+	var stats []LatencyStats
+	s.latency.Range(func(k, v interface{}) bool {
+		stats = append(stats, v.(*latencyHistogram).stats(k.(string)))
+		return true
+	})
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Command < stats[j].Command })
+	return stats
+}
+
+// CommandLatency returns the latency summary of command and the non-empty
+// buckets of its histogram, shortest first. It reports false if no run of
+// command was recorded.
+func (s *Store) CommandLatency(command string) (LatencyStats, []LatencyBucket, bool) {
+	v, ok := s.latency.Load(command)
+	if !ok {
+		return LatencyStats{}, nil, false
+	}
+	h := v.(*latencyHistogram)
+	var buckets []LatencyBucket
+	for i := range h.counts {
+		if n := h.counts[i].Load(); n > 0 {
+			buckets = append(buckets, LatencyBucket{Below: time.Duration(latencyBucketEnd(i)), Count: n})
+		}
+	}
+	return h.stats(command), buckets, true
+}
+
+// ResetLatency forgets every recorded latency
+func (s *Store) ResetLatency() {
+	s.latency.Range(func(k, _ interface{}) bool {
+		s.latency.Delete(k)
+		return true
+	})
+}
+
+// snapshot returns the bucket counts of h and their total
+func (h *latencyHistogram) snapshot() ([]uint64, uint64) {
+	counts := make([]uint64, latencyBuckets)
+	var calls uint64
+	for i := range h.counts {
+		counts[i] = h.counts[i].Load()
+		calls += counts[i]
+	}
+	return counts, calls
+}
+
+// stats summarizes h, the histogram of command
+func (h *latencyHistogram) stats(command string) LatencyStats {
+	counts, calls := h.snapshot()
+	st := LatencyStats{
+		Command: command,
+		Calls:   calls,
+		P50:     h.percentile(counts, calls, 0.50),
+		P90:     h.percentile(counts, calls, 0.90),
+		P99:     h.percentile(counts, calls, 0.99),
+		P999:    h.percentile(counts, calls, 0.999),
+		Max:     time.Duration(h.max.Load()),
+	}
+	if n := h.calls.Load(); n > 0 {
+		st.Mean = time.Duration(h.sum.Load() / n)
+	}
+	return st
+}
+
+// Info reports st as a "command:name=value ..." line, as the latency
+// command prints it
+func (st LatencyStats) Info() string {
+	return fmt.Sprintf("%s:calls=%d mean=%s p50=%s p90=%s p99=%s p99.9=%s max=%s", st.Command, st.Calls,
+		roundLatency(st.Mean), roundLatency(st.P50), roundLatency(st.P90), roundLatency(st.P99), roundLatency(st.P999), roundLatency(st.Max))
+}
+
+// Info reports b as a "<below:count" line, as the latency command prints
+// a command's histogram
+func (b LatencyBucket) Info() string {
+	return fmt.Sprintf("<%s:%d", roundLatency(b.Below), b.Count)
+}
+
+// roundLatency rounds d to three significant digits for display
+func roundLatency(d time.Duration) time.Duration {
+	for unit := time.Duration(1); unit < time.Hour; unit *= 10 {
+		if d < 1000*unit {
+			return d.Round(unit)
+		}
+	}
+	return d.Round(time.Second)
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"slices"
+	"sort"
+	"sync"
+)
+
+// defaultLockStripes is the number of write locks keys are hashed across
+const defaultLockStripes = 64
+
+// stripeIndex hashes key (FNV-1a) onto one of the store's lock stripes
+func (s *Store) stripeIndex(key string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % uint32(len(s.stripes)))
+}
+
+// stripeFor returns the lock guarding writes to key
+func (s *Store) stripeFor(key string) *sync.RWMutex {
+	return &s.stripes[s.stripeIndex(key)]
+}
+
+// lockKeys write-locks the stripes covering keys and returns the matching
+// unlock function. Stripes are always taken in ascending index order, the
+// canonical order shared by every multi-key writer, so two writers locking
+// overlapping key sets can never deadlock.
+func (s *Store) lockKeys(keys []string) func() {
+	seen := make(map[int]bool, len(keys))
+	indexes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		i := s.stripeIndex(key)
+		if !seen[i] {
+			seen[i] = true
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+
+	for _, i := range indexes {
+		s.stripes[i].Lock()
+	}
+	return func() {
+		for j := len(indexes) - 1; j >= 0; j-- {
+			s.stripes[indexes[j]].Unlock()
+		}
+	}
+}
+
+// rlockKeys read-locks the stripes covering keys, in canonical order, and
+// holds off Raft's applier, excluding writers to keys. It returns the
+// matching unlock function.
+func (s *Store) rlockKeys(keys []string) func() {
+	indexes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		indexes = append(indexes, s.stripeIndex(key))
+	}
+	slices.Sort(indexes)
+	indexes = slices.Compact(indexes)
+
+	for _, i := range indexes {
+		s.stripes[i].RLock()
+	}
+	s.applyMutex.RLock()
+	return func() {
+		s.applyMutex.RUnlock()
+		for j := len(indexes) - 1; j >= 0; j-- {
+			s.stripes[indexes[j]].RUnlock()
+		}
+	}
+}
+
+// lockAll write-locks every stripe in canonical order and holds off Raft's
+// applier, excluding every reader and writer that takes locks. It returns
+// the matching unlock function.
+func (s *Store) lockAll() func() {
+	for i := range s.stripes {
+		s.stripes[i].Lock()
+	}
+	s.applyMutex.Lock()
+	return func() {
+		s.applyMutex.Unlock()
+		for i := len(s.stripes) - 1; i >= 0; i-- {
+			s.stripes[i].Unlock()
+		}
+	}
+}
+
+// rlockAll read-locks every stripe in canonical order, excluding all writers.
+// It also holds off Raft's applier, which writes without taking stripes.
+func (s *Store) rlockAll() {
+	for i := range s.stripes {
+		s.stripes[i].RLock()
+	}
+	s.applyMutex.RLock()
+}
+
+func (s *Store) runlockAll() {
+	s.applyMutex.RUnlock()
+	for i := len(s.stripes) - 1; i >= 0; i-- {
+		s.stripes[i].RUnlock()
+	}
+}
@@ -0,0 +1,19 @@
+package store
+
+import "log/slog"
+
+// The store logs what happens to it beyond single reads and writes:
+// opening and replaying its write log, evictions, replication, failover,
+// backups and restores, and the failures of work it does in the
+// background. It logs to the *slog.Logger given by WithLogger, and nowhere
+// without one. Errors are logged at ErrorLevel, trouble the store recovers
+// from, such as a broken replication stream it reconnects, at WarnLevel,
+// changes of state at InfoLevel and single evictions at DebugLevel.
+
+// discardLogger is the logger of a store made without WithLogger
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// Logger returns the logger the store logs to
+func (s *Store) Logger() *slog.Logger {
+	return s.logger
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// The store exports its Stats and its commands' latency histograms in the
+// Prometheus text exposition format, for Prometheus or any agent that
+// scrapes it. A latency histogram is exported as the
+// kv_command_duration_seconds histogram with a command label, over a fixed
+// set of bucket bounds from 10µs to 10s that the HDR buckets are summed
+// into, so histogram_quantile gives the percentiles over any window.
+
+// metricsBounds are the upper bounds, in seconds, of the exported latency
+// buckets
+var metricsBounds = []float64{0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// WriteMetrics writes the store's metrics to w in the Prometheus text
+// format
+func (s *Store) WriteMetrics(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	st := s.Stats()
+	gauge := func(name, help string, value int64) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, value)
+	}
+	counter := func(name, help string, value uint64) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+	}
+	gauge("kv_keys", "Entries in the store.", int64(st.Keys))
+	gauge("kv_attributes", "Attributes over all entries.", int64(st.Attributes))
+	gauge("kv_memory_bytes", "Estimated memory of the entries.", st.MemoryBytes)
+	gauge("kv_max_memory_bytes", "Memory limit of the entries, 0 for none.", st.MaxMemoryBytes)
+	counter("kv_puts_total", "Puts, successful or not.", st.Puts)
+	counter("kv_type_errors_total", "Puts rejected for a value of another type than its attribute's.", st.TypeErrors)
+	counter("kv_gets_total", "Keys read.", st.Gets)
+	counter("kv_hits_total", "Keys read that had an entry.", st.Hits)
+	counter("kv_misses_total", "Keys read that had no entry.", st.Misses)
+	counter("kv_deletes_total", "Keys deleted.", st.Deletes)
+	counter("kv_searches_total", "Searches made.", st.Searches)
+
+	fmt.Fprintf(bw, "# HELP kv_command_duration_seconds Latency of the commands run.\n# TYPE kv_command_duration_seconds histogram\n")
+	var commands []string
+	s.latency.Range(func(k, _ interface{}) bool {
+		commands = append(commands, k.(string))
+		return true
+	})
+	sort.Strings(commands)
+	for _, command := range commands {
+		v, ok := s.latency.Load(command)
+		if !ok {
+			continue
+		}
+		h := v.(*latencyHistogram)
+		label := strconv.Quote(command)
+		counts, calls := h.snapshot()
+		var below uint64
+		i := 0
+		for _, bound := range metricsBounds {
+			for ; i < len(counts) && float64(latencyBucketEnd(i)) <= bound*1e9; i++ {
+				below += counts[i]
+			}
+			fmt.Fprintf(bw, "kv_command_duration_seconds_bucket{command=%s,le=%q} %d\n", label, formatBound(bound), below)
+		}
+		fmt.Fprintf(bw, "kv_command_duration_seconds_bucket{command=%s,le=\"+Inf\"} %d\n", label, calls)
+		fmt.Fprintf(bw, "kv_command_duration_seconds_sum{command=%s} %g\n", label, float64(h.sum.Load())/1e9)
+		fmt.Fprintf(bw, "kv_command_duration_seconds_count{command=%s} %d\n", label, calls)
+	}
+	return bw.Flush()
+}
+
+// formatBound formats a bucket bound in seconds as a decimal
+func formatBound(bound float64) string {
+	return strconv.FormatFloat(bound, 'f', -1, 64)
+}
+
+// MetricsHandler returns an HTTP handler serving the store's metrics, as
+// WriteMetrics writes them, to Prometheus scrapes
+func (s *Store) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := s.WriteMetrics(w); err != nil {
+			s.logger.Warn("writing metrics failed", "remote", r.RemoteAddr, "err", err)
+		}
+	})
+}
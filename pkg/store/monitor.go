@@ -0,0 +1,163 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Monitors see every command run against the store as it completes, for
+// debugging what traffic is hitting it: the server publishes each command
+// it runs, with the client that sent it, how long it took and the error it
+// replied, if any. In server mode MONITOR turns a connection into such a
+// monitor, as in Redis: it replies OK, then writes a line per command run
+// on the node, by any client, until the client disconnects. Building events
+// costs nothing while no one monitors. A monitor that falls more than
+// monitorBuffer events behind is dropped: its channel is closed, and a
+// network monitor is disconnected.
+
+// monitorBuffer is how many events a monitor may fall behind before it is
+// dropped
+const monitorBuffer = 1024
+
+// MonitorEvent is a command run against the store, as monitors see it
+type MonitorEvent struct {
+	Time     time.Time     // when it started
+	Client   string        // who sent it, a server client's address
+	Command  []string      // the command and its arguments
+	Duration time.Duration // how long it took, reply included
+	Err      string        // the error it replied, "" if it succeeded
+}
+
+// String formats ev as MONITOR writes it: the start time in Unix seconds,
+// the client, the quoted words of the command, its duration and any error
+func (ev MonitorEvent) String() string {
+	var b strings.Builder
+	us := ev.Time.UnixMicro()
+	fmt.Fprintf(&b, "%d.%06d [%s]", us/1e6, us%1e6, ev.Client)
+	for _, word := range ev.Command {
+		b.WriteString(" ")
+		b.WriteString(strconv.Quote(word))
+	}
+	fmt.Fprintf(&b, " (%s)", roundLatency(ev.Duration))
+	if ev.Err != "" {
+		b.WriteString(" -" + strings.ReplaceAll(ev.Err, "\n", " "))
+	}
+	return b.String()
+}
+
+// monitors holds the channels handed out by Monitor
+type monitors struct {
+	mu    sync.Mutex
+	chans map[<-chan MonitorEvent]chan MonitorEvent
+	count atomic.Int32 // len(chans), read without mu
+}
+
+// Monitor returns a channel that receives every command published to the
+// store from now on, by the server or PublishCommand, until StopMonitor.
+// A monitor that falls more than monitorBuffer events behind has its
+// channel closed.
+func (s *Store) Monitor() <-chan MonitorEvent {
+	s.mons.mu.Lock()
+	defer s.mons.mu.Unlock()
+
+	if s.mons.chans == nil {
+		s.mons.chans = make(map[<-chan MonitorEvent]chan MonitorEvent)
+	}
+	ch := make(chan MonitorEvent, monitorBuffer)
+	s.mons.chans[ch] = ch
+	s.mons.count.Store(int32(len(s.mons.chans)))
+	return ch
+}
+
+// StopMonitor stops the events sent to ch by Monitor and closes it
+func (s *Store) StopMonitor(ch <-chan MonitorEvent) {
+	s.mons.mu.Lock()
+	defer s.mons.mu.Unlock()
+
+	if c, exists := s.mons.chans[ch]; exists {
+		s.dropMonitorLocked(c)
+	}
+}
+
+// dropMonitorLocked removes and closes ch. Caller must hold mons.mu.
+func (s *Store) dropMonitorLocked(ch chan MonitorEvent) {
+	delete(s.mons.chans, ch)
+	s.mons.count.Store(int32(len(s.mons.chans)))
+	close(ch)
+}
+
+// monitoring reports whether any monitor is listening, so publishers can
+// skip building events
+func (s *Store) monitoring() bool {
+	return s.mons.count.Load() > 0
+}
+
+// PublishCommand sends ev to the store's monitors, for an embedder's own
+// front end; the server publishes the commands it runs itself
+func (s *Store) PublishCommand(ev MonitorEvent) {
+	if !s.monitoring() {
+		return
+	}
+	s.mons.mu.Lock()
+	defer s.mons.mu.Unlock()
+
+	for _, ch := range s.mons.chans {
+		select {
+		case ch <- ev:
+		default:
+			s.dropMonitorLocked(ch)
+		}
+	}
+}
+
+// pushMonitor writes a line for each command mon receives, until the
+// connection closes, disconnecting a client that falls behind
+func (c *clientConn) pushMonitor(mon <-chan MonitorEvent) {
+	for ev := range mon {
+		c.wmu.Lock()
+		c.rw.WriteSimple(ev.String())
+		err := c.rw.Flush()
+		c.wmu.Unlock()
+		if err != nil {
+			break
+		}
+	}
+	c.conn.Close()
+}
+
+// MonitorServer sends MONITOR to the server at addr and calls fn with each
+// line it writes back, until ctx is done or the connection breaks. It
+// returns nil once ctx is done.
+func MonitorServer(ctx context.Context, addr string, fn func(line string)) error {
+	conn, err := net.DialTimeout("tcp", addr, clusterDialTimeout)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer conn.Close()
+
+	pc := &peerConn{conn: conn, r: newRESPReader(conn), w: newRESPWriter(conn)}
+	reply, err := pc.do([]string{"MONITOR"})
+	if err == nil {
+		if e, failed := reply.(respError); failed {
+			err = fmt.Errorf("monitor: %s", e)
+		}
+	}
+	for err == nil {
+		if reply, err = pc.r.ReadReply(); err == nil {
+			line, _ := reply.(respSimple)
+			fn(string(line))
+		}
+	}
+	if ctx.Err() != nil {
+		return nil // the connection was closed to stop
+	}
+	return err
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"reflect"
+
+	"github.com/hashicorp/go-msgpack/v2/codec"
+)
+
+// The msgpack codec encodes with github.com/hashicorp/go-msgpack, as Raft
+// and the connectors' msgpack format do: structs are maps keyed by their
+// fields' json names, honoring omitempty and "-", with the fields of
+// embedded structs promoted. Strings are written as MessagePack str and
+// decode back into an interface{} as strings, maps as
+// map[string]interface{}, and floats as float64, so attribute values come
+// back with the types they were written with.
+
+type msgpackCodec struct{}
+
+// msgpackHandle configures the encoders and decoders of the msgpack codec
+// and of connectors' msgpack format
+var msgpackHandle = newMsgpackHandle()
+
+func newMsgpackHandle() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{WriteExt: true}
+	h.TypeInfos = codec.NewTypeInfos([]string{"json"})
+	h.RawToString = true
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return h
+}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Encode(v interface{}) ([]byte, error) {
+	var out []byte
+	err := codec.NewEncoderBytes(&out, msgpackHandle).Encode(v)
+	return out, err
+}
+
+func (msgpackCodec) Decode(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, msgpackHandle).Decode(v)
+}
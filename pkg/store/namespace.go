@@ -0,0 +1,254 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Namespaces let several datasets share a store: a key of the form
+// namespace/name, such as tenantA/user1, is in namespace tenantA, and the
+// attribute types of its entries are registered for that namespace alone,
+// so tenantA's age can be a float while tenantB's is a string. A key
+// without a separator is in the default namespace, whose types are
+// registered by attribute name as before. The namespace ends at the first
+// separator, so tenantA/a/b is name a/b in tenantA; namespaces don't nest.
+// A namespace's types are registered under namespace/attribute, the name
+// AttributeNames, backups and type mismatch errors give them.
+//
+// Store.Namespace returns a view of a namespace, a KVStore whose keys are
+// the names within it, for code that works on one tenant's data.
+
+// NamespaceSeparator separates a key's namespace from its name within it
+const NamespaceSeparator = "/"
+
+// SplitNamespace returns the namespace of key and its name within it: ""
+// and key itself for a key in the default namespace
+func SplitNamespace(key string) (namespace, name string) {
+	if i := strings.Index(key, NamespaceSeparator); i > 0 {
+		return key[:i], key[i+1:]
+	}
+	return "", key
+}
+
+// typeKey returns the name the type registry knows attrKey by for the
+// entry at key: attrKey itself in the default namespace, and
+// namespace/attrKey in another
+func typeKey(key, attrKey string) string {
+	if namespace, _ := SplitNamespace(key); namespace != "" {
+		return namespace + NamespaceSeparator + attrKey
+	}
+	return attrKey
+}
+
+// Namespaces returns the namespaces the store's keys are in, sorted, but
+// for the default namespace
+func (s *Store) Namespaces() []string {
+	seen := make(map[string]struct{})
+	s.data.Range(func(k, _ interface{}) bool {
+		if namespace, _ := SplitNamespace(k.(string)); namespace != "" {
+			seen[namespace] = struct{}{}
+		}
+		return true
+	})
+	namespaces := make([]string, 0, len(seen))
+	for namespace := range seen {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// Namespace is a view of the entries of one namespace of a store. Its keys
+// are names within the namespace: Put("user1", ...) writes tenantA/user1,
+// and Keys lists user1. The view of namespace "" is the whole store, its
+// keys as they are.
+type Namespace struct {
+	s      *Store
+	name   string
+	prefix string // name and the separator, "" for the whole store
+}
+
+var _ KVStore = (*Namespace)(nil)
+
+// Namespace returns the view of namespace name, or of the whole store if
+// name is ""
+func (s *Store) Namespace(name string) (*Namespace, error) {
+	if strings.Contains(name, NamespaceSeparator) {
+		return nil, invalid("namespace %q holds the separator %q", name, NamespaceSeparator)
+	}
+	n := &Namespace{s: s, name: name}
+	if name != "" {
+		n.prefix = name + NamespaceSeparator
+	}
+	return n, nil
+}
+
+// Name returns the namespace's name, "" for the whole store
+func (n *Namespace) Name() string { return n.name }
+
+// Store returns the store the namespace is a view of
+func (n *Namespace) Store() *Store { return n.s }
+
+// Key returns the store key of name in the namespace
+func (n *Namespace) Key(name string) string { return n.prefix + name }
+
+// Local returns the name of the store key key within the namespace,
+// reporting false if key is in another
+func (n *Namespace) Local(key string) (string, bool) {
+	if n.prefix == "" {
+		return key, true
+	}
+	return strings.CutPrefix(key, n.prefix)
+}
+
+// local returns the names of those of keys in the namespace, in order
+func (n *Namespace) local(keys []string) []string {
+	if n.prefix == "" {
+		return keys
+	}
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if name, ok := n.Local(key); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// keys returns the store keys of names
+func (n *Namespace) keys(names []string) []string {
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = n.Key(name)
+	}
+	return keys
+}
+
+// Put is Store.Put at name in the namespace
+func (n *Namespace) Put(name string, attributes [][]string) error {
+	return n.s.Put(n.Key(name), attributes)
+}
+
+// PutCtx is Store.PutCtx at name in the namespace
+func (n *Namespace) PutCtx(ctx context.Context, name string, attributes [][]string) error {
+	return n.s.PutCtx(ctx, n.Key(name), attributes)
+}
+
+// PutValues is Store.PutValues at name in the namespace
+func (n *Namespace) PutValues(name string, attrs map[string]interface{}) error {
+	return n.s.PutValues(n.Key(name), attrs)
+}
+
+// Get is Store.Get of name in the namespace
+func (n *Namespace) Get(name string) map[string]interface{} {
+	return n.s.Get(n.Key(name))
+}
+
+// GetCtx is Store.GetCtx of name in the namespace
+func (n *Namespace) GetCtx(ctx context.Context, name string) (map[string]interface{}, error) {
+	return n.s.GetCtx(ctx, n.Key(name))
+}
+
+// MGet is Store.MGet of names in the namespace, by name
+func (n *Namespace) MGet(names []string) (map[string]map[string]interface{}, []string) {
+	found, missing := n.s.MGet(n.keys(names))
+	if n.prefix == "" {
+		return found, missing
+	}
+	entries := make(map[string]map[string]interface{}, len(found))
+	for key, attrs := range found {
+		name, _ := n.Local(key)
+		entries[name] = attrs
+	}
+	return entries, n.local(missing)
+}
+
+// Delete is Store.Delete of name in the namespace
+func (n *Namespace) Delete(name string) error {
+	return n.s.Delete(n.Key(name))
+}
+
+// DeleteKeys is Store.DeleteKeys of names in the namespace
+func (n *Namespace) DeleteKeys(names []string) (int, error) {
+	return n.s.DeleteKeys(n.keys(names))
+}
+
+// Search is Store.Search over the namespace's entries
+func (n *Namespace) Search(attrKey, attrValue string) []string {
+	return n.local(n.s.Search(attrKey, attrValue))
+}
+
+// SearchCtx is Store.SearchCtx over the namespace's entries
+func (n *Namespace) SearchCtx(ctx context.Context, attrKey, attrValue string) ([]string, error) {
+	keys, err := n.s.SearchCtx(ctx, attrKey, attrValue)
+	return n.local(keys), err
+}
+
+// SearchValue is Store.SearchValue over the namespace's entries
+func (n *Namespace) SearchValue(attrKey string, value interface{}) []string {
+	return n.local(n.s.SearchValue(attrKey, value))
+}
+
+// SearchValueCount is Store.SearchValueCount over the namespace's entries.
+// The scan visits every entry of the store.
+func (n *Namespace) SearchValueCount(ctx context.Context, attrKey string, value interface{}) ([]string, int, error) {
+	keys, visited, err := n.s.SearchValueCount(ctx, attrKey, value)
+	return n.local(keys), visited, err
+}
+
+// Keys returns the names of the namespace's entries, sorted
+func (n *Namespace) Keys() []string {
+	return n.local(n.s.Keys())
+}
+
+// ForEach is Store.ForEach over the namespace's entries, by name
+func (n *Namespace) ForEach(fn func(name string, attrs map[string]interface{}) bool) {
+	n.s.ForEach(func(key string, attrs map[string]interface{}) bool {
+		if name, ok := n.Local(key); ok {
+			return fn(name, attrs)
+		}
+		return true
+	})
+}
+
+// KeysMatching returns the names in the namespace matching a glob pattern,
+// sorted
+func (n *Namespace) KeysMatching(pattern string) ([]string, error) {
+	names, _, err := n.KeysMatchingCount(pattern)
+	return names, err
+}
+
+// KeysMatchingCount is KeysMatching, also returning how many names it
+// visited
+func (n *Namespace) KeysMatchingCount(pattern string) ([]string, int, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, 0, fmt.Errorf("bad key pattern %q: %w", pattern, err)
+	}
+	all := n.Keys()
+	names := make([]string, 0)
+	for _, name := range all {
+		if ok, _ := path.Match(pattern, name); ok {
+			names = append(names, name)
+		}
+	}
+	return names, len(all), nil
+}
+
+// AttributeNames returns the names of the attributes with a type
+// registered in the namespace, sorted. The whole store's are every
+// registered name, those of other namespaces prefixed by theirs.
+func (n *Namespace) AttributeNames() []string {
+	all := n.s.AttributeNames()
+	if n.prefix == "" {
+		return all
+	}
+	return n.local(all)
+}
+
+// CheckValues is Store.CheckValues for a put at name in the namespace
+func (n *Namespace) CheckValues(name string, attrs map[string]interface{}, pending map[string]AttributeMetadata) error {
+	return n.s.CheckValues(n.Key(name), attrs, pending)
+}
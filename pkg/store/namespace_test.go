@@ -0,0 +1,73 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestNamespaces writes the same names into two namespaces through their
+// views, with the same attribute typed differently in each, and checks
+// each view sees only its own entries by their names
+func TestNamespaces(t *testing.T) {
+	s := NewStore()
+	a, err := s.Namespace("tenantA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.Namespace("tenantB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Put("user1", [][]string{{"age", "30"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put("user1", [][]string{{"age", "thirty"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("user1", [][]string{{"age", "true"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		got, want interface{}
+	}{
+		{a.Get("user1"), map[string]interface{}{"age": 30.0}},
+		{b.Get("user1"), map[string]interface{}{"age": "thirty"}},
+		{s.Get("tenantA/user1"), map[string]interface{}{"age": 30.0}},
+		{a.Keys(), []string{"user1"}},
+		{a.Search("age", "30"), []string{"user1"}},
+		{b.Search("age", "30"), []string{}},
+		{s.Namespaces(), []string{"tenantA", "tenantB"}},
+	} {
+		if !reflect.DeepEqual(tc.got, tc.want) {
+			t.Errorf("got %#v, want %#v", tc.got, tc.want)
+		}
+	}
+	if err := a.Put("user2", [][]string{{"age", "old"}}); err == nil {
+		t.Error("tenantA's float age took a string")
+	}
+
+	if err := a.Delete("user1"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Get("tenantA/user1") != nil || b.Get("user1") == nil {
+		t.Error("deleting tenantA's user1 didn't remove exactly that entry")
+	}
+}
+
+// TestNamespaceRejected checks a namespace name can't hold the separator,
+// and that the name after the first separator is a namespace's name
+func TestNamespaceRejected(t *testing.T) {
+	if _, err := NewStore().Namespace("a/b"); err == nil {
+		t.Error("Namespace accepted a/b")
+	}
+	for key, want := range map[string][2]string{
+		"user1":    {"", "user1"},
+		"a/b/c":    {"a", "b/c"},
+		"/leading": {"", "/leading"},
+	} {
+		if namespace, name := SplitNamespace(key); namespace != want[0] || name != want[1] {
+			t.Errorf("SplitNamespace(%q) = %q, %q, want %q, %q", key, namespace, name, want[0], want[1])
+		}
+	}
+}
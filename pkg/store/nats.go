@@ -0,0 +1,132 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// natsPublisher publishes to a NATS subject over the NATS text protocol.
+// Each PUB is followed by a PING, and the message counts as accepted once
+// the server's PONG arrives without an error before it.
+type natsPublisher struct {
+	addr     string
+	subject  string
+	user     string // a user name, or a token if password is empty
+	password string
+
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (n *natsPublisher) publish(ctx context.Context, _ string, value []byte) error {
+	err := n.pub(ctx, value)
+	if err != nil {
+		n.close()
+	}
+	return err
+}
+
+func (n *natsPublisher) pub(ctx context.Context, value []byte) error {
+	if n.conn == nil {
+		if err := n.connect(ctx); err != nil {
+			return err
+		}
+	}
+	stop := deadlineOnCancel(ctx, n.conn)
+	defer stop()
+
+	msg := fmt.Sprintf("PUB %s %d\r\n%s\r\nPING\r\n", n.subject, len(value), value)
+	if _, err := n.conn.Write([]byte(msg)); err != nil {
+		return err
+	}
+	return n.awaitPong()
+}
+
+// connect dials the server and introduces the publisher, confirming the
+// server accepted it with a PING
+func (n *natsPublisher) connect(ctx context.Context) error {
+	conn, err := dialContext(ctx, n.addr)
+	if err != nil {
+		return err
+	}
+	n.conn, n.r = conn, bufio.NewReader(conn)
+	stop := deadlineOnCancel(ctx, conn)
+	defer stop()
+
+	line, err := n.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("nats: unexpected greeting %q", line)
+	}
+
+	options := map[string]interface{}{"verbose": false, "pedantic": false, "name": connectorClientID, "lang": "go"}
+	switch {
+	case n.password != "":
+		options["user"], options["pass"] = n.user, n.password
+	case n.user != "":
+		options["auth_token"] = n.user
+	}
+	connect, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", connect); err != nil {
+		return err
+	}
+	return n.awaitPong()
+}
+
+// awaitPong reads until the server's PONG, answering its PINGs and failing
+// on an error
+func (n *natsPublisher) awaitPong() error {
+	for {
+		line, err := n.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := n.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("nats: " + strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'"))
+		}
+		// +OK and INFO updates need no answer
+	}
+}
+
+func (n *natsPublisher) readLine() (string, error) {
+	line, err := n.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (n *natsPublisher) close() {
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn, n.r = nil, nil
+	}
+}
+
+// validNATSSubject reports whether subject can be published to: dot-separated
+// tokens, none empty, without whitespace or wildcards
+func validNATSSubject(subject string) bool {
+	for _, token := range strings.Split(subject, ".") {
+		if token == "" || token == "*" || token == ">" || strings.ContainsAny(token, " \t\r\n") {
+			return false
+		}
+	}
+	return true
+}
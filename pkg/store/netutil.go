@@ -0,0 +1,92 @@
+package store
+
+import (
+	"net"
+	"sync"
+)
+
+// tcpService is the accept loop and connection bookkeeping shared by the
+// network services, so closing one also disconnects all of its clients
+type tcpService struct {
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	done     chan struct{} // closed by close
+}
+
+func newTCPService() tcpService {
+	return tcpService{
+		conns: make(map[net.Conn]struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// serve accepts connections on l and runs handle for each in its own
+// goroutine, closing the connection when handle returns
+func (t *tcpService) serve(l net.Listener, handle func(net.Conn)) error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	t.listener = l
+	t.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			t.mu.Lock()
+			closed := t.closed
+			t.mu.Unlock()
+			if closed {
+				return net.ErrClosed
+			}
+			return err
+		}
+
+		t.mu.Lock()
+		t.conns[conn] = struct{}{}
+		t.mu.Unlock()
+
+		go func() {
+			defer func() {
+				conn.Close()
+				t.mu.Lock()
+				delete(t.conns, conn)
+				t.mu.Unlock()
+			}()
+			handle(conn)
+		}()
+	}
+}
+
+// clients returns the number of connections being served
+func (t *tcpService) clients() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.conns)
+}
+
+// close stops accepting connections and disconnects every client
+func (t *tcpService) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	close(t.done)
+
+	var err error
+	if t.listener != nil {
+		err = t.listener.Close()
+	}
+	for conn := range t.conns {
+		conn.Close()
+	}
+	return err
+}
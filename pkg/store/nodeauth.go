@@ -0,0 +1,83 @@
+package store
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Cluster nodes send each other their internal commands, gossip, migrate
+// and handoff, and the commands they forward, prefixed "local", over the
+// client port, where any client could send them too: a forged migrate or
+// local put writes an entry past the owner's checks, and a forged handoff
+// moves a key away. Promote and raft add and remove, which change a node's
+// role, are held to the same proof; operators send them to the admin
+// listener instead. With a cluster secret a node's connection to
+// another proves it knows the secret before it sends them: it sends
+// "local nodeauth", the listening node answers with a nonce, and the node
+// replies "local nodeauth <proof>", its HMAC of the nonce. The secret
+// never crosses the wire, and each proof is only good for its connection.
+// A cluster without a secret takes the internal commands from anyone.
+
+// errNodeAuth is returned by a node whose peer failed to take its proof
+var errNodeAuth = errors.New("peer refused this node's proof of the cluster secret")
+
+// nodeMAC is the proof that a node knows secret, bound to nonce
+func nodeMAC(secret, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "node\x00%s", nonce)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// authenticate proves to the peer over pc that this node knows secret
+func (pc *peerConn) authenticate(secret string) error {
+	reply, err := pc.do([]string{"local", "nodeauth"})
+	if err != nil {
+		return err
+	}
+	nonce, ok := reply.(string)
+	if !ok {
+		return fmt.Errorf("%w: %v", errNodeAuth, reply)
+	}
+	if reply, err = pc.do([]string{"local", "nodeauth", nodeMAC(secret, nonce)}); err != nil {
+		return err
+	}
+	if reply != respSimple("OK") {
+		return fmt.Errorf("%w: %v", errNodeAuth, reply)
+	}
+	return nil
+}
+
+// nodeAuth runs the listening end of nodeauth on c: without a proof it
+// issues the connection a nonce, and with one it checks it against the
+// nonce issued
+func (c *clientConn) nodeAuth(args []string) {
+	secret := c.srv.Cluster.secret
+	switch {
+	case secret == "":
+		c.rw.WriteError("ERR this cluster has no secret")
+	case len(args) == 1:
+		nonce, err := replNonce()
+		if err != nil {
+			c.writeErr(err)
+			return
+		}
+		c.nodeNonce = nonce
+		c.rw.WriteBulk(nonce)
+	case len(args) == 2 && c.nodeNonce != "" && hmac.Equal([]byte(args[1]), []byte(nodeMAC(secret, c.nodeNonce))):
+		c.node, c.nodeNonce = true, ""
+		c.rw.WriteSimple("OK")
+	default:
+		c.nodeNonce = ""
+		c.srv.store.logger.Error("cluster peer failed to prove it knows the cluster secret", "addr", c.conn.RemoteAddr().String())
+		c.rw.WriteError("ERR wrong proof of the cluster secret")
+	}
+}
+
+// fromNode reports whether c may send the internal commands of cluster
+// nodes: it proved it knows the cluster secret, or there is none
+func (c *clientConn) fromNode() bool {
+	return c.srv.Cluster != nil && (c.srv.Cluster.secret == "" || c.node)
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"net"
+	"testing"
+)
+
+// TestNodeAuth sends a cluster node's internal commands, forwarded ones and
+// those changing its role over the client port, which only takes them once
+// the connection proved it knows the cluster secret
+func TestNodeAuth(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster, err := NewCluster("a", []string{"a=" + ln.Addr().String()}, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	s := NewStore()
+	srv := NewServer(s)
+	srv.Cluster = cluster
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	dial := func() *peerConn {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return &peerConn{conn: conn, r: newRESPReader(conn), w: newRESPWriter(conn)}
+	}
+	do := func(pc *peerConn, args ...string) interface{} {
+		t.Helper()
+		reply, err := pc.do(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+	migrate := []string{"local", "migrate", "user1", "3", "name", "ann"}
+
+	client := dial()
+	if _, refused := do(client, migrate...).(respError); !refused {
+		t.Error("migrate accepted without a proof")
+	}
+	if _, refused := do(client, "local", "handoff", "user1", "b").(respError); !refused {
+		t.Error("handoff accepted without a proof")
+	}
+	do(client, "local", "nodeauth")
+	if _, refused := do(client, "local", "nodeauth", nodeMAC("guess", "any")).(respError); !refused {
+		t.Error("nodeauth took a wrong proof")
+	}
+	if _, refused := do(client, migrate...).(respError); !refused {
+		t.Error("migrate accepted after a wrong proof")
+	}
+	for _, args := range [][]string{{"local", "put", "user1", "name", "ann"}, {"promote"}, {"raft", "remove", "b"}} {
+		if _, refused := do(client, args...).(respError); !refused {
+			t.Errorf("%v accepted without a proof", args)
+		}
+	}
+	if s.Get("user1") != nil {
+		t.Fatal("a refused command wrote its entry")
+	}
+
+	node := dial()
+	if err := node.authenticate("s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	if reply := do(node, migrate...); reply != respSimple("OK") {
+		t.Fatalf("migrate after the proof = %v", reply)
+	}
+	if e, ok := s.GetEntry("user1"); !ok || e.Version != 3 {
+		t.Errorf("migrated entry = %+v, %v", e, ok)
+	}
+	if reply := do(node, "local", "put", "user2", "name", "bo"); reply != respSimple("OK") {
+		t.Errorf("local put after the proof = %v", reply)
+	}
+
+	if err := dial().authenticate("wrong"); err == nil {
+		t.Error("a node with the wrong secret authenticated")
+	}
+}
@@ -0,0 +1,160 @@
+package store
+
+import (
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures a store made by NewStore or OpenStore, so new settings
+// can be added without changing their signatures:
+//
+//	s, err := store.OpenStore("data.log", store.WithDurability(store.Fsynced), store.WithMaxMemory(512<<20, store.EvictLRU))
+type Option func(*storeConfig)
+
+// storeConfig is what the options of a new store set
+type storeConfig struct {
+	durability    Durability
+	durabilitySet bool
+	stripes       int
+	maxMemory     int64
+	eviction      EvictionPolicy
+	resolver      ConflictResolver
+	triggers      []Trigger
+	quotas        map[string]NamespaceQuota
+	hooks         []optionHook
+	codec         Codec
+	logger        *slog.Logger
+	tracer        trace.Tracer
+}
+
+// optionHook is a hook given by WithHook
+type optionHook struct {
+	kind HookKind
+	fn   Hook
+}
+
+// WithDurability sets the durability of writes that don't give one, as
+// SetDurability does. It only matters for a store with a write log, where
+// it defaults to Logged.
+func WithDurability(d Durability) Option {
+	return func(c *storeConfig) {
+		c.durability, c.durabilitySet = d, true
+	}
+}
+
+// WithLockStripes sets how many write locks keys are hashed across, 64 by
+// default. More stripes let more writers to unrelated keys run at once, at
+// the cost of a longer wait for Keys, Search and the other operations that
+// lock them all. n is at least 1.
+func WithLockStripes(n int) Option {
+	return func(c *storeConfig) {
+		c.stripes = max(n, 1)
+	}
+}
+
+// WithMaxMemory caps the store's approximate memory, the bytes its keys
+// and attributes take, at bytes. Once a write takes the store over it,
+// policy picks what happens: NoEviction fails later writes that would add
+// to it with ErrOutOfMemory, and EvictLRU and EvictRandom delete entries
+// until the store is back under it. 0, the default, sets no limit.
+func WithMaxMemory(bytes int64, policy EvictionPolicy) Option {
+	return func(c *storeConfig) {
+		c.maxMemory, c.eviction = max(bytes, 0), policy
+	}
+}
+
+// WithNamespaceQuota limits namespace as SetNamespaceQuota does. NewStore
+// panics if namespace can't have a quota; OpenStore returns the error.
+func WithNamespaceQuota(namespace string, q NamespaceQuota) Option {
+	return func(c *storeConfig) {
+		if c.quotas == nil {
+			c.quotas = make(map[string]NamespaceQuota)
+		}
+		c.quotas[namespace] = q
+	}
+}
+
+// WithConflictResolver installs r for colliding versioned writes, as
+// SetConflictResolver does
+func WithConflictResolver(r ConflictResolver) Option {
+	return func(c *storeConfig) {
+		c.resolver = r
+	}
+}
+
+// WithTrigger registers t, as AddTrigger does, before the store replays
+// its log or takes writes. NewStore panics if t is incomplete; OpenStore
+// returns the error.
+func WithTrigger(t Trigger) Option {
+	return func(c *storeConfig) {
+		c.triggers = append(c.triggers, t)
+	}
+}
+
+// WithHook registers fn to run on writes as kind says, as RegisterHook
+// does, before the store replays its log or takes writes, so AfterPut
+// hooks also see the entries OpenStore replays
+func WithHook(kind HookKind, fn Hook) Option {
+	return func(c *storeConfig) {
+		c.hooks = append(c.hooks, optionHook{kind, fn})
+	}
+}
+
+// WithCodec sets the codec of a new write log, and of the stream a
+// follower asks its leader for, JSON by default. A log already written in
+// a codec keeps it. A nil codec is JSON.
+func WithCodec(codec Codec) Option {
+	return func(c *storeConfig) {
+		if codec == nil {
+			codec = JSONCodec
+		}
+		c.codec = codec
+	}
+}
+
+// WithLogger makes the store log to l (see logging.go), including while
+// OpenStore replays its log
+func WithLogger(l *slog.Logger) Option {
+	return func(c *storeConfig) {
+		if l == nil {
+			l = discardLogger
+		}
+		c.logger = l
+	}
+}
+
+// WithTracerProvider makes the store trace its operations as spans of tp
+// (see tracing.go)
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *storeConfig) {
+		c.tracer = noopTracer
+		if tp != nil {
+			c.tracer = tp.Tracer(tracerName)
+		}
+	}
+}
+
+// validate checks the triggers of c
+func (c storeConfig) validate() error {
+	for _, t := range c.triggers {
+		if err := t.validate(); err != nil {
+			return err
+		}
+	}
+	for namespace, q := range c.quotas {
+		if err := checkQuota(namespace, q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newConfig returns the configuration opts set
+func newConfig(opts []Option) storeConfig {
+	c := storeConfig{stripes: defaultLockStripes, codec: JSONCodec, logger: discardLogger, tracer: noopTracer}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
@@ -0,0 +1,344 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Parquet exports write the entries as a columnar Parquet file for Spark,
+// DuckDB, pandas and the like. The schema follows the attribute type
+// registry: a required key column of strings, then an optional column per
+// attribute the exported entries carry, BYTE_ARRAY annotated as STRING for
+// strings, DOUBLE for floats and BOOLEAN for bools, null where an entry
+// lacks the attribute. As in SQLite exports, namespaces share the columns
+// of their attribute names, and one they type differently is a string
+// column. Pages are
+// PLAIN encoded and uncompressed, and written one at a time, so the export
+// streams like the JSON and YAML ones. The format, with its Thrift compact
+// protocol footer, is written directly
+// (https://parquet.apache.org/docs/file-format/).
+
+const (
+	// parquetRowGroupRows caps the rows of a row group
+	parquetRowGroupRows = 1 << 20
+
+	// parquetPageSize is the size at which a column's page is cut
+	parquetPageSize = 1 << 20
+
+	parquetMagic = "PAR1"
+)
+
+// Parquet enum values, as defined in parquet.thrift
+const (
+	parquetBoolean   = 0 // Type
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetRequired = 0 // FieldRepetitionType
+	parquetOptional = 1
+
+	parquetUTF8 = 0 // ConvertedType
+
+	parquetPlain = 0 // Encoding
+	parquetRLE   = 3
+
+	parquetUncompressed = 0 // CompressionCodec
+	parquetDataPage     = 0 // PageType
+)
+
+// parquetColumn is a column of a Parquet export
+type parquetColumn struct {
+	name     string
+	attrKey  string // "" for the key column
+	attrType AttributeType
+	physical int32
+}
+
+// parquetChunk records a written column chunk for the footer
+type parquetChunk struct {
+	offset int64 // of its first page
+	size   int64 // of its pages, with their headers
+}
+
+// writeParquetExport writes the entries under keys as a Parquet file
+func writeParquetExport(w *bufio.Writer, st *storeState, keys []string) error {
+	attrKeys, types := st.tableColumns(keys)
+	names := tableColumnNames(attrKeys)
+	columns := []parquetColumn{{name: names[0], physical: parquetByteArray}}
+	for i, attrKey := range attrKeys {
+		physical := int32(parquetByteArray)
+		switch types[attrKey] {
+		case FloatType:
+			physical = parquetDouble
+		case BoolType:
+			physical = parquetBoolean
+		}
+		columns = append(columns, parquetColumn{name: names[i+1], attrKey: attrKey, attrType: types[attrKey], physical: physical})
+	}
+
+	cw := &countingWriter{w: w}
+	if _, err := cw.Write([]byte(parquetMagic)); err != nil {
+		return err
+	}
+	var groups [][]parquetChunk
+	for start := 0; start < len(keys); start += parquetRowGroupRows {
+		rows := keys[start:min(start+parquetRowGroupRows, len(keys))]
+		chunks := make([]parquetChunk, len(columns))
+		for i, col := range columns {
+			chunks[i].offset = cw.n
+			if err := writeParquetChunk(cw, st, rows, col); err != nil {
+				return err
+			}
+			chunks[i].size = cw.n - chunks[i].offset
+		}
+		groups = append(groups, chunks)
+	}
+
+	footer := parquetFooter(columns, groups, len(keys))
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	footer = append(footer, parquetMagic...)
+	_, err := cw.Write(footer)
+	return err
+}
+
+// writeParquetChunk writes the column chunk of col for the entries under
+// rows, as data pages of about parquetPageSize
+func writeParquetChunk(cw *countingWriter, st *storeState, rows []string, col parquetColumn) error {
+	var levels, values []byte // definition levels and values, bit-packed for bools
+	var present int           // values in values
+	n := 0                    // rows in the page
+	flush := func() error {
+		if n == 0 {
+			return nil
+		}
+		var data []byte
+		if col.attrKey != "" {
+			// Definition levels of the optional column: one bit-packed run
+			// of bit width 1, prefixed with its length
+			run := binary.AppendUvarint(nil, uint64((n+7)/8)<<1|1)
+			run = append(run, levels...)
+			data = binary.LittleEndian.AppendUint32(data, uint32(len(run)))
+			data = append(data, run...)
+		}
+		data = append(data, values...)
+
+		var h thriftWriter
+		h.i32(1, parquetDataPage)
+		h.i32(2, int32(len(data)))
+		h.i32(3, int32(len(data)))
+		h.beginStruct(5)
+		h.i32(1, int32(n))
+		h.i32(2, parquetPlain)
+		h.i32(3, parquetRLE)
+		h.i32(4, parquetRLE)
+		h.endStruct()
+		h.stop()
+		if _, err := cw.Write(h.buf); err != nil {
+			return err
+		}
+		if _, err := cw.Write(data); err != nil {
+			return err
+		}
+		levels, values, present, n = levels[:0], values[:0], 0, 0
+		return nil
+	}
+
+	for _, key := range rows {
+		var value interface{} = key
+		if col.attrKey != "" {
+			var exists bool
+			value, exists = st.entries[key].attrs[col.attrKey]
+			if n%8 == 0 {
+				levels = append(levels, 0)
+			}
+			if exists {
+				levels[n/8] |= 1 << (n % 8)
+				value = tableValue(value, col.attrType)
+			} else {
+				value = nil
+			}
+		}
+		switch v := value.(type) {
+		case nil:
+		case string:
+			values = binary.LittleEndian.AppendUint32(values, uint32(len(v)))
+			values = append(values, v...)
+		case float64:
+			values = binary.LittleEndian.AppendUint64(values, math.Float64bits(v))
+		case bool:
+			if present%8 == 0 {
+				values = append(values, 0)
+			}
+			if v {
+				values[present/8] |= 1 << (present % 8)
+			}
+		default:
+			return fmt.Errorf("entry %q: attribute %q has unexpected value %v", key, col.attrKey, v)
+		}
+		if value != nil {
+			present++
+		}
+		n++
+		if len(values)+len(levels) >= parquetPageSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// parquetFooter encodes the FileMetaData of a file with columns and the
+// column chunks of its row groups
+func parquetFooter(columns []parquetColumn, groups [][]parquetChunk, rows int) []byte {
+	var t thriftWriter
+	t.i32(1, 1) // version
+	t.listHeader(2, thriftStruct, len(columns)+1)
+	t.beginElement()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(columns)))
+	t.endStruct()
+	for i, col := range columns {
+		t.beginElement()
+		t.i32(1, col.physical)
+		if i == 0 {
+			t.i32(3, parquetRequired)
+		} else {
+			t.i32(3, parquetOptional)
+		}
+		t.binary(4, col.name)
+		if col.physical == parquetByteArray {
+			t.i32(6, parquetUTF8)
+			t.beginStruct(10) // LogicalType
+			t.beginStruct(1)  // STRING
+			t.endStruct()
+			t.endStruct()
+		}
+		t.endStruct()
+	}
+	t.i64(3, int64(rows))
+
+	t.listHeader(4, thriftStruct, len(groups))
+	for g, chunks := range groups {
+		groupRows := min(parquetRowGroupRows, rows-g*parquetRowGroupRows)
+		var size int64
+		t.beginElement()
+		t.listHeader(1, thriftStruct, len(chunks))
+		for i, chunk := range chunks {
+			col := columns[i]
+			size += chunk.size
+			t.beginElement()
+			t.i64(2, chunk.offset)
+			t.beginStruct(3) // ColumnMetaData
+			t.i32(1, col.physical)
+			t.listHeader(2, thriftI32, 2)
+			t.listI32(parquetPlain)
+			t.listI32(parquetRLE)
+			t.listHeader(3, thriftBinary, 1)
+			t.listBinary(col.name)
+			t.i32(4, parquetUncompressed)
+			t.i64(5, int64(groupRows))
+			t.i64(6, chunk.size)
+			t.i64(7, chunk.size)
+			t.i64(9, chunk.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64(2, size)
+		t.i64(3, int64(groupRows))
+		t.i64(5, chunks[0].offset)
+		t.i64(6, size)
+		t.endStruct()
+	}
+	t.binary(6, "key-value-go")
+	t.stop()
+	return t.buf
+}
+
+// Thrift compact protocol type codes
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes a struct in the Thrift compact protocol, in which a
+// field header holds the field's type and its id as a delta from the
+// previous field of the same struct
+type thriftWriter struct {
+	buf   []byte
+	last  int16   // id of the struct's last field
+	outer []int16 // last of the enclosing structs
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.buf = binary.AppendVarint(t.buf, int64(id))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.buf = binary.AppendVarint(t.buf, int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.buf = binary.AppendVarint(t.buf, v)
+}
+
+func (t *thriftWriter) binary(id int16, v string) {
+	t.field(id, thriftBinary)
+	t.listBinary(v)
+}
+
+// beginStruct starts a struct field; endStruct ends it
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.beginElement()
+}
+
+// beginElement starts a struct that is an element of a list
+func (t *thriftWriter) beginElement() {
+	t.outer = append(t.outer, t.last)
+	t.last = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.stop()
+	t.last = t.outer[len(t.outer)-1]
+	t.outer = t.outer[:len(t.outer)-1]
+}
+
+// stop ends the outermost struct
+func (t *thriftWriter) stop() {
+	t.buf = append(t.buf, 0)
+}
+
+// listHeader starts a list field of size elements of type typ
+func (t *thriftWriter) listHeader(id int16, typ byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf = append(t.buf, byte(size)<<4|typ)
+	} else {
+		t.buf = append(t.buf, 0xf0|typ)
+		t.buf = binary.AppendUvarint(t.buf, uint64(size))
+	}
+}
+
+func (t *thriftWriter) listI32(v int32) {
+	t.buf = binary.AppendVarint(t.buf, int64(v))
+}
+
+func (t *thriftWriter) listBinary(v string) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(v)))
+	t.buf = append(t.buf, v...)
+}
@@ -0,0 +1,395 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The tests read exports back with a reader of their own, written from the
+// format's specification rather than from the writer: the Thrift compact
+// footer is decoded generically, then each column chunk page by page.
+
+// thriftFields is a decoded Thrift struct, its fields by id
+type thriftFields map[int16]interface{}
+
+// thriftDecoder reads the Thrift compact protocol
+type thriftDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *thriftDecoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf(format, args...)
+	}
+	d.b = nil
+}
+
+func (d *thriftDecoder) byte() byte {
+	if len(d.b) == 0 {
+		d.fail("truncated")
+		return 0
+	}
+	c := d.b[0]
+	d.b = d.b[1:]
+	return c
+}
+
+func (d *thriftDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail("bad varint")
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *thriftDecoder) zigzag() int64 {
+	v := d.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (d *thriftDecoder) value(typ byte) interface{} {
+	switch typ {
+	case 1:
+		return true
+	case 2:
+		return false
+	case 3:
+		return int64(int8(d.byte()))
+	case 4, 5, 6:
+		return d.zigzag()
+	case 7:
+		if len(d.b) < 8 {
+			d.fail("truncated double")
+			return 0.0
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(d.b))
+		d.b = d.b[8:]
+		return v
+	case 8:
+		n := d.uvarint()
+		if uint64(len(d.b)) < n {
+			d.fail("truncated binary")
+			return ""
+		}
+		v := string(d.b[:n])
+		d.b = d.b[n:]
+		return v
+	case 9, 10:
+		h := d.byte()
+		size := uint64(h >> 4)
+		if size == 15 {
+			size = d.uvarint()
+		}
+		list := make([]interface{}, 0, size)
+		for i := uint64(0); i < size && d.err == nil; i++ {
+			elem := h & 0x0f
+			if elem == 1 || elem == 2 {
+				list = append(list, d.byte() == 1)
+				continue
+			}
+			list = append(list, d.value(elem))
+		}
+		return list
+	case 12:
+		return d.fields()
+	}
+	d.fail("unknown Thrift type %d", typ)
+	return nil
+}
+
+func (d *thriftDecoder) fields() thriftFields {
+	f := make(thriftFields)
+	var last int16
+	for d.err == nil {
+		h := d.byte()
+		if h == 0 {
+			break
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(d.zigzag())
+		}
+		f[id] = d.value(h & 0x0f)
+		last = id
+	}
+	return f
+}
+
+// parquetTestColumn is a column read back from an export
+type parquetTestColumn struct {
+	name       string
+	physical   int64
+	repetition int64
+	utf8       bool
+	pages      int
+	values     []interface{} // nil where the row has no value
+}
+
+// readParquetTest reads the columns of a Parquet file written as one or
+// more row groups of PLAIN, uncompressed data pages
+func readParquetTest(t *testing.T, file []byte) (int64, []parquetTestColumn) {
+	t.Helper()
+	if !bytes.HasPrefix(file, []byte(parquetMagic)) || !bytes.HasSuffix(file, []byte(parquetMagic)) {
+		t.Fatal("missing PAR1 magic")
+	}
+	n := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	d := &thriftDecoder{b: file[len(file)-8-n : len(file)-8]}
+	meta := d.fields()
+	if d.err != nil {
+		t.Fatalf("footer: %v", d.err)
+	}
+
+	schema := meta[2].([]interface{})
+	root := schema[0].(thriftFields)
+	if root[4] != "schema" || root[5] != int64(len(schema)-1) {
+		t.Fatalf("schema root = %v", root)
+	}
+	columns := make([]parquetTestColumn, len(schema)-1)
+	for i, e := range schema[1:] {
+		el := e.(thriftFields)
+		columns[i] = parquetTestColumn{name: el[4].(string), physical: el[1].(int64), repetition: el[3].(int64)}
+		if logical, ok := el[10].(thriftFields); ok {
+			_, columns[i].utf8 = logical[1]
+		}
+	}
+
+	for _, g := range meta[4].([]interface{}) {
+		group := g.(thriftFields)
+		groupRows := group[3].(int64)
+		for i, c := range group[1].([]interface{}) {
+			cm := c.(thriftFields)[3].(thriftFields)
+			if cm[1] != columns[i].physical || cm[4] != int64(parquetUncompressed) || cm[5] != groupRows {
+				t.Fatalf("column %s chunk metadata = %v", columns[i].name, cm)
+			}
+			offset, size := cm[9].(int64), cm[6].(int64)
+			readParquetTestChunk(t, file[offset:offset+size], &columns[i])
+		}
+	}
+	for _, col := range columns {
+		if int64(len(col.values)) != meta[3].(int64) {
+			t.Fatalf("column %s has %d values, file %v rows", col.name, len(col.values), meta[3])
+		}
+	}
+	return meta[3].(int64), columns
+}
+
+func readParquetTestChunk(t *testing.T, chunk []byte, col *parquetTestColumn) {
+	t.Helper()
+	for len(chunk) > 0 {
+		d := &thriftDecoder{b: chunk}
+		h := d.fields()
+		if d.err != nil {
+			t.Fatalf("column %s page header: %v", col.name, d.err)
+		}
+		size := h[3].(int64)
+		if h[1] != int64(parquetDataPage) || h[2] != size {
+			t.Fatalf("column %s page header = %v", col.name, h)
+		}
+		data := d.b[:size]
+		chunk = d.b[size:]
+		col.pages++
+
+		dp := h[5].(thriftFields)
+		rows := int(dp[1].(int64))
+		present := make([]bool, rows)
+		if col.repetition == parquetOptional {
+			n := binary.LittleEndian.Uint32(data)
+			present = readParquetTestLevels(t, data[4:4+n], rows)
+			data = data[4+n:]
+		} else {
+			for i := range present {
+				present[i] = true
+			}
+		}
+
+		var bit int
+		for _, ok := range present {
+			if !ok {
+				col.values = append(col.values, nil)
+				continue
+			}
+			switch col.physical {
+			case parquetByteArray:
+				n := binary.LittleEndian.Uint32(data)
+				col.values = append(col.values, string(data[4:4+n]))
+				data = data[4+n:]
+			case parquetDouble:
+				col.values = append(col.values, math.Float64frombits(binary.LittleEndian.Uint64(data)))
+				data = data[8:]
+			case parquetBoolean:
+				col.values = append(col.values, data[bit/8]&(1<<(bit%8)) != 0)
+				bit++
+			}
+		}
+		if bit > 0 {
+			data = data[(bit+7)/8:]
+		}
+		if len(data) != 0 {
+			t.Fatalf("column %s page has %d bytes left over", col.name, len(data))
+		}
+	}
+}
+
+// readParquetTestLevels decodes definition levels of bit width 1 in the
+// RLE/bit-packed hybrid encoding
+func readParquetTestLevels(t *testing.T, data []byte, rows int) []bool {
+	t.Helper()
+	var levels []bool
+	for len(data) > 0 {
+		h, n := binary.Uvarint(data)
+		data = data[n:]
+		if h&1 == 1 {
+			count := int(h>>1) * 8
+			for i := 0; i < count; i++ {
+				levels = append(levels, data[i/8]&(1<<(i%8)) != 0)
+			}
+			data = data[count/8:]
+		} else {
+			for i := 0; i < int(h>>1); i++ {
+				levels = append(levels, data[0] == 1)
+			}
+			data = data[1:]
+		}
+	}
+	if len(levels) < rows {
+		t.Fatalf("%d definition levels for %d rows", len(levels), rows)
+	}
+	return levels[:rows]
+}
+
+func exportParquet(t *testing.T, s *Store) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := s.ExportTo(&buf, "parquet"); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExportParquet(t *testing.T) {
+	s := NewStore()
+	for key, attrs := range map[string][][]string{
+		"user1": {{"name", "ann"}, {"age", "30"}, {"admin", "true"}},
+		"user2": {{"name", "bob"}, {"age", "41.5"}, {"admin", "false"}},
+		"user3": {{"name", "cy"}},
+		"user4": {{"Name", "clash"}, {"admin", "true"}},
+	} {
+		if err := s.Put(key, attrs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := exportParquet(t, s)
+
+	golden := filepath.Join("testdata", "export.parquet")
+	if *update {
+		if err := os.WriteFile(golden, file, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want, err := os.ReadFile(golden); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(file, want) {
+		t.Errorf("export differs from %s; rerun with -update if the change is intended", golden)
+	}
+
+	rows, columns := readParquetTest(t, file)
+	if rows != 4 {
+		t.Fatalf("rows = %d, want 4", rows)
+	}
+	want := []parquetTestColumn{
+		{name: "key", physical: parquetByteArray, repetition: parquetRequired, utf8: true, values: []interface{}{"user1", "user2", "user3", "user4"}},
+		{name: "Name", physical: parquetByteArray, repetition: parquetOptional, utf8: true, values: []interface{}{nil, nil, nil, "clash"}},
+		{name: "admin", physical: parquetBoolean, repetition: parquetOptional, values: []interface{}{true, false, nil, true}},
+		{name: "age", physical: parquetDouble, repetition: parquetOptional, values: []interface{}{30.0, 41.5, nil, nil}},
+		{name: "name_2", physical: parquetByteArray, repetition: parquetOptional, utf8: true, values: []interface{}{"ann", "bob", "cy", nil}},
+	}
+	for i := range columns {
+		columns[i].pages = 0
+	}
+	if !reflect.DeepEqual(columns, want) {
+		t.Errorf("columns =\n%v\nwant\n%v", columns, want)
+	}
+}
+
+// TestExportParquetPages exports columns large enough to be cut into
+// several pages, with bools and nulls straddling the cuts
+func TestExportParquetPages(t *testing.T) {
+	const n = 3000
+	s := NewStore()
+	for i := 0; i < n; i++ {
+		attrs := [][]string{{"text", strings.Repeat(string(rune('a'+i%26)), 1000)}, {"even", strconv.FormatBool(i%2 == 0)}}
+		if i%3 != 0 {
+			attrs = append(attrs, []string{"n", strconv.Itoa(i)})
+		}
+		if err := s.Put(fmt.Sprintf("key%05d", i), attrs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows, columns := readParquetTest(t, exportParquet(t, s))
+	if rows != n {
+		t.Fatalf("rows = %d, want %d", rows, n)
+	}
+	byName := make(map[string]parquetTestColumn)
+	for _, col := range columns {
+		byName[col.name] = col
+	}
+	if pages := byName["text"].pages; pages < 2 {
+		t.Fatalf("text column has %d pages, want several", pages)
+	}
+	for i := 0; i < n; i++ {
+		if got := byName["key"].values[i]; got != fmt.Sprintf("key%05d", i) {
+			t.Fatalf("row %d key = %v", i, got)
+		}
+		if got := byName["text"].values[i].(string); len(got) != 1000 || got[0] != byte('a'+i%26) {
+			t.Fatalf("row %d text = %.10q...", i, got)
+		}
+		if got := byName["even"].values[i]; got != (i%2 == 0) {
+			t.Fatalf("row %d even = %v", i, got)
+		}
+		var wantN interface{}
+		if i%3 != 0 {
+			wantN = float64(i)
+		}
+		if got := byName["n"].values[i]; got != wantN {
+			t.Fatalf("row %d n = %v, want %v", i, got, wantN)
+		}
+	}
+}
+
+// TestExportParquetNamespaces exports entries of several namespaces, which
+// share the columns of their attribute names
+func TestExportParquetNamespaces(t *testing.T) {
+	s := NewStore()
+	for key, attrs := range map[string][][]string{
+		"tenantA/u1": {{"age", "30"}, {"name", "ann"}, {"score", "1.5"}},
+		"tenantB/u2": {{"age", "thirty"}, {"score", "2"}},
+	} {
+		if err := s.Put(key, attrs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, columns := readParquetTest(t, exportParquet(t, s))
+	for i := range columns {
+		columns[i].pages = 0
+	}
+	want := []parquetTestColumn{
+		{name: "key", physical: parquetByteArray, repetition: parquetRequired, utf8: true, values: []interface{}{"tenantA/u1", "tenantB/u2"}},
+		{name: "age", physical: parquetByteArray, repetition: parquetOptional, utf8: true, values: []interface{}{"30", "thirty"}},
+		{name: "name", physical: parquetByteArray, repetition: parquetOptional, utf8: true, values: []interface{}{"ann", nil}},
+		{name: "score", physical: parquetDouble, repetition: parquetOptional, values: []interface{}{1.5, 2.0}},
+	}
+	if !reflect.DeepEqual(columns, want) {
+		t.Errorf("columns =\n%v\nwant\n%v", columns, want)
+	}
+}
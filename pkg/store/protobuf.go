@@ -0,0 +1,331 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+// Protobuf exports are a keyvalue.v1.Snapshot message, defined in
+// proto/keyvalue.proto, so other languages can read and write store dumps
+// with code generated from the schema. The attribute types are written
+// first, by the names the registry knows them by, namespace/attribute for
+// a namespace's, then each entry as its own length-delimited field, so the
+// export streams like the JSON one; the wire format is written and read
+// directly (https://protobuf.dev/programming-guides/encoding/).
+
+// Protobuf wire types
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+// Field numbers of proto/keyvalue.proto
+const (
+	protoSnapshotTypes   = 1 // Snapshot.attribute_types
+	protoSnapshotEntries = 2 // Snapshot.entries
+
+	protoMapKey   = 1 // of a map entry
+	protoMapValue = 2
+
+	protoEntryKey   = 1 // Entry.key
+	protoEntryAttrs = 2 // Entry.attributes
+
+	protoValueString = 1 // Value.string_value
+	protoValueFloat  = 2 // Value.float_value
+	protoValueBool   = 3 // Value.bool_value
+)
+
+// protoAttributeTypes maps the store's attribute types to AttributeType
+// enum values
+var protoAttributeTypes = map[AttributeType]uint64{StringType: 1, FloatType: 2, BoolType: 3}
+
+// writeProtobufExport writes the entries under keys as a Snapshot message,
+// one entry at a time
+func writeProtobufExport(w *bufio.Writer, st *storeState, keys []string) error {
+	var field, msg []byte
+	for _, attrKey := range st.attributeNames() {
+		msg = protoAppendString(msg[:0], protoMapKey, attrKey)
+		msg = protoAppendVarint(msg, protoMapValue, protoAttributeTypes[st.types[attrKey].dataType])
+		field = protoAppendBytes(field[:0], protoSnapshotTypes, msg)
+		if _, err := w.Write(field); err != nil {
+			return err
+		}
+	}
+
+	var value, attr []byte
+	for _, key := range keys {
+		attrs := st.entries[key].attrs
+		names := make([]string, 0, len(attrs))
+		for attrKey := range attrs {
+			names = append(names, attrKey)
+		}
+		sort.Strings(names)
+
+		msg = protoAppendString(msg[:0], protoEntryKey, key)
+		for _, attrKey := range names {
+			switch v := attrs[attrKey].(type) {
+			case string:
+				value = protoAppendString(value[:0], protoValueString, v)
+			case float64:
+				value = protoAppendTag(value[:0], protoValueFloat, protoFixed64)
+				value = binary.LittleEndian.AppendUint64(value, math.Float64bits(v))
+			case bool:
+				var b uint64
+				if v {
+					b = 1
+				}
+				value = protoAppendVarint(value[:0], protoValueBool, b)
+			default:
+				return fmt.Errorf("entry %q: attribute %q has unexpected value %v", key, attrKey, v)
+			}
+			attr = protoAppendString(attr[:0], protoMapKey, attrKey)
+			attr = protoAppendBytes(attr, protoMapValue, value)
+			msg = protoAppendBytes(msg, protoEntryAttrs, attr)
+		}
+		field = protoAppendBytes(field[:0], protoSnapshotEntries, msg)
+		if _, err := w.Write(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func protoAppendTag(b []byte, num uint64, wire byte) []byte {
+	return binary.AppendUvarint(b, num<<3|uint64(wire))
+}
+
+func protoAppendVarint(b []byte, num, v uint64) []byte {
+	return binary.AppendUvarint(protoAppendTag(b, num, protoVarint), v)
+}
+
+func protoAppendBytes(b []byte, num uint64, data []byte) []byte {
+	b = binary.AppendUvarint(protoAppendTag(b, num, protoBytes), uint64(len(data)))
+	return append(b, data...)
+}
+
+func protoAppendString(b []byte, num uint64, s string) []byte {
+	b = binary.AppendUvarint(protoAppendTag(b, num, protoBytes), uint64(len(s)))
+	return append(b, s...)
+}
+
+// ImportProtobuf is ImportJSON for a Snapshot message, as written by an
+// export in the protobuf format
+func (s *Store) ImportProtobuf(r io.Reader) (int, error) {
+	set, err := readProtobuf(r)
+	if err != nil {
+		return 0, err
+	}
+	return s.importAll(set)
+}
+
+// readProtobuf reads a Snapshot message. As in protobuf's own decoders,
+// unknown fields are skipped and a repeated map key keeps its last value.
+func readProtobuf(r io.Reader) (*importSet, error) {
+	set := &importSet{types: make(map[string]AttributeType), entries: make(map[string]map[string]interface{})}
+	err := readProtoFields(bufio.NewReader(r), func(f protoField) error {
+		switch f.num {
+		case protoSnapshotTypes:
+			if err := f.want(protoBytes); err != nil {
+				return err
+			}
+			attrKey, t, err := readProtoAttributeType(f.data)
+			if err != nil {
+				return fmt.Errorf("attribute_types: %w", err)
+			}
+			set.types[attrKey] = t
+		case protoSnapshotEntries:
+			if err := f.want(protoBytes); err != nil {
+				return err
+			}
+			key, attrs, err := readProtoEntry(f.data)
+			if err != nil {
+				return fmt.Errorf("entry %q: %w", key, err)
+			}
+			set.entries[key] = attrs
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading protobuf export: %w", err)
+	}
+	return set, nil
+}
+
+// readProtoAttributeType reads an entry of Snapshot.attribute_types
+func readProtoAttributeType(data []byte) (string, AttributeType, error) {
+	var attrKey string
+	var value uint64
+	err := readProtoFields(bytes.NewReader(data), func(f protoField) error {
+		var err error
+		switch f.num {
+		case protoMapKey:
+			attrKey, err = f.string()
+		case protoMapValue:
+			err = f.want(protoVarint)
+			value = f.v
+		}
+		return err
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	for t, v := range protoAttributeTypes {
+		if v == value {
+			return attrKey, t, nil
+		}
+	}
+	return "", 0, fmt.Errorf("type of %q: unknown AttributeType %d", attrKey, value)
+}
+
+// readProtoEntry reads an Entry message
+func readProtoEntry(data []byte) (string, map[string]interface{}, error) {
+	var key string
+	attrs := make(map[string]interface{})
+	err := readProtoFields(bytes.NewReader(data), func(f protoField) error {
+		var err error
+		switch f.num {
+		case protoEntryKey:
+			key, err = f.string()
+		case protoEntryAttrs:
+			if err = f.want(protoBytes); err == nil {
+				err = readProtoAttribute(f.data, attrs)
+			}
+		}
+		return err
+	})
+	return key, attrs, err
+}
+
+// readProtoAttribute reads an entry of Entry.attributes into attrs
+func readProtoAttribute(data []byte, attrs map[string]interface{}) error {
+	var attrKey string
+	var value interface{}
+	err := readProtoFields(bytes.NewReader(data), func(f protoField) error {
+		var err error
+		switch f.num {
+		case protoMapKey:
+			attrKey, err = f.string()
+		case protoMapValue:
+			if err = f.want(protoBytes); err == nil {
+				value, err = readProtoValue(f.data)
+			}
+		}
+		return err
+	})
+	if err == nil && value == nil {
+		err = errors.New("has no value")
+	}
+	if err != nil {
+		return fmt.Errorf("attribute %q: %w", attrKey, err)
+	}
+	attrs[attrKey] = value
+	return nil
+}
+
+// readProtoValue reads a Value message, returning nil if no field is set
+func readProtoValue(data []byte) (interface{}, error) {
+	var value interface{}
+	err := readProtoFields(bytes.NewReader(data), func(f protoField) error {
+		var err error
+		switch f.num {
+		case protoValueString:
+			value, err = f.string()
+		case protoValueFloat:
+			err = f.want(protoFixed64)
+			value = math.Float64frombits(f.v)
+		case protoValueBool:
+			err = f.want(protoVarint)
+			value = f.v != 0
+		}
+		return err
+	})
+	return value, err
+}
+
+// protoField is a field read from a message: the value of a varint or
+// fixed-size field is in v, that of a length-delimited one in data
+type protoField struct {
+	num  uint64
+	wire byte
+	v    uint64
+	data []byte
+}
+
+// want checks that the field has the wire type its field number calls for
+func (f protoField) want(wire byte) error {
+	if f.wire != wire {
+		return fmt.Errorf("field %d has wire type %d, want %d", f.num, f.wire, wire)
+	}
+	return nil
+}
+
+// string returns the value of a string field
+func (f protoField) string() (string, error) {
+	if err := f.want(protoBytes); err != nil {
+		return "", err
+	}
+	if !utf8.Valid(f.data) {
+		return "", fmt.Errorf("field %d is not valid UTF-8", f.num)
+	}
+	return string(f.data), nil
+}
+
+// readProtoFields calls fn with each field of the message read from r
+func readProtoFields(r interface {
+	io.Reader
+	io.ByteReader
+}, fn func(protoField) error) error {
+	for {
+		tag, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return nil
+		}
+		f := protoField{num: tag >> 3, wire: byte(tag & 7)}
+		if err == nil {
+			switch f.wire {
+			case protoVarint:
+				f.v, err = binary.ReadUvarint(r)
+			case protoFixed64:
+				var b [8]byte
+				_, err = io.ReadFull(r, b[:])
+				f.v = binary.LittleEndian.Uint64(b[:])
+			case protoFixed32:
+				var b [4]byte
+				_, err = io.ReadFull(r, b[:])
+				f.v = uint64(binary.LittleEndian.Uint32(b[:]))
+			case protoBytes:
+				var n uint64
+				if n, err = binary.ReadUvarint(r); err == nil {
+					// Read gradually, so a damaged length can't allocate it all
+					f.data, err = io.ReadAll(io.LimitReader(r, int64(min(n, math.MaxInt64))))
+					if err == nil && uint64(len(f.data)) != n {
+						err = io.ErrUnexpectedEOF
+					}
+				}
+			default:
+				err = fmt.Errorf("field %d has unsupported wire type %d", f.num, f.wire)
+			}
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil && f.num == 0 {
+			err = errors.New("field number 0 is invalid")
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+}
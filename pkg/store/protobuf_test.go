@@ -0,0 +1,262 @@
+package store
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// snapshotDescriptor describes keyvalue.v1.Snapshot as proto/keyvalue.proto
+// defines it, for reading and writing exports with the protobuf library as
+// generated code in another language would
+func snapshotDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	field := func(name string, num int32, label *descriptorpb.FieldDescriptorProto_Label, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(num), Label: label, Type: typ.Enum(), JsonName: proto.String(name)}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	mapEntry := func(name string, value *descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+		return &descriptorpb.DescriptorProto{
+			Name: proto.String(name),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("key", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				value,
+			},
+			Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+		}
+	}
+	oneof := proto.Int32(0)
+	valueField := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		f := field(name, num, optional, typ, "")
+		f.OneofIndex = oneof
+		return f
+	}
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("keyvalue.proto"),
+		Package: proto.String("keyvalue.v1"),
+		Syntax:  proto.String("proto3"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("AttributeType"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("ATTRIBUTE_TYPE_UNSPECIFIED"), Number: proto.Int32(0)},
+				{Name: proto.String("ATTRIBUTE_TYPE_STRING"), Number: proto.Int32(1)},
+				{Name: proto.String("ATTRIBUTE_TYPE_FLOAT"), Number: proto.Int32(2)},
+				{Name: proto.String("ATTRIBUTE_TYPE_BOOL"), Number: proto.Int32(3)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Snapshot"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("attribute_types", 1, repeated, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".keyvalue.v1.Snapshot.AttributeTypesEntry"),
+					field("entries", 2, repeated, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".keyvalue.v1.Entry"),
+				},
+				NestedType: []*descriptorpb.DescriptorProto{
+					mapEntry("AttributeTypesEntry", field("value", 2, optional, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".keyvalue.v1.AttributeType")),
+				},
+			},
+			{
+				Name: proto.String("Entry"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("key", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					field("attributes", 2, repeated, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".keyvalue.v1.Entry.AttributesEntry"),
+				},
+				NestedType: []*descriptorpb.DescriptorProto{
+					mapEntry("AttributesEntry", field("value", 2, optional, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".keyvalue.v1.Value")),
+				},
+			},
+			{
+				Name: proto.String("Value"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					valueField("string_value", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+					valueField("float_value", 2, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE),
+					valueField("bool_value", 3, descriptorpb.FieldDescriptorProto_TYPE_BOOL),
+				},
+				OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("kind")}},
+			},
+		},
+	}
+	fd, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fd.Messages().ByName("Snapshot")
+}
+
+// decodeProtoSnapshot reads a Snapshot with the protobuf library into the
+// types and entries it holds
+func decodeProtoSnapshot(t *testing.T, md protoreflect.MessageDescriptor, data []byte) (map[string]string, map[string]map[string]interface{}) {
+	t.Helper()
+	msg := dynamicpb.NewMessage(md)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		t.Fatal(err)
+	}
+	types := make(map[string]string)
+	msg.Get(md.Fields().ByName("attribute_types")).Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+		types[k.String()] = string(md.Fields().ByName("attribute_types").MapValue().Enum().Values().ByNumber(v.Enum()).Name())
+		return true
+	})
+
+	entries := make(map[string]map[string]interface{})
+	list := msg.Get(md.Fields().ByName("entries")).List()
+	for i := 0; i < list.Len(); i++ {
+		entry := list.Get(i).Message()
+		fields := entry.Descriptor().Fields()
+		attrs := make(map[string]interface{})
+		entry.Get(fields.ByName("attributes")).Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			value := v.Message()
+			which := value.WhichOneof(value.Descriptor().Oneofs().ByName("kind"))
+			if which == nil {
+				t.Errorf("attribute %s has no value", k)
+				return true
+			}
+			attrs[k.String()] = value.Get(which).Interface()
+			return true
+		})
+		entries[entry.Get(fields.ByName("key")).String()] = attrs
+	}
+	return types, entries
+}
+
+func TestExportProtobuf(t *testing.T) {
+	s := NewStore()
+	for key, attrs := range map[string][][]string{
+		"user1": {{"name", "ann"}, {"age", "30"}, {"admin", "true"}},
+		"user2": {{"name", "bob"}, {"age", "41.5"}},
+	} {
+		if err := s.Put(key, attrs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := s.ExportTo(&buf, "protobuf"); err != nil {
+		t.Fatal(err)
+	}
+
+	types, entries := decodeProtoSnapshot(t, snapshotDescriptor(t), buf.Bytes())
+	wantTypes := map[string]string{"name": "ATTRIBUTE_TYPE_STRING", "age": "ATTRIBUTE_TYPE_FLOAT", "admin": "ATTRIBUTE_TYPE_BOOL"}
+	if !reflect.DeepEqual(types, wantTypes) {
+		t.Errorf("attribute_types = %v, want %v", types, wantTypes)
+	}
+	wantEntries := map[string]map[string]interface{}{
+		"user1": {"name": "ann", "age": 30.0, "admin": true},
+		"user2": {"name": "bob", "age": 41.5},
+	}
+	if !reflect.DeepEqual(entries, wantEntries) {
+		t.Errorf("entries = %v, want %v", entries, wantEntries)
+	}
+
+	imported := NewStore()
+	if _, err := imported.ImportProtobuf(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range wantEntries {
+		if got := imported.Get(key); !reflect.DeepEqual(got, want) {
+			t.Errorf("imported %s = %v, want %v", key, got, want)
+		}
+	}
+}
+
+// TestImportProtobuf imports a Snapshot written by the protobuf library,
+// its fields in the library's order rather than the store's
+func TestImportProtobuf(t *testing.T) {
+	md := snapshotDescriptor(t)
+	snapshot := dynamicpb.NewMessage(md)
+	typesField := md.Fields().ByName("attribute_types")
+	types := snapshot.Mutable(typesField).Map()
+	types.Set(protoreflect.ValueOfString("score").MapKey(), protoreflect.ValueOfEnum(2))
+	types.Set(protoreflect.ValueOfString("unused").MapKey(), protoreflect.ValueOfEnum(3))
+
+	entriesField := md.Fields().ByName("entries")
+	entries := snapshot.Mutable(entriesField).List()
+	entry := entries.NewElement().Message()
+	entryFields := entry.Descriptor().Fields()
+	entry.Set(entryFields.ByName("key"), protoreflect.ValueOfString("k1"))
+	attrs := entry.Mutable(entryFields.ByName("attributes")).Map()
+	for name, v := range map[string]protoreflect.Value{
+		"score": protoreflect.ValueOfFloat64(9.5),
+		"label": protoreflect.ValueOfString("x y"),
+		"ok":    protoreflect.ValueOfBool(false),
+	} {
+		value := attrs.NewValue().Message()
+		valueFields := value.Descriptor().Fields()
+		switch v.Interface().(type) {
+		case float64:
+			value.Set(valueFields.ByName("float_value"), v)
+		case string:
+			value.Set(valueFields.ByName("string_value"), v)
+		case bool:
+			value.Set(valueFields.ByName("bool_value"), v)
+		}
+		attrs.Set(protoreflect.ValueOfString(name).MapKey(), protoreflect.ValueOfMessage(value))
+	}
+	entries.Append(protoreflect.ValueOfMessage(entry))
+
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStore()
+	if n, err := s.ImportProtobuf(bytes.NewReader(data)); err != nil || n != 1 {
+		t.Fatalf("ImportProtobuf = %d, %v", n, err)
+	}
+	want := map[string]interface{}{"score": 9.5, "label": "x y", "ok": false}
+	if got := s.Get("k1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("k1 = %v, want %v", got, want)
+	}
+	if err := s.Put("k2", [][]string{{"unused", "maybe"}}); err == nil {
+		t.Error("put of a string into the imported bool type unused succeeded")
+	}
+}
+
+// TestProtobufNamespaces round-trips entries of namespaces typing the same
+// attribute differently
+func TestProtobufNamespaces(t *testing.T) {
+	s := NewStore()
+	for key, attrs := range map[string][][]string{
+		"tenantA/u1": {{"age", "30"}, {"name", "ann"}},
+		"tenantB/u2": {{"age", "thirty"}},
+	} {
+		if err := s.Put(key, attrs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := s.ExportTo(&buf, "protobuf"); err != nil {
+		t.Fatal(err)
+	}
+	types, _ := decodeProtoSnapshot(t, snapshotDescriptor(t), buf.Bytes())
+	wantTypes := map[string]string{"tenantA/age": "ATTRIBUTE_TYPE_FLOAT", "tenantA/name": "ATTRIBUTE_TYPE_STRING", "tenantB/age": "ATTRIBUTE_TYPE_STRING"}
+	if !reflect.DeepEqual(types, wantTypes) {
+		t.Errorf("attribute_types = %v, want %v", types, wantTypes)
+	}
+
+	imported := NewStore()
+	if _, err := imported.ImportProtobuf(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := imported.Get("tenantA/u1"); !reflect.DeepEqual(got, map[string]interface{}{"age": 30.0, "name": "ann"}) {
+		t.Errorf("tenantA/u1 = %v", got)
+	}
+	if got := imported.Get("tenantB/u2"); !reflect.DeepEqual(got, map[string]interface{}{"age": "thirty"}) {
+		t.Errorf("tenantB/u2 = %v", got)
+	}
+	if err := imported.Put("tenantA/u3", [][]string{{"age", "old"}}); err == nil {
+		t.Error("imported tenantA takes a string age")
+	}
+	if err := imported.Put("u4", [][]string{{"age", "old"}}); err != nil {
+		t.Errorf("default namespace age: %v", err)
+	}
+}
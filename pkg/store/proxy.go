@@ -0,0 +1,528 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A proxy lets clients use a sharded deployment as if it were one server.
+// It holds no data: it places the shard nodes on the same hash ring as
+// cluster mode, sends each single-key command to the node that owns the key
+// and runs keys and search on every node, merging the results. The shards
+// can be independent servers or the nodes of a cluster; in the latter case
+// the proxy learns about nodes that join or leave, and which nodes are down,
+// from their "cluster nodes" table, and a node that owns a key the proxy's
+// ring has not caught up with yet forwards the command itself. The proxy
+// runs keys and search on each node as the nodes forward them to each
+// other, prefixed "local", so for a cluster with a secret it is given the
+// secret too and proves it knows it on each connection, as a node does.
+
+// proxyRefresh is how often the proxy reloads the cluster's member table
+const proxyRefresh = time.Second
+
+// Proxy routes client commands to the shard nodes that own their keys
+type Proxy struct {
+	mu     sync.RWMutex
+	ring   []ringPoint
+	peers  map[string]*clusterPeer
+	states map[string]string // node state from the cluster's member table
+	secret string            // the cluster secret, "" for none
+
+	svc  tcpService
+	done chan struct{}
+}
+
+// NewProxy creates a proxy for the shard nodes given as "id=addr" pairs of
+// their client listen addresses; call ListenAndServe or Serve to start it.
+// For a cluster, use the same IDs as the nodes' -cluster-id so the proxy's
+// ring matches theirs, and the cluster's secret, or "" for a cluster
+// without one or independent servers.
+func NewProxy(nodes []string, secret string) (*Proxy, error) {
+	p := &Proxy{
+		peers:  make(map[string]*clusterPeer),
+		states: make(map[string]string),
+		secret: secret,
+		svc:    newTCPService(),
+		done:   make(chan struct{}),
+	}
+	now := time.Now()
+	for _, node := range nodes {
+		id, addr, ok := strings.Cut(node, "=")
+		if !ok || id == "" || addr == "" {
+			return nil, fmt.Errorf("shard node %q is not of the form id=addr", node)
+		}
+		if _, dup := p.peers[id]; dup {
+			return nil, fmt.Errorf("shard node %q is listed twice", id)
+		}
+		p.peers[id] = newClusterPeer(id, addr, secret, now)
+	}
+	if len(p.peers) == 0 {
+		return nil, errors.New("the proxy needs at least one shard node")
+	}
+	p.rebuildRingLocked()
+	go p.runRefresh()
+	return p, nil
+}
+
+// ListenAndServe listens on the TCP address addr and serves clients until
+// the proxy is closed
+func (p *Proxy) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(l)
+}
+
+// Serve accepts connections on l, handling each in its own goroutine
+func (p *Proxy) Serve(l net.Listener) error {
+	return p.svc.serve(l, p.handle)
+}
+
+// Close stops the proxy, disconnecting every client and dropping the idle
+// connections to the shards
+func (p *Proxy) Close() error {
+	err := p.svc.close()
+	<-p.done
+	for _, peer := range p.nodes() {
+		peer.closeIdle()
+	}
+	return err
+}
+
+// owner returns the node that owns key
+func (p *Proxy) owner(key string) *clusterPeer {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.peers[ringOwner(p.ring, key)]
+}
+
+// nodes returns every shard node ordered by ID
+func (p *Proxy) nodes() []*clusterPeer {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	nodes := make([]*clusterPeer, 0, len(p.peers))
+	for _, peer := range p.peers {
+		nodes = append(nodes, peer)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].id < nodes[j].id })
+	return nodes
+}
+
+// holders returns the nodes that may hold keys: all but those that have
+// left the cluster and handed their keys over
+func (p *Proxy) holders() []*clusterPeer {
+	nodes := p.nodes()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	holders := nodes[:0]
+	for _, peer := range nodes {
+		if p.states[peer.id] != nodeLeft {
+			holders = append(holders, peer)
+		}
+	}
+	return holders
+}
+
+// send runs a command on node peer. Nodes the cluster reports dead are not
+// tried.
+func (p *Proxy) send(peer *clusterPeer, args []string) (interface{}, error) {
+	p.mu.RLock()
+	state := p.states[peer.id]
+	p.mu.RUnlock()
+
+	if state == nodeDead {
+		return nil, fmt.Errorf("%w node %s at %s is down", errClusterDown, peer.id, peer.addr)
+	}
+	return peer.do(args)
+}
+
+// rebuildRingLocked places the nodes that haven't left the cluster on the
+// ring. Caller must hold mu for writing.
+func (p *Proxy) rebuildRingLocked() {
+	ids := make([]string, 0, len(p.peers))
+	for id := range p.peers {
+		if state := p.states[id]; state != nodeLeaving && state != nodeLeft {
+			ids = append(ids, id)
+		}
+	}
+	p.ring = buildRing(ids)
+}
+
+func (p *Proxy) runRefresh() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(proxyRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.svc.done:
+			return
+		case <-ticker.C:
+			p.refresh()
+		}
+	}
+}
+
+// refresh reloads the member table from the first node that answers. Shards
+// that aren't running in cluster mode reply with an error, leaving the
+// node list as it was configured.
+func (p *Proxy) refresh() {
+	for _, peer := range p.nodes() {
+		reply, err := peer.do([]string{"cluster", "nodes"})
+		if err != nil {
+			continue
+		}
+		rows, ok := reply.([]interface{})
+		if !ok {
+			return
+		}
+
+		p.mu.Lock()
+		for _, row := range rows {
+			// Each row is id, addr, state, last seen and ranges
+			fields, ok := row.([]interface{})
+			if !ok || len(fields) < 3 {
+				continue
+			}
+			id, _ := fields[0].(string)
+			addr, _ := fields[1].(string)
+			state, _ := fields[2].(string)
+			if id == "" || addr == "" {
+				continue
+			}
+			if _, known := p.peers[id]; !known {
+				p.peers[id] = newClusterPeer(id, addr, p.secret, time.Now())
+			}
+			p.states[id] = state
+		}
+		p.rebuildRingLocked()
+		p.mu.Unlock()
+		return
+	}
+}
+
+// proxyConn holds the per-connection protocol state. A transaction runs on
+// one node over a connection pinned to the client from its first WATCH, or
+// at EXEC if it watches nothing, until it ends.
+type proxyConn struct {
+	proxy *Proxy
+	rw    *respWriter
+
+	pinned     *peerConn
+	pinnedNode *clusterPeer
+
+	multi     bool
+	queued    [][]string
+	queueNode *clusterPeer // owner of the queued commands' keys
+	execAbort bool
+}
+
+func (p *Proxy) handle(conn net.Conn) {
+	c := &proxyConn{proxy: p, rw: newRESPWriter(conn)}
+	defer func() {
+		// Closing the pinned connection makes the node discard the
+		// transaction
+		if c.pinned != nil {
+			c.pinned.conn.Close()
+		}
+	}()
+
+	rr := newRESPReader(conn)
+	for {
+		args, err := rr.ReadCommand()
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				c.writeErr(err)
+				c.rw.Flush()
+			}
+			return
+		}
+
+		quit := c.dispatch(args)
+		if err := c.rw.Flush(); err != nil || quit {
+			return
+		}
+	}
+}
+
+// dispatch routes one command and writes its reply. It reports whether the
+// client asked to close the connection.
+func (c *proxyConn) dispatch(args []string) bool {
+	command := strings.ToLower(args[0])
+
+	if c.multi {
+		switch command {
+		case "exec", "discard", "multi", "watch", "quit":
+		case "put", "delete", "get":
+			if len(args) < 2 {
+				c.execAbort = true
+				c.rw.WriteError(fmt.Sprintf("ERR wrong number of arguments for '%s'", command))
+				return false
+			}
+			if err := c.checkTxnKeys(args[1:2]); err != nil {
+				c.execAbort = true
+				c.writeErr(err)
+				return false
+			}
+			c.queued = append(c.queued, args)
+			c.rw.WriteSimple("QUEUED")
+			return false
+		default:
+			c.execAbort = true
+			c.rw.WriteError(fmt.Sprintf("ERR '%s' cannot be queued in MULTI, only put, delete and get", command))
+			return false
+		}
+	}
+
+	switch command {
+	case "ping":
+		if len(args) > 1 {
+			c.rw.WriteBulk(args[1])
+		} else {
+			c.rw.WriteSimple("PONG")
+		}
+
+	case "quit":
+		c.rw.WriteSimple("OK")
+		return true
+
+	case "put", "get", "delete", "version", "cas":
+		if len(args) < 2 {
+			c.rw.WriteError(fmt.Sprintf("ERR wrong number of arguments for '%s'", command))
+			return false
+		}
+		c.relay(c.proxy.send(c.proxy.owner(args[1]), args))
+
+	case "keys", "search":
+		merged, err := gatherStrings(c.proxy.holders(), nil, args)
+		if err != nil {
+			c.writeErr(err)
+			return false
+		}
+		c.rw.WriteStrings(merged)
+
+	case "owner":
+		if len(args) != 2 {
+			c.rw.WriteError("ERR wrong number of arguments for 'owner'")
+			return false
+		}
+		owner := c.proxy.owner(args[1])
+		c.rw.WriteStrings([]string{owner.id, owner.addr})
+
+	case "cluster":
+		// Any node's member table will do
+		var err error
+		for _, peer := range c.proxy.nodes() {
+			var reply interface{}
+			if reply, err = c.proxy.send(peer, args); err == nil {
+				c.rw.WriteReply(reply)
+				return false
+			}
+		}
+		c.writeErr(err)
+
+	case "watch":
+		if len(args) < 2 {
+			c.rw.WriteError("ERR wrong number of arguments for 'watch'")
+			return false
+		}
+		if c.multi {
+			c.rw.WriteError("ERR WATCH inside MULTI is not allowed")
+			return false
+		}
+		if err := c.checkTxnKeys(args[1:]); err != nil {
+			c.writeErr(err)
+			return false
+		}
+		if err := c.pin(c.proxy.owner(args[1])); err != nil {
+			c.writeErr(err)
+			return false
+		}
+		c.relay(c.pinnedDo(args))
+
+	case "unwatch":
+		if c.pinned != nil {
+			if _, err := c.pinnedDo(args); err == nil {
+				c.unpin()
+			}
+		}
+		c.rw.WriteSimple("OK")
+
+	case "multi":
+		if c.multi {
+			c.rw.WriteError("ERR MULTI calls can not be nested")
+			return false
+		}
+		c.multi = true
+		c.rw.WriteSimple("OK")
+
+	case "exec":
+		if !c.multi {
+			c.rw.WriteError("ERR EXEC without MULTI")
+			return false
+		}
+		c.exec()
+
+	case "discard":
+		if !c.multi {
+			c.rw.WriteError("ERR DISCARD without MULTI")
+			return false
+		}
+		if c.pinned != nil {
+			if _, err := c.pinnedDo([]string{"unwatch"}); err == nil {
+				c.unpin()
+			}
+		}
+		c.endMulti()
+		c.rw.WriteSimple("OK")
+
+	case "token", "session":
+		c.rw.WriteError("ERR session tokens are per node and can't be used through the proxy")
+
+	case "select":
+		c.rw.WriteError("ERR the proxy shares its connections to the nodes between clients, so it can't select a database; send select to a node directly")
+
+	case "role", "replication", "promote", "changes", "webhooks", "connectors", "trigger", "quota", "raft", "gossip", "hints", "subscribe", "psubscribe", "unsubscribe", "punsubscribe", "local":
+		c.rw.WriteError(fmt.Sprintf("ERR '%s' is specific to one node; send it to the node directly", args[0]))
+
+	default:
+		c.rw.WriteError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+	return false
+}
+
+// checkTxnKeys returns errNotOwner unless keys and every key the
+// transaction already uses belong to the same node
+func (c *proxyConn) checkTxnKeys(keys []string) error {
+	node := c.pinnedNode
+	if node == nil {
+		node = c.queueNode
+	}
+	for _, key := range keys {
+		owner := c.proxy.owner(key)
+		if node == nil {
+			node = owner
+		}
+		if owner != node {
+			return fmt.Errorf("%w key %q belongs to node %s, not %s; a transaction must keep to one node", errNotOwner, key, owner.id, node.id)
+		}
+	}
+	if c.multi {
+		c.queueNode = node
+	}
+	return nil
+}
+
+// exec runs the queued transaction on its node and relays the node's reply
+func (c *proxyConn) exec() {
+	queued, aborted, node := c.queued, c.execAbort, c.queueNode
+	c.endMulti()
+	if aborted {
+		if c.pinned != nil {
+			c.pinnedDo([]string{"unwatch"})
+			c.unpin()
+		}
+		c.rw.WriteError("EXECABORT Transaction discarded because of previous errors.")
+		return
+	}
+	if c.pinned == nil {
+		if node == nil {
+			c.rw.WriteArrayHeader(0)
+			return
+		}
+		if err := c.pin(node); err != nil {
+			c.writeErr(err)
+			return
+		}
+	}
+
+	// Send the whole transaction before reading any reply, so it costs one
+	// round trip
+	pc, pinnedNode := c.pinned, c.pinnedNode
+	pc.w.WriteStrings([]string{"multi"})
+	for _, args := range queued {
+		pc.w.WriteStrings(args)
+	}
+	pc.w.WriteStrings([]string{"exec"})
+	var reply interface{}
+	err := pc.w.Flush()
+	for i := 0; err == nil && i < len(queued)+2; i++ {
+		reply, err = pc.r.ReadReply()
+	}
+	if err != nil {
+		c.dropPinned()
+		c.writeErr(pinnedNode.unreachable(err))
+		return
+	}
+	c.unpin()
+	c.rw.WriteReply(reply)
+}
+
+func (c *proxyConn) endMulti() {
+	c.multi, c.queued, c.queueNode, c.execAbort = false, nil, nil, false
+}
+
+// pin dedicates a connection to node to this client's transaction
+func (c *proxyConn) pin(node *clusterPeer) error {
+	if c.pinned != nil {
+		return nil
+	}
+	pc, err := node.conn()
+	if err != nil {
+		return err
+	}
+	c.pinned, c.pinnedNode = pc, node
+	return nil
+}
+
+// pinnedDo runs a command over the pinned connection
+func (c *proxyConn) pinnedDo(args []string) (interface{}, error) {
+	reply, err := c.pinned.do(args)
+	if err != nil {
+		node := c.pinnedNode
+		c.dropPinned()
+		return nil, node.unreachable(err)
+	}
+	return reply, nil
+}
+
+// unpin returns the pinned connection, whose transaction has ended, to the
+// node's pool
+func (c *proxyConn) unpin() {
+	c.pinnedNode.release(c.pinned)
+	c.pinned, c.pinnedNode = nil, nil
+}
+
+// dropPinned closes a pinned connection that failed
+func (c *proxyConn) dropPinned() {
+	c.pinned.conn.Close()
+	c.pinned, c.pinnedNode = nil, nil
+}
+
+// relay writes a node's reply, or the error that prevented one
+func (c *proxyConn) relay(reply interface{}, err error) {
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.rw.WriteReply(reply)
+}
+
+// writeErr replies with err, prefixed with the generic ERR code unless it
+// carries its own
+func (c *proxyConn) writeErr(err error) {
+	if errors.Is(err, errClusterDown) || errors.Is(err, errNotOwner) {
+		c.rw.WriteError(err.Error())
+		return
+	}
+	c.rw.WriteError("ERR " + err.Error())
+}
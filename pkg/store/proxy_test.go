@@ -0,0 +1,122 @@
+package store
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// startProxy serves p on a loopback port until the test ends and returns
+// its address
+func startProxy(t *testing.T, p *Proxy) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(ln)
+	t.Cleanup(func() { p.Close() })
+	return ln.Addr().String()
+}
+
+// TestProxyRouting spreads keys over two independent servers through a
+// proxy, and checks each key lands on its owner and that keys and search
+// merge what every shard holds
+func TestProxyRouting(t *testing.T) {
+	stores := map[string]*Store{"a": NewStore(), "b": NewStore()}
+	var nodes []string
+	for _, id := range []string{"a", "b"} {
+		nodes = append(nodes, id+"="+startServer(t, NewServer(stores[id])))
+	}
+	p, err := NewProxy(nodes, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := dialServer(t, startProxy(t, p))
+
+	var keys, even []string
+	for i := range 20 {
+		key := fmt.Sprintf("user%d", i)
+		keys = append(keys, key)
+		if i%2 == 0 {
+			even = append(even, key)
+		}
+		if reply := send(t, client, "put", key, "parity", fmt.Sprint(i%2)); reply != respSimple("OK") {
+			t.Fatalf("put %s = %v", key, reply)
+		}
+	}
+	sort.Strings(keys)
+	sort.Strings(even)
+
+	held := map[string]int{}
+	for _, key := range keys {
+		owner := p.owner(key).id
+		for id, s := range stores {
+			if (s.Get(key) != nil) != (id == owner) {
+				t.Errorf("%s on %s: %v, owner %s", key, id, s.Get(key) != nil, owner)
+			}
+		}
+		held[owner]++
+		if reply := send(t, client, "get", key); reply == nil || reflect.DeepEqual(reply, []interface{}(nil)) {
+			t.Errorf("get %s through the proxy found nothing", key)
+		}
+	}
+	if held["a"] == 0 || held["b"] == 0 {
+		t.Fatalf("every key went to one shard: %v", held)
+	}
+
+	toStrings := func(reply interface{}) []string {
+		t.Helper()
+		items, ok := reply.([]interface{})
+		if !ok {
+			t.Fatalf("reply = %#v, want an array", reply)
+		}
+		out := make([]string, len(items))
+		for i, item := range items {
+			out[i], _ = item.(string)
+		}
+		return out
+	}
+	if got := toStrings(send(t, client, "keys")); !reflect.DeepEqual(got, keys) {
+		t.Errorf("keys = %v, want %v", got, keys)
+	}
+	if got := toStrings(send(t, client, "search", "parity", "0")); !reflect.DeepEqual(got, even) {
+		t.Errorf("search = %v, want %v", got, even)
+	}
+}
+
+// TestProxySecret runs keys through proxies in front of a cluster with a
+// secret, which only the proxy given the secret may do
+func TestProxySecret(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := "a=" + ln.Addr().String()
+	cluster, err := NewCluster("a", []string{node}, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	s := NewStore()
+	if err := s.Put("user1", [][]string{{"name", "ann"}}); err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(s)
+	srv.Cluster = cluster
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	for secret, want := range map[string]bool{"": false, "wrong": false, "s3cret": true} {
+		p, err := NewProxy([]string{node}, secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply := send(t, dialServer(t, startProxy(t, p)), "keys")
+		if ok := reflect.DeepEqual(reply, []interface{}{"user1"}); ok != want {
+			t.Errorf("keys through a proxy with secret %q = %#v", secret, reply)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package store
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Keyspace notifications let clients follow changes as Redis clients do,
+// with SUBSCRIBE and PSUBSCRIBE. Every put or delete is published on two
+// channels: "__keyspace@<db>__:<key>", with the operation ("put" or
+// "delete") as the message, and "__keyevent@<db>__:<operation>", with the
+// key as the message, where db is the database of the key (see
+// database.go) and key its name in it. The store has no expiry, so there
+// are no expired events. PSUBSCRIBE patterns use path.Match syntax. Once
+// subscribed, a connection only accepts the subscription commands, PING and
+// QUIT, until it unsubscribes from everything. Notifications are for the
+// node the client is connected to; a client too slow to keep up with them
+// is disconnected.
+
+// notificationChannels returns the keyspace and keyevent channels of a
+// change by op to the store key key, and the key's name in its database
+func (srv *Server) notificationChannels(key, op string) (keyspace, keyevent, name string) {
+	db, name := srv.databaseOf(key), key
+	if db != 0 {
+		_, name = SplitNamespace(key)
+	}
+	n := strconv.Itoa(db)
+	return "__keyspace@" + n + "__:" + name, "__keyevent@" + n + "__:" + op, name
+}
+
+// pubsubState is a connection's keyspace subscriptions. It is guarded by the
+// connection's write mutex, which its notification pusher also takes.
+type pubsubState struct {
+	channels map[string]bool
+	patterns map[string]bool
+	events   <-chan ChangeEvent
+	closing  bool // the connection unsubscribed from the store itself
+}
+
+// pubsubCommand handles the subscription commands, reporting whether
+// command was one
+func (c *clientConn) pubsubCommand(command string, args []string) bool {
+	switch command {
+	case "subscribe", "psubscribe":
+		if len(args) < 2 {
+			c.rw.WriteError("ERR wrong number of arguments for '" + command + "'")
+			return true
+		}
+		for _, name := range args[1:] {
+			if command == "psubscribe" {
+				if _, err := path.Match(name, ""); err != nil {
+					c.rw.WriteError("ERR bad channel pattern " + name)
+					return true
+				}
+			}
+		}
+		c.startPubsub()
+		for _, name := range args[1:] {
+			if command == "subscribe" {
+				c.ps.channels[name] = true
+			} else {
+				c.ps.patterns[name] = true
+			}
+			c.writeSubscription(command, name)
+		}
+
+	case "unsubscribe", "punsubscribe":
+		var subscribed map[string]bool
+		if c.ps != nil {
+			subscribed = c.ps.channels
+			if command == "punsubscribe" {
+				subscribed = c.ps.patterns
+			}
+		}
+		names := args[1:]
+		if len(names) == 0 {
+			for name := range subscribed {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+		if len(names) == 0 {
+			c.rw.WriteArrayHeader(3)
+			c.rw.WriteBulk(command)
+			c.rw.WriteNull()
+			c.rw.WriteInt(int64(c.subscriptionCount()))
+		}
+		for _, name := range names {
+			delete(subscribed, name)
+			c.writeSubscription(command, name)
+		}
+		if c.subscriptionCount() == 0 {
+			c.stopPubsub()
+		}
+
+	default:
+		return false
+	}
+	return true
+}
+
+// writeSubscription confirms a change to the connection's subscriptions
+func (c *clientConn) writeSubscription(command, name string) {
+	c.rw.WriteArrayHeader(3)
+	c.rw.WriteBulk(command)
+	c.rw.WriteBulk(name)
+	c.rw.WriteInt(int64(c.subscriptionCount()))
+}
+
+func (c *clientConn) subscriptionCount() int {
+	if c.ps == nil {
+		return 0
+	}
+	return len(c.ps.channels) + len(c.ps.patterns)
+}
+
+// startPubsub subscribes the connection to the store's changes, if it isn't
+// already. Caller must hold wmu.
+func (c *clientConn) startPubsub() {
+	if c.ps != nil {
+		return
+	}
+	everything := func(string, map[string]interface{}, map[string]interface{}) bool { return true }
+	ps := &pubsubState{
+		channels: make(map[string]bool),
+		patterns: make(map[string]bool),
+		events:   c.srv.store.subscribe(&subscriber{wants: everything}),
+	}
+	c.ps = ps
+	go c.pushNotifications(ps)
+}
+
+// stopPubsub drops the connection's subscription to the store's changes.
+// Caller must hold wmu.
+func (c *clientConn) stopPubsub() {
+	if c.ps == nil {
+		return
+	}
+	c.ps.closing = true
+	c.srv.store.Unsubscribe(c.ps.events)
+	c.ps = nil
+}
+
+// pushNotifications writes the messages for each change ps receives until
+// the connection unsubscribes, disconnecting a client that falls behind
+func (c *clientConn) pushNotifications(ps *pubsubState) {
+	for event := range ps.events {
+		c.wmu.Lock()
+		keyspace, keyevent, name := c.srv.notificationChannels(event.Key, event.Op)
+		c.publish(ps, keyspace, event.Op)
+		c.publish(ps, keyevent, name)
+		err := c.rw.Flush()
+		c.wmu.Unlock()
+		if err != nil {
+			c.conn.Close()
+			return
+		}
+	}
+
+	c.wmu.Lock()
+	closing := ps.closing
+	c.wmu.Unlock()
+	if !closing {
+		c.conn.Close()
+	}
+}
+
+// publish writes message on channel if ps covers it. Caller must hold wmu.
+func (c *clientConn) publish(ps *pubsubState, channel, message string) {
+	if ps.channels[channel] {
+		c.rw.WriteStrings([]string{"message", channel, message})
+	}
+	patterns := make([]string, 0, len(ps.patterns))
+	for pattern := range ps.patterns {
+		if ok, _ := path.Match(pattern, channel); ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		c.rw.WriteStrings([]string{"pmessage", pattern, channel, message})
+	}
+}
+
+// allowedWhileSubscribed reports whether command can be sent by a
+// connection with keyspace subscriptions
+func allowedWhileSubscribed(command string) bool {
+	switch strings.ToLower(command) {
+	case "subscribe", "psubscribe", "unsubscribe", "punsubscribe", "ping", "quit":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// receive reads the next message pushed to a subscribed connection
+func receive(t *testing.T, pc *peerConn) interface{} {
+	t.Helper()
+	pc.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := pc.r.ReadReply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+// TestKeyspaceNotifications subscribes to a channel and a pattern and
+// checks each change is published on the channels it matches only, keys of
+// a database under that database's number and their name in it
+func TestKeyspaceNotifications(t *testing.T) {
+	addr := startServer(t, NewServer(NewStore()))
+	sub, writer := dialServer(t, addr), dialServer(t, addr)
+	for _, tc := range []struct {
+		args []string
+		want interface{}
+	}{
+		{[]string{"subscribe", "__keyevent@0__:delete"}, []interface{}{"subscribe", "__keyevent@0__:delete", int64(1)}},
+		{[]string{"psubscribe", "__keyspace@*__:user:*"}, []interface{}{"psubscribe", "__keyspace@*__:user:*", int64(2)}},
+	} {
+		if reply := send(t, sub, tc.args...); !reflect.DeepEqual(reply, tc.want) {
+			t.Fatalf("%v = %#v, want %#v", tc.args, reply, tc.want)
+		}
+	}
+
+	send(t, writer, "put", "order:1", "n", "1") // matches neither
+	send(t, writer, "put", "user:1", "n", "1")
+	send(t, writer, "delete", "order:1")
+	send(t, writer, "select", "1")
+	send(t, writer, "put", "user:2", "n", "1")
+	for _, want := range [][]interface{}{
+		{"pmessage", "__keyspace@*__:user:*", "__keyspace@0__:user:1", "put"},
+		{"message", "__keyevent@0__:delete", "order:1"},
+		{"pmessage", "__keyspace@*__:user:*", "__keyspace@1__:user:2", "put"},
+	} {
+		if reply := receive(t, sub); !reflect.DeepEqual(reply, want) {
+			t.Fatalf("received %#v, want %#v", reply, want)
+		}
+	}
+
+	if _, refused := send(t, sub, "psubscribe", "[").(respError); !refused {
+		t.Error("psubscribe accepted a bad pattern")
+	}
+}
+
+// TestSubscribedCommands checks a subscribed connection refuses commands
+// other than the subscription ones, PING and QUIT, and takes them again
+// once it unsubscribes from everything
+func TestSubscribedCommands(t *testing.T) {
+	pc := dialServer(t, startServer(t, NewServer(NewStore())))
+	send(t, pc, "subscribe", "a", "b")
+	if reply, want := receive(t, pc), []interface{}{"subscribe", "b", int64(2)}; !reflect.DeepEqual(reply, want) {
+		t.Fatalf("subscribe = %#v, want %#v", reply, want)
+	}
+	if _, refused := send(t, pc, "get", "k").(respError); !refused {
+		t.Error("get accepted while subscribed")
+	}
+	if reply := send(t, pc, "ping"); reply != respSimple("PONG") {
+		t.Errorf("ping = %#v, want PONG", reply)
+	}
+	send(t, pc, "psubscribe", "c*")
+
+	// UNSUBSCRIBE with no channels replies once for each, and the pattern
+	// keeps the connection subscribed
+	if reply, want := send(t, pc, "unsubscribe"), []interface{}{"unsubscribe", "a", int64(2)}; !reflect.DeepEqual(reply, want) {
+		t.Fatalf("unsubscribe = %#v, want %#v", reply, want)
+	}
+	if reply, want := receive(t, pc), []interface{}{"unsubscribe", "b", int64(1)}; !reflect.DeepEqual(reply, want) {
+		t.Fatalf("unsubscribe = %#v, want %#v", reply, want)
+	}
+	if _, refused := send(t, pc, "get", "k").(respError); !refused {
+		t.Error("get accepted while subscribed to a pattern")
+	}
+	if reply, want := send(t, pc, "punsubscribe"), []interface{}{"punsubscribe", "c*", int64(0)}; !reflect.DeepEqual(reply, want) {
+		t.Fatalf("punsubscribe = %#v, want %#v", reply, want)
+	}
+	if reply := send(t, pc, "get", "k"); reply != nil && !reflect.DeepEqual(reply, []interface{}(nil)) {
+		t.Errorf("get after unsubscribing = %#v, want nil", reply)
+	}
+}
@@ -0,0 +1,313 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Quotas keep one tenant from taking a shared store over: a namespace (see
+// namespace.go) can be limited to a number of keys, an estimate of the
+// memory its entries take, measured as for WithMaxMemory, or both. A write
+// that would take a namespace over either limit fails with a QuotaError,
+// leaving the store as it was; writes that shrink a namespace, deletes
+// among them, always succeed, so a namespace over a quota lowered beneath
+// it can be brought back under. Trigger copies count against the
+// namespaces they land in. The store keeps every namespace's usage as it
+// changes, so checking a write costs only its own keys. Writes that grow
+// a namespace with a quota are checked and committed one at a time, so
+// racing writers can't take it over its limits together; the rest run
+// concurrently. Imports, bulk loads and changefeed batches are limited
+// like any write; followers and Raft nodes apply what the node taking the
+// writes accepted, and restores aren't limited. The default namespace
+// can't have a quota; WithMaxMemory limits the whole store.
+
+// ErrQuotaExceeded is matched by the QuotaError of a write that would take
+// a namespace over one of its quotas
+var ErrQuotaExceeded = errors.New("QUOTA namespace over its quota")
+
+// QuotaError reports a write refused because it would take Namespace over
+// its quota of Resource, "keys" or "memory"
+type QuotaError struct {
+	Namespace string
+	Resource  string
+	Usage     int64 // what the namespace would hold after the write
+	Limit     int64
+}
+
+func (e *QuotaError) Error() string {
+	unit := "keys"
+	if e.Resource == "memory" {
+		unit = "bytes"
+	}
+	return fmt.Sprintf("%s: namespace %s would hold %d %s, its %s quota is %d",
+		ErrQuotaExceeded, e.Namespace, e.Usage, unit, e.Resource, e.Limit)
+}
+
+// Unwrap makes a QuotaError match ErrQuotaExceeded
+func (e *QuotaError) Unwrap() error { return ErrQuotaExceeded }
+
+// NamespaceQuota limits a namespace. A zero field sets no limit.
+type NamespaceQuota struct {
+	MaxKeys   int64 // keys the namespace may hold
+	MaxMemory int64 // estimated bytes its entries may take
+}
+
+// namespaceUsage is what a namespace's entries hold
+type namespaceUsage struct {
+	keys   atomic.Int64
+	memory atomic.Int64
+	grow   sync.Mutex // held by a write growing the namespace against its quota until it is applied
+}
+
+// quotaSet holds a store's namespace quotas and usage
+type quotaSet struct {
+	mu     sync.RWMutex
+	quotas map[string]NamespaceQuota // replaced, never modified in place
+	usage  sync.Map                  // namespace -> *namespaceUsage
+}
+
+// checkQuota reports whether namespace can have quota q
+func checkQuota(namespace string, q NamespaceQuota) error {
+	if namespace == "" || strings.Contains(namespace, NamespaceSeparator) {
+		return invalid("a quota needs a namespace name without %q", NamespaceSeparator)
+	}
+	if q.MaxKeys < 0 || q.MaxMemory < 0 {
+		return invalid("quota limits can't be negative")
+	}
+	return nil
+}
+
+// SetNamespaceQuota limits namespace to q from the next write on, replacing
+// any quota it had; the zero quota removes it. A namespace already over a
+// new limit keeps its entries, but writes that would add to it fail.
+func (s *Store) SetNamespaceQuota(namespace string, q NamespaceQuota) error {
+	if err := checkQuota(namespace, q); err != nil {
+		return err
+	}
+
+	s.quota.mu.Lock()
+	defer s.quota.mu.Unlock()
+
+	quotas := maps.Clone(s.quota.quotas)
+	if quotas == nil {
+		quotas = make(map[string]NamespaceQuota)
+	}
+	if q == (NamespaceQuota{}) {
+		delete(quotas, namespace)
+	} else {
+		quotas[namespace] = q
+	}
+	s.quota.quotas = quotas
+	return nil
+}
+
+// ParseNamespaceQuota parses the limits of a quota as the quota commands
+// take them, "keys <n>" and "memory <bytes>" in either order, either left
+// out for no limit
+func ParseNamespaceQuota(words []string) (NamespaceQuota, error) {
+	var q NamespaceQuota
+	for rest := words; len(rest) > 0; rest = rest[2:] {
+		if len(rest) < 2 {
+			return NamespaceQuota{}, invalid("%q in quota needs a limit after it", rest[0])
+		}
+		n, err := strconv.ParseInt(rest[1], 10, 64)
+		if err != nil || n < 0 {
+			return NamespaceQuota{}, invalid("quota limit %q is not a whole number of at least 0", rest[1])
+		}
+		switch {
+		case strings.EqualFold(rest[0], "keys"):
+			q.MaxKeys = n
+		case strings.EqualFold(rest[0], "memory"):
+			q.MaxMemory = n
+		default:
+			return NamespaceQuota{}, invalid("unexpected %q in quota; want keys <n> or memory <bytes>", rest[0])
+		}
+	}
+	return q, nil
+}
+
+// NamespaceQuotas returns the quotas set, by namespace
+func (s *Store) NamespaceQuotas() map[string]NamespaceQuota {
+	return maps.Clone(s.quotaMap())
+}
+
+// quotaMap returns the quotas set, not to be modified
+func (s *Store) quotaMap() map[string]NamespaceQuota {
+	s.quota.mu.RLock()
+	defer s.quota.mu.RUnlock()
+	return s.quota.quotas
+}
+
+// usageOf returns the usage of namespace, creating it if create is set, or
+// nil
+func (s *Store) usageOf(namespace string, create bool) *namespaceUsage {
+	if v, ok := s.quota.usage.Load(namespace); ok {
+		return v.(*namespaceUsage)
+	}
+	if !create {
+		return nil
+	}
+	v, _ := s.quota.usage.LoadOrStore(namespace, new(namespaceUsage))
+	return v.(*namespaceUsage)
+}
+
+// accountNamespace updates the usage of key's namespace for the change of
+// its entry by keys and memory
+func (s *Store) accountNamespace(key string, keys, memory int64) {
+	namespace, _ := SplitNamespace(key)
+	if namespace == "" || (keys == 0 && memory == 0) {
+		return
+	}
+	u := s.usageOf(namespace, true)
+	u.keys.Add(keys)
+	u.memory.Add(memory)
+}
+
+// checkQuotas fails a write of ops that would take a namespace over one of
+// its quotas. Otherwise it returns release, which the caller must call once
+// the write is applied or has failed: until then, other writes growing the
+// namespaces ops grow wait to be checked. Caller must hold the stripes of
+// every key in ops.
+func (s *Store) checkQuotas(ops []logOp) (release func(), err error) {
+	var held []*sync.Mutex
+	release = func() {
+		for _, mu := range held {
+			mu.Unlock()
+		}
+	}
+	quotas := s.quotaMap()
+	if len(quotas) == 0 {
+		return release, nil
+	}
+
+	// Each key's entry as ops leave it, so a key written twice counts once
+	after := make(map[string]map[string]interface{})
+	var order []string
+	for _, op := range ops {
+		namespace, _ := SplitNamespace(op.Key)
+		if _, limited := quotas[namespace]; !limited {
+			continue
+		}
+		prev, seen := after[op.Key]
+		if !seen {
+			prev = s.currentAttrs(op.Key)
+			order = append(order, op.Key)
+		}
+		switch op.Op {
+		case "del":
+			after[op.Key] = nil
+		case "patch":
+			next := maps.Clone(prev)
+			if next == nil {
+				next = make(map[string]interface{}, len(op.Attrs))
+			}
+			maps.Copy(next, op.Attrs)
+			after[op.Key] = next
+		default:
+			after[op.Key] = op.Attrs
+		}
+	}
+
+	type change struct{ keys, memory int64 }
+	changes := make(map[string]*change)
+	for _, key := range order {
+		namespace, _ := SplitNamespace(key)
+		c := changes[namespace]
+		if c == nil {
+			c = new(change)
+			changes[namespace] = c
+		}
+		if prev := s.currentAttrs(key); prev != nil {
+			c.keys--
+			c.memory -= entrySize(key, prev)
+		}
+		if next := after[key]; next != nil {
+			c.keys++
+			c.memory += entrySize(key, next)
+		}
+	}
+
+	namespaces := make([]string, 0, len(changes))
+	for namespace := range changes {
+		namespaces = append(namespaces, namespace)
+	}
+	// Locked in order, so writes growing the same namespaces can't wait
+	// for each other
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		c, q := changes[namespace], quotas[namespace]
+		growsKeys, growsMemory := q.MaxKeys > 0 && c.keys > 0, q.MaxMemory > 0 && c.memory > 0
+		if !growsKeys && !growsMemory {
+			continue
+		}
+		u := s.usageOf(namespace, true)
+		u.grow.Lock()
+		held = append(held, &u.grow)
+		keys, memory := u.keys.Load(), u.memory.Load()
+		if growsKeys && keys+c.keys > q.MaxKeys {
+			release()
+			return nil, &QuotaError{Namespace: namespace, Resource: "keys", Usage: keys + c.keys, Limit: q.MaxKeys}
+		}
+		if growsMemory && memory+c.memory > q.MaxMemory {
+			release()
+			return nil, &QuotaError{Namespace: namespace, Resource: "memory", Usage: memory + c.memory, Limit: q.MaxMemory}
+		}
+	}
+	return release, nil
+}
+
+// currentAttrs returns the attributes of the entry at key, or nil. Caller
+// must hold the key's stripe.
+func (s *Store) currentAttrs(key string) map[string]interface{} {
+	if v, ok := s.data.Load(key); ok {
+		return v.(*entry).attrs
+	}
+	return nil
+}
+
+// NamespaceUsage is what a namespace holds, against its quota
+type NamespaceUsage struct {
+	Namespace   string
+	Keys        int64
+	MemoryBytes int64
+	Quota       NamespaceQuota
+}
+
+// Info returns the usage as a line of the namespace's name and key=value
+// fields, as the quota commands print it
+func (u NamespaceUsage) Info() string {
+	return fmt.Sprintf("%s:keys=%d,max_keys=%d,memory_bytes=%d,max_memory_bytes=%d",
+		u.Namespace, u.Keys, u.Quota.MaxKeys, u.MemoryBytes, u.Quota.MaxMemory)
+}
+
+// NamespaceUsage returns the usage of every namespace, but the default
+// one, that holds entries or has a quota, sorted by name
+func (s *Store) NamespaceUsage() []NamespaceUsage {
+	quotas := s.quotaMap()
+	byName := make(map[string]NamespaceUsage)
+	s.quota.usage.Range(func(k, v interface{}) bool {
+		u := v.(*namespaceUsage)
+		if keys := u.keys.Load(); keys > 0 {
+			namespace := k.(string)
+			byName[namespace] = NamespaceUsage{Namespace: namespace, Keys: keys, MemoryBytes: u.memory.Load(), Quota: quotas[namespace]}
+		}
+		return true
+	})
+	for namespace, q := range quotas {
+		if _, ok := byName[namespace]; !ok {
+			byName[namespace] = NamespaceUsage{Namespace: namespace, Quota: q}
+		}
+	}
+	usage := make([]NamespaceUsage, 0, len(byName))
+	for _, u := range byName {
+		usage = append(usage, u)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Namespace < usage[j].Namespace })
+	return usage
+}
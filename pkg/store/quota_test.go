@@ -0,0 +1,100 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestQuotaRacingWriters puts many new keys of one namespace at once,
+// with each write's sync widening the window between its check and its
+// apply, and checks no more than the quota got in
+func TestQuotaRacingWriters(t *testing.T) {
+	const limit, writers = 5, 40
+	s, err := OpenStore(filepath.Join(t.TempDir(), "data.log"),
+		WithDurability(Fsynced), WithNamespaceQuota("tenant", NamespaceQuota{MaxKeys: limit}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	var wg sync.WaitGroup
+	errs := make([]error, writers)
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.Put("tenant/k"+strconv.Itoa(i), [][]string{{"n", strconv.Itoa(i)}})
+		}()
+	}
+	wg.Wait()
+
+	written := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			written++
+		case !errors.Is(err, ErrQuotaExceeded):
+			t.Fatal(err)
+		}
+	}
+	if written != limit {
+		t.Errorf("%d writes succeeded, want %d", written, limit)
+	}
+	if usage := s.NamespaceUsage(); len(usage) != 1 || usage[0].Keys != limit {
+		t.Errorf("usage = %+v, want %d keys", usage, limit)
+	}
+}
+
+// TestQuotaCopiedWrites takes a namespace over its quota with an import, a
+// bulk load and a changefeed batch, none of which may write anything
+func TestQuotaCopiedWrites(t *testing.T) {
+	src := NewStore()
+	for _, key := range []string{"tenantA/a", "tenantA/b", "tenantA/c"} {
+		if err := src.Put(key, [][]string{{"n", "1"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var doc bytes.Buffer
+	if err := src.ExportJSON(&doc); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, write := range map[string]func(s *Store) error{
+		"import": func(s *Store) error {
+			_, err := s.ImportJSON(bytes.NewReader(doc.Bytes()))
+			return err
+		},
+		"bulk load": func(s *Store) error {
+			b, err := s.BulkLoad()
+			if err != nil {
+				return err
+			}
+			for _, key := range src.Keys() {
+				b.Put(key, [][]string{{"n", "1"}})
+			}
+			_, err = b.Close()
+			return err
+		},
+		"changefeed": func(s *Store) error {
+			var batch ChangeBatch
+			for _, key := range src.Keys() {
+				batch.Changes = append(batch.Changes, Change{Op: "put", Key: key, Attributes: src.Get(key)})
+			}
+			return s.ApplyChanges(batch)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := NewStore(WithNamespaceQuota("tenantA", NamespaceQuota{MaxKeys: 1}))
+			if err := write(s); !errors.Is(err, ErrQuotaExceeded) {
+				t.Fatalf("err = %v, want %v", err, ErrQuotaExceeded)
+			}
+			if keys := s.Keys(); len(keys) != 0 {
+				t.Errorf("keys = %v, want none", keys)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// In Raft mode every write is a proposal to the cluster. The leader
+// validates a write against its applied state, as in single-node mode, and
+// proposes its log ops; once a majority has logged the entry, every node
+// applies it through raftFSM. The raft log index becomes the store's
+// sequence number, so session tokens work across the whole cluster. The
+// type registry only changes when entries are applied, so two writes racing
+// to give a new attribute different types are resolved the same way on
+// every node: the later entry is rejected and its proposer gets the error.
+
+// ErrNotLeader is returned by writes to a Raft node that isn't the leader
+var ErrNotLeader = errors.New("NOTLEADER this node is not the raft leader")
+
+// raftApplyTimeout bounds how long a write waits for its entry to be
+// committed and applied
+const raftApplyTimeout = 10 * time.Second
+
+// RaftConfig configures a store's Raft node
+type RaftConfig struct {
+	NodeID string // unique and stable across restarts
+	Addr   string // TCP address for Raft traffic between nodes
+	Dir    string // where the Raft log, stable state and snapshots live
+
+	// Peers lists the initial cluster as "id=addr" pairs, including this
+	// node. It is only used to bootstrap a node with no Raft state; leave
+	// it empty on nodes that will be added to a running cluster.
+	Peers []string
+}
+
+// RaftNode is a store's membership in a Raft cluster
+type RaftNode struct {
+	store     *Store
+	raft      *raft.Raft
+	files     *raftFileStore
+	transport *raft.NetworkTransport
+}
+
+// StartRaft makes the store a member of a Raft cluster. It must be called
+// on a new store, before the store is shared, and can't be combined with a
+// write log or log replication: the Raft log takes their place.
+func (s *Store) StartRaft(cfg RaftConfig) (*RaftNode, error) {
+	if s.log != nil || s.follower != nil {
+		return nil, errors.New("raft mode can't be combined with a write log or replication")
+	}
+	if cfg.NodeID == "" || cfg.Addr == "" || cfg.Dir == "" {
+		return nil, errors.New("raft mode needs a node ID, address and directory")
+	}
+	servers, err := parseRaftPeers(cfg.Peers)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	conf := raft.DefaultConfig()
+	conf.LocalID = raft.ServerID(cfg.NodeID)
+	conf.LogOutput = os.Stderr
+	conf.LogLevel = "WARN"
+
+	files, err := openRaftFileStore(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := raft.NewFileSnapshotStore(cfg.Dir, 2, os.Stderr)
+	if err != nil {
+		files.Close()
+		return nil, err
+	}
+	advertise, err := net.ResolveTCPAddr("tcp", cfg.Addr)
+	if err != nil {
+		files.Close()
+		return nil, err
+	}
+	transport, err := raft.NewTCPTransport(cfg.Addr, advertise, 3, 10*time.Second, os.Stderr)
+	if err != nil {
+		files.Close()
+		return nil, err
+	}
+
+	if len(servers) > 0 {
+		existing, err := raft.HasExistingState(files, files, snapshots)
+		if err != nil {
+			transport.Close()
+			files.Close()
+			return nil, err
+		}
+		if !existing {
+			if err := raft.BootstrapCluster(conf, files, files, snapshots, transport, raft.Configuration{Servers: servers}); err != nil {
+				transport.Close()
+				files.Close()
+				return nil, err
+			}
+		}
+	}
+
+	n := &RaftNode{store: s, files: files, transport: transport}
+	s.raftNode = n
+	r, err := raft.NewRaft(conf, &raftFSM{store: s}, files, files, snapshots, transport)
+	if err != nil {
+		s.raftNode = nil
+		transport.Close()
+		files.Close()
+		return nil, err
+	}
+	n.raft = r
+	return n, nil
+}
+
+// parseRaftPeers parses "id=addr" pairs into a cluster configuration
+func parseRaftPeers(peers []string) ([]raft.Server, error) {
+	servers := make([]raft.Server, 0, len(peers))
+	for _, peer := range peers {
+		id, addr, ok := strings.Cut(peer, "=")
+		if !ok || id == "" || addr == "" {
+			return nil, fmt.Errorf("raft peer %q is not of the form id=addr", peer)
+		}
+		servers = append(servers, raft.Server{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(id),
+			Address:  raft.ServerAddress(addr),
+		})
+	}
+	return servers, nil
+}
+
+// RaftNode returns the store's Raft node, or nil outside Raft mode
+func (s *Store) RaftNode() *RaftNode {
+	return s.raftNode
+}
+
+// propose replicates ops as one log entry and waits for it to be applied
+func (n *RaftNode) propose(ops []logOp) error {
+	data, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+	f := n.raft.Apply(data, raftApplyTimeout)
+	if err := f.Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			return n.notLeader()
+		}
+		return err
+	}
+	if err, ok := f.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// notLeader returns ErrNotLeader, naming the current leader if one is known
+func (n *RaftNode) notLeader() error {
+	if addr, _ := n.raft.LeaderWithID(); addr != "" {
+		return fmt.Errorf("%w; leader is %s", ErrNotLeader, addr)
+	}
+	return fmt.Errorf("%w; no leader is elected", ErrNotLeader)
+}
+
+// State returns the node's Raft state: Leader, Follower, Candidate or Shutdown
+func (n *RaftNode) State() string {
+	return n.raft.State().String()
+}
+
+// Leader returns the Raft address of the current leader, if one is known
+func (n *RaftNode) Leader() (string, bool) {
+	addr, _ := n.raft.LeaderWithID()
+	return string(addr), addr != ""
+}
+
+// AddVoter adds a node to the cluster; this node must be the leader
+func (n *RaftNode) AddVoter(id, addr string) error {
+	err := n.raft.AddVoter(raft.ServerID(id), raft.ServerAddress(addr), 0, raftApplyTimeout).Error()
+	if errors.Is(err, raft.ErrNotLeader) {
+		return n.notLeader()
+	}
+	return err
+}
+
+// RemoveServer removes a node from the cluster; this node must be the leader
+func (n *RaftNode) RemoveServer(id string) error {
+	err := n.raft.RemoveServer(raft.ServerID(id), 0, raftApplyTimeout).Error()
+	if errors.Is(err, raft.ErrNotLeader) {
+		return n.notLeader()
+	}
+	return err
+}
+
+// Shutdown stops the node. The store keeps its data but rejects writes.
+func (n *RaftNode) Shutdown() error {
+	err := n.raft.Shutdown().Error()
+	if closeErr := n.transport.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := n.files.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// applyRaft applies a committed log entry. The entry's index is consumed
+// even when its values are rejected, so the sequence number always matches
+// the last applied index.
+func (s *Store) applyRaft(rec logRecord) error {
+	// Writers on the leader hold their stripes while they wait for this,
+	// so the applier excludes consistent readers with applyMutex instead.
+	s.applyMutex.Lock()
+	defer s.applyMutex.Unlock()
+
+	entries := make(map[string]map[string]interface{})
+	for _, op := range rec.Ops {
+		if op.Op != "del" {
+			entries[op.Key] = op.Attrs
+		}
+	}
+	s.typesMutex.Lock()
+	pending, err := s.checkValuesLocked(entries)
+	if err == nil {
+		s.addTypes(pending)
+	}
+	s.typesMutex.Unlock()
+
+	s.logMutex.Lock()
+	defer s.logMutex.Unlock()
+	if err == nil {
+		s.applyOps(rec.Ops)
+	}
+	s.advanceSeqLocked(rec.Seq)
+	if err == nil {
+		s.publishLocked(rec)
+	}
+	return err
+}
+
+// raftFSM applies committed entries to the store and snapshots it
+type raftFSM struct {
+	store *Store
+}
+
+func (f *raftFSM) Apply(l *raft.Log) interface{} {
+	var ops []logOp
+	if err := json.Unmarshal(l.Data, &ops); err != nil {
+		return fmt.Errorf("raft entry %d: %w", l.Index, err)
+	}
+	return f.store.applyRaft(logRecord{Seq: l.Index, Ops: ops})
+}
+
+// Snapshot captures the store. Raft never calls it concurrently with Apply,
+// and entries are immutable, so the capture can be written out later while
+// new entries are applied.
+func (f *raftFSM) Snapshot() (raft.FSMSnapshot, error) {
+	return &raftSnapshot{state: f.store.captureState()}, nil
+}
+
+func (f *raftFSM) Restore(r io.ReadCloser) error {
+	defer r.Close()
+
+	f.store.applyMutex.Lock()
+	defer f.store.applyMutex.Unlock()
+	return f.store.restoreSnapshot(r)
+}
+
+// raftSnapshot is a captured store waiting to be persisted
+type raftSnapshot struct {
+	state *storeState
+}
+
+func (rs *raftSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := rs.state.WriteTo(sink); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (rs *raftSnapshot) Release() {}
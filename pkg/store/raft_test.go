@@ -0,0 +1,105 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a loopback address no one is listening on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// TestRaft bootstraps a three-node cluster, writes through its leader and
+// checks every node applies the write while the others refuse writes
+func TestRaft(t *testing.T) {
+	const nodes = 3
+	addrs := make([]string, nodes)
+	peers := make([]string, nodes)
+	for i := range addrs {
+		addrs[i] = freeAddr(t)
+		peers[i] = fmt.Sprintf("n%d=%s", i, addrs[i])
+	}
+	stores := make([]*Store, nodes)
+	dir := t.TempDir()
+	for i := range stores {
+		stores[i] = NewStore()
+		n, err := stores[i].StartRaft(RaftConfig{NodeID: fmt.Sprintf("n%d", i), Addr: addrs[i], Dir: filepath.Join(dir, fmt.Sprint(i)), Peers: peers})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { n.Shutdown() })
+	}
+
+	var leader *Store
+	for deadline := time.Now().Add(10 * time.Second); leader == nil; time.Sleep(50 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("no leader was elected")
+		}
+		for _, s := range stores {
+			if s.RaftNode().State() == "Leader" {
+				leader = s
+			}
+		}
+	}
+	if err := leader.Put("k", [][]string{{"n", "1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i, s := range stores {
+		if err := s.WaitForSeq(ctx, leader.Seq()); err != nil {
+			t.Fatalf("node %d: %v", i, err)
+		}
+		if got := s.Get("k")["n"]; got != 1.0 {
+			t.Errorf("node %d: n = %v, want 1", i, got)
+		}
+		if s == leader {
+			continue
+		}
+		if err := s.Put("k", [][]string{{"n", "2"}}); !errors.Is(err, ErrNotLeader) {
+			t.Errorf("node %d: put on a follower = %v, want ErrNotLeader", i, err)
+		}
+	}
+
+	// A type error is rejected on every node alike
+	if err := leader.Put("k2", [][]string{{"n", "many"}}); err == nil {
+		t.Error("a put breaking n's type was committed")
+	}
+}
+
+// TestStartRaftRejected checks StartRaft refuses an incomplete config, a
+// malformed peer and a store with a write log
+func TestStartRaftRejected(t *testing.T) {
+	dir := t.TempDir()
+	logged, err := OpenStore(filepath.Join(dir, "data.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logged.Close()
+	for name, tc := range map[string]struct {
+		store *Store
+		cfg   RaftConfig
+	}{
+		"no id":     {NewStore(), RaftConfig{Addr: "127.0.0.1:0", Dir: dir}},
+		"bad peer":  {NewStore(), RaftConfig{NodeID: "n0", Addr: "127.0.0.1:0", Dir: dir, Peers: []string{"n0"}}},
+		"write log": {logged, RaftConfig{NodeID: "n0", Addr: "127.0.0.1:0", Dir: dir}},
+	} {
+		if n, err := tc.store.StartRaft(tc.cfg); err == nil {
+			n.Shutdown()
+			t.Errorf("%s: StartRaft succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/hashicorp/raft"
+)
+
+// raftFileStore is the Raft log and stable store of one node. The log is
+// kept in memory and mirrored to an append-only file of JSON lines that is
+// fsynced before StoreLogs returns; the stable store is a small JSON file
+// replaced atomically on every change. Raft compacts its log after each
+// snapshot, so the in-memory copy stays bounded.
+type raftFileStore struct {
+	mu sync.Mutex
+
+	logPath string
+	logFile *os.File
+	first   uint64
+	logs    []*raft.Log // logs[i] has index first+i
+
+	stablePath string
+	stable     map[string][]byte
+}
+
+// openRaftFileStore loads, or creates, the Raft state kept in dir
+func openRaftFileStore(dir string) (*raftFileStore, error) {
+	rs := &raftFileStore{
+		logPath:    filepath.Join(dir, "raft.log"),
+		stablePath: filepath.Join(dir, "raft-stable.json"),
+		stable:     make(map[string][]byte),
+	}
+
+	if data, err := os.ReadFile(rs.stablePath); err == nil {
+		if err := json.Unmarshal(data, &rs.stable); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(rs.logPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		var l raft.Log
+		if err := json.Unmarshal(line, &l); err != nil {
+			file.Close()
+			return nil, err
+		}
+		rs.appendMemory(&l)
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return nil, err
+	}
+	rs.logFile = file
+	return rs, nil
+}
+
+// appendMemory adds l to the in-memory log. A log that does not follow the
+// current last index replaces everything from its index on, which is how
+// a rewritten suffix is recorded in the file. Caller must hold mu.
+func (rs *raftFileStore) appendMemory(l *raft.Log) {
+	if len(rs.logs) == 0 || l.Index < rs.first || l.Index > rs.first+uint64(len(rs.logs)) {
+		rs.first = l.Index
+		rs.logs = rs.logs[:0]
+	} else {
+		rs.logs = rs.logs[:l.Index-rs.first]
+	}
+	rs.logs = append(rs.logs, l)
+}
+
+// IsMonotonic tells Raft the log can't hold gaps, so after installing a
+// snapshot it clears the log instead of leaving a gap before new entries
+func (rs *raftFileStore) IsMonotonic() bool {
+	return true
+}
+
+func (rs *raftFileStore) FirstIndex() (uint64, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if len(rs.logs) == 0 {
+		return 0, nil
+	}
+	return rs.first, nil
+}
+
+func (rs *raftFileStore) LastIndex() (uint64, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if len(rs.logs) == 0 {
+		return 0, nil
+	}
+	return rs.first + uint64(len(rs.logs)) - 1, nil
+}
+
+func (rs *raftFileStore) GetLog(index uint64, log *raft.Log) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if len(rs.logs) == 0 || index < rs.first || index >= rs.first+uint64(len(rs.logs)) {
+		return raft.ErrLogNotFound
+	}
+	*log = *rs.logs[index-rs.first]
+	return nil
+}
+
+func (rs *raftFileStore) StoreLog(log *raft.Log) error {
+	return rs.StoreLogs([]*raft.Log{log})
+}
+
+func (rs *raftFileStore) StoreLogs(logs []*raft.Log) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	w := bufio.NewWriter(rs.logFile)
+	enc := json.NewEncoder(w)
+	for _, l := range logs {
+		copied := *l
+		if err := enc.Encode(&copied); err != nil {
+			return err
+		}
+		rs.appendMemory(&copied)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return rs.logFile.Sync()
+}
+
+// DeleteRange removes logs min through max, inclusive. Raft deletes either a
+// prefix, after a snapshot, or a suffix, on conflict, so the remaining logs
+// are rewritten to a new file that atomically replaces the old one.
+func (rs *raftFileStore) DeleteRange(min, max uint64) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	kept := make([]*raft.Log, 0, len(rs.logs))
+	for _, l := range rs.logs {
+		if l.Index < min || l.Index > max {
+			kept = append(kept, l)
+		}
+	}
+
+	tmpPath := rs.logPath + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, l := range kept {
+		if err := enc.Encode(l); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmpPath, rs.logPath); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	rs.logFile.Close()
+	rs.logFile = tmp
+	rs.logs = rs.logs[:0]
+	for _, l := range kept {
+		rs.appendMemory(l)
+	}
+	return nil
+}
+
+func (rs *raftFileStore) Set(key []byte, val []byte) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.stable[string(key)] = append([]byte(nil), val...)
+	data, err := json.Marshal(rs.stable)
+	if err != nil {
+		return err
+	}
+	tmpPath := rs.stablePath + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, rs.stablePath)
+}
+
+func (rs *raftFileStore) Get(key []byte) ([]byte, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	return rs.stable[string(key)], nil
+}
+
+func (rs *raftFileStore) SetUint64(key []byte, val uint64) error {
+	return rs.Set(key, []byte(strconv.FormatUint(val, 10)))
+}
+
+func (rs *raftFileStore) GetUint64(key []byte) (uint64, error) {
+	val, err := rs.Get(key)
+	if err != nil || len(val) == 0 {
+		return 0, err
+	}
+	return strconv.ParseUint(string(val), 10, 64)
+}
+
+func (rs *raftFileStore) Close() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	return rs.logFile.Close()
+}
@@ -0,0 +1,686 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// ImportRDB imports the hashes of database db of a Redis RDB file, as
+// written by SAVE or BGSAVE, skipping those that had already expired. It
+// reads RDB versions up to 12 (Redis 7.4); hashes with field expiry, new in
+// Redis 7.4, and module values can't be read.
+func (s *Store) ImportRDB(r io.Reader, db int) (*ImportReport, error) {
+	set, err := s.readRDB(r, db)
+	if err != nil {
+		return nil, err
+	}
+	return s.writeImport(set, ImportOptions{})
+}
+
+// readRDB reads and types the hashes of database db of an RDB file
+func (s *Store) readRDB(r io.Reader, db int) (*importSet, error) {
+	hashes, err := readRDBHashes(bufio.NewReader(r), db)
+	if err != nil {
+		return nil, fmt.Errorf("reading RDB: %w", err)
+	}
+	return s.typeHashes(hashes), nil
+}
+
+// RDB opcodes and value types
+const (
+	rdbOpSlotInfo      = 0xf4
+	rdbOpFunction2     = 0xf5
+	rdbOpFunctionPreGA = 0xf6
+	rdbOpModuleAux     = 0xf7
+	rdbOpIdle          = 0xf8
+	rdbOpFreq          = 0xf9
+	rdbOpAux           = 0xfa
+	rdbOpResizeDB      = 0xfb
+	rdbOpExpireMS      = 0xfc
+	rdbOpExpire        = 0xfd
+	rdbOpSelectDB      = 0xfe
+	rdbOpEOF           = 0xff
+
+	rdbString           = 0
+	rdbList             = 1
+	rdbSet              = 2
+	rdbZSet             = 3
+	rdbHash             = 4
+	rdbZSet2            = 5
+	rdbModule           = 6
+	rdbModule2          = 7
+	rdbHashZipmap       = 9
+	rdbListZiplist      = 10
+	rdbSetIntset        = 11
+	rdbZSetZiplist      = 12
+	rdbHashZiplist      = 13
+	rdbListQuicklist    = 14
+	rdbStreamListpacks  = 15
+	rdbHashListpack     = 16
+	rdbZSetListpack     = 17
+	rdbListQuicklist2   = 18
+	rdbStreamListpacks2 = 19
+	rdbSetListpack      = 20
+	rdbStreamListpacks3 = 21
+
+	rdbHashMetadataPreGA   = 22
+	rdbHashListpackExPreGA = 23
+	rdbHashMetadata        = 24
+	rdbHashListpackEx      = 25
+)
+
+// rdbMaxString bounds the strings read, so a corrupt length fails cleanly
+const rdbMaxString = 512 << 20
+
+var errRDBCorrupt = errors.New("corrupt RDB file")
+
+// readRDBHashes returns the unexpired hashes of database db, as field/value
+// pairs keyed by key
+func readRDBHashes(r *bufio.Reader, db int) (map[string][][]string, error) {
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if string(header[:5]) != "REDIS" || err != nil {
+		return nil, errors.New("not an RDB file")
+	}
+	if version > 12 {
+		return nil, fmt.Errorf("RDB version %d is newer than the 12 supported", version)
+	}
+
+	d := &rdbReader{r: r}
+	hashes := make(map[string][][]string)
+	current := 0
+	var expireAt time.Time
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case rdbOpEOF:
+			return hashes, nil
+		case rdbOpAux:
+			if _, err = d.string(); err == nil {
+				_, err = d.string()
+			}
+		case rdbOpSelectDB:
+			var n uint64
+			n, err = d.length()
+			current = int(n)
+		case rdbOpResizeDB:
+			if _, err = d.length(); err == nil {
+				_, err = d.length()
+			}
+		case rdbOpSlotInfo:
+			for i := 0; i < 3 && err == nil; i++ {
+				_, err = d.length()
+			}
+		case rdbOpExpire:
+			var b []byte
+			if b, err = d.bytes(4); err == nil {
+				expireAt = time.Unix(int64(binary.LittleEndian.Uint32(b)), 0)
+			}
+		case rdbOpExpireMS:
+			var b []byte
+			if b, err = d.bytes(8); err == nil {
+				expireAt = time.UnixMilli(int64(binary.LittleEndian.Uint64(b)))
+			}
+		case rdbOpIdle:
+			_, err = d.length()
+		case rdbOpFreq:
+			_, err = r.ReadByte()
+		case rdbOpFunction2:
+			_, err = d.string()
+		case rdbOpFunctionPreGA, rdbOpModuleAux:
+			return nil, errors.New("RDB holds module or pre-release function data, which can't be read")
+		default:
+			var key string
+			if key, err = d.string(); err != nil {
+				break
+			}
+			var pairs [][]string
+			if pairs, err = d.value(op); err != nil {
+				return nil, fmt.Errorf("key %q: %w", key, err)
+			}
+			expired := !expireAt.IsZero() && expireAt.Before(time.Now())
+			if pairs != nil && current == db && !expired {
+				hashes[key] = pairs
+			}
+			expireAt = time.Time{}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// rdbReader decodes the encodings of an RDB file
+type rdbReader struct {
+	r *bufio.Reader
+}
+
+func (d *rdbReader) bytes(n int) ([]byte, error) {
+	if n < 0 || n > rdbMaxString {
+		return nil, errRDBCorrupt
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(d.r, b)
+	return b, err
+}
+
+// length reads a length, failing on the special string encodings
+func (d *rdbReader) length() (uint64, error) {
+	n, special, err := d.lengthOrEncoding()
+	if err == nil && special {
+		err = errRDBCorrupt
+	}
+	return n, err
+}
+
+// lengthOrEncoding reads a length or, if special, the format of an encoded
+// string
+func (d *rdbReader) lengthOrEncoding() (n uint64, special bool, err error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+	case 1:
+		next, err := d.r.ReadByte()
+		return uint64(b&0x3f)<<8 | uint64(next), false, err
+	case 3:
+		return uint64(b & 0x3f), true, nil
+	}
+	switch b {
+	case 0x80:
+		buf, err := d.bytes(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(buf)), false, nil
+	case 0x81:
+		buf, err := d.bytes(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(buf), false, nil
+	}
+	return 0, false, errRDBCorrupt
+}
+
+// string reads a string in any of its encodings: raw, as an integer, or
+// LZF-compressed
+func (d *rdbReader) string() (string, error) {
+	n, special, err := d.lengthOrEncoding()
+	if err != nil {
+		return "", err
+	}
+	if !special {
+		b, err := d.bytes(int(min(n, rdbMaxString+1)))
+		return string(b), err
+	}
+	switch n {
+	case 0, 1, 2:
+		b, err := d.bytes(1 << n)
+		if err != nil {
+			return "", err
+		}
+		var v int64
+		switch n {
+		case 0:
+			v = int64(int8(b[0]))
+		case 1:
+			v = int64(int16(binary.LittleEndian.Uint16(b)))
+		case 2:
+			v = int64(int32(binary.LittleEndian.Uint32(b)))
+		}
+		return strconv.FormatInt(v, 10), nil
+	case 3:
+		compressed, err := d.length()
+		if err != nil {
+			return "", err
+		}
+		size, err := d.length()
+		if err != nil {
+			return "", err
+		}
+		b, err := d.bytes(int(min(compressed, rdbMaxString+1)))
+		if err != nil {
+			return "", err
+		}
+		if size > rdbMaxString {
+			return "", errRDBCorrupt
+		}
+		return lzfDecompress(b, int(size))
+	}
+	return "", errRDBCorrupt
+}
+
+// value reads a value of type t, returning its field/value pairs if it is a
+// hash and nil otherwise
+func (d *rdbReader) value(t byte) ([][]string, error) {
+	switch t {
+	case rdbString:
+		_, err := d.string()
+		return nil, err
+	case rdbList, rdbSet:
+		return nil, d.skipStrings(1)
+	case rdbZSet:
+		n, err := d.length()
+		for ; n > 0 && err == nil; n-- {
+			if _, err = d.string(); err == nil {
+				err = d.skipDouble()
+			}
+		}
+		return nil, err
+	case rdbZSet2:
+		n, err := d.length()
+		for ; n > 0 && err == nil; n-- {
+			if _, err = d.string(); err == nil {
+				_, err = d.bytes(8)
+			}
+		}
+		return nil, err
+	case rdbHash:
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		pairs := make([][]string, 0, min(n, 1024))
+		for ; n > 0; n-- {
+			field, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, []string{field, value})
+		}
+		return pairs, nil
+	case rdbHashZipmap, rdbHashZiplist, rdbHashListpack:
+		blob, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		var items []string
+		switch t {
+		case rdbHashZipmap:
+			items, err = zipmapItems([]byte(blob))
+		case rdbHashZiplist:
+			items, err = ziplistItems([]byte(blob))
+		default:
+			items, err = listpackItems([]byte(blob))
+		}
+		if err != nil || len(items)%2 != 0 {
+			return nil, errRDBCorrupt
+		}
+		pairs := make([][]string, 0, len(items)/2)
+		for i := 0; i < len(items); i += 2 {
+			pairs = append(pairs, []string{items[i], items[i+1]})
+		}
+		return pairs, nil
+	case rdbListZiplist, rdbSetIntset, rdbZSetZiplist, rdbZSetListpack, rdbSetListpack:
+		_, err := d.string()
+		return nil, err
+	case rdbListQuicklist:
+		return nil, d.skipStrings(1)
+	case rdbListQuicklist2:
+		n, err := d.length()
+		for ; n > 0 && err == nil; n-- {
+			if _, err = d.length(); err == nil { // container kind
+				_, err = d.string()
+			}
+		}
+		return nil, err
+	case rdbStreamListpacks, rdbStreamListpacks2, rdbStreamListpacks3:
+		return nil, d.skipStream(t)
+	case rdbModule, rdbModule2:
+		return nil, errors.New("module values can't be read")
+	case rdbHashMetadataPreGA, rdbHashListpackExPreGA, rdbHashMetadata, rdbHashListpackEx:
+		return nil, errors.New("hashes with field expiry can't be read")
+	}
+	return nil, fmt.Errorf("unsupported RDB value type %d", t)
+}
+
+// skipStrings skips a length followed by per strings per element
+func (d *rdbReader) skipStrings(per int) error {
+	n, err := d.length()
+	for ; n > 0 && err == nil; n-- {
+		for i := 0; i < per && err == nil; i++ {
+			_, err = d.string()
+		}
+	}
+	return err
+}
+
+// skipDouble skips a double stored as a length-prefixed string
+func (d *rdbReader) skipDouble() error {
+	n, err := d.r.ReadByte()
+	if err == nil && n < 253 {
+		_, err = d.bytes(int(n))
+	}
+	return err
+}
+
+// skipLengths skips n lengths
+func (d *rdbReader) skipLengths(n int) error {
+	var err error
+	for i := 0; i < n && err == nil; i++ {
+		_, err = d.length()
+	}
+	return err
+}
+
+// skipStream skips a stream of type t
+func (d *rdbReader) skipStream(t byte) error {
+	if err := d.skipStrings(2); err != nil { // listpacks with their master IDs
+		return err
+	}
+	ids := 3 // length and last ID
+	if t >= rdbStreamListpacks2 {
+		ids += 5 // first ID, max deleted ID, entries added
+	}
+	if err := d.skipLengths(ids); err != nil {
+		return err
+	}
+
+	groups, err := d.length()
+	for ; groups > 0 && err == nil; groups-- {
+		if _, err = d.string(); err != nil {
+			break
+		}
+		lengths := 2 // last delivered ID
+		if t >= rdbStreamListpacks2 {
+			lengths++ // entries read
+		}
+		if err = d.skipLengths(lengths); err != nil {
+			break
+		}
+		var pending uint64
+		if pending, err = d.length(); err != nil {
+			break
+		}
+		for ; pending > 0 && err == nil; pending-- {
+			if _, err = d.bytes(16 + 8); err == nil { // ID, delivery time
+				_, err = d.length() // delivery count
+			}
+		}
+		var consumers uint64
+		if err == nil {
+			consumers, err = d.length()
+		}
+		for ; consumers > 0 && err == nil; consumers-- {
+			if _, err = d.string(); err != nil {
+				break
+			}
+			times := 8 // seen time
+			if t >= rdbStreamListpacks3 {
+				times += 8 // active time
+			}
+			if _, err = d.bytes(times); err != nil {
+				break
+			}
+			var owned uint64
+			if owned, err = d.length(); err == nil {
+				_, err = d.bytes(int(min(owned, rdbMaxString/16)) * 16)
+			}
+		}
+	}
+	return err
+}
+
+// lzfDecompress expands LZF-compressed data to its size
+func lzfDecompress(in []byte, size int) (string, error) {
+	out := make([]byte, 0, size)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 { // a run of ctrl+1 literal bytes
+			n := ctrl + 1
+			if i+n > len(in) {
+				return "", errRDBCorrupt
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+		// A back reference
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return "", errRDBCorrupt
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return "", errRDBCorrupt
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return "", errRDBCorrupt
+		}
+		for j := 0; j < n+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != size {
+		return "", errRDBCorrupt
+	}
+	return string(out), nil
+}
+
+// ziplistItems returns the entries of a ziplist as strings
+func ziplistItems(b []byte) ([]string, error) {
+	if len(b) < 11 {
+		return nil, errRDBCorrupt
+	}
+	var items []string
+	for i := 10; ; {
+		if i >= len(b) {
+			return nil, errRDBCorrupt
+		}
+		if b[i] == 0xff {
+			return items, nil
+		}
+		// Previous entry's length
+		if b[i] == 0xfe {
+			i += 5
+		} else {
+			i++
+		}
+		if i >= len(b) {
+			return nil, errRDBCorrupt
+		}
+		enc := b[i]
+		var n int // string length, or -1 for an integer of size bytes
+		size := 0
+		switch {
+		case enc>>6 == 0:
+			n, i = int(enc&0x3f), i+1
+		case enc>>6 == 1:
+			if i+2 > len(b) {
+				return nil, errRDBCorrupt
+			}
+			n, i = int(enc&0x3f)<<8|int(b[i+1]), i+2
+		case enc == 0x80:
+			if i+5 > len(b) {
+				return nil, errRDBCorrupt
+			}
+			n, i = int(binary.BigEndian.Uint32(b[i+1:])), i+5
+		case enc == 0xc0:
+			n, size = -1, 2
+		case enc == 0xd0:
+			n, size = -1, 4
+		case enc == 0xe0:
+			n, size = -1, 8
+		case enc == 0xf0:
+			n, size = -1, 3
+		case enc == 0xfe:
+			n, size = -1, 1
+		case enc >= 0xf1 && enc <= 0xfd:
+			items = append(items, strconv.Itoa(int(enc&0x0f)-1))
+			i++
+			continue
+		default:
+			return nil, errRDBCorrupt
+		}
+		if n < 0 {
+			i++
+			if i+size > len(b) {
+				return nil, errRDBCorrupt
+			}
+			items = append(items, strconv.FormatInt(littleEndianInt(b[i:i+size]), 10))
+			i += size
+			continue
+		}
+		if n > len(b)-i {
+			return nil, errRDBCorrupt
+		}
+		items = append(items, string(b[i:i+n]))
+		i += n
+	}
+}
+
+// listpackItems returns the entries of a listpack as strings
+func listpackItems(b []byte) ([]string, error) {
+	if len(b) < 7 {
+		return nil, errRDBCorrupt
+	}
+	var items []string
+	for i := 6; ; {
+		if i >= len(b) {
+			return nil, errRDBCorrupt
+		}
+		enc := b[i]
+		if enc == 0xff {
+			return items, nil
+		}
+		start := i
+		var item string
+		switch {
+		case enc>>7 == 0: // 7-bit unsigned integer
+			item, i = strconv.Itoa(int(enc)), i+1
+		case enc>>6 == 2: // string of up to 63 bytes
+			n := int(enc & 0x3f)
+			if i+1+n > len(b) {
+				return nil, errRDBCorrupt
+			}
+			item, i = string(b[i+1:i+1+n]), i+1+n
+		case enc>>5 == 6: // 13-bit signed integer
+			if i+2 > len(b) {
+				return nil, errRDBCorrupt
+			}
+			v := int(enc&0x1f)<<8 | int(b[i+1])
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			item, i = strconv.Itoa(v), i+2
+		case enc>>4 == 0xe: // string of up to 4095 bytes
+			if i+2 > len(b) {
+				return nil, errRDBCorrupt
+			}
+			n := int(enc&0x0f)<<8 | int(b[i+1])
+			if i+2+n > len(b) {
+				return nil, errRDBCorrupt
+			}
+			item, i = string(b[i+2:i+2+n]), i+2+n
+		case enc == 0xf0: // string with a 32-bit length
+			if i+5 > len(b) {
+				return nil, errRDBCorrupt
+			}
+			n := int(binary.LittleEndian.Uint32(b[i+1:]))
+			if n < 0 || n > len(b)-i-5 {
+				return nil, errRDBCorrupt
+			}
+			item, i = string(b[i+5:i+5+n]), i+5+n
+		case enc >= 0xf1 && enc <= 0xf4: // 16, 24, 32 or 64-bit integer
+			size := map[byte]int{0xf1: 2, 0xf2: 3, 0xf3: 4, 0xf4: 8}[enc]
+			if i+1+size > len(b) {
+				return nil, errRDBCorrupt
+			}
+			item, i = strconv.FormatInt(littleEndianInt(b[i+1:i+1+size]), 10), i+1+size
+		default:
+			return nil, errRDBCorrupt
+		}
+		items = append(items, item)
+
+		// Skip the entry's length, stored backwards after it
+		switch n := i - start; {
+		case n < 1<<7:
+			i++
+		case n < 1<<14:
+			i += 2
+		case n < 1<<21:
+			i += 3
+		case n < 1<<28:
+			i += 4
+		default:
+			i += 5
+		}
+	}
+}
+
+// zipmapItems returns the keys and values of a zipmap, in order
+func zipmapItems(b []byte) ([]string, error) {
+	var items []string
+	length := func(i int) (int, int, error) {
+		if i >= len(b) {
+			return 0, 0, errRDBCorrupt
+		}
+		if b[i] < 254 {
+			return int(b[i]), i + 1, nil
+		}
+		if b[i] == 254 && i+5 <= len(b) {
+			return int(binary.LittleEndian.Uint32(b[i+1:])), i + 5, nil
+		}
+		return 0, 0, errRDBCorrupt
+	}
+	for i := 1; ; {
+		if i >= len(b) {
+			return nil, errRDBCorrupt
+		}
+		if b[i] == 0xff {
+			return items, nil
+		}
+		n, next, err := length(i)
+		if err != nil || n > len(b)-next {
+			return nil, errRDBCorrupt
+		}
+		items = append(items, string(b[next:next+n]))
+		i = next + n
+
+		if n, next, err = length(i); err != nil || next >= len(b) {
+			return nil, errRDBCorrupt
+		}
+		free := int(b[next])
+		next++
+		if n+free > len(b)-next {
+			return nil, errRDBCorrupt
+		}
+		items = append(items, string(b[next:next+n]))
+		i = next + n + free
+	}
+}
+
+// littleEndianInt decodes a signed little-endian integer of len(b) bytes
+func littleEndianInt(b []byte) int64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	shift := 64 - 8*uint(len(b))
+	return int64(v<<shift) >> shift
+}
@@ -0,0 +1,215 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// The fixtures are assembled byte by byte after the RDB file format as
+// Redis writes it (rdb.h, ziplist.c, listpack.c, zipmap.c), each encoding
+// spelled out so a reader bug can't cancel out a writer bug.
+
+func rdbLen(n int) []byte {
+	switch {
+	case n < 1<<6:
+		return []byte{byte(n)}
+	case n < 1<<14:
+		return []byte{0x40 | byte(n>>8), byte(n)}
+	}
+	return binary.BigEndian.AppendUint32([]byte{0x80}, uint32(n))
+}
+
+func rdbStr(s string) []byte { return append(rdbLen(len(s)), s...) }
+
+// rdbInt8 is a string stored as an 8-bit integer
+func rdbInt8(v int8) []byte { return []byte{0xc0, byte(v)} }
+
+// rdbInt32 is a string stored as a 32-bit integer
+func rdbInt32(v int32) []byte { return binary.LittleEndian.AppendUint32([]byte{0xc2}, uint32(v)) }
+
+func rdbCat(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+// ziplist encodes strings and small integers as a ziplist
+func ziplist(items ...interface{}) []byte {
+	var entries []byte
+	prev := 0
+	for _, item := range items {
+		var e []byte
+		if prev < 254 {
+			e = []byte{byte(prev)}
+		} else {
+			e = binary.LittleEndian.AppendUint32([]byte{0xfe}, uint32(prev))
+		}
+		switch v := item.(type) {
+		case string:
+			e = append(append(e, byte(len(v))), v...) // 00pppppp
+		case int:
+			if v >= 0 && v <= 12 {
+				e = append(e, 0xf1+byte(v)) // 1111xxxx, xxxx-1 the value
+			} else {
+				e = binary.LittleEndian.AppendUint16(append(e, 0xc0), uint16(v)) // int16
+			}
+		}
+		entries = append(entries, e...)
+		prev = len(e)
+	}
+	b := binary.LittleEndian.AppendUint32(nil, uint32(10+len(entries)+1))
+	b = binary.LittleEndian.AppendUint32(b, uint32(10+len(entries)-prev))
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(items)))
+	return append(append(b, entries...), 0xff)
+}
+
+// listpack encodes strings and integers as a listpack
+func listpack(items ...interface{}) []byte {
+	var entries []byte
+	for _, item := range items {
+		var e []byte
+		switch v := item.(type) {
+		case string:
+			if len(v) < 64 {
+				e = append([]byte{0x80 | byte(len(v))}, v...) // 10xxxxxx
+			} else {
+				e = append([]byte{0xe0 | byte(len(v)>>8), byte(len(v))}, v...) // 1110xxxx
+			}
+		case int:
+			switch {
+			case v >= 0 && v < 128:
+				e = []byte{byte(v)} // 0xxxxxxx
+			case v >= -4096 && v < 4096:
+				u := uint16(v) & 0x1fff
+				e = []byte{0xc0 | byte(u>>8), byte(u)} // 110xxxxx
+			default:
+				e = binary.LittleEndian.AppendUint32([]byte{0xf3}, uint32(int32(v)))
+			}
+		}
+		backlen := len(e)
+		if backlen < 128 {
+			e = append(e, byte(backlen))
+		} else {
+			e = append(e, byte(backlen>>7), 0x80|byte(backlen&0x7f))
+		}
+		entries = append(entries, e...)
+	}
+	b := binary.LittleEndian.AppendUint32(nil, uint32(6+len(entries)+1))
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(items)))
+	return append(append(b, entries...), 0xff)
+}
+
+// zipmap encodes pairs as a zipmap, the pre-2.6 hash encoding, with free
+// bytes after each value as an updated zipmap has
+func zipmap(pairs ...string) []byte {
+	b := []byte{byte(len(pairs) / 2)}
+	for i := 0; i < len(pairs); i += 2 {
+		b = append(append(b, byte(len(pairs[i]))), pairs[i]...)
+		b = append(append(b, byte(len(pairs[i+1])), 2), pairs[i+1]...)
+		b = append(b, 0, 0)
+	}
+	return append(b, 0xff)
+}
+
+func rdbFile(body ...[]byte) []byte {
+	return rdbCat(
+		[]byte("REDIS0011"),
+		[]byte{rdbOpAux}, rdbStr("redis-ver"), rdbStr("7.2.4"),
+		[]byte{rdbOpAux}, rdbStr("redis-bits"), rdbInt8(64),
+		rdbCat(body...),
+		[]byte{rdbOpEOF}, make([]byte, 8), // a checksum of 0, as with rdbchecksum no
+	)
+}
+
+func fixtureRDB() []byte {
+	future := binary.LittleEndian.AppendUint64([]byte{rdbOpExpireMS}, uint64(time.Now().Add(time.Hour).UnixMilli()))
+	past := binary.LittleEndian.AppendUint64([]byte{rdbOpExpireMS}, uint64(time.Now().Add(-time.Hour).UnixMilli()))
+	past32 := binary.LittleEndian.AppendUint32([]byte{rdbOpExpire}, uint32(time.Now().Add(-time.Hour).Unix()))
+
+	// "aaaaaaaaaa" LZF-compressed: one literal, then a back reference of
+	// 7+2 bytes at distance 1
+	lzf := rdbCat([]byte{0xc3}, rdbLen(5), rdbLen(10), []byte{0x00, 'a', 0xe0, 0x00, 0x00})
+
+	return rdbFile(
+		[]byte{rdbOpSelectDB}, rdbLen(0),
+		[]byte{rdbOpResizeDB}, rdbLen(9), rdbLen(2),
+
+		// A hash in the plain encoding, a value stored as an integer
+		[]byte{rdbHash}, rdbStr("user:1"), rdbLen(3),
+		rdbStr("name"), rdbStr("ann"),
+		rdbStr("age"), rdbInt8(30),
+		rdbStr("bio"), lzf,
+		// Listpack, with small, 13-bit and 32-bit integers
+		[]byte{rdbHashListpack}, rdbStr("user:2"), rdbStr(string(listpack("name", "bob", "age", 41, "score", -300, "big", 100000))),
+		// Ziplist, with an expiry still ahead
+		future, []byte{rdbHashZiplist}, rdbStr("user:3"), rdbStr(string(ziplist("name", "cy", "level", 7, "rank", 1000))),
+		// Zipmap
+		[]byte{rdbHashZipmap}, rdbStr("user:4"), rdbStr(string(zipmap("name", "di", "admin", "true"))),
+		// A listpack string past 63 bytes
+		[]byte{rdbHashListpack}, rdbStr("long"), rdbStr(string(listpack("text", string(bytes.Repeat([]byte("x"), 200))))),
+
+		// Expired hashes are skipped
+		past, []byte{rdbHash}, rdbStr("gone:ms"), rdbLen(1), rdbStr("a"), rdbStr("b"),
+		past32, []byte{rdbHashListpack}, rdbStr("gone:s"), rdbStr(string(listpack("a", "b"))),
+
+		// Other types are skipped, with their idle time or frequency
+		[]byte{rdbOpIdle}, rdbLen(5), []byte{rdbString}, rdbStr("plain"), rdbInt32(123456),
+		[]byte{rdbOpFreq, 3}, []byte{rdbSetListpack}, rdbStr("tags"), rdbStr(string(listpack("x", "y"))),
+		[]byte{rdbListQuicklist2}, rdbStr("queue"), rdbLen(1), rdbLen(2), rdbStr(string(listpack("job1", "job2"))),
+		[]byte{rdbZSet2}, rdbStr("board"), rdbLen(1), rdbStr("p1"), make([]byte, 8),
+
+		// Database 1
+		[]byte{rdbOpSelectDB}, rdbLen(1),
+		[]byte{rdbHash}, rdbStr("other"), rdbLen(1), rdbStr("db"), rdbStr("one"),
+	)
+}
+
+func TestImportRDB(t *testing.T) {
+	s := NewStore()
+	report, err := s.ImportRDB(bytes.NewReader(fixtureRDB()), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]interface{}{
+		"user:1": {"name": "ann", "age": 30.0, "bio": "aaaaaaaaaa"},
+		"user:2": {"name": "bob", "age": 41.0, "score": -300.0, "big": 100000.0},
+		"user:3": {"name": "cy", "level": 7.0, "rank": 1000.0},
+		"user:4": {"name": "di", "admin": true},
+		"long":   {"text": string(bytes.Repeat([]byte("x"), 200))},
+	}
+	if report.Imported != len(want) {
+		t.Errorf("imported %d entries, want %d", report.Imported, len(want))
+	}
+	for key, attrs := range want {
+		if got := s.Get(key); !reflect.DeepEqual(got, attrs) {
+			t.Errorf("%s = %v, want %v", key, got, attrs)
+		}
+	}
+	if n := s.Len(); n != len(want) {
+		t.Errorf("store holds %d entries, want %d", n, len(want))
+	}
+
+	other := NewStore()
+	if _, err := other.ImportRDB(bytes.NewReader(fixtureRDB()), 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := other.Get("other"); !reflect.DeepEqual(got, map[string]interface{}{"db": "one"}) || other.Len() != 1 {
+		t.Errorf("database 1 = %v, %d entries", got, other.Len())
+	}
+}
+
+func TestImportRDBErrors(t *testing.T) {
+	valid := fixtureRDB()
+	for name, data := range map[string][]byte{
+		"not rdb":   []byte("RESP0011\xff"),
+		"too new":   []byte("REDIS0013\xff"),
+		"truncated": valid[:len(valid)/2],
+		"bad lzf":   rdbFile([]byte{rdbHash}, rdbStr("k"), rdbLen(1), rdbStr("f"), []byte{0xc3}, rdbLen(3), rdbLen(10), []byte{0x01, 'a', 'b'}),
+		"ziplist":   rdbFile([]byte{rdbHashZiplist}, rdbStr("k"), rdbStr("\x0b\x00\x00\x00")),
+		"module":    rdbFile([]byte{rdbModule2}, rdbStr("k")),
+		"field ttl": rdbFile([]byte{rdbHashListpackEx}, rdbStr("k")),
+	} {
+		if _, err := NewStore().ImportRDB(bytes.NewReader(data), 0); err == nil {
+			t.Errorf("%s: import succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// When the ring changes, because a node joined or left, some keys end up on
+// nodes that no longer own them. Each node's rebalancer finds the keys it
+// holds but doesn't own and moves them one at a time, at a limited rate, to
+// their owners with the internal command "local migrate". A node that is
+// moving keys says so in its gossip. Meanwhile clients keep using the
+// cluster: the owner of a key it doesn't have yet asks the nodes that may
+// still hold it, those gossiping that they are moving keys and, for a while
+// after a ring change, every node, to hand it over first with "local
+// handoff", so reads find the key and writes apply to its latest version. A
+// move holds the key's lock on the sending node until the owner has stored
+// it, and the owner keeps its own copy if it already has one, which is then
+// newer.
+
+// rebalanceRetry is how often a node rescans for keys it doesn't own, so
+// moves that failed are retried
+const rebalanceRetry = 5 * time.Second
+
+// handoffGrace is how long after a ring change a node asks every other node
+// for keys it owns but doesn't have, covering nodes that haven't heard of
+// the change yet
+const handoffGrace = nodeDeadAfter
+
+// rebalancer moves keys a node holds to the nodes that own them
+type rebalancer struct {
+	cluster *Cluster
+	store   *Store
+	rate    int // keys moved per second, 0 for no limit
+
+	wake chan struct{}
+	done chan struct{}
+
+	mu      sync.Mutex
+	pending int    // keys left to move in the current pass
+	moved   uint64 // keys moved since the node started, including handoffs
+	lastErr error
+}
+
+// StartRebalance makes the node move keys it holds but doesn't own to their
+// owners whenever the ring changes, at up to rate keys a second, or without a
+// limit if rate is 0. It also lets the node hand keys over to owners that
+// ask for them.
+func (c *Cluster) StartRebalance(store *Store, rate int) {
+	r := &rebalancer{
+		cluster: c,
+		store:   store,
+		rate:    rate,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	c.mu.Lock()
+	c.rebalancer = r
+	c.mu.Unlock()
+
+	r.kick()
+	go r.run()
+}
+
+// kick starts a pass as soon as the current one, if any, is over
+func (r *rebalancer) kick() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *rebalancer) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(rebalanceRetry)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.cluster.stop:
+			return
+		case <-r.wake:
+		case <-ticker.C:
+		}
+		r.pass()
+	}
+}
+
+// pass moves every key the node holds but doesn't own
+func (r *rebalancer) pass() {
+	var foreign []string
+	for _, key := range r.store.Keys() {
+		if !r.cluster.Owns(key) {
+			foreign = append(foreign, key)
+		}
+	}
+	r.mu.Lock()
+	r.pending = len(foreign)
+	r.mu.Unlock()
+	if len(foreign) == 0 {
+		r.cluster.setMigrating(false)
+		return
+	}
+	r.cluster.setMigrating(true)
+
+	var interval time.Duration
+	if r.rate > 0 {
+		interval = time.Second / time.Duration(r.rate)
+	}
+	next := time.Now()
+	failed := 0
+	for _, key := range foreign {
+		if interval > 0 {
+			select {
+			case <-r.cluster.stop:
+				return
+			case <-time.After(time.Until(next)):
+			}
+			if next = next.Add(interval); next.Before(time.Now()) {
+				next = time.Now()
+			}
+		}
+
+		_, err := r.move(key, r.cluster.Owner(key))
+		r.mu.Lock()
+		if err != nil {
+			// The key stays pending until a later pass moves it
+			failed++
+			r.lastErr = fmt.Errorf("moving %q: %w", key, err)
+		} else {
+			r.pending--
+		}
+		r.mu.Unlock()
+	}
+	if failed == 0 {
+		r.mu.Lock()
+		r.lastErr = nil
+		r.mu.Unlock()
+		r.cluster.setMigrating(false)
+	}
+}
+
+// move sends key to node to and removes it here, reporting whether the node
+// held it
+func (r *rebalancer) move(key, to string) (bool, error) {
+	if to == r.cluster.self {
+		return false, nil
+	}
+	unlock := r.store.lockKeys([]string{key})
+	defer unlock()
+
+	current, exists := r.store.GetEntry(key)
+	if !exists {
+		return false, nil
+	}
+	attrs := make([]string, 0, len(current.Attributes))
+	for attrKey := range current.Attributes {
+		attrs = append(attrs, attrKey)
+	}
+	sort.Strings(attrs)
+	args := []string{"migrate", key, strconv.FormatUint(current.Version, 10)}
+	for _, attrKey := range attrs {
+		args = append(args, attrKey, rawValue(current.Attributes[attrKey]))
+	}
+
+	reply, err := r.cluster.forward(to, args)
+	if err != nil {
+		return false, err
+	}
+	if reply, ok := reply.(respError); ok {
+		return false, fmt.Errorf("node %s: %s", to, reply)
+	}
+	if err := r.store.commitRecord([]logOp{{Op: "del", Key: key}}, r.store.defaultDurability()); err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	r.moved++
+	r.mu.Unlock()
+	return true, nil
+}
+
+// setMigrating sets the flag this node gossips while it is moving keys
+func (c *Cluster) setMigrating(migrating bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.peers[c.self].migrating = migrating
+}
+
+// handoff moves key, if this node holds it, to node to, which owns it
+func (c *Cluster) handoff(key, to string) (bool, error) {
+	c.mu.RLock()
+	r := c.rebalancer
+	_, known := c.peers[to]
+	c.mu.RUnlock()
+
+	if r == nil {
+		return false, errors.New("rebalancing is not running on this node")
+	}
+	if !known {
+		return false, fmt.Errorf("unknown cluster node %q", to)
+	}
+	return r.move(key, to)
+}
+
+// pull asks the nodes that may still hold key, which this node owns, to
+// hand it over, unless the node already has it. It is best effort: a node
+// that can't be asked is skipped.
+func (c *Cluster) pull(store *Store, key string) {
+	if _, exists := store.GetEntry(key); exists {
+		return
+	}
+
+	c.mu.RLock()
+	now := time.Now()
+	grace := now.Sub(c.ringChanged) < handoffGrace
+	var sources []*clusterPeer
+	for id, p := range c.peers {
+		if id != c.self && (grace || p.migrating) && c.stateLocked(p, now) != nodeDead {
+			sources = append(sources, p)
+		}
+	}
+	c.mu.RUnlock()
+
+	for _, p := range sources {
+		if reply, err := p.do([]string{"local", "handoff", key, c.self}); err == nil && reply == int64(1) {
+			return
+		}
+	}
+}
+
+// Leave takes this node off the ring. Its rebalancer then moves all of its
+// keys to the remaining nodes; once "rebalance" shows none are pending the
+// node can be shut down. A node that has left can't rejoin under the same ID.
+func (c *Cluster) Leave() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	remaining := 0
+	for id, p := range c.peers {
+		if id != c.self && !p.left {
+			remaining++
+		}
+	}
+	if remaining == 0 {
+		return errors.New("the last node of a cluster can't leave it")
+	}
+	me := c.peers[c.self]
+	me.left, me.migrating = true, true
+	c.rebuildRingLocked()
+	return nil
+}
+
+// RebalanceInfo reports rebalancing progress as "name:value" lines
+func (c *Cluster) RebalanceInfo() []string {
+	c.mu.RLock()
+	r := c.rebalancer
+	me := c.peers[c.self]
+	state := "idle"
+	switch {
+	case me.left && !me.migrating:
+		state = "left"
+	case me.migrating:
+		state = "moving"
+	}
+	sinceChange := time.Since(c.ringChanged)
+	c.mu.RUnlock()
+
+	lines := []string{"state:" + state, fmt.Sprintf("ring_changed_ms:%d", sinceChange.Milliseconds())}
+	if r == nil {
+		return lines
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lines = append(lines,
+		fmt.Sprintf("pending_keys:%d", r.pending),
+		fmt.Sprintf("moved_keys:%d", r.moved),
+		fmt.Sprintf("rate:%d", r.rate))
+	if r.lastErr != nil {
+		lines = append(lines, "last_error:"+r.lastErr.Error())
+	}
+	return lines
+}
+
+// adoptLockWait bounds how long adopt waits for a key's stripe. The sender
+// holds its own stripe meanwhile, so two nodes moving keys of the same
+// stripe to each other would otherwise wait for each other forever.
+const adoptLockWait = time.Second
+
+// errAdoptBusy is returned by adopt when the key's stripe stays locked
+var errAdoptBusy = errors.New("key is busy; the move will be retried")
+
+// adopt stores an entry moved here from another node, keeping its version,
+// unless the key already exists here, in which case this node's copy is
+// newer. It is a write like any other, so the hooks, triggers, memory limit
+// and quotas of this node apply to it.
+func (s *Store) adopt(key string, version uint64, attributes [][]string) error {
+	stripe := s.stripeFor(key)
+	if !tryLockFor(stripe, adoptLockWait) {
+		return errAdoptBusy
+	}
+	defer stripe.Unlock()
+
+	if err := s.Writable(); err != nil {
+		return err
+	}
+	if _, exists := s.data.Load(key); exists {
+		return nil
+	}
+
+	s.typesMutex.Lock()
+	pending := make(map[string]AttributeMetadata)
+	newData, err := s.parseAttributes(key, attributes, pending)
+	if err == nil {
+		s.holdTypes(pending)
+	}
+	s.typesMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = s.commit([]logOp{{Op: "put", Key: key, Attrs: newData, Version: version}}, s.defaultDurability())
+	s.releaseTypes(pending, err)
+	return err
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Redis imports move hash datasets over from Redis: every hash becomes an
+// entry under the same key, each field an attribute. Field values are typed
+// as the CLI's put types them, so "30" becomes a float. Keys of other types
+// are ignored, and hashes whose fields conflict with the store's attribute
+// types are skipped and reported. The store has no expiry, so imported
+// hashes keep no TTL.
+
+// redisScanCount is the COUNT hint of each SCAN of a live import
+const redisScanCount = 1000
+
+// ImportRedis imports the hashes of database opts.DB of the live Redis
+// server at addr, given as host:port or as a redis:// URL, which can carry a
+// user and password and, as its path, the database. opts.Bulk makes it a
+// bulk load.
+func (s *Store) ImportRedis(addr string, opts ImportOptions) (*ImportReport, error) {
+	set, err := s.readRedis(addr, opts.DB)
+	if err != nil {
+		return nil, err
+	}
+	return s.writeImport(set, opts)
+}
+
+// readRedis scans and types the hashes of database db of a Redis server
+func (s *Store) readRedis(addr string, db int) (*importSet, error) {
+	var user, password string
+	if strings.HasPrefix(addr, "redis://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, err
+		}
+		user = u.User.Username()
+		password, _ = u.User.Password()
+		if name := strings.TrimPrefix(u.Path, "/"); name != "" {
+			if db, err = strconv.Atoi(name); err != nil {
+				return nil, fmt.Errorf("bad Redis database %q", name)
+			}
+		}
+		addr = u.Host
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, clusterDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	pc := &peerConn{conn: conn, r: newRESPReader(conn), w: newRESPWriter(conn)}
+	do := func(args ...string) (interface{}, error) {
+		reply, err := pc.do(args)
+		if e, failed := reply.(respError); err == nil && failed {
+			err = fmt.Errorf("redis %s: %s", strings.ToLower(args[0]), e)
+		}
+		return reply, err
+	}
+
+	switch {
+	case password != "" && user != "":
+		_, err = do("AUTH", user, password)
+	case password != "":
+		_, err = do("AUTH", password)
+	}
+	if err == nil {
+		_, err = do("SELECT", strconv.Itoa(db))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[string][][]string)
+	cursor := "0"
+	for {
+		reply, err := do("SCAN", cursor, "COUNT", strconv.Itoa(redisScanCount))
+		if err != nil {
+			return nil, err
+		}
+		page, ok := reply.([]interface{})
+		if !ok || len(page) != 2 {
+			return nil, fmt.Errorf("redis scan: unexpected reply %v", reply)
+		}
+		cursor, _ = page[0].(string)
+		keys, _ := page[1].([]interface{})
+		for _, k := range keys {
+			key, _ := k.(string)
+			reply, err := pc.do([]string{"HGETALL", key})
+			if err != nil {
+				return nil, err
+			}
+			fields, isHash := reply.([]interface{})
+			if !isHash || len(fields) == 0 {
+				continue // not a hash, or gone since the scan
+			}
+			pairs := make([][]string, 0, len(fields)/2)
+			for i := 0; i+1 < len(fields); i += 2 {
+				field, _ := fields[i].(string)
+				value, _ := fields[i+1].(string)
+				pairs = append(pairs, []string{field, value})
+			}
+			hashes[key] = pairs
+		}
+		if cursor == "0" || cursor == "" {
+			break
+		}
+	}
+	return s.typeHashes(hashes), nil
+}
+
+// typeHashes types hashes, field/value pairs keyed by key, skipping those
+// whose values conflict with the attribute types
+func (s *Store) typeHashes(hashes map[string][][]string) *importSet {
+	keys := make([]string, 0, len(hashes))
+	for key := range hashes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	set := &importSet{entries: make(map[string]map[string]interface{}, len(hashes))}
+	s.typesMutex.Lock()
+	pending := make(map[string]AttributeMetadata)
+	for _, key := range keys {
+		trial := maps.Clone(pending)
+		attrs, err := s.parseAttributes(key, hashes[key], trial)
+		if err != nil {
+			set.skipped = append(set.skipped, RowError{Key: key, Err: err})
+			continue
+		}
+		pending = trial
+		set.entries[key] = attrs
+	}
+	s.typesMutex.Unlock()
+	return set
+}
@@ -0,0 +1,876 @@
+package store
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// Replication is asynchronous and log based. A follower connects to the
+// leader's replication listener, sends a hello with the sequence number it
+// has applied, and receives a reply followed by every later log record:
+// first the backlog, read from the leader's write log, then records as they
+// are committed. The hello, the reply and the follower's acks are JSON
+// lines; the stream after the reply is in the codec the hello names, the
+// one the follower's own log is written in (its -codec), whatever the
+// leader's log uses, and JSON lines for a JSON follower. A new follower, or
+// one further behind than the leader's log reaches, instead receives a
+// snapshot of the leader's state before the records that follow it.
+// Followers apply records with the leader's sequence numbers, so a
+// follower's own log, replayed after a restart, lets it resume where it
+// left off. The leader also sends a heartbeat with its sequence number every
+// replHeartbeat, from which a follower knows when it was last caught up and
+// so how stale its reads may be. Followers answer each heartbeat with an ack
+// of the sequence number they have applied, which lets the leader report on
+// every follower.
+
+// ErrReadOnly is returned by writes to a store that is following a leader
+var ErrReadOnly = errors.New("READONLY store is a follower; send writes to the leader")
+
+// sinkBuffer is how many records a record sink may fall behind before it is
+// dropped
+const sinkBuffer = 1024
+
+// followerRetry is how long a follower waits before reconnecting
+const followerRetry = time.Second
+
+// replHeartbeat is how often the leader tells followers its sequence number
+const replHeartbeat = 250 * time.Millisecond
+
+// ErrTooStale is returned for reads whose staleness bound the replica can't
+// meet
+var ErrTooStale = errors.New("TOOSTALE")
+
+// replHello is sent by a follower when it connects
+type replHello struct {
+	From uint64 `json:"from"` // last sequence number the follower applied
+
+	// Snapshot offers to start from a snapshot of the leader's state
+	// instead of from the leader's full history
+	Snapshot bool `json:"snapshot,omitempty"`
+
+	// Status asks for a replStatus instead of a record stream
+	Status bool `json:"status,omitempty"`
+
+	// Nonce starts the shared secret exchange, if the store has a secret
+	Nonce string `json:"nonce,omitempty"`
+
+	// Codec names the codec of everything the leader sends after its
+	// reply, JSON if empty; the handshake and acks are always JSON
+	Codec string `json:"codec,omitempty"`
+}
+
+// replStatus describes a node to a peer probing it for failover
+type replStatus struct {
+	Seq     uint64       `json:"seq"`
+	Role    string       `json:"role"` // "leader" or "follower"
+	Epoch   uint64       `json:"epoch"`
+	History []epochStart `json:"history,omitempty"`
+}
+
+// replReply answers a replHello. Records follow it unless Error is set.
+type replReply struct {
+	Seq        uint64 `json:"seq"`                   // leader's sequence number at handshake
+	ClientAddr string `json:"client_addr,omitempty"` // where the leader serves clients
+	Snapshot   bool   `json:"snapshot,omitempty"`    // a snapshot at Seq comes before the records
+	Error      string `json:"error,omitempty"`
+}
+
+// replAck is sent back by a follower after each heartbeat
+type replAck struct {
+	Seq   uint64 `json:"seq"`    // last sequence number the follower applied
+	LagMS int64  `json:"lag_ms"` // follower's own estimate of its lag, -1 if never caught up
+}
+
+// replFrame is a line of the record stream: a log record, or a heartbeat
+// carrying only the leader's sequence number
+type replFrame struct {
+	logRecord
+	Heartbeat bool `json:"heartbeat,omitempty"`
+}
+
+// replGobFrame is a replFrame as gob sees it: gob skips unexported fields,
+// embedded or not
+type replGobFrame struct {
+	Record    logRecord
+	Heartbeat bool
+}
+
+func (f replFrame) GobEncode() ([]byte, error) {
+	return GobCodec.Encode(replGobFrame{f.logRecord, f.Heartbeat})
+}
+
+func (f *replFrame) GobDecode(data []byte) error {
+	var g replGobFrame
+	if err := GobCodec.Decode(data, &g); err != nil {
+		return err
+	}
+	f.logRecord, f.Heartbeat = g.Record, g.Heartbeat
+	return nil
+}
+
+// Writable reports ErrReadOnly while the store follows a leader, and
+// ErrNotLeader on a Raft node that isn't currently the leader
+func (s *Store) Writable() error {
+	if s.readOnly.Load() {
+		return ErrReadOnly
+	}
+	if n := s.raftNode; n != nil && n.raft.State() != raft.Leader {
+		return n.notLeader()
+	}
+	return nil
+}
+
+// subscribeRecords registers a channel that receives every record committed
+// from now on, in sequence order, and returns it with the sequence number of
+// the last record committed before it. Buffered log records are flushed
+// first, so a reader of the log file finds everything up to that number. If
+// the receiver falls more than sinkBuffer records behind, the channel is
+// closed.
+func (s *Store) subscribeRecords() (chan logRecord, uint64, error) {
+	s.logMutex.Lock()
+	defer s.logMutex.Unlock()
+
+	if s.log != nil {
+		if err := s.log.buf.Flush(); err != nil {
+			return nil, 0, err
+		}
+	}
+	ch := make(chan logRecord, sinkBuffer)
+	if s.sinks == nil {
+		s.sinks = make(map[chan logRecord]struct{})
+	}
+	s.sinks[ch] = struct{}{}
+	return ch, s.seq, nil
+}
+
+// unsubscribeRecords removes a channel registered by subscribeRecords
+func (s *Store) unsubscribeRecords(ch chan logRecord) {
+	s.logMutex.Lock()
+	defer s.logMutex.Unlock()
+
+	if _, exists := s.sinks[ch]; exists {
+		delete(s.sinks, ch)
+		close(ch)
+	}
+}
+
+// publishLocked hands rec to every record sink, dropping sinks that are too
+// far behind to take it. Caller must hold logMutex.
+func (s *Store) publishLocked(rec logRecord) {
+	for ch := range s.sinks {
+		select {
+		case ch <- rec:
+		default:
+			delete(s.sinks, ch)
+			close(ch)
+		}
+	}
+}
+
+// readLogRange calls fn for every record in the log file at path with a
+// sequence number in (from, to]
+func readLogRange(path string, from, to uint64, fn func(logRecord) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	codec, _, err := readCodecHeader(r)
+	if err != nil {
+		return err
+	}
+	for {
+		data, _, err := readFrame(r, codec)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var rec logRecord
+		if err := codec.Decode(data, &rec); err != nil {
+			return err
+		}
+		if rec.Seq > to {
+			return nil
+		}
+		if rec.Seq > from {
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// logPath returns the path of the store's write log, or "" without one
+func (s *Store) logPath() string {
+	s.logMutex.Lock()
+	defer s.logMutex.Unlock()
+
+	if s.log == nil {
+		return ""
+	}
+	return s.log.path
+}
+
+// Leader streams the store's committed writes to followers
+type Leader struct {
+	store *Store
+
+	// ClientAddr is the address the leader serves clients on, if any.
+	// Followers hand it to reads they are too stale to answer.
+	ClientAddr string
+
+	mu        sync.Mutex
+	followers map[*followerConn]struct{}
+
+	svc tcpService
+}
+
+// followerConn is the leader's record of one connected follower, guarded by
+// the leader's mu
+type followerConn struct {
+	addr      string
+	connected time.Time
+	acked     uint64
+	lagMS     int64
+	lastAck   time.Time
+}
+
+// FollowerStatus describes a follower connected to a leader
+type FollowerStatus struct {
+	Addr      string
+	Connected time.Time
+	Acked     uint64        // last sequence number the follower acknowledged
+	LagOps    uint64        // writes the leader has committed past Acked
+	Lag       time.Duration // how stale the follower may be; -1 if never caught up
+	LastAck   time.Time     // zero until the first ack
+}
+
+// NewLeader creates a replication leader for store. Followers that are
+// behind are caught up from the store's write log, so a store without one
+// can only serve followers that start from its current state.
+func NewLeader(store *Store) *Leader {
+	l := &Leader{
+		store:     store,
+		followers: make(map[*followerConn]struct{}),
+		svc:       newTCPService(),
+	}
+	store.logMutex.Lock()
+	store.leader = l
+	store.logMutex.Unlock()
+	return l
+}
+
+// Followers returns the status of every connected follower
+func (l *Leader) Followers() []FollowerStatus {
+	seq := l.store.Seq()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	statuses := make([]FollowerStatus, 0, len(l.followers))
+	for fc := range l.followers {
+		st := FollowerStatus{
+			Addr:      fc.addr,
+			Connected: fc.connected,
+			Acked:     fc.acked,
+			Lag:       -1,
+			LastAck:   fc.lastAck,
+		}
+		if seq > fc.acked {
+			st.LagOps = seq - fc.acked
+		}
+		if !fc.lastAck.IsZero() && fc.lagMS >= 0 {
+			// The follower's estimate has aged since it was sent
+			st.Lag = time.Duration(fc.lagMS)*time.Millisecond + now.Sub(fc.lastAck)
+			if st.LagOps == 0 {
+				st.Lag = 0
+			}
+		}
+		statuses = append(statuses, st)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Addr < statuses[j].Addr })
+	return statuses
+}
+
+// ListenAndServe accepts followers on the TCP address addr until closed
+func (l *Leader) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return l.Serve(ln)
+}
+
+// Serve accepts followers on ln until closed, over TLS if the store's
+// replication is secured with it
+func (l *Leader) Serve(ln net.Listener) error {
+	if l.store.replTLS != nil {
+		ln = tls.NewListener(ln, l.store.replTLS)
+	}
+	return l.svc.serve(ln, l.handle)
+}
+
+// Close stops accepting followers and disconnects the current ones
+func (l *Leader) Close() error {
+	return l.svc.close()
+}
+
+func (l *Leader) handle(conn net.Conn) {
+	var hello replHello
+	dec := json.NewDecoder(conn)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if err := dec.Decode(&hello); err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	if !l.store.checkHello(conn, dec, hello) {
+		return
+	}
+	if hello.Status {
+		json.NewEncoder(conn).Encode(l.store.replStatus())
+		return
+	}
+
+	// A follower that is new, or further behind than the leader's log
+	// reaches back, can be sent a snapshot followed by the later records.
+	// The snapshot must be taken at the point the records start from, so
+	// both happen with writers excluded.
+	path := l.store.logPath()
+	var base uint64
+	if path != "" {
+		var err error
+		if base, err = logBase(path); err != nil {
+			return
+		}
+	}
+	replayable := path != "" && (hello.From == 0 || hello.From >= base)
+
+	if hello.Snapshot {
+		l.store.rlockAll()
+	}
+	records, seq, err := l.store.subscribeRecords()
+	var st *storeState
+	if err == nil && hello.Snapshot && hello.From < seq && (hello.From == 0 || !replayable) {
+		st = l.store.captureState()
+	}
+	if hello.Snapshot {
+		l.store.runlockAll()
+	}
+	if err != nil {
+		return
+	}
+	defer l.store.unsubscribeRecords(records)
+
+	w := bufio.NewWriter(conn)
+	enc := json.NewEncoder(w)
+	reply := replReply{Seq: seq, ClientAddr: l.ClientAddr, Snapshot: st != nil}
+	codec, codecErr := JSONCodec, error(nil)
+	if hello.Codec != "" {
+		codec, codecErr = ParseCodec(hello.Codec)
+	}
+	switch {
+	case codecErr != nil:
+		reply.Error = codecErr.Error()
+	case hello.From > seq:
+		reply.Error = fmt.Sprintf("follower at %d is ahead of leader at %d", hello.From, seq)
+	case st == nil && hello.From < seq && path == "":
+		reply.Error = "leader has no write log to catch the follower up from"
+	case st == nil && hello.From < seq && !replayable:
+		reply.Error = fmt.Sprintf("follower at %d is behind the leader's log, which starts at %d", hello.From, base)
+	}
+	if err := enc.Encode(reply); err != nil || reply.Error != "" {
+		w.Flush()
+		return
+	}
+
+	var out valueEncoder = enc
+	if codec != JSONCodec {
+		out = newFrameEncoder(w, codec)
+	}
+	switch {
+	case st != nil:
+		if err := st.encode(out); err != nil {
+			return
+		}
+	case hello.From < seq:
+		if err := readLogRange(path, hello.From, seq, func(rec logRecord) error {
+			return out.Encode(replFrame{logRecord: rec})
+		}); err != nil {
+			return
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return
+	}
+
+	fc := &followerConn{addr: conn.RemoteAddr().String(), connected: time.Now(), acked: hello.From, lagMS: -1}
+	l.mu.Lock()
+	l.followers[fc] = struct{}{}
+	l.mu.Unlock()
+	l.store.logger.Info("follower connected", "addr", fc.addr, "from", hello.From, "snapshot", st != nil)
+	defer func() {
+		l.mu.Lock()
+		delete(l.followers, fc)
+		l.mu.Unlock()
+		l.store.logger.Info("follower disconnected", "addr", fc.addr)
+	}()
+	go func() {
+		// A follower that stops acking is cut off, and reconnects
+		for {
+			var ack replAck
+			if err := dec.Decode(&ack); err != nil {
+				conn.Close()
+				return
+			}
+			l.mu.Lock()
+			fc.acked, fc.lagMS, fc.lastAck = ack.Seq, ack.LagMS, time.Now()
+			l.mu.Unlock()
+		}
+	}()
+
+	heartbeat := time.NewTicker(replHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case rec, ok := <-records:
+			if !ok {
+				// Too far behind; the follower reconnects and catches up
+				// from the log.
+				return
+			}
+			if err := out.Encode(replFrame{logRecord: rec}); err != nil {
+				return
+			}
+			if len(records) == 0 {
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		case <-heartbeat.C:
+			// Records up to this sequence number are already queued ahead
+			// of any later ones, so a follower that has applied it is
+			// caught up as of now.
+			hb := replFrame{logRecord: logRecord{Seq: l.store.Seq()}, Heartbeat: true}
+			if err := out.Encode(hb); err != nil {
+				return
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		case <-l.svc.done:
+			return
+		}
+	}
+}
+
+// Follower keeps a store in sync with a leader, reconnecting after failures
+type Follower struct {
+	store *Store
+	addr  string
+
+	mu         sync.Mutex
+	conn       net.Conn
+	connected  bool
+	lastErr    error
+	leaderSeq  uint64    // leader's latest known sequence number
+	contact    time.Time // when the follower last heard from the leader
+	caughtUp   time.Time // when the store last had everything up to leaderSeq
+	clientAddr string    // leader's client address, if it has one
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Follow makes the store a read-only replica of the leader whose replication
+// listener is at addr. The store must be empty or hold only records
+// replicated from the same leader. Writes fail with ErrReadOnly until Promote.
+func (s *Store) Follow(addr string) (*Follower, error) {
+	s.logMutex.Lock()
+	defer s.logMutex.Unlock()
+
+	if s.follower != nil {
+		return nil, fmt.Errorf("already following %s", s.follower.addr)
+	}
+
+	f := &Follower{
+		store:   s,
+		addr:    addr,
+		contact: time.Now(),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	s.follower = f
+	s.readOnly.Store(true)
+	go f.run()
+	return f, nil
+}
+
+// Promote stops following the leader and makes the store writable again
+func (s *Store) Promote() error {
+	s.logMutex.Lock()
+	f := s.follower
+	s.follower = nil
+	s.logMutex.Unlock()
+
+	if f == nil {
+		return errors.New("store is not following a leader")
+	}
+	f.close()
+	s.readOnly.Store(false)
+	s.logger.Info("stopped following the leader and accepting writes", "leader", f.addr, "seq", s.Seq())
+	return nil
+}
+
+// LeaderAddr returns the address of the leader being followed, if any
+func (s *Store) LeaderAddr() (string, bool) {
+	s.logMutex.Lock()
+	defer s.logMutex.Unlock()
+
+	if s.follower == nil {
+		return "", false
+	}
+	return s.follower.addr, true
+}
+
+// LastContact returns when the follower last heard from its leader, or
+// when it started following if it never has
+func (f *Follower) LastContact() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.contact
+}
+
+// LeaderSeq returns the leader's latest sequence number known to the
+// follower
+func (f *Follower) LeaderSeq() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.leaderSeq
+}
+
+// Connected reports whether the follower currently has a live stream
+func (f *Follower) Connected() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.connected
+}
+
+// Err returns the error that ended the most recent connection attempt
+func (f *Follower) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.lastErr
+}
+
+func (f *Follower) close() {
+	close(f.stop)
+	f.mu.Lock()
+	if f.conn != nil {
+		f.conn.Close()
+	}
+	f.mu.Unlock()
+	<-f.done
+}
+
+func (f *Follower) run() {
+	defer close(f.done)
+
+	for {
+		err := f.sync()
+		f.mu.Lock()
+		f.connected = false
+		f.conn = nil
+		f.lastErr = err
+		f.mu.Unlock()
+
+		select {
+		case <-f.stop:
+			return
+		default:
+		}
+		f.store.logger.Warn("replication stream from the leader broke; reconnecting", "leader", f.addr, "err", err)
+		select {
+		case <-f.stop:
+			return
+		case <-time.After(followerRetry):
+		}
+	}
+}
+
+// sync runs one replication session, returning when the stream breaks
+func (f *Follower) sync() error {
+	conn, err := f.store.dialReplication(f.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	f.mu.Lock()
+	select {
+	case <-f.stop:
+		f.mu.Unlock()
+		return nil
+	default:
+	}
+	f.conn = conn
+	f.mu.Unlock()
+
+	acks := json.NewEncoder(conn)
+	br := bufio.NewReader(conn)
+	dec := json.NewDecoder(br)
+	hello := replHello{From: f.store.Seq(), Snapshot: true}
+	if f.store.codec != JSONCodec {
+		hello.Codec = f.store.codec.Name()
+	}
+	if err := f.store.sendHello(acks, dec, hello); err != nil {
+		return err
+	}
+	var reply replReply
+	if err := dec.Decode(&reply); err != nil {
+		return err
+	}
+	if reply.Error != "" {
+		return errors.New(reply.Error)
+	}
+	var in valueDecoder = dec
+	if f.store.codec != JSONCodec {
+		// The JSON decoder has read past the reply, up to the newline
+		// ending it at least
+		rest := bufio.NewReader(io.MultiReader(dec.Buffered(), br))
+		if b, err := rest.Peek(1); err == nil && b[0] == '\n' {
+			rest.Discard(1)
+		}
+		in = newFrameDecoder(rest, f.store.codec)
+	}
+	if reply.Snapshot {
+		st, err := decodeSnapshot(in)
+		if err != nil {
+			return err
+		}
+		if err := f.store.installSnapshot(st); err != nil {
+			return err
+		}
+	}
+
+	f.mu.Lock()
+	f.connected = true
+	f.lastErr = nil
+	f.clientAddr = reply.ClientAddr
+	f.mu.Unlock()
+	f.store.logger.Info("following the leader", "leader", f.addr, "leader_seq", reply.Seq, "snapshot", reply.Snapshot)
+	f.advance(reply.Seq)
+
+	for {
+		var frame replFrame
+		if err := in.Decode(&frame); err != nil {
+			return err
+		}
+		if !frame.Heartbeat {
+			if err := f.store.applyReplicated(frame.logRecord); err != nil {
+				return err
+			}
+		}
+		f.advance(frame.Seq)
+
+		if frame.Heartbeat {
+			ack := replAck{Seq: f.store.Seq(), LagMS: -1}
+			if lag, ok := f.Lag(); ok {
+				ack.LagMS = lag.Milliseconds()
+			}
+			if err := acks.Encode(ack); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// advance notes that the leader has reached seq, marking the follower
+// caught up if it has applied that far
+func (f *Follower) advance(seq uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.contact = time.Now()
+	if seq > f.leaderSeq {
+		f.leaderSeq = seq
+	}
+	if f.store.Seq() >= f.leaderSeq {
+		f.caughtUp = time.Now()
+	}
+}
+
+// Lag returns how long ago the follower last had every write the leader
+// had acknowledged, an upper bound on how stale its reads are. It reports
+// false if the follower has never caught up.
+func (f *Follower) Lag() (time.Duration, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.caughtUp.IsZero() {
+		return 0, false
+	}
+	return time.Since(f.caughtUp), true
+}
+
+// CheckStaleness returns ErrTooStale if the store is a follower that may be
+// more than maxStale behind its leader. A leader is never stale.
+func (s *Store) CheckStaleness(maxStale time.Duration) error {
+	s.logMutex.Lock()
+	f := s.follower
+	s.logMutex.Unlock()
+	if f == nil {
+		return nil
+	}
+
+	lag, ok := f.Lag()
+	if ok && lag <= maxStale {
+		return nil
+	}
+	f.mu.Lock()
+	redirect := "the leader's client address is unknown"
+	if f.clientAddr != "" {
+		redirect = "read from the leader at " + f.clientAddr
+	}
+	f.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w replica has not caught up with the leader; %s", ErrTooStale, redirect)
+	}
+	return fmt.Errorf("%w replica may be %s behind the leader, over the %s bound; %s",
+		ErrTooStale, lag.Round(time.Millisecond), maxStale, redirect)
+}
+
+// applyReplicated applies a record received from the leader, logging it
+// with the leader's sequence number. Records must arrive in order; ones
+// already applied are skipped.
+func (s *Store) applyReplicated(rec logRecord) error {
+	keys := make([]string, 0, len(rec.Ops))
+	entries := make(map[string]map[string]interface{})
+	for _, op := range rec.Ops {
+		keys = append(keys, op.Key)
+		if op.Op != "del" {
+			entries[op.Key] = op.Attrs
+		}
+	}
+
+	unlock := s.lockKeys(keys)
+	defer unlock()
+
+	if seq := s.Seq(); rec.Seq <= seq {
+		return nil
+	} else if rec.Seq != seq+1 {
+		return fmt.Errorf("replication gap: have %d, received %d", seq, rec.Seq)
+	}
+	held, err := s.checkValues(entries)
+	if err != nil {
+		return fmt.Errorf("record %d: %w", rec.Seq, err)
+	}
+
+	// Unlike commit, the record is applied before the sequence number
+	// advances, so a session waiting on this follower for its token
+	// observes the write as soon as it is released.
+	s.logMutex.Lock()
+	defer s.logMutex.Unlock()
+	err = s.appendLocked(rec, Logged)
+	s.releaseTypes(held, err)
+	if err != nil {
+		return err
+	}
+	s.applyOps(rec.Ops)
+	s.advanceSeqLocked(rec.Seq)
+	s.publishLocked(rec)
+	return nil
+}
+
+// ReplicationInfo reports the store's replication state as "name:value"
+// lines: for a follower its leader, connection and lag, for a leader the
+// offset, lag and last ack of every connected follower, and the position of
+// every sync link copying writes into the store
+func (s *Store) ReplicationInfo() []string {
+	s.logMutex.Lock()
+	f, l, failover := s.follower, s.leader, s.failover
+	links := append([]*SyncLink(nil), s.links...)
+	s.logMutex.Unlock()
+
+	info := s.roleInfo(f, l)
+	if failover != nil {
+		info = append(info, fmt.Sprintf("epoch:%d", failover.Epoch()))
+	}
+	for i, link := range links {
+		info = append(info, link.info(i))
+	}
+	return info
+}
+
+func (s *Store) roleInfo(f *Follower, l *Leader) []string {
+	seq := s.Seq()
+	if f != nil {
+		connected := 0
+		if f.Connected() {
+			connected = 1
+		}
+		lagMS := int64(-1)
+		if lag, ok := f.Lag(); ok {
+			lagMS = lag.Milliseconds()
+		}
+		leaderSeq := f.LeaderSeq()
+		var lagOps uint64
+		if leaderSeq > seq {
+			lagOps = leaderSeq - seq
+		}
+		info := []string{
+			"role:follower",
+			"leader:" + f.addr,
+			fmt.Sprintf("connected:%d", connected),
+			fmt.Sprintf("seq:%d", seq),
+			fmt.Sprintf("leader_seq:%d", leaderSeq),
+			fmt.Sprintf("lag_ops:%d", lagOps),
+			fmt.Sprintf("lag_ms:%d", lagMS),
+		}
+		if err := f.Err(); err != nil {
+			info = append(info, "last_error:"+err.Error())
+		}
+		return info
+	}
+
+	info := []string{"role:leader", fmt.Sprintf("seq:%d", seq)}
+	if l == nil {
+		return append(info, "followers:0")
+	}
+	followers := l.Followers()
+	info = append(info, fmt.Sprintf("followers:%d", len(followers)))
+	now := time.Now()
+	for i, st := range followers {
+		lastAckMS := int64(-1)
+		if !st.LastAck.IsZero() {
+			lastAckMS = now.Sub(st.LastAck).Milliseconds()
+		}
+		lagMS := int64(-1)
+		if st.Lag >= 0 {
+			lagMS = st.Lag.Milliseconds()
+		}
+		info = append(info, fmt.Sprintf("follower%d:addr=%s,acked=%d,lag_ops=%d,lag_ms=%d,last_ack_ms=%d",
+			i, st.Addr, st.Acked, st.LagOps, lagMS, lastAckMS))
+	}
+	return info
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startLeader serves store's replication listener on a loopback port until
+// the test ends and returns its address
+func startLeader(t *testing.T, store *Store) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLeader(store)
+	go l.Serve(ln)
+	t.Cleanup(func() { l.Close() })
+	return ln.Addr().String()
+}
+
+// waitFor waits until follower has applied every write leader has made
+func waitFor(t *testing.T, follower, leader *Store) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := follower.WaitForSeq(ctx, leader.Seq()); err != nil {
+		t.Fatalf("follower at %d, leader at %d: %v", follower.Seq(), leader.Seq(), err)
+	}
+}
+
+// TestReplication follows a leader from its log's backlog through live
+// writes and deletes, refusing writes to the follower until it is promoted
+func TestReplication(t *testing.T) {
+	leader, err := OpenStore(filepath.Join(t.TempDir(), "leader.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leader.Close()
+	if err := leader.Put("before", [][]string{{"n", "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	addr := startLeader(t, leader)
+
+	follower, err := OpenStore(filepath.Join(t.TempDir(), "follower.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Close()
+	if _, err := follower.Follow(addr); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, follower, leader)
+	if follower.Get("before") == nil {
+		t.Fatal("the follower missed the backlog")
+	}
+
+	if err := leader.Put("after", [][]string{{"n", "2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.Delete("before"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, follower, leader)
+	if follower.Get("before") != nil || follower.Get("after")["n"] != 2.0 {
+		t.Errorf("follower holds %v, want only after", follower.Keys())
+	}
+	if err := follower.Put("x", [][]string{{"n", "1"}}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("put on the follower = %v, want ErrReadOnly", err)
+	}
+	if _, err := follower.Follow(addr); err == nil {
+		t.Error("a follower followed a second time")
+	}
+
+	if err := follower.Promote(); err != nil {
+		t.Fatal(err)
+	}
+	if err := follower.Put("x", [][]string{{"n", "1"}}); err != nil {
+		t.Errorf("put after promoting: %v", err)
+	}
+	if err := follower.Promote(); err == nil {
+		t.Error("promoted a store that follows no one")
+	}
+}
+
+// TestReplicationCodec follows a leader writing a JSON log from a msgpack
+// follower, whose stream comes in its own codec
+func TestReplicationCodec(t *testing.T) {
+	leader, err := OpenStore(filepath.Join(t.TempDir(), "leader.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leader.Close()
+	if err := leader.Put("k", [][]string{{"name", "ann lee"}, {"age", "30"}, {"admin", "true"}}); err != nil {
+		t.Fatal(err)
+	}
+	addr := startLeader(t, leader)
+
+	follower, err := OpenStore(filepath.Join(t.TempDir(), "follower.log"), WithCodec(MsgpackCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Close()
+	if _, err := follower.Follow(addr); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, follower, leader)
+	got, want := follower.Get("k"), leader.Get("k")
+	for attr, v := range want {
+		if got[attr] != v {
+			t.Errorf("%s = %#v, want %#v", attr, got[attr], v)
+		}
+	}
+}
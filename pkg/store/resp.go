@@ -0,0 +1,248 @@
+package store
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The server speaks RESP, the Redis serialization protocol, so redis-cli and
+// existing Redis client libraries can talk to the store. Requests are either
+// arrays of bulk strings or plain inline command lines.
+
+// maxBulkLen bounds a single bulk string so a bad length can't exhaust memory
+const maxBulkLen = 512 * 1024 * 1024
+
+// maxArrayLen bounds the elements of an array, and preallocLen the room made
+// for them up front, so a bad count can't exhaust memory either: an array
+// that really is long grows as its elements arrive
+const (
+	maxArrayLen = 1024 * 1024
+	preallocLen = 64
+)
+
+var errProtocol = errors.New("protocol error")
+
+// respReader decodes client requests
+type respReader struct {
+	r *bufio.Reader
+}
+
+func newRESPReader(r io.Reader) *respReader {
+	return &respReader{r: bufio.NewReader(r)}
+}
+
+// readLine returns the next CRLF- or LF-terminated line without its ending
+func (rr *respReader) readLine() (string, error) {
+	line, err := rr.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// ReadCommand returns the next request as a list of arguments. Empty
+// requests, blank inline lines and empty arrays, are skipped.
+func (rr *respReader) ReadCommand() ([]string, error) {
+	for {
+		line, err := rr.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			continue
+		}
+		if line[0] != '*' {
+			if args := strings.Fields(line); len(args) > 0 {
+				return args, nil
+			}
+			continue
+		}
+
+		count, err := strconv.Atoi(line[1:])
+		if err != nil || count < 0 || count > maxArrayLen {
+			return nil, errProtocol
+		}
+		if count == 0 {
+			continue
+		}
+
+		args := make([]string, 0, min(count, preallocLen))
+		for i := 0; i < count; i++ {
+			arg, err := rr.readBulk()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		return args, nil
+	}
+}
+
+func (rr *respReader) readBulk() (string, error) {
+	header, err := rr.readLine()
+	if err != nil {
+		return "", err
+	}
+	if len(header) == 0 || header[0] != '$' {
+		return "", errProtocol
+	}
+	n, err := strconv.Atoi(header[1:])
+	if err != nil || n < 0 || n > maxBulkLen {
+		return "", errProtocol
+	}
+
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(rr.r, buf); err != nil {
+		return "", err
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return "", errProtocol
+	}
+	return string(buf[:n]), nil
+}
+
+// respSimple and respError are simple-string and error replies read by
+// ReadReply; bulk strings are returned as plain strings
+type (
+	respSimple string
+	respError  string
+)
+
+// ReadReply decodes one reply from a server: a respSimple, a respError, an
+// int64, a string, nil for a null bulk string, or an []interface{} for an
+// array, which is nil for a null array
+func (rr *respReader) ReadReply() (interface{}, error) {
+	line, err := rr.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, errProtocol
+	}
+
+	switch line[0] {
+	case '+':
+		return respSimple(line[1:]), nil
+	case '-':
+		return respError(line[1:]), nil
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, errProtocol
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 || n > maxBulkLen {
+			return nil, errProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rr.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 || n > maxArrayLen {
+			return nil, errProtocol
+		}
+		if n == -1 {
+			return []interface{}(nil), nil
+		}
+		items := make([]interface{}, 0, min(n, preallocLen))
+		for i := 0; i < n; i++ {
+			item, err := rr.ReadReply()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	return nil, errProtocol
+}
+
+// respWriter encodes replies. Errors are sticky and reported by Flush.
+type respWriter struct {
+	w *bufio.Writer
+
+	lastError string // the last error replied, for tracing
+}
+
+func newRESPWriter(w io.Writer) *respWriter {
+	return &respWriter{w: bufio.NewWriter(w)}
+}
+
+func (rw *respWriter) WriteSimple(s string) {
+	fmt.Fprintf(rw.w, "+%s\r\n", s)
+}
+
+func (rw *respWriter) WriteError(msg string) {
+	rw.lastError = msg
+	fmt.Fprintf(rw.w, "-%s\r\n", strings.ReplaceAll(msg, "\n", " "))
+}
+
+func (rw *respWriter) WriteInt(n int64) {
+	fmt.Fprintf(rw.w, ":%d\r\n", n)
+}
+
+func (rw *respWriter) WriteBulk(s string) {
+	fmt.Fprintf(rw.w, "$%d\r\n%s\r\n", len(s), s)
+}
+
+func (rw *respWriter) WriteNull() {
+	rw.w.WriteString("$-1\r\n")
+}
+
+func (rw *respWriter) WriteNullArray() {
+	rw.w.WriteString("*-1\r\n")
+}
+
+// WriteArrayHeader starts an array of n elements; the caller writes them next
+func (rw *respWriter) WriteArrayHeader(n int) {
+	fmt.Fprintf(rw.w, "*%d\r\n", n)
+}
+
+func (rw *respWriter) WriteStrings(items []string) {
+	rw.WriteArrayHeader(len(items))
+	for _, item := range items {
+		rw.WriteBulk(item)
+	}
+}
+
+// WriteReply encodes a reply as decoded by ReadReply, so replies from
+// another server can be relayed unchanged
+func (rw *respWriter) WriteReply(v interface{}) {
+	switch v := v.(type) {
+	case respSimple:
+		rw.WriteSimple(string(v))
+	case respError:
+		rw.WriteError(string(v))
+	case int64:
+		rw.WriteInt(v)
+	case string:
+		rw.WriteBulk(v)
+	case []interface{}:
+		if v == nil {
+			rw.WriteNullArray()
+			return
+		}
+		rw.WriteArrayHeader(len(v))
+		for _, item := range v {
+			rw.WriteReply(item)
+		}
+	default:
+		rw.WriteNull()
+	}
+}
+
+func (rw *respWriter) Flush() error {
+	return rw.w.Flush()
+}
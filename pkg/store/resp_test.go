@@ -0,0 +1,60 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestReadCommand parses requests in both forms, skipping empty ones
+func TestReadCommand(t *testing.T) {
+	rr := newRESPReader(strings.NewReader("   \t\r\n\r\n*0\r\nget  k\r\n*3\r\n$3\r\nput\r\n$1\r\nk\r\n$5\r\na b\r\n\r\n"))
+	for _, want := range [][]string{{"get", "k"}, {"put", "k", "a b\r\n"}} {
+		args, err := rr.ReadCommand()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(args, want) {
+			t.Errorf("args = %q, want %q", args, want)
+		}
+	}
+	if _, err := rr.ReadCommand(); err == nil {
+		t.Error("read past the end")
+	}
+}
+
+// TestReadCommandBounds rejects malformed and oversized requests before
+// allocating for them
+func TestReadCommandBounds(t *testing.T) {
+	for name, req := range map[string]string{
+		"negative count": "*-1\r\n",
+		"huge count":     "*999999999999\r\n",
+		"over the limit": "*1048577\r\n",
+		"not a bulk":     "*1\r\n:1\r\n",
+		"huge bulk":      "*1\r\n$999999999999\r\n",
+		"bad ending":     "*1\r\n$1\r\nab\r\n",
+	} {
+		if _, err := newRESPReader(strings.NewReader(req)).ReadCommand(); !errors.Is(err, errProtocol) {
+			t.Errorf("%s: err = %v, want %v", name, err, errProtocol)
+		}
+	}
+}
+
+// TestReadReply decodes each kind of reply, and rejects an oversized array
+func TestReadReply(t *testing.T) {
+	rr := newRESPReader(strings.NewReader("*5\r\n+OK\r\n-ERR no\r\n:42\r\n$-1\r\n*-1\r\n$2\r\nhi\r\n"))
+	want := []interface{}{respSimple("OK"), respError("ERR no"), int64(42), nil, []interface{}(nil)}
+	for _, want := range []interface{}{want, "hi"} {
+		reply, err := rr.ReadReply()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(reply, want) {
+			t.Errorf("reply = %#v, want %#v", reply, want)
+		}
+	}
+	if _, err := newRESPReader(strings.NewReader("*1048577\r\n")).ReadReply(); !errors.Is(err, errProtocol) {
+		t.Errorf("oversized array: err = %v, want %v", err, errProtocol)
+	}
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A dump script is the store written out as CLI commands, one put per
+// entry, which replayed through the CLI (key-value-go < dump.txt) rebuild
+// the same entries and attribute types: a fixture or a bug report that
+// needs nothing but the binary. Words the CLI would split or type
+// differently are written as quoted strings, which the CLI reads as strings.
+
+// dumpTypesKey is the key under which a dump script registers the attribute
+// types no dumped entry carries, with a put that it deletes right away
+const dumpTypesKey = "__dump_types__"
+
+// DumpScript writes the entries selected by filter to w as a script of CLI
+// commands, returning how many it wrote. Versions aren't kept: replaying
+// the script writes each entry anew.
+func (s *Store) DumpScript(w io.Writer, filter ExportFilter) (int, error) {
+	if err := filter.validate(); err != nil {
+		return 0, err
+	}
+	st, keys := s.captureFiltered(filter)
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# key-value-go dump of %d entries at sequence %d\n", len(keys), st.seq)
+	fmt.Fprintf(bw, "# Replay with: key-value-go < dump.txt\n")
+
+	// Types no entry registers are registered by a throwaway entry in their
+	// namespace, whose key is chosen not to clash with a dumped one
+	carried := make(map[string]bool)
+	for _, key := range keys {
+		for attrKey := range st.entries[key].attrs {
+			carried[typeKey(key, attrKey)] = true
+		}
+	}
+	placeholders := make(map[string][]string) // by namespace
+	var namespaces []string
+	for _, name := range st.attributeNames() {
+		if carried[name] {
+			continue
+		}
+		var zero interface{}
+		switch st.types[name].dataType {
+		case StringType:
+			zero = ""
+		case FloatType:
+			zero = 0.0
+		case BoolType:
+			zero = false
+		}
+		namespace, attrKey := SplitNamespace(name)
+		if _, seen := placeholders[namespace]; !seen {
+			namespaces = append(namespaces, namespace)
+		}
+		placeholders[namespace] = append(placeholders[namespace], ScriptWord(attrKey), scriptValue(zero))
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		prefix := ""
+		if namespace != "" {
+			prefix = namespace + NamespaceSeparator
+		}
+		typesKey := prefix + dumpTypesKey
+		for n := 2; st.entries[typesKey] != nil; n++ {
+			typesKey = fmt.Sprintf("%s%s%d", prefix, dumpTypesKey, n)
+		}
+		fmt.Fprintf(bw, "put %s %s\n", ScriptWord(typesKey), strings.Join(placeholders[namespace], " "))
+		fmt.Fprintf(bw, "delete %s\n", ScriptWord(typesKey))
+	}
+
+	for _, key := range keys {
+		attrs := st.entries[key].attrs
+		if len(attrs) == 0 {
+			// put needs an attribute; an empty entry can't be scripted
+			return 0, fmt.Errorf("entry %q has no attributes", key)
+		}
+		bw.WriteString("put " + ScriptWord(key))
+		for _, attrKey := range sortedAttributeNames(attrs) {
+			bw.WriteString(" " + ScriptWord(attrKey) + " " + scriptValue(attrs[attrKey]))
+		}
+		if err := bw.WriteByte('\n'); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), bw.Flush()
+}
+
+// DumpScriptFile is DumpScript to the file at path
+func (s *Store) DumpScriptFile(path string, filter ExportFilter) (int, error) {
+	if err := filter.validate(); err != nil {
+		return 0, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := s.DumpScript(file, filter)
+	if err != nil {
+		file.Close()
+		return 0, err
+	}
+	return n, file.Close()
+}
+
+// sortedAttributeNames returns the names of attrs in order
+func sortedAttributeNames(attrs map[string]interface{}) []string {
+	names := make([]string, 0, len(attrs))
+	for attrKey := range attrs {
+		names = append(names, attrKey)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ScriptWord writes s as a word the CLI reads back as s: as is if it can,
+// quoted otherwise
+func ScriptWord(s string) string {
+	if s == "" || s[0] == '"' || strings.IndexFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || !unicode.IsPrint(r)
+	}) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// scriptValue writes value as a word put types back as value. A string that
+// put would read as a float or bool is quoted.
+func scriptValue(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		if t, _, _ := DetermineType(v); t != StringType {
+			return strconv.Quote(v)
+		}
+		return ScriptWord(v)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,1041 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultSessionWait bounds how long a read waits for this node to catch up
+// with the caller's session token
+const defaultSessionWait = time.Second
+
+// defaultDatabases is how many databases SELECT offers unless Databases
+// says otherwise, as in Redis
+const defaultDatabases = 16
+
+// changesReplyLimit caps the batches one "changes" reply returns
+const changesReplyLimit = 1000
+
+// Server exposes a Store to network clients over RESP
+type Server struct {
+	store *Store
+
+	// SessionWait is how long a read from a session waits for the node to
+	// apply the session's token before failing with STALE
+	SessionWait time.Duration
+
+	// Databases is how many numbered databases SELECT offers, 0 to
+	// Databases-1 (see database.go)
+	Databases int
+
+	// BatchWrites routes puts through the store's async batcher, so puts
+	// from concurrent clients share log flushes and fsyncs
+	BatchWrites bool
+
+	// Cluster, when set, partitions keys across the cluster's nodes:
+	// commands for keys another node owns are forwarded to it
+	Cluster *Cluster
+
+	svc tcpService
+}
+
+// NewServer creates a server for store; call ListenAndServe or Serve to start it
+func NewServer(store *Store) *Server {
+	return &Server{
+		store:       store,
+		SessionWait: defaultSessionWait,
+		Databases:   defaultDatabases,
+		svc:         newTCPService(),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves clients until
+// the server is closed
+func (srv *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(l)
+}
+
+// Serve accepts connections on l, handling each in its own goroutine
+func (srv *Server) Serve(l net.Listener) error {
+	return srv.svc.serve(l, srv.handle)
+}
+
+// Close stops accepting connections and disconnects every client
+func (srv *Server) Close() error {
+	return srv.svc.close()
+}
+
+// clientConn holds the per-connection protocol state
+type clientConn struct {
+	srv  *Server
+	conn net.Conn
+	rw   *respWriter
+	ctx  context.Context // of the current command: its span, and the client's address as the actor of its writes
+
+	// wmu serializes writes to rw between the command loop and the pusher
+	// of keyspace notifications, and guards ps
+	wmu sync.Mutex
+	ps  *pubsubState        // set while the client has keyspace subscriptions
+	mon <-chan MonitorEvent // set once the client is a monitor
+
+	txn       *Txn // created by WATCH or MULTI
+	multi     bool
+	queued    []string // the commands queued in MULTI, for EXEC's replies
+	execAbort bool     // a command queued in MULTI was rejected
+
+	// token is the session token: the store sequence number the client's
+	// reads must observe, advanced by its own writes and by SESSION
+	token uint64
+
+	// db is the database SELECT chose, and ns its namespace, nil for
+	// database 0
+	db int
+	ns *Namespace
+
+	// node is set once the client proved it is a cluster node, to the
+	// nonce issued for it meanwhile (see nodeauth.go)
+	node      bool
+	nodeNonce string
+}
+
+func (srv *Server) handle(conn net.Conn) {
+	c := &clientConn{srv: srv, conn: conn, rw: newRESPWriter(conn)}
+	connCtx := WithActor(context.Background(), conn.RemoteAddr().String())
+	defer func() {
+		if c.txn != nil {
+			c.txn.Discard()
+		}
+		c.wmu.Lock()
+		c.stopPubsub()
+		if c.mon != nil {
+			srv.store.StopMonitor(c.mon)
+		}
+		c.wmu.Unlock()
+	}()
+
+	rr := newRESPReader(conn)
+	for {
+		args, err := rr.ReadCommand()
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				c.wmu.Lock()
+				c.writeErr(err)
+				c.rw.Flush()
+				c.wmu.Unlock()
+			}
+			return
+		}
+
+		if quit, err := c.serve(connCtx, args); err != nil || quit {
+			return
+		}
+	}
+}
+
+// serve dispatches one command, traced and timed, and flushes its reply. It
+// holds wmu only meanwhile, so the connection's cleanup can take it even if
+// the command panics. It reports whether the client asked to close the
+// connection.
+func (c *clientConn) serve(connCtx context.Context, args []string) (bool, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	var span trace.Span
+	c.ctx, span = c.startSpan(connCtx, args)
+	start := time.Now()
+	quit := c.dispatch(args)
+	elapsed := time.Since(start)
+	c.recordLatency(args[0], elapsed)
+	if c.srv.store.monitoring() {
+		c.srv.store.PublishCommand(MonitorEvent{Time: start, Client: c.conn.RemoteAddr().String(), Command: slices.Clone(args), Duration: elapsed, Err: c.rw.lastError})
+	}
+	c.endSpan(span)
+	return quit, c.rw.Flush()
+}
+
+// startSpan starts the server span of the command args, the parent of the
+// spans of the store operations it runs
+func (c *clientConn) startSpan(ctx context.Context, args []string) (context.Context, trace.Span) {
+	command := strings.ToLower(args[0])
+	ctx, span := c.srv.store.tracer.Start(ctx, command, trace.WithSpanKind(trace.SpanKindServer))
+	if span.IsRecording() {
+		span.SetAttributes(attribute.String("db.system.name", dbSystem), attribute.String("db.operation.name", command),
+			attribute.String("client.address", c.conn.RemoteAddr().String()))
+		if len(args) > 1 && commandHasKey(command) {
+			span.SetAttributes(attribute.String("kv.key", args[1]))
+		}
+	}
+	c.rw.lastError = ""
+	return ctx, span
+}
+
+// endSpan ends the span of the command just dispatched, recording the
+// error it replied, if any
+func (c *clientConn) endSpan(span trace.Span) {
+	if c.rw.lastError != "" {
+		span.SetStatus(codes.Error, c.rw.lastError)
+		span.SetAttributes(attribute.String("kv.result", "error"))
+	} else {
+		span.SetAttributes(attribute.String("kv.result", "ok"))
+	}
+	span.End()
+}
+
+// recordLatency records that the command just dispatched took d, unless
+// it was unknown, so clients can't fill the histograms with made-up names
+func (c *clientConn) recordLatency(command string, d time.Duration) {
+	if !strings.HasPrefix(c.rw.lastError, "ERR unknown command") {
+		c.srv.store.RecordLatency(strings.ToLower(command), d)
+	}
+}
+
+// commandHasKey reports whether command's first argument is a key
+func commandHasKey(command string) bool {
+	switch command {
+	case "put", "get", "delete", "version", "cas":
+		return true
+	}
+	return false
+}
+
+// dispatch executes one command and writes its reply. It reports whether the
+// client asked to close the connection.
+func (c *clientConn) dispatch(args []string) bool {
+	store := c.srv.store
+	command := strings.ToLower(args[0])
+
+	// Commands forwarded by another cluster node arrive as "local <command>"
+	// and are run on this node, without being routed again
+	local := false
+	if command == "local" && len(args) > 1 {
+		args, command, local = args[1:], strings.ToLower(args[1]), true
+	}
+	// Forwarded commands skip the owner's checks, so in a cluster only
+	// nodes may send them, once they proved it (see nodeauth.go). A server
+	// out of any cluster owns every key, so there it bypasses nothing.
+	if local && command != "nodeauth" && c.srv.Cluster != nil && !c.fromNode() {
+		c.rw.WriteError("ERR local commands are only accepted from cluster nodes")
+		return false
+	}
+	cluster := c.srv.Cluster
+	if local {
+		cluster = nil
+	}
+
+	if c.mon != nil && command != "ping" && command != "quit" {
+		c.rw.WriteError(fmt.Sprintf("ERR '%s' is not allowed while monitoring, only ping and quit", command))
+		return false
+	}
+	if c.ps != nil && !allowedWhileSubscribed(command) {
+		c.rw.WriteError(fmt.Sprintf("ERR '%s' is not allowed while subscribed, only (p)subscribe, (p)unsubscribe, ping and quit", command))
+		return false
+	}
+
+	// Forwarded commands carry store keys already
+	if !local {
+		var inDB bool
+		if args, inDB = c.inDatabase(command, args); !inDB {
+			return false
+		}
+	}
+	if c.srv.Cluster != nil {
+		c.pullMissing(command, args)
+	}
+
+	if c.multi {
+		switch command {
+		case "exec", "discard", "multi", "watch", "quit":
+		case "put", "delete", "get":
+			if !c.checkArity(command, args) {
+				c.execAbort = true
+				return false
+			}
+			if cluster != nil {
+				if err := cluster.checkOwned(args[1:2]); err != nil {
+					c.execAbort = true
+					c.writeErr(err)
+					return false
+				}
+			}
+			switch command {
+			case "put":
+				c.txn.Put(args[1], AttributePairs(args[2:]))
+			case "delete":
+				c.txn.Delete(args[1])
+			default:
+				c.txn.Get(args[1])
+			}
+			c.queued = append(c.queued, command)
+			c.rw.WriteSimple("QUEUED")
+			return false
+		default:
+			c.execAbort = true
+			c.rw.WriteError(fmt.Sprintf("ERR '%s' cannot be queued in MULTI, only put, delete and get", command))
+			return false
+		}
+	}
+
+	// Keyspace notifications are for this node's changes, so subscriptions
+	// aren't routed
+	if c.pubsubCommand(command, args) {
+		return false
+	}
+
+	if cluster != nil && c.route(cluster, command, args) {
+		return false
+	}
+
+	var ok bool
+
+	switch command {
+	case "ping":
+		if len(args) > 1 {
+			c.rw.WriteBulk(args[1])
+		} else {
+			c.rw.WriteSimple("PONG")
+		}
+
+	case "quit":
+		c.rw.WriteSimple("OK")
+		return true
+
+	case "put":
+		if !c.checkArity(command, args) {
+			return false
+		}
+		var err error
+		if c.srv.BatchWrites {
+			err = store.putAsync(c.ctx, args[1], AttributePairs(args[2:])).Wait()
+		} else {
+			err = store.PutCtx(c.ctx, args[1], AttributePairs(args[2:]))
+		}
+		if err != nil {
+			c.writeErr(err)
+			return false
+		}
+		c.advanceToken()
+		c.rw.WriteSimple("OK")
+
+	case "get":
+		if args, ok = c.readBound(args, 2); !ok {
+			return false
+		}
+		if !c.checkArity(command, args) || !c.awaitToken() {
+			return false
+		}
+		c.writeAttributes(store.get(c.ctx, args[1]))
+
+	case "delete":
+		if !c.checkArity(command, args) {
+			return false
+		}
+		if err := store.DeleteCtx(c.ctx, args[1]); err != nil {
+			c.writeErr(err)
+			return false
+		}
+		c.advanceToken()
+		c.rw.WriteSimple("OK")
+
+	case "search":
+		if args, ok = c.readBound(args, 3); !ok {
+			return false
+		}
+		if !c.checkArity(command, args) || !c.awaitToken() {
+			return false
+		}
+		results, _ := store.SearchCtx(c.ctx, args[1], args[2])
+		c.rw.WriteStrings(c.databaseKeys(results))
+
+	case "keys":
+		if args, ok = c.readBound(args, 1); !ok || !c.awaitToken() {
+			return false
+		}
+		c.rw.WriteStrings(c.databaseKeys(store.Keys()))
+
+	case "select":
+		c.selectDatabase(args)
+
+	case "version":
+		if args, ok = c.readBound(args, 2); !ok {
+			return false
+		}
+		if len(args) != 2 {
+			c.rw.WriteError("ERR wrong number of arguments for 'version'")
+			return false
+		}
+		if !c.awaitToken() {
+			return false
+		}
+		current, _ := store.GetEntry(args[1])
+		c.rw.WriteInt(int64(current.Version))
+
+	case "cas":
+		if len(args) < 5 || len(args)%2 != 1 {
+			c.rw.WriteError("ERR wrong number of arguments for 'cas'")
+			return false
+		}
+		version, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			c.rw.WriteError("ERR version must be a non-negative integer")
+			return false
+		}
+		err = store.putIfVersion(c.ctx, args[1], AttributePairs(args[3:]), version)
+		switch {
+		case errors.Is(err, ErrVersionConflict):
+			c.rw.WriteError("CONFLICT " + c.databaseKey(args[1]) + " is not at version " + args[2])
+			return false
+		case err != nil:
+			c.writeErr(err)
+			return false
+		}
+		c.advanceToken()
+		c.rw.WriteSimple("OK")
+
+	case "role":
+		if node := store.RaftNode(); node != nil {
+			leader, _ := node.Leader()
+			c.rw.WriteStrings([]string{"raft", strings.ToLower(node.State()), leader})
+		} else if addr, following := store.LeaderAddr(); following {
+			c.rw.WriteStrings([]string{"follower", addr})
+		} else {
+			c.rw.WriteStrings([]string{"leader"})
+		}
+
+	case "raft":
+		if !c.fromNode() {
+			c.rw.WriteError("ERR raft add and remove are only accepted from cluster nodes; use the admin listener's POST /raft/add and /raft/remove")
+			return false
+		}
+		node := store.RaftNode()
+		if node == nil {
+			c.rw.WriteError("ERR store is not running in raft mode")
+			return false
+		}
+		var err error
+		switch {
+		case len(args) == 4 && strings.EqualFold(args[1], "add"):
+			err = node.AddVoter(args[2], args[3])
+		case len(args) == 3 && strings.EqualFold(args[1], "remove"):
+			err = node.RemoveServer(args[2])
+		default:
+			c.rw.WriteError("ERR usage: raft add <id> <addr> | raft remove <id>")
+			return false
+		}
+		if err != nil {
+			c.writeErr(err)
+			return false
+		}
+		c.rw.WriteSimple("OK")
+
+	case "replication":
+		c.rw.WriteBulk(strings.Join(store.ReplicationInfo(), "\r\n"))
+
+	case "info":
+		if len(args) > 2 {
+			c.rw.WriteError("ERR usage: info [<section>]")
+			return false
+		}
+		section := ""
+		if len(args) == 2 {
+			section = args[1]
+		}
+		lines, err := store.info(section, []string{
+			fmt.Sprintf("connected_clients:%d", c.srv.svc.clients()),
+			"tcp_addr:" + c.conn.LocalAddr().String(),
+			fmt.Sprintf("databases:%d", c.srv.Databases),
+		})
+		if err != nil {
+			c.writeErr(err)
+			return false
+		}
+		c.rw.WriteBulk(strings.Join(lines, "\r\n"))
+
+	case "stats":
+		switch {
+		case len(args) == 1:
+			c.rw.WriteBulk(strings.Join(store.Stats().Info(), "\r\n"))
+		case len(args) == 2 && strings.EqualFold(args[1], "reset"):
+			store.ResetStats()
+			c.rw.WriteSimple("OK")
+		case (len(args) == 2 || len(args) == 3) && strings.EqualFold(args[1], "attributes"):
+			stats, err := store.AttributeStats(c.ctx)
+			if err != nil {
+				c.rw.WriteError("ERR " + err.Error())
+				return false
+			}
+			lines := []string{}
+			for _, st := range stats {
+				if len(args) == 2 {
+					lines = append(lines, st.Info())
+				} else if st.Name == args[2] {
+					lines = append(append(lines, st.Info()), st.Details()...)
+				}
+			}
+			if len(args) == 3 && len(lines) == 0 {
+				c.rw.WriteError(fmt.Sprintf("ERR no entry has attribute '%s'", args[2]))
+				return false
+			}
+			c.rw.WriteBulk(strings.Join(lines, "\r\n"))
+		default:
+			c.rw.WriteError("ERR usage: stats [reset | attributes [<attribute>]]")
+			return false
+		}
+
+	case "monitor":
+		if len(args) != 1 {
+			c.rw.WriteError("ERR wrong number of arguments for 'monitor'")
+			return false
+		}
+		if c.ps != nil {
+			c.rw.WriteError("ERR 'monitor' is not allowed while subscribed, only (p)subscribe, (p)unsubscribe, ping and quit")
+			return false
+		}
+		c.mon = store.Monitor()
+		c.rw.WriteSimple("OK")
+		go c.pushMonitor(c.mon)
+
+	case "latency":
+		switch {
+		case len(args) == 1:
+			lines := []string{}
+			for _, st := range store.Latency() {
+				lines = append(lines, st.Info())
+			}
+			c.rw.WriteBulk(strings.Join(lines, "\r\n"))
+		case len(args) == 2 && strings.EqualFold(args[1], "reset"):
+			store.ResetLatency()
+			c.rw.WriteSimple("OK")
+		case len(args) == 2:
+			st, buckets, found := store.CommandLatency(strings.ToLower(args[1]))
+			if !found {
+				c.rw.WriteError(fmt.Sprintf("ERR no latency recorded for '%s'", args[1]))
+				return false
+			}
+			lines := []string{st.Info()}
+			for _, b := range buckets {
+				lines = append(lines, b.Info())
+			}
+			c.rw.WriteBulk(strings.Join(lines, "\r\n"))
+		default:
+			c.rw.WriteError("ERR usage: latency [<command> | reset]")
+			return false
+		}
+
+	case "trigger":
+		switch {
+		case len(args) >= 4 && strings.EqualFold(args[1], "set"):
+			t, err := ParseTrigger(args[2:])
+			if err == nil {
+				err = store.AddTrigger(t)
+			}
+			if err != nil {
+				c.writeErr(err)
+				return false
+			}
+			c.rw.WriteSimple("OK")
+		case len(args) == 3 && strings.EqualFold(args[1], "del"):
+			if store.RemoveTrigger(args[2]) {
+				c.rw.WriteInt(1)
+			} else {
+				c.rw.WriteInt(0)
+			}
+		case len(args) == 2 && strings.EqualFold(args[1], "list"):
+			triggers := store.Triggers()
+			lines := make([]string, len(triggers))
+			for i, t := range triggers {
+				lines[i] = t.String()
+			}
+			c.rw.WriteStrings(lines)
+		default:
+			c.rw.WriteError("ERR usage: trigger set <name> <pattern> [when <attr> <value>] [set <attr> <value>]... [copy <key>] | trigger del <name> | trigger list")
+			return false
+		}
+
+	case "quota":
+		switch {
+		case len(args) == 1:
+			usage := store.NamespaceUsage()
+			lines := make([]string, len(usage))
+			for i, u := range usage {
+				lines[i] = u.Info()
+			}
+			c.rw.WriteBulk(strings.Join(lines, "\r\n"))
+		case len(args) >= 3 && strings.EqualFold(args[1], "set"):
+			q, err := ParseNamespaceQuota(args[3:])
+			if err == nil {
+				err = store.SetNamespaceQuota(args[2], q)
+			}
+			if err != nil {
+				c.writeErr(err)
+				return false
+			}
+			c.rw.WriteSimple("OK")
+		default:
+			c.rw.WriteError("ERR usage: quota | quota set <namespace> [keys <n>] [memory <bytes>]")
+			return false
+		}
+
+	case "webhooks":
+		c.rw.WriteBulk(strings.Join(store.WebhookInfo(), "\r\n"))
+
+	case "connectors":
+		c.rw.WriteBulk(strings.Join(store.ConnectorInfo(), "\r\n"))
+
+	case "changes":
+		if len(args) != 2 && len(args) != 3 {
+			c.rw.WriteError("ERR wrong number of arguments for 'changes'")
+			return false
+		}
+		from, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			c.rw.WriteError("ERR offset is not a sequence number")
+			return false
+		}
+		limit := changesReplyLimit
+		if len(args) == 3 {
+			if limit, err = strconv.Atoi(args[2]); err != nil || limit <= 0 || limit > changesReplyLimit {
+				c.rw.WriteError(fmt.Sprintf("ERR limit must be between 1 and %d", changesReplyLimit))
+				return false
+			}
+		}
+		batches, err := store.ReadChanges(from, limit)
+		if err != nil {
+			c.writeErr(err)
+			return false
+		}
+		lines := make([]string, len(batches))
+		for i, batch := range batches {
+			line, _ := json.Marshal(batch)
+			lines[i] = string(line)
+		}
+		c.rw.WriteStrings(lines)
+
+	case "promote":
+		if !c.fromNode() {
+			c.rw.WriteError("ERR promote is only accepted from cluster nodes; use the admin listener's POST /promote")
+			return false
+		}
+		if err := store.Promote(); err != nil {
+			c.writeErr(err)
+			return false
+		}
+		c.rw.WriteSimple("OK")
+
+	case "token":
+		c.rw.WriteInt(int64(c.token))
+
+	case "owner":
+		if len(args) != 2 {
+			c.rw.WriteError("ERR wrong number of arguments for 'owner'")
+			return false
+		}
+		if c.srv.Cluster == nil {
+			c.rw.WriteError("ERR server is not running in cluster mode")
+			return false
+		}
+		owner := c.srv.Cluster.Owner(args[1])
+		c.rw.WriteStrings([]string{owner, c.srv.Cluster.Addr(owner)})
+
+	case "cluster":
+		if len(args) != 2 || !strings.EqualFold(args[1], "nodes") && !strings.EqualFold(args[1], "leave") {
+			c.rw.WriteError("ERR usage: cluster nodes | cluster leave")
+			return false
+		}
+		if c.srv.Cluster == nil {
+			c.rw.WriteError("ERR server is not running in cluster mode")
+			return false
+		}
+		if strings.EqualFold(args[1], "leave") {
+			if err := c.srv.Cluster.Leave(); err != nil {
+				c.writeErr(err)
+				return false
+			}
+			c.rw.WriteSimple("OK")
+			return false
+		}
+		nodes := c.srv.Cluster.Nodes()
+		c.rw.WriteArrayHeader(len(nodes))
+		for _, node := range nodes {
+			c.rw.WriteArrayHeader(5)
+			c.rw.WriteBulk(node.ID)
+			c.rw.WriteBulk(node.Addr)
+			c.rw.WriteBulk(node.State)
+			c.rw.WriteInt(node.LastSeen.Milliseconds())
+			c.rw.WriteStrings(node.Ranges)
+		}
+
+	case "nodeauth":
+		if len(args) > 2 || c.srv.Cluster == nil {
+			c.rw.WriteError("ERR nodeauth is only accepted from cluster nodes")
+			return false
+		}
+		c.nodeAuth(args)
+
+	case "gossip":
+		if len(args) != 2 || !c.fromNode() {
+			c.rw.WriteError("ERR gossip is only accepted from cluster nodes")
+			return false
+		}
+		table, err := c.srv.Cluster.handleGossip(args[1])
+		if err != nil {
+			c.writeErr(err)
+			return false
+		}
+		c.rw.WriteBulk(table)
+
+	case "rebalance":
+		if c.srv.Cluster == nil {
+			c.rw.WriteError("ERR server is not running in cluster mode")
+			return false
+		}
+		c.rw.WriteBulk(strings.Join(c.srv.Cluster.RebalanceInfo(), "\r\n"))
+
+	case "hints":
+		if c.srv.Cluster == nil {
+			c.rw.WriteError("ERR server is not running in cluster mode")
+			return false
+		}
+		c.rw.WriteBulk(strings.Join(c.srv.Cluster.HintInfo(), "\r\n"))
+
+	case "migrate":
+		if len(args) < 5 || len(args)%2 != 1 || !c.fromNode() {
+			c.rw.WriteError("ERR migrate is only accepted from cluster nodes")
+			return false
+		}
+		version, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			c.rw.WriteError("ERR version must be a non-negative integer")
+			return false
+		}
+		if err := store.adopt(args[1], version, AttributePairs(args[3:])); err != nil {
+			c.writeErr(err)
+			return false
+		}
+		c.rw.WriteSimple("OK")
+
+	case "handoff":
+		if len(args) != 3 || !c.fromNode() {
+			c.rw.WriteError("ERR handoff is only accepted from cluster nodes")
+			return false
+		}
+		moved, err := c.srv.Cluster.handoff(args[1], args[2])
+		if err != nil {
+			c.writeErr(err)
+			return false
+		}
+		if moved {
+			c.rw.WriteInt(1)
+		} else {
+			c.rw.WriteInt(0)
+		}
+
+	case "session":
+		if len(args) != 2 {
+			c.rw.WriteError("ERR wrong number of arguments for 'session'")
+			return false
+		}
+		token, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			c.rw.WriteError("ERR session token must be a non-negative integer")
+			return false
+		}
+		if token > c.token {
+			c.token = token
+		}
+		c.rw.WriteSimple("OK")
+
+	case "watch":
+		if len(args) < 2 {
+			c.rw.WriteError("ERR wrong number of arguments for 'watch'")
+			return false
+		}
+		if c.multi {
+			c.rw.WriteError("ERR WATCH inside MULTI is not allowed")
+			return false
+		}
+		if c.txn == nil {
+			c.txn = store.Watch(args[1:]...)
+		} else {
+			c.txn.Watch(args[1:]...)
+		}
+		c.rw.WriteSimple("OK")
+
+	case "unwatch":
+		if c.txn != nil {
+			c.txn.Discard()
+			c.txn = nil
+		}
+		c.rw.WriteSimple("OK")
+
+	case "multi":
+		if c.multi {
+			c.rw.WriteError("ERR MULTI calls can not be nested")
+			return false
+		}
+		if c.txn == nil {
+			c.txn = store.Watch()
+		}
+		c.multi = true
+		c.rw.WriteSimple("OK")
+
+	case "exec":
+		if !c.multi {
+			c.rw.WriteError("ERR EXEC without MULTI")
+			return false
+		}
+		txn, queued, aborted := c.txn, c.queued, c.execAbort
+		c.txn, c.multi, c.queued, c.execAbort = nil, false, nil, false
+		if aborted {
+			txn.Discard()
+			c.rw.WriteError("EXECABORT Transaction discarded because of previous errors.")
+			return false
+		}
+
+		err := txn.ExecCtx(c.ctx)
+		switch {
+		case errors.Is(err, ErrTxnAborted):
+			c.rw.WriteNullArray()
+		case err != nil:
+			c.writeErr(err)
+		default:
+			c.advanceToken()
+			reads := txn.Reads()
+			c.rw.WriteArrayHeader(len(queued))
+			for _, command := range queued {
+				if command != "get" {
+					c.rw.WriteSimple("OK")
+					continue
+				}
+				c.writeAttributes(reads[0])
+				reads = reads[1:]
+			}
+		}
+
+	case "discard":
+		if !c.multi {
+			c.rw.WriteError("ERR DISCARD without MULTI")
+			return false
+		}
+		c.txn.Discard()
+		c.txn, c.multi, c.queued, c.execAbort = nil, false, nil, false
+		c.rw.WriteSimple("OK")
+
+	default:
+		c.rw.WriteError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+	return false
+}
+
+// route handles the commands that cluster mode sends to other nodes:
+// single-key commands for keys this node doesn't own are forwarded to the
+// owner, and commands over all keys are run on every node. It reports
+// whether it replied; other commands are left to dispatch.
+func (c *clientConn) route(cluster *Cluster, command string, args []string) bool {
+	switch command {
+	case "put", "get", "delete", "version", "cas":
+		if len(args) < 2 || cluster.Owns(args[1]) {
+			return false
+		}
+		owner := cluster.Owner(args[1])
+		hintable := command == "put" || command == "delete"
+		if hintable && cluster.hinting(owner) {
+			// Queue behind the owner's earlier hints so it sees writes in order
+			c.hintWrite(cluster, owner, command, args, errClusterDown)
+			return true
+		}
+		reply, err := cluster.forward(owner, args)
+		switch {
+		case err != nil && hintable && errors.Is(err, errClusterDown):
+			c.hintWrite(cluster, owner, command, args, err)
+		case err != nil:
+			c.writeErr(err)
+		default:
+			c.rw.WriteReply(reply)
+		}
+		return true
+
+	case "keys", "search":
+		arity := 1
+		if command == "search" {
+			arity = 3
+		}
+		bounded, ok := c.readBound(args, arity)
+		if !ok {
+			return true
+		}
+		if command == "search" && !c.checkArity(command, bounded) {
+			return true
+		}
+		if !c.awaitToken() {
+			return true
+		}
+		var local []string
+		if command == "keys" {
+			local = c.srv.store.Keys()
+		} else {
+			local = c.srv.store.Search(bounded[1], bounded[2])
+		}
+		merged, err := cluster.gatherStrings(local, args)
+		if err != nil {
+			c.writeErr(err)
+		} else {
+			c.rw.WriteStrings(c.databaseKeys(merged))
+		}
+		return true
+
+	case "watch":
+		if err := cluster.checkOwned(args[1:]); err != nil {
+			c.writeErr(err)
+			return true
+		}
+	}
+	return false
+}
+
+// hintWrite queues a put or delete for owner, which can't be reached,
+// replying OK, or with err if the write can't be queued
+func (c *clientConn) hintWrite(cluster *Cluster, owner, command string, args []string, err error) {
+	if !c.checkArity(command, args) {
+		return
+	}
+	if !cluster.hint(owner, args) {
+		c.writeErr(err)
+		return
+	}
+	c.rw.WriteSimple("OK")
+}
+
+// pullMissing fetches the keys a command uses that this node owns but
+// doesn't have yet from the nodes still moving keys, so the command sees
+// them. Keys owned by other nodes are left to the command's routing.
+func (c *clientConn) pullMissing(command string, args []string) {
+	var keys []string
+	switch command {
+	case "put", "get", "delete", "version", "cas":
+		keys = args[1:min(2, len(args))]
+	case "watch":
+		keys = args[1:]
+	}
+	for _, key := range keys {
+		if c.srv.Cluster.Owns(key) {
+			c.srv.Cluster.pull(c.srv.store, key)
+		}
+	}
+}
+
+// writeErr replies with err, prefixed with the generic ERR code unless it
+// carries its own
+func (c *clientConn) writeErr(err error) {
+	if errors.Is(err, ErrReadOnly) || errors.Is(err, ErrNotLeader) ||
+		errors.Is(err, errClusterDown) || errors.Is(err, errNotOwner) ||
+		errors.Is(err, ErrTooStale) {
+		c.rw.WriteError(err.Error())
+		return
+	}
+	c.rw.WriteError("ERR " + err.Error())
+}
+
+// advanceToken moves the session token past the client's latest write
+func (c *clientConn) advanceToken() {
+	if seq := c.srv.store.Seq(); seq > c.token {
+		c.token = seq
+	}
+}
+
+// awaitToken blocks a read until the store has applied the session token,
+// replying STALE and returning false if that takes longer than SessionWait
+func (c *clientConn) awaitToken() bool {
+	if c.token == 0 {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.srv.SessionWait)
+	defer cancel()
+	if err := c.srv.store.WaitForSeq(ctx, c.token); err != nil {
+		c.rw.WriteError(fmt.Sprintf("STALE node has not yet applied session token %d", c.token))
+		return false
+	}
+	return true
+}
+
+// readBound strips a trailing "maxstale <ms>" option from a read command
+// with arity base and checks that the node can meet the bound, replying with
+// an error and returning false when it can't
+func (c *clientConn) readBound(args []string, base int) ([]string, bool) {
+	n := len(args)
+	if n != base+2 || !strings.EqualFold(args[n-2], "maxstale") {
+		return args, true
+	}
+	ms, err := strconv.ParseUint(args[n-1], 10, 32)
+	if err != nil {
+		c.rw.WriteError("ERR maxstale must be a non-negative number of milliseconds")
+		return nil, false
+	}
+	if err := c.srv.store.CheckStaleness(time.Duration(ms) * time.Millisecond); err != nil {
+		c.writeErr(err)
+		return nil, false
+	}
+	return args[:n-2], true
+}
+
+// checkArity validates the argument count of the basic data commands,
+// replying with an error when it is wrong
+func (c *clientConn) checkArity(command string, args []string) bool {
+	var ok bool
+	switch command {
+	case "put":
+		ok = len(args) >= 4 && len(args)%2 == 0
+	case "get", "delete":
+		ok = len(args) == 2
+	case "search":
+		ok = len(args) == 3
+	}
+	if !ok {
+		c.rw.WriteError(fmt.Sprintf("ERR wrong number of arguments for '%s'", command))
+	}
+	return ok
+}
+
+// writeAttributes replies with an entry's attributes as a flat array of
+// names and values sorted by name, or a null array for no entry
+func (c *clientConn) writeAttributes(value map[string]interface{}) {
+	if value == nil {
+		c.rw.WriteNullArray()
+		return
+	}
+	attrs := make([]string, 0, len(value))
+	for k := range value {
+		attrs = append(attrs, k)
+	}
+	sort.Strings(attrs)
+	c.rw.WriteArrayHeader(2 * len(attrs))
+	for _, k := range attrs {
+		c.rw.WriteBulk(k)
+		c.rw.WriteBulk(rawValue(value[k]))
+	}
+}
+
+// rawValue renders an attribute value losslessly, so that it parses back to
+// the same value and type when sent in a put
+func rawValue(value interface{}) string {
+	if floatVal, ok := value.(float64); ok {
+		return strconv.FormatFloat(floatVal, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", value)
+}
@@ -0,0 +1,140 @@
+package store
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+// startServer serves srv on a loopback port until the test ends and
+// returns its address
+func startServer(t *testing.T, srv *Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+// dialServer opens a client connection to addr, closed when the test ends
+func dialServer(t *testing.T, addr string) *peerConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &peerConn{conn: conn, r: newRESPReader(conn), w: newRESPWriter(conn)}
+}
+
+// send runs one command on pc and returns its reply
+func send(t *testing.T, pc *peerConn, args ...string) interface{} {
+	t.Helper()
+	reply, err := pc.do(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+// TestServerBlankCommand sends a line of only whitespace, which is skipped,
+// and checks the connection still serves the next command
+func TestServerBlankCommand(t *testing.T) {
+	addr := startServer(t, NewServer(NewStore()))
+	pc := dialServer(t, addr)
+	if _, err := pc.conn.Write([]byte("  \t \r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if reply := send(t, pc, "ping"); reply != respSimple("PONG") {
+		t.Errorf("ping = %v, want PONG", reply)
+	}
+}
+
+// TestMultiExec queues writes and gets, and checks EXEC replies to each in
+// order, each get seeing the writes queued before it
+func TestMultiExec(t *testing.T) {
+	s := NewStore()
+	pc := dialServer(t, startServer(t, NewServer(s)))
+	send(t, pc, "put", "k", "n", "1")
+
+	send(t, pc, "multi")
+	for _, args := range [][]string{{"get", "k"}, {"put", "k", "n", "2"}, {"get", "k"}, {"delete", "k"}, {"get", "k"}} {
+		if reply := send(t, pc, args...); reply != respSimple("QUEUED") {
+			t.Fatalf("%v = %v, want QUEUED", args, reply)
+		}
+	}
+	want := []interface{}{
+		[]interface{}{"n", "1"}, respSimple("OK"), []interface{}{"n", "2"}, respSimple("OK"), []interface{}(nil),
+	}
+	if reply := send(t, pc, "exec"); !reflect.DeepEqual(reply, want) {
+		t.Errorf("exec = %#v, want %#v", reply, want)
+	}
+	if s.Get("k") != nil {
+		t.Error("k survived the transaction's delete")
+	}
+
+	// A command that can't be queued fails the whole transaction
+	send(t, pc, "multi")
+	send(t, pc, "put", "k", "n", "3")
+	if _, refused := send(t, pc, "keys").(respError); !refused {
+		t.Error("keys was queued")
+	}
+	if reply := send(t, pc, "exec"); reply != respError("EXECABORT Transaction discarded because of previous errors.") {
+		t.Errorf("exec = %v, want EXECABORT", reply)
+	}
+	if s.Get("k") != nil {
+		t.Error("an aborted transaction wrote k")
+	}
+}
+
+// TestWatchAbort changes a watched key from another connection and checks
+// EXEC applies nothing, replying with a null array
+func TestWatchAbort(t *testing.T) {
+	s := NewStore()
+	addr := startServer(t, NewServer(s))
+	pc, other := dialServer(t, addr), dialServer(t, addr)
+	send(t, pc, "put", "k", "n", "1")
+
+	send(t, pc, "watch", "k")
+	send(t, pc, "multi")
+	send(t, pc, "put", "k", "n", "2")
+	send(t, other, "put", "k", "n", "3")
+	if reply := send(t, pc, "exec"); !reflect.DeepEqual(reply, []interface{}(nil)) {
+		t.Errorf("exec = %#v, want a null array", reply)
+	}
+	if got := s.Get("k")["n"]; got != 3.0 {
+		t.Errorf("n = %v, want 3", got)
+	}
+
+	// The watch ended with EXEC, so the next transaction goes through
+	send(t, pc, "multi")
+	send(t, pc, "put", "k", "n", "4")
+	send(t, other, "put", "k", "n", "5")
+	if reply := send(t, pc, "exec"); !reflect.DeepEqual(reply, []interface{}{respSimple("OK")}) {
+		t.Errorf("exec = %#v, want [OK]", reply)
+	}
+}
+
+// TestDiscard drops a queued transaction
+func TestDiscard(t *testing.T) {
+	s := NewStore()
+	pc := dialServer(t, startServer(t, NewServer(s)))
+
+	send(t, pc, "multi")
+	send(t, pc, "put", "k", "n", "1")
+	if reply := send(t, pc, "discard"); reply != respSimple("OK") {
+		t.Errorf("discard = %v, want OK", reply)
+	}
+	if s.Get("k") != nil {
+		t.Error("a discarded transaction wrote k")
+	}
+	if _, refused := send(t, pc, "exec").(respError); !refused {
+		t.Error("exec after discard ran")
+	}
+	if _, refused := send(t, pc, "discard").(respError); !refused {
+		t.Error("discard without multi succeeded")
+	}
+}
@@ -0,0 +1,77 @@
+package store
+
+import "context"
+
+// Every committed write advances the store's sequence number. A client that
+// remembers the sequence number after its own write holds a session token:
+// any node that has applied at least that many records is guaranteed to
+// reflect the write, which is what read-your-writes needs once reads can be
+// served by replicas.
+
+// Seq returns the sequence number of the last committed write
+func (s *Store) Seq() uint64 {
+	s.logMutex.Lock()
+	defer s.logMutex.Unlock()
+
+	return s.seq
+}
+
+// WaitForSeq blocks until the store has committed and applied the writes
+// up to sequence number seq, so that reads see them, or ctx is done
+func (s *Store) WaitForSeq(ctx context.Context, seq uint64) error {
+	for {
+		s.logMutex.Lock()
+		if s.visible >= seq {
+			s.logMutex.Unlock()
+			return nil
+		}
+		if s.seqChanged == nil {
+			s.seqChanged = make(chan struct{})
+		}
+		changed := s.seqChanged
+		s.logMutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// advanceSeqLocked records seq as committed and applied, with every record
+// before it, and wakes WaitForSeq callers. Caller must hold logMutex.
+func (s *Store) advanceSeqLocked(seq uint64) {
+	s.seq, s.ahead = seq, nil
+	s.setVisibleLocked(seq)
+}
+
+// appliedLocked records that the record with sequence number seq, which
+// commitRecord logged, is applied. Writes to different keys apply
+// concurrently, and so not always in the order they were logged in: the
+// records become visible to WaitForSeq once all those before them are
+// applied too. Caller must hold logMutex.
+func (s *Store) appliedLocked(seq uint64) {
+	if seq != s.visible+1 {
+		if s.ahead == nil {
+			s.ahead = make(map[uint64]bool)
+		}
+		s.ahead[seq] = true
+		return
+	}
+	for s.ahead[seq+1] {
+		delete(s.ahead, seq+1)
+		seq++
+	}
+	s.setVisibleLocked(seq)
+}
+
+// setVisibleLocked makes the records up to seq visible and wakes
+// WaitForSeq callers. Caller must hold logMutex.
+func (s *Store) setVisibleLocked(seq uint64) {
+	s.visible = seq
+	if s.seqChanged != nil {
+		close(s.seqChanged)
+		s.seqChanged = nil
+	}
+}
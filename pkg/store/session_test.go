@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestSessionTokenSeesWrite holds a write part way through applying it,
+// and checks a read waiting on the sequence number it was logged at waits
+// for all of it
+func TestSessionTokenSeesWrite(t *testing.T) {
+	s := NewStore()
+	entered, release := make(chan struct{}), make(chan struct{})
+	s.RegisterHook(AfterPut, func(key string, _ map[string]interface{}) error {
+		if key == "a" {
+			close(entered)
+			<-release
+		}
+		return nil
+	})
+
+	txn := s.Watch()
+	txn.Put("a", [][]string{{"n", "1"}})
+	txn.Put("b", [][]string{{"n", "1"}})
+	written := make(chan error, 1)
+	go func() { written <- txn.Exec() }()
+	<-entered
+
+	token := s.Seq()
+	read := make(chan map[string]interface{}, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := s.WaitForSeq(ctx, token); err != nil {
+			t.Error(err)
+		}
+		read <- s.Get("b")
+	}()
+	select {
+	case attrs := <-read:
+		t.Fatalf("the read waiting on token %d went ahead of the write, seeing b = %v", token, attrs)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if attrs := <-read; attrs == nil {
+		t.Error("the read waiting on the write's token missed it")
+	}
+}
+
+// TestAppliedOutOfOrder applies records in another order than they were
+// logged in, and checks each only becomes visible with those before it
+func TestAppliedOutOfOrder(t *testing.T) {
+	s := NewStore()
+	s.logMutex.Lock()
+	defer s.logMutex.Unlock()
+
+	s.seq = 4
+	for _, step := range []struct{ applied, visible uint64 }{{2, 0}, {4, 0}, {1, 2}, {3, 4}} {
+		s.appliedLocked(step.applied)
+		if s.visible != step.visible {
+			t.Errorf("after applying %d, %d visible, want %d", step.applied, s.visible, step.visible)
+		}
+	}
+	if len(s.ahead) != 0 {
+		t.Errorf("still ahead: %v", s.ahead)
+	}
+}
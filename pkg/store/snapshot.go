@@ -0,0 +1,283 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// A snapshot is the full state of a store at one sequence number: a header
+// line with the sequence number and attribute type registry, followed by one
+// JSON line per entry in key order. The line format lets snapshots be
+// written and read as streams, whatever the size of the store.
+
+// snapshotHeader is the first line of a snapshot
+type snapshotHeader struct {
+	Seq     uint64            `json:"seq"`
+	Types   map[string]string `json:"types"`
+	Entries int               `json:"entries"` // number of entry lines that follow
+}
+
+// snapshotEntry is one entry line of a snapshot
+type snapshotEntry struct {
+	Key     string                 `json:"key"`
+	Attrs   map[string]interface{} `json:"attrs"`
+	Version uint64                 `json:"version"`
+	Created int64                  `json:"created,omitempty"` // Unix nanoseconds
+	Updated int64                  `json:"updated,omitempty"`
+}
+
+// storeState is an in-memory capture of the store that can be written out
+// as a snapshot after the store has moved on, since entries are immutable
+type storeState struct {
+	seq     uint64
+	types   map[string]AttributeMetadata
+	keys    []string
+	entries map[string]*entry
+}
+
+// captureState returns the store's current state. Caller must exclude
+// writers, for example by holding every stripe.
+func (s *Store) captureState() *storeState {
+	st := &storeState{
+		seq:     s.Seq(),
+		types:   make(map[string]AttributeMetadata),
+		entries: make(map[string]*entry),
+	}
+
+	s.typesMutex.Lock()
+	for attrKey, metadata := range s.attributeTypes {
+		st.types[attrKey] = metadata
+	}
+	s.typesMutex.Unlock()
+
+	s.data.Range(func(k, v interface{}) bool {
+		st.keys = append(st.keys, k.(string))
+		st.entries[k.(string)] = v.(*entry)
+		return true
+	})
+	sort.Strings(st.keys)
+	return st
+}
+
+// WriteTo streams the captured state to w in snapshot format
+func (st *storeState) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+	if err := st.encode(json.NewEncoder(cw)); err != nil {
+		return cw.n, err
+	}
+	return cw.n, bw.Flush()
+}
+
+// encode writes the captured state to enc as a snapshot's header and
+// entries
+func (st *storeState) encode(enc valueEncoder) error {
+	header := snapshotHeader{Seq: st.seq, Types: make(map[string]string, len(st.types)), Entries: len(st.keys)}
+	for attrKey, metadata := range st.types {
+		header.Types[attrKey] = metadata.dataType.String()
+	}
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+
+	for _, key := range st.keys {
+		e := st.entries[key]
+		if err := enc.Encode(snapshotEntry{Key: key, Attrs: e.attrs, Version: e.version, Created: e.created, Updated: e.updated}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreSnapshot replaces the store's entire contents, including its
+// sequence number and type registry, with the snapshot read from r. Caller
+// must exclude writers and readers that need a consistent view.
+func (s *Store) restoreSnapshot(r io.Reader) error {
+	st, err := decodeSnapshot(json.NewDecoder(bufio.NewReader(r)))
+	if err != nil {
+		return err
+	}
+	s.installState(st)
+	return nil
+}
+
+// decodeSnapshot reads one snapshot from dec, leaving anything after it
+func decodeSnapshot(dec valueDecoder) (*storeState, error) {
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("snapshot header: %w", err)
+	}
+	st := &storeState{
+		seq:     header.Seq,
+		types:   make(map[string]AttributeMetadata, len(header.Types)),
+		keys:    make([]string, 0, header.Entries),
+		entries: make(map[string]*entry, header.Entries),
+	}
+	for attrKey, name := range header.Types {
+		t, err := parseAttributeType(name)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot type of %q: %w", attrKey, err)
+		}
+		st.types[attrKey] = AttributeMetadata{dataType: t}
+	}
+
+	for i := 0; i < header.Entries; i++ {
+		var se snapshotEntry
+		if err := dec.Decode(&se); err != nil {
+			return nil, fmt.Errorf("snapshot entry: %w", err)
+		}
+		st.keys = append(st.keys, se.Key)
+		e := &entry{attrs: se.Attrs, version: se.Version, created: se.Created, updated: se.Updated}
+		if e.updated == 0 {
+			// Written before entries were dated
+			now := time.Now().UnixNano()
+			e.created, e.updated = now, now
+		}
+		st.entries[se.Key] = e
+	}
+	return st, nil
+}
+
+// installState replaces the store's contents with st. Caller must exclude
+// writers and readers that need a consistent view.
+func (s *Store) installState(st *storeState) {
+	s.typesMutex.Lock()
+	s.attributeTypes = st.types
+	s.typesMutex.Unlock()
+
+	s.data.Range(func(k, v interface{}) bool {
+		if _, keep := st.entries[k.(string)]; !keep {
+			s.data.Delete(k)
+			s.changed(k.(string), v, nil)
+			s.touch(k.(string))
+		}
+		return true
+	})
+	for key, e := range st.entries {
+		prev, _ := s.data.Swap(key, e)
+		s.changed(key, prev, e)
+		s.touch(key)
+	}
+
+	s.logMutex.Lock()
+	s.advanceSeqLocked(st.seq)
+	s.logMutex.Unlock()
+}
+
+// record returns st as a snapshot log record
+func (st *storeState) record() logRecord {
+	rec := logRecord{Seq: st.seq, Ops: make([]logOp, 0, len(st.keys)), Snapshot: true}
+	for _, key := range st.keys {
+		e := st.entries[key]
+		rec.Ops = append(rec.Ops, logOp{Op: "put", Key: key, Attrs: e.attrs, Version: e.version, Created: e.created, Time: e.updated})
+	}
+	return rec
+}
+
+// installSnapshot replaces the store's contents with st, a snapshot taken
+// from a leader or a backup, and restarts the write log with it, so that the log once
+// again replays to the store's state
+func (s *Store) installSnapshot(st *storeState) error {
+	unlock := s.lockAll()
+	defer unlock()
+
+	s.logMutex.Lock()
+	err := s.truncateLogLocked()
+	if err == nil && s.log != nil {
+		if err = s.appendLocked(st.record(), Logged); err == nil {
+			err = s.checkSyncLocked(s.log, s.log.file.Sync())
+		}
+	}
+	s.logMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.installState(st)
+	s.logger.Info("installed a snapshot", "seq", st.seq, "entries", len(st.keys))
+	return nil
+}
+
+// Backup writes a snapshot of the store to the file at path, replacing it
+// atomically, and returns the sequence number the snapshot was taken at.
+// A new follower of this store, or of its leader, can be seeded from it
+// with SeedFromBackup.
+func (s *Store) Backup(path string) (uint64, error) {
+	s.rlockAll()
+	st := s.captureState()
+	s.runlockAll()
+
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := st.WriteTo(f); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	return st.seq, os.Rename(tmpPath, path)
+}
+
+// SeedFromBackup loads a backup written by Backup, or a backup directory
+// written by BackupDir, into a new, empty store, which then holds the state
+// the backup was taken at, and logs it. Following the leader afterwards only
+// transfers the writes made since, provided the leader's log still reaches
+// back that far.
+func (s *Store) SeedFromBackup(path string) (uint64, error) {
+	if s.Seq() != 0 {
+		return 0, errors.New("only an empty store can be seeded from a backup")
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		b, err := readBackup(path)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := s.installBackup(b); err != nil {
+			return 0, err
+		}
+		return b.manifest.Seq, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	st, err := decodeSnapshot(json.NewDecoder(bufio.NewReader(f)))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := s.installSnapshot(st); err != nil {
+		return 0, err
+	}
+	return st.seq, nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
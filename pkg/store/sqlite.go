@@ -0,0 +1,393 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// SQLite exports write the entries to a new SQLite database so they can be
+// queried with SQL: a single table, entries, with a row per key, the key in
+// its key column, and a column per attribute the exported entries carry,
+// typed after the attribute's registered type. Strings are TEXT, floats
+// REAL and bools INTEGER 0 or 1; attributes an entry lacks are NULL.
+// Entries in namespaces share the columns of their attribute names, and an
+// attribute two namespaces type differently is a TEXT column. The file is written directly in
+// SQLite's file format (https://www.sqlite.org/fileformat2.html), with one
+// table b-tree laid out bottom-up as the sorted entries stream past.
+
+const (
+	sqlitePageSize   = 4096
+	sqliteMaxColumns = 2000 // SQLite's default SQLITE_MAX_COLUMN
+	sqliteTable      = "entries"
+
+	// Table b-tree page types
+	sqliteInteriorTable = 0x05
+	sqliteLeafTable     = 0x0d
+
+	// sqliteMaxLocal and sqliteMinLocal bound the part of a cell's payload
+	// kept on its b-tree page; the rest goes to overflow pages
+	sqliteMaxLocal = sqlitePageSize - 35
+	sqliteMinLocal = (sqlitePageSize-12)*32/255 - 23
+)
+
+// exportSQLite writes the entries selected by filter to a new SQLite
+// database at path and returns how many it wrote
+func (s *Store) exportSQLite(path string, filter ExportFilter) (int, error) {
+	if err := filter.validate(); err != nil {
+		return 0, err
+	}
+	st, keys := s.captureFiltered(filter)
+
+	attrKeys, types := st.tableColumns(keys)
+	if len(attrKeys)+1 > sqliteMaxColumns {
+		return 0, fmt.Errorf("%d attributes don't fit in a SQLite table of at most %d columns", len(attrKeys), sqliteMaxColumns)
+	}
+	columns := tableColumnNames(attrKeys)
+	var sql strings.Builder
+	fmt.Fprintf(&sql, "CREATE TABLE %s (%s TEXT", sqliteTable, sqliteQuote(columns[0]))
+	for i, attrKey := range attrKeys {
+		decl := "TEXT"
+		switch types[attrKey] {
+		case FloatType:
+			decl = "REAL"
+		case BoolType:
+			decl = "INTEGER"
+		}
+		fmt.Fprintf(&sql, ", %s %s", sqliteQuote(columns[i+1]), decl)
+	}
+	sql.WriteString(")")
+
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	sw := &sqliteWriter{w: bufio.NewWriter(file)}
+	err = sw.write(st, keys, attrKeys, types, sql.String())
+	if err == nil {
+		_, err = file.WriteAt(sw.page1, 0)
+	}
+	if err != nil {
+		file.Close()
+		return 0, err
+	}
+	return len(keys), file.Close()
+}
+
+// sqliteQuote quotes name as an SQL identifier
+func sqliteQuote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sqliteChild is a b-tree page as seen by its parent
+type sqliteChild struct {
+	page     uint32
+	maxRowid int64 // largest rowid in the page's subtree
+}
+
+// sqliteWriter writes a database's pages in the order it allocates them,
+// except page 1, which holds the schema and the file header and is only
+// complete once everything else is written
+type sqliteWriter struct {
+	w     *bufio.Writer
+	pages uint32 // pages allocated, page 1 included
+	page1 []byte
+}
+
+// write writes the pages of a database holding the entries under keys, with
+// a column per attribute in attrKeys, of the type in types, created by the
+// statement sql. It leaves page 1 in page1.
+func (sw *sqliteWriter) write(st *storeState, keys, attrKeys []string, types map[string]AttributeType, sql string) error {
+	// Page 1 is written last; reserve its place
+	sw.pages = 1
+	if _, err := sw.w.Write(make([]byte, sqlitePageSize)); err != nil {
+		return err
+	}
+
+	var leaves []sqliteChild
+	var cells [][]byte
+	used := 8 // the leaf page header
+	values := make([]interface{}, len(attrKeys)+1)
+	for i, key := range keys {
+		attrs := st.entries[key].attrs
+		values[0] = key
+		for j, attrKey := range attrKeys {
+			values[j+1] = sqliteValue(tableValue(attrs[attrKey], types[attrKey]))
+		}
+		rowid := int64(i + 1)
+		cell, err := sw.leafCell(rowid, sqliteRecord(values))
+		if err != nil {
+			return err
+		}
+		if used+len(cell)+2 > sqlitePageSize {
+			page, err := sw.writePage(sqliteBTreePage(sqliteLeafTable, 0, cells, 0))
+			if err != nil {
+				return err
+			}
+			leaves = append(leaves, sqliteChild{page: page, maxRowid: rowid - 1})
+			cells, used = nil, 8
+		}
+		cells = append(cells, cell)
+		used += len(cell) + 2
+	}
+	if len(cells) > 0 || len(leaves) == 0 {
+		page, err := sw.writePage(sqliteBTreePage(sqliteLeafTable, 0, cells, 0))
+		if err != nil {
+			return err
+		}
+		leaves = append(leaves, sqliteChild{page: page, maxRowid: int64(len(keys))})
+	}
+	root, err := sw.writeInterior(leaves)
+	if err != nil {
+		return err
+	}
+
+	// The schema row must fit on page 1 after its 100-byte file header,
+	// which has less room than other pages. The part of a payload kept on
+	// the page depends only on the payload's length, so trailing spaces,
+	// which SQLite ignores when it parses the statement, can bring that
+	// part down to a size that fits.
+	var schemaCell []byte
+	for {
+		record := sqliteRecord([]interface{}{"table", sqliteTable, sqliteTable, int64(root), sql})
+		if sqliteLocalSize(len(record))+9+1+4+2 <= sqlitePageSize-100-8 {
+			if schemaCell, err = sw.leafCell(1, record); err != nil {
+				return err
+			}
+			break
+		}
+		sql += " "
+	}
+	if err := sw.w.Flush(); err != nil {
+		return err
+	}
+
+	sw.page1 = sqliteBTreePage(sqliteLeafTable, 100, [][]byte{schemaCell}, 0)
+	h := sw.page1
+	copy(h, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(h[16:], sqlitePageSize)
+	h[18], h[19] = 1, 1 // legacy journal mode file format
+	h[20] = 0           // no reserved bytes per page
+	h[21], h[22], h[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(h[24:], 1)        // file change counter
+	binary.BigEndian.PutUint32(h[28:], sw.pages) // database size in pages
+	binary.BigEndian.PutUint32(h[40:], 1)        // schema cookie
+	binary.BigEndian.PutUint32(h[44:], 4)        // schema format
+	binary.BigEndian.PutUint32(h[56:], 1)        // UTF-8
+	binary.BigEndian.PutUint32(h[92:], 1)        // database size valid for change 1
+	binary.BigEndian.PutUint32(h[96:], 3045000)  // written as by SQLite 3.45.0
+	return nil
+}
+
+// writePage writes page as the next page and returns its number
+func (sw *sqliteWriter) writePage(page []byte) (uint32, error) {
+	sw.pages++
+	_, err := sw.w.Write(page)
+	return sw.pages, err
+}
+
+// leafCell encodes a table leaf cell holding payload under rowid, writing
+// the part of the payload that doesn't fit on the page to overflow pages
+func (sw *sqliteWriter) leafCell(rowid int64, payload []byte) ([]byte, error) {
+	local := sqliteLocalSize(len(payload))
+	cell := appendSQLiteVarint(nil, uint64(len(payload)))
+	cell = appendSQLiteVarint(cell, uint64(rowid))
+	cell = append(cell, payload[:local]...)
+	rest := payload[local:]
+	if len(rest) == 0 {
+		return cell, nil
+	}
+	cell = binary.BigEndian.AppendUint32(cell, sw.pages+1)
+	for len(rest) > 0 {
+		page := make([]byte, sqlitePageSize)
+		n := copy(page[4:], rest)
+		rest = rest[n:]
+		if len(rest) > 0 {
+			binary.BigEndian.PutUint32(page, sw.pages+2)
+		}
+		if _, err := sw.writePage(page); err != nil {
+			return nil, err
+		}
+	}
+	return cell, nil
+}
+
+// writeInterior writes the interior levels of a table b-tree over children,
+// the pages of the level below in rowid order, and returns its root page
+func (sw *sqliteWriter) writeInterior(children []sqliteChild) (uint32, error) {
+	for len(children) > 1 {
+		// Group the children into pages. In a page of n children the last
+		// is the right-most pointer and the others are cells.
+		var groups [][]sqliteChild
+		start, used := 0, 12
+		for i := 1; i < len(children); i++ {
+			size := 4 + len(appendSQLiteVarint(nil, uint64(children[i-1].maxRowid))) + 2
+			if used+size > sqlitePageSize {
+				groups = append(groups, children[start:i])
+				start, used = i, 12
+				continue
+			}
+			used += size
+		}
+		groups = append(groups, children[start:])
+		// An interior page needs a cell, so its last can't have a single child
+		if last := len(groups) - 1; last > 0 && len(groups[last]) == 1 {
+			prev := groups[last-1]
+			groups[last-1] = prev[:len(prev)-1]
+			groups[last] = children[len(children)-2:]
+		}
+
+		parents := make([]sqliteChild, 0, len(groups))
+		for _, group := range groups {
+			cells := make([][]byte, 0, len(group)-1)
+			for _, child := range group[:len(group)-1] {
+				cell := binary.BigEndian.AppendUint32(nil, child.page)
+				cells = append(cells, appendSQLiteVarint(cell, uint64(child.maxRowid)))
+			}
+			right := group[len(group)-1]
+			page, err := sw.writePage(sqliteBTreePage(sqliteInteriorTable, 0, cells, right.page))
+			if err != nil {
+				return 0, err
+			}
+			parents = append(parents, sqliteChild{page: page, maxRowid: right.maxRowid})
+		}
+		children = parents
+	}
+	return children[0].page, nil
+}
+
+// sqliteBTreePage lays out a b-tree page of kind with its header at offset,
+// its cell pointers after the header and its cells packed at the end of
+// the page. right is an interior page's right-most child.
+func sqliteBTreePage(kind byte, offset int, cells [][]byte, right uint32) []byte {
+	page := make([]byte, sqlitePageSize)
+	h := page[offset:]
+	h[0] = kind
+	binary.BigEndian.PutUint16(h[3:], uint16(len(cells)))
+	ptr := offset + 8
+	if kind == sqliteInteriorTable {
+		binary.BigEndian.PutUint32(h[8:], right)
+		ptr += 4
+	}
+	content := sqlitePageSize
+	for _, cell := range cells {
+		content -= len(cell)
+		copy(page[content:], cell)
+		binary.BigEndian.PutUint16(page[ptr:], uint16(content))
+		ptr += 2
+	}
+	binary.BigEndian.PutUint16(h[5:], uint16(content))
+	return page
+}
+
+// sqliteLocalSize returns how much of a table leaf cell's payload of size
+// bytes is kept on its page
+func sqliteLocalSize(size int) int {
+	if size <= sqliteMaxLocal {
+		return size
+	}
+	local := sqliteMinLocal + (size-sqliteMinLocal)%(sqlitePageSize-4)
+	if local > sqliteMaxLocal {
+		local = sqliteMinLocal
+	}
+	return local
+}
+
+// sqliteValue converts an attribute value to its SQLite column value
+func sqliteValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return int64(1)
+		}
+		return int64(0)
+	case float64:
+		if math.IsNaN(v) {
+			return nil // SQLite has no NaN and reads it as NULL
+		}
+	}
+	return value
+}
+
+// sqliteRecord encodes values in SQLite's record format: nil as NULL, int64
+// and float64 as numbers and anything else, normally a string, as TEXT
+func sqliteRecord(values []interface{}) []byte {
+	var header, body []byte
+	for _, value := range values {
+		switch v := value.(type) {
+		case nil:
+			header = append(header, 0)
+		case int64:
+			switch {
+			case v == 0:
+				header = append(header, 8)
+			case v == 1:
+				header = append(header, 9)
+			case v >= math.MinInt8 && v <= math.MaxInt8:
+				header = append(header, 1)
+				body = append(body, byte(v))
+			case v >= math.MinInt16 && v <= math.MaxInt16:
+				header = append(header, 2)
+				body = binary.BigEndian.AppendUint16(body, uint16(v))
+			case v >= math.MinInt32 && v <= math.MaxInt32:
+				header = append(header, 4)
+				body = binary.BigEndian.AppendUint32(body, uint32(v))
+			default:
+				header = append(header, 6)
+				body = binary.BigEndian.AppendUint64(body, uint64(v))
+			}
+		case float64:
+			header = append(header, 7)
+			body = binary.BigEndian.AppendUint64(body, math.Float64bits(v))
+		default:
+			text := fmt.Sprint(v)
+			header = appendSQLiteVarint(header, uint64(2*len(text)+13))
+			body = append(body, text...)
+		}
+	}
+	// The header's size includes the varint holding it
+	size := len(header) + 1
+	for len(appendSQLiteVarint(nil, uint64(size)))+len(header) != size {
+		size = len(header) + len(appendSQLiteVarint(nil, uint64(size)))
+	}
+	record := appendSQLiteVarint(make([]byte, 0, size+len(body)), uint64(size))
+	record = append(record, header...)
+	return append(record, body...)
+}
+
+// appendSQLiteVarint appends v as a SQLite varint: big-endian groups of 7
+// bits with the high bit set on all but the last byte, and a ninth byte of
+// 8 bits for values over 56 bits
+func appendSQLiteVarint(b []byte, v uint64) []byte {
+	if v>>56 != 0 {
+		var buf [9]byte
+		buf[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(b, buf[:]...)
+	}
+	var buf [8]byte
+	n := 0
+	for {
+		buf[n] = byte(v & 0x7f)
+		n++
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		c := buf[i]
+		if i > 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+	}
+	return b
+}
@@ -0,0 +1,232 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// sqliteQuery runs query against the database at path with the sqlite3
+// shell, returning its rows, and skips the test where sqlite3 isn't
+// installed
+func sqliteQuery(t *testing.T, path, query string) []map[string]interface{} {
+	t.Helper()
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	out, err := exec.Command("sqlite3", "-bail", "-json", path, query).CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3 %q: %v\n%s", query, err, out)
+	}
+	var rows []map[string]interface{}
+	if len(bytes.TrimSpace(out)) > 0 {
+		if err := json.Unmarshal(out, &rows); err != nil {
+			t.Fatalf("sqlite3 %q: %v\n%s", query, err, out)
+		}
+	}
+	return rows
+}
+
+// checkSQLiteIntegrity fails the test unless SQLite finds the database at
+// path well formed
+func checkSQLiteIntegrity(t *testing.T, path string) {
+	t.Helper()
+	rows := sqliteQuery(t, path, "PRAGMA integrity_check")
+	if len(rows) != 1 || rows[0]["integrity_check"] != "ok" {
+		t.Fatalf("integrity_check: %v", rows)
+	}
+}
+
+func exportSQLiteFile(t *testing.T, s *Store) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "export.sqlite")
+	if _, err := s.ExportFile(path, "sqlite", ExportFilter{}); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExportSQLiteGolden(t *testing.T) {
+	s := NewStore()
+	for key, attrs := range map[string][][]string{
+		"user1": {{"name", "ann"}, {"age", "30"}, {"admin", "true"}},
+		"user2": {{"name", "bob"}, {"age", "41.5"}},
+		"user3": {{"name", `quote "this"`}, {"Name", "clash"}},
+	} {
+		if err := s.Put(key, attrs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := exportSQLiteFile(t, s)
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "export.sqlite")
+	if *update {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("export differs from %s; rerun with -update if the change is intended", golden)
+	}
+
+	checkSQLiteIntegrity(t, path)
+	// Name sorts first, so the name column clashing with it is name_2
+	rows := sqliteQuery(t, path, `SELECT key, "Name", name_2, age, admin, typeof(age) AS t FROM entries ORDER BY key`)
+	wantRows := []string{
+		`{"Name":null,"admin":1,"age":30,"key":"user1","name_2":"ann","t":"real"}`,
+		`{"Name":null,"admin":null,"age":41.5,"key":"user2","name_2":"bob","t":"real"}`,
+		`{"Name":"clash","admin":null,"age":null,"key":"user3","name_2":"quote \"this\"","t":"null"}`,
+	}
+	if len(rows) != len(wantRows) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(wantRows))
+	}
+	for i, row := range rows {
+		if b, _ := json.Marshal(row); string(b) != wantRows[i] {
+			t.Errorf("row %d = %s, want %s", i, b, wantRows[i])
+		}
+	}
+}
+
+// TestExportSQLiteMultiPage exports enough rows for several levels of
+// interior pages over the leaves
+func TestExportSQLiteMultiPage(t *testing.T) {
+	const n = 40000
+	s := NewStore()
+	for i := 0; i < n; i++ {
+		key := "key" + strconv.Itoa(100000+i)
+		attrs := [][]string{{"n", strconv.Itoa(i)}, {"pad", strings.Repeat("x", i%200)}}
+		if err := s.Put(key, attrs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := exportSQLiteFile(t, s)
+
+	// A page count past one interior page's reach over the leaves
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if pages := info.Size() / sqlitePageSize; pages < 1000 {
+		t.Fatalf("export has %d pages, want a multi-level tree", pages)
+	}
+	checkSQLiteIntegrity(t, path)
+
+	rows := sqliteQuery(t, path, "SELECT count(*) AS c, sum(n) AS s, max(length(pad)) AS m FROM entries")
+	if got := rows[0]; got["c"] != float64(n) || got["s"] != float64(n*(n-1)/2) || got["m"] != float64(199) {
+		t.Errorf("aggregates = %v", got)
+	}
+	rows = sqliteQuery(t, path, "SELECT key, n FROM entries WHERE rowid IN (1, 20000, 40000) ORDER BY rowid")
+	for i, want := range []int{0, 19999, 39999} {
+		if rows[i]["key"] != "key"+strconv.Itoa(100000+want) || rows[i]["n"] != float64(want) {
+			t.Errorf("row %d = %v", i, rows[i])
+		}
+	}
+}
+
+// TestExportSQLiteOverflow exports rows whose payload spills to one and to
+// many overflow pages, and a schema row too long for page 1
+func TestExportSQLiteOverflow(t *testing.T) {
+	s := NewStore()
+	sizes := map[string]int{
+		"fits":      sqliteMaxLocal - 100,
+		"one":       sqliteMaxLocal + 1,
+		"edge":      sqliteMaxLocal + sqlitePageSize - 4,
+		"many":      10 * sqlitePageSize,
+		"very_long": 100000,
+	}
+	for key, size := range sizes {
+		if err := s.Put(key, [][]string{{"blob", strings.Repeat(key[:1], size)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var wide [][]string
+	for i := 0; i < 150; i++ {
+		wide = append(wide, []string{"a_rather_long_attribute_name_" + strconv.Itoa(i), "1"})
+	}
+	if err := s.Put("wide", wide); err != nil {
+		t.Fatal(err)
+	}
+	path := exportSQLiteFile(t, s)
+	checkSQLiteIntegrity(t, path)
+
+	rows := sqliteQuery(t, path, "SELECT key, length(blob) AS n, substr(blob, length(blob)) AS last FROM entries WHERE blob IS NOT NULL ORDER BY key")
+	if len(rows) != len(sizes) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(sizes))
+	}
+	for _, row := range rows {
+		key := row["key"].(string)
+		if row["n"] != float64(sizes[key]) || row["last"] != key[:1] {
+			t.Errorf("%s: length %v, last %v; want %d, %s", key, row["n"], row["last"], sizes[key], key[:1])
+		}
+	}
+	rows = sqliteQuery(t, path, `SELECT a_rather_long_attribute_name_149 AS v FROM entries WHERE key = 'wide'`)
+	if len(rows) != 1 || rows[0]["v"] != float64(1) {
+		t.Errorf("wide row = %v", rows)
+	}
+}
+
+// TestExportSQLiteNamespaces exports entries of several namespaces, which
+// share the columns of their attribute names
+func TestExportSQLiteNamespaces(t *testing.T) {
+	s := NewStore()
+	for key, attrs := range map[string][][]string{
+		"tenantA/u1": {{"age", "30"}, {"name", "ann"}},
+		"tenantB/u2": {{"age", "thirty"}, {"admin", "true"}},
+		"u3":         {{"age", "5"}},
+	} {
+		if err := s.Put(key, attrs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := exportSQLiteFile(t, s)
+	checkSQLiteIntegrity(t, path)
+
+	// tenantB types age as a string, so the column shared with tenantA's
+	// and the default namespace's floats is TEXT
+	rows := sqliteQuery(t, path, "SELECT name, type FROM pragma_table_info('entries') ORDER BY cid")
+	var columns []string
+	for _, row := range rows {
+		columns = append(columns, row["name"].(string)+" "+row["type"].(string))
+	}
+	if got, want := strings.Join(columns, ", "), "key TEXT, admin INTEGER, age TEXT, name TEXT"; got != want {
+		t.Errorf("columns = %s, want %s", got, want)
+	}
+	rows = sqliteQuery(t, path, "SELECT key, age, admin, name FROM entries ORDER BY key")
+	want := []string{
+		`{"admin":null,"age":"30","key":"tenantA/u1","name":"ann"}`,
+		`{"admin":1,"age":"thirty","key":"tenantB/u2","name":null}`,
+		`{"admin":null,"age":"5","key":"u3","name":null}`,
+	}
+	for i, row := range rows {
+		if b, _ := json.Marshal(row); i >= len(want) || string(b) != want[i] {
+			t.Errorf("row %d = %s", i, b)
+		}
+	}
+
+	// Without tenantB, every namespace's age is a float
+	s = NewStore()
+	for _, key := range []string{"tenantA/u1", "u3"} {
+		if err := s.Put(key, [][]string{{"age", "30"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path = exportSQLiteFile(t, s)
+	rows = sqliteQuery(t, path, "SELECT typeof(age) AS t, count(*) AS n FROM entries GROUP BY 1")
+	if len(rows) != 1 || rows[0]["t"] != "real" || rows[0]["n"] != float64(2) {
+		t.Errorf("age = %v, want two reals", rows)
+	}
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// The store counts its entries and their attributes as it changes, so
+// Len, AttributeCount and Stats answer without scanning it, and counts the
+// operations made through its methods, until ResetStats starts them over.
+
+// Stats is a summary of a store's size and of the operations made on it
+type Stats struct {
+	Keys       int // entries
+	Attributes int // attributes over all entries
+
+	// Attributes by type
+	Strings int
+	Floats  int
+	Bools   int
+
+	AttributeNames int   // attribute names with a registered type
+	MemoryBytes    int64 // estimated memory of the entries, as MemoryUsage returns
+	MaxMemoryBytes int64 // the limit set by WithMaxMemory, 0 for none
+
+	// Operations since Since, when the store was made or ResetStats last
+	// called. Puts counts every put, typed or not, whether it succeeded or
+	// not, and TypeErrors those rejected for a value of another type than
+	// its attribute's. Gets counts the keys read by Get, GetCtx and MGet,
+	// Hits and Misses whether each had an entry. Deletes counts the keys
+	// given to Delete and DeleteKeys, and Searches the searches made.
+	Puts       uint64
+	TypeErrors uint64
+	Gets       uint64
+	Hits       uint64
+	Misses     uint64
+	Deletes    uint64
+	Searches   uint64
+	Since      time.Time
+}
+
+// entryCounts counts a store's entries and attributes
+type entryCounts struct {
+	keys       atomic.Int64
+	attributes atomic.Int64
+	byType     [BoolType + 1]atomic.Int64
+}
+
+// add adds the entry e, or takes it away for sign -1. e is nil for no
+// entry.
+func (c *entryCounts) add(e interface{}, sign int64) {
+	if e == nil {
+		return
+	}
+	attrs := e.(*entry).attrs
+	c.keys.Add(sign)
+	c.attributes.Add(sign * int64(len(attrs)))
+	for _, value := range attrs {
+		if t, err := valueType(value); err == nil {
+			c.byType[t].Add(sign)
+		}
+	}
+}
+
+// opCounts counts a store's operations
+type opCounts struct {
+	puts       atomic.Uint64
+	typeErrors atomic.Uint64
+	gets       atomic.Uint64
+	hits       atomic.Uint64
+	misses     atomic.Uint64
+	deletes    atomic.Uint64
+	searches   atomic.Uint64
+	since      atomic.Int64 // UnixNano
+}
+
+// put counts a put that returned err
+func (c *opCounts) put(err error) {
+	c.puts.Add(1)
+	if errors.Is(err, ErrTypeMismatch) {
+		c.typeErrors.Add(1)
+	}
+}
+
+// get counts a read of a key, a hit if found
+func (c *opCounts) get(found bool) {
+	c.gets.Add(1)
+	if found {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+}
+
+// reset starts the counts over from now
+func (c *opCounts) reset() {
+	for _, n := range []*atomic.Uint64{&c.puts, &c.typeErrors, &c.gets, &c.hits, &c.misses, &c.deletes, &c.searches} {
+		n.Store(0)
+	}
+	c.since.Store(time.Now().UnixNano())
+}
+
+// ResetStats starts the operation counts of Stats over from zero. The
+// counts drop to zero one at a time, so a Stats call made meanwhile may see
+// some reset and others not.
+func (s *Store) ResetStats() {
+	s.ops.reset()
+}
+
+// Len returns the number of entries in the store
+func (s *Store) Len() int {
+	return int(s.counts.keys.Load())
+}
+
+// AttributeCount returns the number of attributes over all the store's
+// entries
+func (s *Store) AttributeCount() int {
+	return int(s.counts.attributes.Load())
+}
+
+// Stats returns a summary of the store's size as of one point in time
+func (s *Store) Stats() Stats {
+	s.rlockAll()
+	st := Stats{
+		Keys:           int(s.counts.keys.Load()),
+		Attributes:     int(s.counts.attributes.Load()),
+		Strings:        int(s.counts.byType[StringType].Load()),
+		Floats:         int(s.counts.byType[FloatType].Load()),
+		Bools:          int(s.counts.byType[BoolType].Load()),
+		MemoryBytes:    s.memory.Load(),
+		MaxMemoryBytes: s.maxMemory,
+	}
+	s.runlockAll()
+
+	s.typesMutex.Lock()
+	st.AttributeNames = len(s.attributeTypes)
+	s.typesMutex.Unlock()
+
+	st.Puts, st.TypeErrors = s.ops.puts.Load(), s.ops.typeErrors.Load()
+	st.Gets, st.Hits, st.Misses = s.ops.gets.Load(), s.ops.hits.Load(), s.ops.misses.Load()
+	st.Deletes, st.Searches = s.ops.deletes.Load(), s.ops.searches.Load()
+	st.Since = time.Unix(0, s.ops.since.Load())
+	return st
+}
+
+// Info reports st as "name:value" lines, as the stats command prints them
+func (st Stats) Info() []string {
+	return []string{
+		fmt.Sprintf("keys:%d", st.Keys),
+		fmt.Sprintf("attributes:%d", st.Attributes),
+		fmt.Sprintf("strings:%d", st.Strings),
+		fmt.Sprintf("floats:%d", st.Floats),
+		fmt.Sprintf("bools:%d", st.Bools),
+		fmt.Sprintf("attribute_names:%d", st.AttributeNames),
+		fmt.Sprintf("memory_bytes:%d", st.MemoryBytes),
+		fmt.Sprintf("max_memory_bytes:%d", st.MaxMemoryBytes),
+		fmt.Sprintf("puts:%d", st.Puts),
+		fmt.Sprintf("type_errors:%d", st.TypeErrors),
+		fmt.Sprintf("gets:%d", st.Gets),
+		fmt.Sprintf("hits:%d", st.Hits),
+		fmt.Sprintf("misses:%d", st.Misses),
+		fmt.Sprintf("deletes:%d", st.Deletes),
+		fmt.Sprintf("searches:%d", st.Searches),
+		"since:" + st.Since.UTC().Format(time.RFC3339),
+	}
+}
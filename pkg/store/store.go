@@ -0,0 +1,667 @@
+// Package store is a thread-safe in-memory key-value store whose entries
+// are sets of typed attributes, with an optional write log, snapshots and
+// backups, imports and exports, replication, clustering and a Redis
+// protocol server. The key-value-go CLI is built on it.
+package store
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// [Previous type definitions and struct definitions remain the same...]
+// AttributeType represents the possible data types for attribute values
+type AttributeType int
+
+const (
+	StringType AttributeType = iota
+	FloatType
+	BoolType
+)
+
+// String returns the type's name: "string", "float" or "bool"
+func (t AttributeType) String() string {
+	switch t {
+	case StringType:
+		return "string"
+	case FloatType:
+		return "float"
+	case BoolType:
+		return "bool"
+	}
+	return fmt.Sprintf("AttributeType(%d)", int(t))
+}
+
+// parseAttributeType is the inverse of AttributeType.String
+func parseAttributeType(name string) (AttributeType, error) {
+	switch name {
+	case "string":
+		return StringType, nil
+	case "float":
+		return FloatType, nil
+	case "bool":
+		return BoolType, nil
+	}
+	return 0, fmt.Errorf("unknown attribute type %q", name)
+}
+
+// entry is a stored value. Entries are immutable once published in
+// Store.data; every write replaces the whole entry.
+type entry struct {
+	attrs    map[string]interface{}
+	version  uint64
+	created  int64        // UnixNano of the put that created the entry
+	updated  int64        // UnixNano of its last put
+	accessed atomic.Int64 // UnixNano of the last read or write, kept under EvictLRU
+}
+
+// AttributeMetadata stores the data type for an attribute
+type AttributeMetadata struct {
+	dataType AttributeType
+}
+
+// Store represents the thread-safe key-value store.
+//
+// Entries live in a sync.Map so that Get never takes a lock. Writers lock the
+// stripe owning their key (see locks.go), so writes to unrelated keys proceed
+// in parallel, and Search and Keys read-lock every stripe for a consistent
+// view. An entry's attribute map is never modified after it has been
+// published; writes always store a freshly built map.
+type Store struct {
+	data    sync.Map // key -> *entry
+	stripes []sync.RWMutex
+
+	attributeTypes map[string]AttributeMetadata // by name, namespaced by typeKey
+	inflightTypes  map[string]*inflightType     // new types of writes not yet committed
+	typesMutex     sync.Mutex
+
+	log        *writeLog       // nil for a purely in-memory store
+	seq        uint64          // sequence number of the last committed record
+	visible    uint64          // sequence number up to which every record is applied
+	ahead      map[uint64]bool // records applied past visible, see appliedLocked
+	seqChanged chan struct{}
+	durability Durability
+	logMutex   sync.Mutex
+
+	watchers   map[string]map[*Txn]struct{}
+	watchMutex sync.Mutex
+
+	subs subscriptions
+
+	triggers     []Trigger // replaced, never modified in place
+	triggerMutex sync.RWMutex
+
+	hooks hookSet
+
+	resolver ConflictResolver
+
+	batch     *batcher
+	batchOnce sync.Once
+
+	readOnly   atomic.Bool  // set while following a leader
+	follower   *Follower    // guarded by logMutex
+	leader     *Leader      // guarded by logMutex
+	failover   *Failover    // guarded by logMutex
+	links      []*SyncLink  // guarded by logMutex
+	webhooks   []*Webhook   // guarded by logMutex
+	connectors []*Connector // guarded by logMutex
+	sinks      map[chan logRecord]struct{}
+
+	replTLS    *tls.Config // set by SecureReplication before replication starts
+	replSecret string
+
+	crdt     *crdtClock // set by EnableCRDT before the store is shared
+	crdtMeta sync.Map   // key -> *crdtMeta, guarded by the key's stripe
+
+	raftNode   *RaftNode    // set by StartRaft before the store is shared
+	applyMutex sync.RWMutex // serializes Raft's applier with rlockAll
+
+	memory    atomic.Int64 // estimated bytes of the entries, see eviction.go
+	maxMemory int64        // 0 for no limit
+	eviction  EvictionPolicy
+	evicting  atomic.Bool
+	victims   *evictionKeys // the keys eviction samples, nil under NoEviction
+	quota     quotaSet      // see quota.go
+
+	counts  entryCounts // see stats.go
+	ops     opCounts
+	latency sync.Map // command name to *latencyHistogram, see latency.go
+	mons    monitors // see monitor.go
+
+	codec  Codec        // of the replication stream, see codec.go
+	logger *slog.Logger // see logging.go
+	tracer trace.Tracer // see tracing.go
+	audit  atomic.Pointer[AuditLog]
+
+	started time.Time // when the store was made, see info.go
+}
+
+// [Previous helper functions and methods remain the same...]
+// NewStore creates a new instance of the key-value store, configured by
+// opts. Without options it is purely in memory, with no memory limit.
+func NewStore(opts ...Option) *Store {
+	c := newConfig(opts)
+	if err := c.validate(); err != nil {
+		panic("store: " + err.Error())
+	}
+	s := &Store{
+		stripes:        make([]sync.RWMutex, c.stripes),
+		attributeTypes: make(map[string]AttributeMetadata),
+		inflightTypes:  make(map[string]*inflightType),
+		watchers:       make(map[string]map[*Txn]struct{}),
+		durability:     c.durability,
+		resolver:       c.resolver,
+		maxMemory:      c.maxMemory,
+		eviction:       c.eviction,
+		codec:          c.codec,
+		logger:         c.logger,
+		tracer:         c.tracer,
+		started:        time.Now(),
+	}
+	if s.maxMemory > 0 && s.eviction != NoEviction {
+		s.victims = newEvictionKeys()
+	}
+	s.ops.since.Store(time.Now().UnixNano())
+	for _, t := range c.triggers {
+		s.AddTrigger(t)
+	}
+	for _, h := range c.hooks {
+		s.RegisterHook(h.kind, h.fn)
+	}
+	for namespace, q := range c.quotas {
+		s.SetNamespaceQuota(namespace, q)
+	}
+	return s
+}
+
+// DetermineType returns the AttributeType for a given string value
+func DetermineType(value string) (AttributeType, interface{}, error) {
+	// Try boolean first
+	if value == "true" || value == "false" {
+		return BoolType, value == "true", nil
+	}
+
+	// Try float (will also handle integers)
+	if floatVal, err := strconv.ParseFloat(value, 64); err == nil {
+		return FloatType, floatVal, nil
+	}
+
+	// Default to string
+	return StringType, value, nil
+}
+
+// Put adds or updates a key-value pair in the store
+func (s *Store) Put(key string, attributes [][]string) error {
+	return s.PutCtx(context.Background(), key, attributes)
+}
+
+// PutCtx is Put honoring ctx. If ctx is done before the write is applied,
+// including while waiting for the key's lock, nothing is written and ctx's
+// error is returned.
+func (s *Store) PutCtx(ctx context.Context, key string, attributes [][]string) error {
+	return s.put(ctx, key, attributes, s.defaultDurability())
+}
+
+// PutWithDurability is Put acknowledged only once the write has reached the
+// given durability, regardless of the store's default
+func (s *Store) PutWithDurability(key string, attributes [][]string, d Durability) error {
+	return s.put(context.Background(), key, attributes, d)
+}
+
+func (s *Store) put(ctx context.Context, key string, attributes [][]string, d Durability) (err error) {
+	_, span := s.startSpan(ctx, "put", key)
+	defer func() {
+		s.ops.put(err)
+		endSpan(span, err)
+	}()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	stripe := s.stripeFor(key)
+	stripe.Lock()
+	defer stripe.Unlock()
+	lockWaited(span, start)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := s.Writable(); err != nil {
+		return err
+	}
+
+	s.typesMutex.Lock()
+	pending := make(map[string]AttributeMetadata)
+	newData, err := s.parseAttributes(key, attributes, pending)
+	if err == nil {
+		s.holdTypes(pending)
+	}
+	s.typesMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = s.commit([]logOp{{Op: "put", Key: key, Attrs: newData, actor: actorOf(ctx)}}, d)
+	s.releaseTypes(pending, err)
+	return err
+}
+
+// parseAttributes converts raw attribute pairs into typed values and checks
+// them against the attribute types registered in key's namespace. Types seen
+// for the first time are recorded in pending rather than registered, so a
+// failed write leaves the registry untouched: the write holds them with
+// holdTypes until it is committed. Caller must hold typesMutex.
+func (s *Store) parseAttributes(key string, attributes [][]string, pending map[string]AttributeMetadata) (map[string]interface{}, error) {
+	newData := make(map[string]interface{})
+
+	for _, attr := range attributes {
+		if len(attr) != 2 {
+			return nil, invalid("attribute %q is not a name and a value", attr)
+		}
+		attrKey := attr[0]
+		attrValue := attr[1]
+
+		valueType, parsedValue, err := DetermineType(attrValue)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.checkType(typeKey(key, attrKey), valueType, pending); err != nil {
+			return nil, err
+		}
+
+		newData[attrKey] = parsedValue
+	}
+
+	return newData, nil
+}
+
+// checkType verifies valueType against the registered or pending type of
+// attrKey, an attribute name as typeKey scopes it to a namespace, recording
+// it in pending if the attribute is new. A type another write holds counts
+// as registered, and goes in pending too, as the write relies on it. Caller
+// must hold typesMutex.
+func (s *Store) checkType(attrKey string, valueType AttributeType, pending map[string]AttributeMetadata) error {
+	metadata, exists := s.attributeTypes[attrKey]
+	if !exists {
+		metadata, exists = pending[attrKey]
+	}
+	if !exists {
+		if t := s.inflightTypes[attrKey]; t != nil {
+			metadata, exists = t.metadata, true
+			pending[attrKey] = metadata
+		}
+	}
+	if !exists {
+		pending[attrKey] = AttributeMetadata{dataType: valueType}
+		return nil
+	}
+	if metadata.dataType != valueType {
+		return &TypeMismatchError{Attribute: attrKey, Expected: metadata.dataType, Got: valueType}
+	}
+	return nil
+}
+
+// inflightType is an attribute type held by the writes that rely on it
+// while they are committed
+type inflightType struct {
+	metadata AttributeMetadata
+	writes   int
+}
+
+// holdTypes holds the attribute types collected by parseAttributes for a
+// write about to be committed: concurrent writes check against them as if
+// they were registered, but they are only registered once the write is, by
+// releaseTypes, which the write must call with its outcome. In Raft mode it
+// does nothing: there the registry only changes as committed records are
+// applied, so it evolves identically on every node. Caller must hold
+// typesMutex.
+func (s *Store) holdTypes(pending map[string]AttributeMetadata) {
+	if s.raftNode != nil {
+		return
+	}
+	for attrKey, metadata := range pending {
+		t := s.inflightTypes[attrKey]
+		if t == nil {
+			t = &inflightType{metadata: metadata}
+			s.inflightTypes[attrKey] = t
+		}
+		t.writes++
+	}
+}
+
+// releaseTypes ends a write's hold on the types in held: it registers them
+// if err, the write's outcome, is nil. A failed write's types are dropped
+// once no other write holds them, so the registry keeps only the types of
+// writes that happened.
+func (s *Store) releaseTypes(held map[string]AttributeMetadata, err error) {
+	if len(held) == 0 || s.raftNode != nil {
+		return
+	}
+	s.typesMutex.Lock()
+	defer s.typesMutex.Unlock()
+
+	for attrKey, metadata := range held {
+		if err == nil {
+			s.attributeTypes[attrKey] = metadata
+		}
+		if t := s.inflightTypes[attrKey]; t != nil {
+			if t.writes--; t.writes == 0 {
+				delete(s.inflightTypes, attrKey)
+			}
+		}
+	}
+}
+
+// addTypes records pending in the registry. Caller must hold typesMutex.
+func (s *Store) addTypes(pending map[string]AttributeMetadata) {
+	for attrKey, metadata := range pending {
+		s.attributeTypes[attrKey] = metadata
+	}
+}
+
+// Get retrieves a value from the store without taking any lock
+func (s *Store) Get(key string) map[string]interface{} {
+	return s.get(context.Background(), key)
+}
+
+// get is Get traced as a child of the span in ctx
+func (s *Store) get(ctx context.Context, key string) map[string]interface{} {
+	_, span := s.startSpan(ctx, "get", key)
+	attrs := s.lookup(key)
+	s.ops.get(attrs != nil)
+	if attrs != nil {
+		span.SetAttributes(attribute.String("kv.result", "hit"))
+	} else {
+		span.SetAttributes(attribute.String("kv.result", "miss"))
+	}
+	span.End()
+	return attrs
+}
+
+// lookup is Get without counting the read in Stats, for the store's own
+// reads
+func (s *Store) lookup(key string) map[string]interface{} {
+	if value, exists := s.data.Load(key); exists {
+		e := value.(*entry)
+		if s.eviction == EvictLRU {
+			e.accessed.Store(time.Now().UnixNano())
+		}
+		return maps.Clone(e.attrs)
+	}
+	return nil
+}
+
+// MGet returns the entries at keys, by key, as of one point in time, and
+// the keys that have none, in the order given. It locks the keys' stripes
+// once for them all, so no write lands between two of its reads.
+func (s *Store) MGet(keys []string) (map[string]map[string]interface{}, []string) {
+	unlock := s.rlockKeys(keys)
+	defer unlock()
+
+	entries := make(map[string]map[string]interface{}, len(keys))
+	var missing []string
+	for _, key := range keys {
+		if attrs := s.Get(key); attrs != nil {
+			entries[key] = attrs
+		} else if !slices.Contains(missing, key) {
+			missing = append(missing, key)
+		}
+	}
+	return entries, missing
+}
+
+// GetCtx is Get honoring ctx, returning ctx's error once it is done
+func (s *Store) GetCtx(ctx context.Context, key string) (map[string]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.get(ctx, key), nil
+}
+
+// Delete removes a key-value pair from the store
+func (s *Store) Delete(key string) error {
+	return s.DeleteCtx(context.Background(), key)
+}
+
+// DeleteCtx is Delete honoring ctx, as PutCtx is Put
+func (s *Store) DeleteCtx(ctx context.Context, key string) (err error) {
+	s.ops.deletes.Add(1)
+	_, span := s.startSpan(ctx, "delete", key)
+	defer func() { endSpan(span, err) }()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	stripe := s.stripeFor(key)
+	stripe.Lock()
+	defer stripe.Unlock()
+	lockWaited(span, start)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := s.Writable(); err != nil {
+		return err
+	}
+	if _, exists := s.data.Load(key); !exists {
+		return nil
+	}
+	return s.commit([]logOp{{Op: "del", Key: key, actor: actorOf(ctx)}}, s.defaultDurability())
+}
+
+// Search finds all keys that have the given attribute key-value pair
+func (s *Store) Search(attrKey, attrValue string) []string {
+	results, _ := s.SearchCtx(context.Background(), attrKey, attrValue)
+	return results
+}
+
+// SearchValue is Search for a value typed by the caller, such as a string
+// that put would read as a float
+func (s *Store) SearchValue(attrKey string, value interface{}) []string {
+	results, _, _ := s.SearchValueCount(context.Background(), attrKey, value)
+	return results
+}
+
+// ctxCheckInterval is how many entries a scan visits between checks of its
+// context
+const ctxCheckInterval = 1024
+
+// SearchCtx is Search honoring ctx. The scan stops promptly once ctx is done,
+// returning ctx's error and no results.
+func (s *Store) SearchCtx(ctx context.Context, attrKey, attrValue string) ([]string, error) {
+	_, expectedValue, _ := DetermineType(attrValue)
+	results, _, err := s.SearchValueCount(ctx, attrKey, expectedValue)
+	return results, err
+}
+
+// SearchValueCount is SearchCtx for a typed value, also returning how many
+// entries the scan visited
+func (s *Store) SearchValueCount(ctx context.Context, attrKey string, expectedValue interface{}) (results []string, visited int, err error) {
+	s.ops.searches.Add(1)
+	ctx, span := s.startSpan(ctx, "search", "")
+	defer func() {
+		span.SetAttributes(attribute.String("kv.attribute", attrKey), attribute.Int("kv.scanned", visited), attribute.Int("kv.results", len(results)))
+		endSpan(span, err)
+	}()
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	start := time.Now()
+	s.rlockAll()
+	defer s.runlockAll()
+	lockWaited(span, start)
+
+	var ctxErr error
+	s.data.Range(func(k, v interface{}) bool {
+		visited++
+		if visited%ctxCheckInterval == 0 {
+			if ctxErr = ctx.Err(); ctxErr != nil {
+				return false
+			}
+		}
+
+		if attrEquals(v.(*entry).attrs, attrKey, expectedValue) {
+			results = append(results, k.(string))
+		}
+		return true
+	})
+	if ctxErr != nil {
+		return nil, visited, ctxErr
+	}
+
+	sort.Strings(results)
+	return results, visited, nil
+}
+
+// attrEquals reports whether attributes holds attrKey with a value equal to
+// expected, a value parsed with DetermineType or a string
+func attrEquals(attributes map[string]interface{}, attrKey string, expected interface{}) bool {
+	value, exists := attributes[attrKey]
+	return exists && fmt.Sprintf("%v", value) == fmt.Sprintf("%v", expected)
+}
+
+// Keys returns all keys in the store
+func (s *Store) Keys() []string {
+	s.rlockAll()
+	defer s.runlockAll()
+
+	keys := make([]string, 0)
+	s.data.Range(func(k, _ interface{}) bool {
+		keys = append(keys, k.(string))
+		return true
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// ForEach calls fn with each entry in the store, in no particular order,
+// until fn returns false. The entries are those of one point in time:
+// writes wait until ForEach returns, so fn must not write to the store,
+// nor call the methods that scan it, such as Keys and Search. attrs is a
+// copy fn may keep or modify.
+func (s *Store) ForEach(fn func(key string, attrs map[string]interface{}) bool) {
+	s.rlockAll()
+	defer s.runlockAll()
+
+	s.data.Range(func(k, v interface{}) bool {
+		return fn(k.(string), maps.Clone(v.(*entry).attrs))
+	})
+}
+
+// Clone returns an independent in-memory copy of the store: its entries,
+// with their versions, and attribute types, as of one point in time, with
+// the store's lock stripes, memory limit and conflict resolver. Writes to
+// either don't show in the other. The copy has no log, replication,
+// triggers, hooks or subscribers.
+func (s *Store) Clone() *Store {
+	s.rlockAll()
+	st := s.captureState()
+	s.runlockAll()
+
+	s.logMutex.Lock()
+	resolver := s.resolver
+	s.logMutex.Unlock()
+
+	c := NewStore(WithLockStripes(len(s.stripes)), WithMaxMemory(s.maxMemory, s.eviction), WithConflictResolver(resolver))
+	c.quota.quotas = s.quotaMap()
+	c.attributeTypes = st.types
+	for key, e := range st.entries {
+		// An entry's attributes are never modified once published, so the
+		// copy can share them
+		next := &entry{attrs: e.attrs, version: e.version, created: e.created, updated: e.updated}
+		c.data.Store(key, next)
+		c.account(key, nil, next)
+		c.counts.add(next, 1)
+	}
+	return c
+}
+
+// KeysMatching returns the keys in the store matching pattern, a path.Match
+// pattern, in sorted order
+func (s *Store) KeysMatching(pattern string) ([]string, error) {
+	keys, _, err := s.KeysMatchingCount(pattern)
+	return keys, err
+}
+
+// KeysMatchingCount is KeysMatching, also returning how many keys it visited
+func (s *Store) KeysMatchingCount(pattern string) ([]string, int, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, 0, fmt.Errorf("bad key pattern %q: %w", pattern, err)
+	}
+	all := s.Keys()
+	keys := make([]string, 0)
+	for _, key := range all {
+		if ok, _ := path.Match(pattern, key); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, len(all), nil
+}
+
+// AttributeNames returns the names of all attributes with a registered type
+func (s *Store) AttributeNames() []string {
+	s.typesMutex.Lock()
+	defer s.typesMutex.Unlock()
+
+	names := make([]string, 0, len(s.attributeTypes))
+	for attrKey := range s.attributeTypes {
+		names = append(names, attrKey)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FormatValue formats an attribute value as the CLI prints it: a float with
+// one decimal place if it is whole and two otherwise
+func FormatValue(value interface{}) string {
+	if floatVal, ok := value.(float64); ok {
+		// For float values, check if they're whole numbers
+		if floatVal == float64(int(floatVal)) {
+			return fmt.Sprintf("%.1f", floatVal) // Always show one decimal place
+		}
+		return fmt.Sprintf("%.2f", floatVal) // Show two decimal places
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// FormatAttributes lists attrs as "name: value" pairs sorted by name
+func FormatAttributes(attrs map[string]interface{}) string {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var output []string
+	for _, k := range keys {
+		output = append(output, fmt.Sprintf("%s: %v", k, FormatValue(attrs[k])))
+	}
+	return strings.Join(output, ", ")
+}
+
+// AttributePairs groups alternating attribute names and values into pairs
+func AttributePairs(fields []string) [][]string {
+	var attributes [][]string
+	for i := 0; i+1 < len(fields); i += 2 {
+		attributes = append(attributes, []string{fields[i], fields[i+1]})
+	}
+	return attributes
+}
@@ -0,0 +1,154 @@
+package store
+
+import (
+	"errors"
+	"maps"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+const benchKeys = 1024
+
+// rwmutexStore is the read path Store used before Get moved to a sync.Map:
+// one RWMutex over a plain map, read-locked by every Get. Its Get copies the
+// entry out as Store.Get does, leaving the lock, and the counting and
+// tracing Get has taken on since, as the difference between them.
+type rwmutexStore struct {
+	mu   sync.RWMutex
+	data map[string]map[string]interface{}
+}
+
+func (s *rwmutexStore) Get(key string) map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.data[key])
+}
+
+func benchKey(i int) string { return "key" + strconv.Itoa(i%benchKeys) }
+
+// BenchmarkGet compares Get with the RWMutex path it replaced, over every
+// goroutine count -cpu sets, e.g. go test -bench Get -cpu 1,8,64
+func BenchmarkGet(b *testing.B) {
+	b.Run("syncmap", func(b *testing.B) {
+		s := NewStore()
+		for i := 0; i < benchKeys; i++ {
+			if err := s.Put(benchKey(i), [][]string{{"n", strconv.Itoa(i)}}); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				if s.Get(benchKey(i)) == nil {
+					b.Fatal("missing key")
+				}
+			}
+		})
+	})
+
+	b.Run("rwmutex", func(b *testing.B) {
+		s := &rwmutexStore{data: make(map[string]map[string]interface{}, benchKeys)}
+		for i := 0; i < benchKeys; i++ {
+			s.data[benchKey(i)] = map[string]interface{}{"n": float64(i)}
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				if s.Get(benchKey(i)) == nil {
+					b.Fatal("missing key")
+				}
+			}
+		})
+	})
+}
+
+// TestRejectedWriteRegistersNoTypes rejects a write that brings a new
+// attribute type after its values passed, and checks the type is left for
+// the next write to set
+func TestRejectedWriteRegistersNoTypes(t *testing.T) {
+	for name, tc := range map[string]struct {
+		opts []Option
+		ns   string // namespace of the keys written
+		want error
+	}{
+		"hook":   {},
+		"memory": {opts: []Option{WithMaxMemory(1, NoEviction)}, want: ErrOutOfMemory},
+		"quota":  {opts: []Option{WithNamespaceQuota("tenant", NamespaceQuota{MaxKeys: 1})}, ns: "tenant/", want: ErrQuotaExceeded},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := NewStore(tc.opts...)
+			rejected := errors.New("rejected")
+			if tc.want == nil {
+				tc.want = rejected
+				s.RegisterHook(BeforePut, func(key string, _ map[string]interface{}) error {
+					if key == "bad" {
+						return rejected
+					}
+					return nil
+				})
+			} else if err := s.Put(tc.ns+"filler", [][]string{{"note", "x"}}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.Put(tc.ns+"bad", [][]string{{"age", "30"}}); !errors.Is(err, tc.want) {
+				t.Fatalf("put bad = %v, want %v", err, tc.want)
+			}
+			if err := s.Delete(tc.ns + "filler"); err != nil && !errors.Is(err, ErrKeyNotFound) {
+				t.Fatal(err)
+			}
+			if err := s.Put(tc.ns+"good", [][]string{{"age", "thirty"}}); err != nil {
+				t.Fatalf("put after the rejected write: %v", err)
+			}
+			if got := s.Get(tc.ns + "good")["age"]; got != "thirty" {
+				t.Errorf("age = %v, want thirty", got)
+			}
+		})
+	}
+}
+
+// TestTxnGet queues gets between a transaction's writes and checks each one
+// reads the key as the writes before it leave it
+func TestTxnGet(t *testing.T) {
+	s := NewStore()
+	if err := s.Put("k", [][]string{{"n", "1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	txn := s.Watch()
+	txn.Get("k")
+	txn.Put("k", [][]string{{"n", "2"}})
+	txn.Get("k")
+	txn.Delete("k")
+	txn.Get("k")
+	txn.Get("missing")
+	if got := txn.Queued(); got != 6 {
+		t.Errorf("queued = %d, want 6", got)
+	}
+	if err := txn.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]interface{}{{"n": 1.0}, {"n": 2.0}, nil, nil}
+	if got := txn.Reads(); !reflect.DeepEqual(got, want) {
+		t.Errorf("reads = %v, want %v", got, want)
+	}
+	if s.Get("k") != nil {
+		t.Error("k survived the transaction's delete")
+	}
+
+	// An aborted transaction reads nothing
+	txn = s.Watch("k")
+	txn.Get("k")
+	if err := s.Put("k", [][]string{{"n", "3"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Exec(); !errors.Is(err, ErrTxnAborted) {
+		t.Fatalf("exec = %v, want %v", err, ErrTxnAborted)
+	}
+	if got := txn.Reads(); len(got) != 0 {
+		t.Errorf("reads of an aborted transaction = %v", got)
+	}
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+)
+
+// PutStruct and GetStruct write and read an entry as a struct, one
+// attribute per exported field. A field's attribute is named by its kv
+// tag, as in `kv:"first_name"`, or else by the field's name, and a field
+// tagged `kv:"-"` is left out. String fields are string attributes, bool
+// fields bool attributes, and fields of the integer and float kinds float
+// attributes; a struct with exported fields of other types is an error.
+
+// structFieldCache holds the fields of the struct types seen so far
+var structFieldCache sync.Map // reflect.Type -> []structField
+
+// structField is an exported field of a struct and the attribute it is
+type structField struct {
+	index int
+	name  string
+	typ   AttributeType
+}
+
+// PutStruct writes struct v, or the struct v points to, as the entry at
+// key, replacing the whole entry: a field with its zero value is written
+// as that value
+func (s *Store) PutStruct(key string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return invalid("PutStruct of nil")
+	}
+	fields, err := structFields(rv.Type())
+	if err != nil {
+		return err
+	}
+	return s.PutValues(key, structAttributes(rv, fields))
+}
+
+// GetStruct sets the fields of the struct v points to from the entry at
+// key, reporting whether there is one. Fields whose attribute the entry
+// lacks are left as they are and attributes that are no field are
+// ignored; an attribute of another type than its field's is a
+// *TypeMismatchError.
+func (s *Store) GetStruct(key string, v interface{}) (bool, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return false, invalid("GetStruct needs a non-nil pointer to a struct, not %T", v)
+	}
+	fields, err := structFields(rv.Elem().Type())
+	if err != nil {
+		return false, err
+	}
+	attrs := s.Get(key)
+	if attrs == nil {
+		return false, nil
+	}
+	return true, setStructFields(rv.Elem(), fields, key, attrs)
+}
+
+// structFields returns the attributes of the exported fields of struct
+// type typ, worked out once per type
+func structFields(typ reflect.Type) ([]structField, error) {
+	if cached, ok := structFieldCache.Load(typ); ok {
+		return cached.([]structField), nil
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil, invalid("%s is not a struct", typ)
+	}
+	var fields []structField
+	names := make(map[string]string)
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		name := f.Tag.Get("kv")
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if other, dup := names[name]; dup {
+			return nil, invalid("fields %s and %s of %s are both attribute %s", other, f.Name, typ, name)
+		}
+		names[name] = f.Name
+		attrType, ok := kindType(f.Type.Kind())
+		if !ok {
+			return nil, invalid("field %s of %s has unsupported type %s", f.Name, typ, f.Type)
+		}
+		fields = append(fields, structField{index: i, name: name, typ: attrType})
+	}
+	structFieldCache.Store(typ, fields)
+	return fields, nil
+}
+
+// kindType returns the attribute type of fields of kind k
+func kindType(k reflect.Kind) (AttributeType, bool) {
+	switch k {
+	case reflect.String:
+		return StringType, true
+	case reflect.Bool:
+		return BoolType, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return FloatType, true
+	}
+	return 0, false
+}
+
+// structAttributes returns the attributes of struct v's fields
+func structAttributes(v reflect.Value, fields []structField) map[string]interface{} {
+	attrs := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		field := v.Field(f.index)
+		switch {
+		case field.CanInt():
+			attrs[f.name] = float64(field.Int())
+		case field.CanUint():
+			attrs[f.name] = float64(field.Uint())
+		case field.CanFloat():
+			attrs[f.name] = field.Float()
+		case field.Kind() == reflect.Bool:
+			attrs[f.name] = field.Bool()
+		default:
+			attrs[f.name] = field.String()
+		}
+	}
+	return attrs
+}
+
+// setStructFields sets the fields of struct v from attrs, the entry at key
+func setStructFields(v reflect.Value, fields []structField, key string, attrs map[string]interface{}) error {
+	for _, f := range fields {
+		value, ok := attrs[f.name]
+		if !ok {
+			continue
+		}
+		if got, _ := valueType(value); got != f.typ {
+			return &TypeMismatchError{Attribute: f.name, Expected: got, Got: f.typ}
+		}
+		field := v.Field(f.index)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value.(string))
+		case reflect.Bool:
+			field.SetBool(value.(bool))
+		case reflect.Float32, reflect.Float64:
+			field.SetFloat(value.(float64))
+		default:
+			n := value.(float64)
+			if n != math.Trunc(n) {
+				return fmt.Errorf("%s of %s is %v, not a whole number", f.name, key, n)
+			}
+			if field.CanInt() && n >= math.MinInt64 && n < math.MaxInt64 && !field.OverflowInt(int64(n)) {
+				field.SetInt(int64(n))
+			} else if field.CanUint() && n >= 0 && n < math.MaxUint64 && !field.OverflowUint(uint64(n)) {
+				field.SetUint(uint64(n))
+			} else {
+				return fmt.Errorf("%s of %s is %v, out of range for %s", f.name, key, n, field.Type())
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"sync"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// its channel is closed
+const subscriberBuffer = 256
+
+// ChangeEvent describes a change to a subscribed key
+type ChangeEvent struct {
+	Key     string
+	Op      string                 // "put" or "delete"
+	Old     map[string]interface{} // before the change, nil if the key didn't exist
+	New     map[string]interface{} // after the change, nil for a delete
+	Version uint64                 // after the change, 0 for a delete
+}
+
+// subscriber is one channel handed out by a Subscribe method
+type subscriber struct {
+	ch  chan ChangeEvent
+	key string // for Subscribe; empty for a filtered subscription
+
+	// wants reports whether a change from attributes prev to next, either of
+	// which is nil if the key is absent, is sent to a filtered subscription
+	wants func(key string, prev, next map[string]interface{}) bool
+}
+
+// subscriptions holds the channels subscribed to changes
+type subscriptions struct {
+	mu       sync.Mutex
+	byKey    map[string]map[*subscriber]struct{}
+	filtered map[*subscriber]struct{}
+	byChan   map[<-chan ChangeEvent]*subscriber
+}
+
+// Subscribe returns a channel that receives an event for every change to
+// key, from this store's own writes as well as those replicated to it, in
+// the order they are applied. Each event has its own copies of the maps. A
+// subscriber that falls more than subscriberBuffer events behind has its
+// channel closed, after which it should read the key and subscribe again.
+func (s *Store) Subscribe(key string) <-chan ChangeEvent {
+	return s.subscribe(&subscriber{key: key})
+}
+
+// SubscribePattern is Subscribe for every key matching pattern, in
+// path.Match syntax, so "user:*" covers every key starting with "user:"
+func (s *Store) SubscribePattern(pattern string) (<-chan ChangeEvent, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("bad key pattern %q: %w", pattern, err)
+	}
+	return s.subscribe(&subscriber{wants: func(key string, _, _ map[string]interface{}) bool {
+		ok, _ := path.Match(pattern, key)
+		return ok
+	}}), nil
+}
+
+// SubscribeWhere is Subscribe for every key whose attribute attrKey equals
+// attrValue, compared as Search does, before or after the change. A watcher
+// thus also sees the change that makes a key stop matching.
+func (s *Store) SubscribeWhere(attrKey, attrValue string) <-chan ChangeEvent {
+	_, expected, _ := DetermineType(attrValue)
+	return s.subscribe(&subscriber{wants: func(_ string, prev, next map[string]interface{}) bool {
+		return attrEquals(prev, attrKey, expected) || attrEquals(next, attrKey, expected)
+	}})
+}
+
+func (s *Store) subscribe(sub *subscriber) <-chan ChangeEvent {
+	s.subs.mu.Lock()
+	defer s.subs.mu.Unlock()
+
+	if s.subs.byChan == nil {
+		s.subs.byKey = make(map[string]map[*subscriber]struct{})
+		s.subs.filtered = make(map[*subscriber]struct{})
+		s.subs.byChan = make(map[<-chan ChangeEvent]*subscriber)
+	}
+	sub.ch = make(chan ChangeEvent, subscriberBuffer)
+	if sub.wants != nil {
+		s.subs.filtered[sub] = struct{}{}
+	} else {
+		if s.subs.byKey[sub.key] == nil {
+			s.subs.byKey[sub.key] = make(map[*subscriber]struct{})
+		}
+		s.subs.byKey[sub.key][sub] = struct{}{}
+	}
+	s.subs.byChan[sub.ch] = sub
+	return sub.ch
+}
+
+// Unsubscribe stops the events sent to ch by one of the Subscribe methods
+// and closes ch
+func (s *Store) Unsubscribe(ch <-chan ChangeEvent) {
+	s.subs.mu.Lock()
+	defer s.subs.mu.Unlock()
+
+	if sub, exists := s.subs.byChan[ch]; exists {
+		s.dropSubscriberLocked(sub)
+	}
+}
+
+// dropSubscriberLocked removes and closes sub. Caller must hold subs.mu.
+func (s *Store) dropSubscriberLocked(sub *subscriber) {
+	if sub.wants != nil {
+		delete(s.subs.filtered, sub)
+	} else {
+		delete(s.subs.byKey[sub.key], sub)
+		if len(s.subs.byKey[sub.key]) == 0 {
+			delete(s.subs.byKey, sub.key)
+		}
+	}
+	delete(s.subs.byChan, sub.ch)
+	close(sub.ch)
+}
+
+// notify sends the subscribers of key the change from entry prev to entry
+// next, either of which is nil if the key is absent. Caller must hold key's
+// stripe.
+func (s *Store) notify(key string, prev, next interface{}) {
+	s.subs.mu.Lock()
+	defer s.subs.mu.Unlock()
+
+	if len(s.subs.byChan) == 0 || (prev == nil && next == nil) {
+		return
+	}
+	event := ChangeEvent{Key: key, Op: "delete"}
+	if prev != nil {
+		event.Old = prev.(*entry).attrs
+	}
+	if next != nil {
+		e := next.(*entry)
+		event.Op, event.New, event.Version = "put", e.attrs, e.version
+	}
+	for sub := range s.subs.byKey[key] {
+		s.sendLocked(sub, event)
+	}
+	for sub := range s.subs.filtered {
+		if sub.wants(key, event.Old, event.New) {
+			s.sendLocked(sub, event)
+		}
+	}
+}
+
+// sendLocked hands event to sub, dropping sub if it is too far behind to
+// take it. Caller must hold subs.mu.
+func (s *Store) sendLocked(sub *subscriber, event ChangeEvent) {
+	event.Old, event.New = maps.Clone(event.Old), maps.Clone(event.New)
+	select {
+	case sub.ch <- event:
+	default:
+		s.dropSubscriberLocked(sub)
+	}
+}
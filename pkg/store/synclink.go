@@ -0,0 +1,279 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A sync link copies writes from one store to another, independent store,
+// for example in another datacenter. It connects to the source's
+// replication listener like a follower, but the target stays writable and
+// applies the source's writes, optionally only those to keys matching a set
+// of patterns, as ordinary writes with its own sequence numbers. The link
+// remembers how far into the source's log it has got, in an offset file if
+// it has one, so after a restart it resumes there; a few writes may be
+// applied twice.
+
+// SyncLink replicates writes from a source store into a target store
+type SyncLink struct {
+	target     *Store
+	addr       string
+	patterns   []string
+	offsetPath string
+
+	mu        sync.Mutex
+	conn      net.Conn
+	offset    uint64 // last source sequence number applied
+	connected bool
+	skipped   uint64 // writes the target rejected
+	lastErr   error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartSync links the store to the source whose replication listener is at
+// addr, copying writes to keys matching any of patterns, or to every key if
+// there are none. Patterns use path.Match syntax, so "user:*" matches every
+// key starting with "user:". With an offsetPath the link saves its position
+// there and resumes from it; otherwise it starts from the source's first
+// write, which needs the source to have a write log.
+func (s *Store) StartSync(addr string, patterns []string, offsetPath string) (*SyncLink, error) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad key pattern %q: %w", pattern, err)
+		}
+	}
+	l := &SyncLink{
+		target:     s,
+		addr:       addr,
+		patterns:   patterns,
+		offsetPath: offsetPath,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if offsetPath != "" {
+		data, err := os.ReadFile(offsetPath)
+		switch {
+		case err == nil:
+			if l.offset, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64); err != nil {
+				return nil, fmt.Errorf("%s: %w", offsetPath, err)
+			}
+		case !errors.Is(err, os.ErrNotExist):
+			return nil, err
+		}
+	}
+
+	s.logMutex.Lock()
+	s.links = append(s.links, l)
+	s.logMutex.Unlock()
+
+	go l.run()
+	return l, nil
+}
+
+// Offset returns the last source sequence number the link has applied
+func (l *SyncLink) Offset() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.offset
+}
+
+// Close stops the link and saves its offset
+func (l *SyncLink) Close() error {
+	select {
+	case <-l.stop:
+		return nil
+	default:
+		close(l.stop)
+	}
+	l.mu.Lock()
+	if l.conn != nil {
+		l.conn.Close()
+	}
+	l.mu.Unlock()
+	<-l.done
+	return l.saveOffset()
+}
+
+// info describes the link as a "name:value" line for ReplicationInfo
+func (l *SyncLink) info(i int) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	connected := 0
+	if l.connected {
+		connected = 1
+	}
+	line := fmt.Sprintf("sync%d:source=%s,offset=%d,connected=%d,skipped=%d", i, l.addr, l.offset, connected, l.skipped)
+	if len(l.patterns) > 0 {
+		line += ",keys=" + strings.Join(l.patterns, " ")
+	}
+	return line
+}
+
+func (l *SyncLink) saveOffset() error {
+	if l.offsetPath == "" {
+		return nil
+	}
+	tmpPath := l.offsetPath + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(strconv.FormatUint(l.Offset(), 10)+"\n"), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, l.offsetPath)
+}
+
+func (l *SyncLink) run() {
+	defer close(l.done)
+
+	for {
+		err := l.sync()
+		l.mu.Lock()
+		l.connected = false
+		l.conn = nil
+		if err != nil {
+			l.lastErr = err
+		}
+		l.mu.Unlock()
+		if err != nil {
+			l.target.logger.Warn("sync link broke; reconnecting", "source", l.addr, "err", err)
+		}
+
+		select {
+		case <-l.stop:
+			return
+		case <-time.After(followerRetry):
+		}
+	}
+}
+
+// sync runs one session against the source, returning when it breaks
+func (l *SyncLink) sync() error {
+	conn, err := l.target.dialReplication(l.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	l.mu.Lock()
+	select {
+	case <-l.stop:
+		l.mu.Unlock()
+		return nil
+	default:
+	}
+	l.conn = conn
+	l.mu.Unlock()
+
+	acks := json.NewEncoder(conn)
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	if err := l.target.sendHello(acks, dec, replHello{From: l.Offset()}); err != nil {
+		return err
+	}
+	var reply replReply
+	if err := dec.Decode(&reply); err != nil {
+		return err
+	}
+	if reply.Error != "" {
+		return errors.New(reply.Error)
+	}
+
+	l.mu.Lock()
+	l.connected = true
+	l.lastErr = nil
+	l.mu.Unlock()
+
+	for {
+		var frame replFrame
+		if err := dec.Decode(&frame); err != nil {
+			return err
+		}
+		if frame.Heartbeat {
+			if err := l.saveOffset(); err != nil {
+				return err
+			}
+			if err := acks.Encode(replAck{Seq: l.Offset(), LagMS: -1}); err != nil {
+				return err
+			}
+			continue
+		}
+		if frame.Seq <= l.Offset() {
+			continue
+		}
+
+		err := l.target.applyForeign(setActor(l.filter(frame.Ops), "sync "+l.addr))
+		l.mu.Lock()
+		if err != nil {
+			// One bad write mustn't stall the link; it is counted and
+			// reported instead
+			l.skipped++
+			l.lastErr = fmt.Errorf("source record %d: %w", frame.Seq, err)
+		}
+		l.offset = frame.Seq
+		l.mu.Unlock()
+		if err != nil {
+			l.target.logger.Error("sync link skipped a source record", "source", l.addr, "seq", frame.Seq, "err", err)
+		}
+	}
+}
+
+// filter returns the ops whose keys match the link's patterns
+func (l *SyncLink) filter(ops []logOp) []logOp {
+	if len(l.patterns) == 0 {
+		return ops
+	}
+	kept := ops[:0:0]
+	for _, op := range ops {
+		for _, pattern := range l.patterns {
+			if ok, _ := path.Match(pattern, op.Key); ok {
+				kept = append(kept, op)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// applyForeign applies ops taken from another store's log as one atomic
+// write of this store, validating their values against this store's types.
+// Stamped ops from a multi-master peer that would change nothing are
+// dropped.
+func (s *Store) applyForeign(ops []logOp) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(ops))
+	for _, op := range ops {
+		keys = append(keys, op.Key)
+	}
+	unlock := s.lockKeys(keys)
+	defer unlock()
+
+	if err := s.Writable(); err != nil {
+		return err
+	}
+	kept := s.winningOps(ops)
+	entries := make(map[string]map[string]interface{})
+	for _, op := range kept {
+		if op.Op != "del" {
+			entries[op.Key] = op.Attrs
+		}
+	}
+	held, err := s.checkValues(entries)
+	if err != nil {
+		return err
+	}
+	err = s.commit(kept, s.defaultDurability())
+	s.releaseTypes(held, err)
+	return err
+}
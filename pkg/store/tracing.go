@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// The store traces its operations as OpenTelemetry spans, through the
+// trace.TracerProvider given by WithTracerProvider and nowhere without
+// one. Reads, writes, searches and transactions make a span each, a child
+// of the span in their context, named by the operation and holding its key
+// and, for writes, how long it waited for its keys' locks. In server mode
+// each command makes a server span, the parent of the spans of the store
+// operations it runs. Exporting the spans, over OTLP or otherwise, is left
+// to the provider.
+
+// tracerName is the instrumentation scope of the store's spans
+const tracerName = "github.com/dsapoetra/key-value-go/pkg/store"
+
+// dbSystem names the store in its spans' db.system.name attribute
+const dbSystem = "key-value-go"
+
+// noopTracer is the tracer of a store made without WithTracerProvider
+var noopTracer = noop.NewTracerProvider().Tracer(tracerName)
+
+// startSpan starts the span of the store operation op on key, "" for an
+// operation on no single key
+func (s *Store) startSpan(ctx context.Context, op, key string) (context.Context, trace.Span) {
+	ctx, span := s.tracer.Start(ctx, op, trace.WithSpanKind(trace.SpanKindInternal))
+	if span.IsRecording() {
+		span.SetAttributes(attribute.String("db.system.name", dbSystem), attribute.String("db.operation.name", op))
+		if key != "" {
+			span.SetAttributes(attribute.String("kv.key", key))
+		}
+	}
+	return ctx, span
+}
+
+// endSpan ends span, recording err as the operation's result
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(attribute.String("kv.result", "error"))
+	} else {
+		span.SetAttributes(attribute.String("kv.result", "ok"))
+	}
+	span.End()
+}
+
+// lockWaited records on span how long the operation has waited for its
+// locks, since it started waiting at start
+func lockWaited(span trace.Span, start time.Time) {
+	if span.IsRecording() {
+		span.SetAttributes(attribute.Float64("kv.lock_wait_ms", float64(time.Since(start))/float64(time.Millisecond)))
+	}
+}
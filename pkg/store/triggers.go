@@ -0,0 +1,314 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Triggers are rules run as part of the puts they match, so what they write
+// commits atomically with the put, in the same log record. A trigger can set
+// attributes on the entry being written and copy the entry to another key.
+// It fires on every put to a key matching its pattern or, with a condition,
+// only on puts that change an attribute to a given value. Triggers don't
+// fire on each other's writes, nor on keys moved between cluster nodes.
+// They are held in memory and have to be registered again after a restart.
+
+// triggerNow is the Set value replaced by the time the trigger fires
+const triggerNow = "now"
+
+// triggerLockWait bounds how long a trigger waits for the lock of the key it
+// copies to. The writer already holds other stripes, so waiting forever
+// could deadlock with a writer locking them in the canonical order.
+const triggerLockWait = time.Second
+
+// errTriggerBusy is returned when a trigger can't lock its copy's key
+var errTriggerBusy = errors.New("a trigger's copy target is busy; retry the write")
+
+// Trigger is a rule run as part of every put it matches
+type Trigger struct {
+	Name string `json:"name"`
+	Keys string `json:"keys"` // path.Match pattern of the keys it covers
+
+	// Attr and Value, if set, limit the trigger to puts that change
+	// attribute Attr to Value, compared as Search does
+	Attr  string `json:"attr,omitempty"`
+	Value string `json:"value,omitempty"`
+
+	// Set holds attribute/value pairs written into the entry; the value
+	// "now" is the time the trigger fired, in RFC 3339 format
+	Set [][]string `json:"set,omitempty"`
+
+	// CopyTo, if set, is the key the entry is also written to, with "{key}"
+	// standing for the key that was put
+	CopyTo string `json:"copy_to,omitempty"`
+}
+
+// AddTrigger registers t, replacing any trigger with the same name. Triggers
+// run in the order they were first added.
+func (s *Store) AddTrigger(t Trigger) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	t.Set = append([][]string(nil), t.Set...)
+
+	s.triggerMutex.Lock()
+	defer s.triggerMutex.Unlock()
+
+	triggers := append([]Trigger(nil), s.triggers...)
+	for i := range triggers {
+		if triggers[i].Name == t.Name {
+			triggers[i] = t
+			s.triggers = triggers
+			return nil
+		}
+	}
+	s.triggers = append(triggers, t)
+	return nil
+}
+
+// validate checks that t is a complete trigger
+func (t Trigger) validate() error {
+	switch {
+	case t.Name == "":
+		return invalid("a trigger needs a name")
+	case t.Keys == "":
+		return invalid("a trigger needs a key pattern")
+	case len(t.Set) == 0 && t.CopyTo == "":
+		return invalid("a trigger needs attributes to set or a key to copy to")
+	case (t.Attr == "") != (t.Value == ""):
+		return invalid("a trigger's condition needs both an attribute and a value")
+	}
+	if _, err := path.Match(t.Keys, ""); err != nil {
+		return invalid("bad key pattern %q: %v", t.Keys, err)
+	}
+	for _, pair := range t.Set {
+		if len(pair) != 2 || pair[0] == "" {
+			return invalid("a trigger's attributes must be attribute/value pairs")
+		}
+	}
+	return nil
+}
+
+// RemoveTrigger unregisters the trigger named name, reporting whether there
+// was one
+func (s *Store) RemoveTrigger(name string) bool {
+	s.triggerMutex.Lock()
+	defer s.triggerMutex.Unlock()
+
+	for i := range s.triggers {
+		if s.triggers[i].Name == name {
+			s.triggers = append(s.triggers[:i:i], s.triggers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Triggers returns the registered triggers in the order they run
+func (s *Store) Triggers() []Trigger {
+	s.triggerMutex.RLock()
+	defer s.triggerMutex.RUnlock()
+
+	return append([]Trigger(nil), s.triggers...)
+}
+
+// String describes t in the syntax of the server's "trigger set" command
+func (t Trigger) String() string {
+	parts := []string{t.Name, t.Keys}
+	if t.Attr != "" {
+		parts = append(parts, "when", t.Attr, t.Value)
+	}
+	for _, pair := range t.Set {
+		parts = append(parts, "set", pair[0], pair[1])
+	}
+	if t.CopyTo != "" {
+		parts = append(parts, "copy", t.CopyTo)
+	}
+	return strings.Join(parts, " ")
+}
+
+// ParseTrigger parses a trigger from the words of its String form:
+// name and key pattern, then any of "when <attr> <value>", "set <attr>
+// <value>", repeatable, and "copy <key>"
+func ParseTrigger(words []string) (Trigger, error) {
+	if len(words) < 2 {
+		return Trigger{}, invalid("a trigger needs a name and a key pattern")
+	}
+	t := Trigger{Name: words[0], Keys: words[1]}
+	for rest := words[2:]; len(rest) > 0; {
+		switch {
+		case strings.EqualFold(rest[0], "when") && len(rest) >= 3:
+			t.Attr, t.Value = rest[1], rest[2]
+			rest = rest[3:]
+		case strings.EqualFold(rest[0], "set") && len(rest) >= 3:
+			t.Set = append(t.Set, []string{rest[1], rest[2]})
+			rest = rest[3:]
+		case strings.EqualFold(rest[0], "copy") && len(rest) >= 2:
+			t.CopyTo = rest[1]
+			rest = rest[2:]
+		default:
+			return Trigger{}, invalid("unexpected %q in trigger; want when <attr> <value>, set <attr> <value> or copy <key>", rest[0])
+		}
+	}
+	return t, nil
+}
+
+// LoadTriggers registers the triggers in the file at path, one per line in
+// the syntax of ParseTrigger. Blank lines and lines starting with # are
+// skipped.
+func (s *Store) LoadTriggers(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		words := strings.Fields(line)
+		if len(words) == 0 || strings.HasPrefix(words[0], "#") {
+			continue
+		}
+		t, err := ParseTrigger(words)
+		if err == nil {
+			err = s.AddTrigger(t)
+		}
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+	}
+	return nil
+}
+
+// fires reports whether t runs for a put of attrs to key, which held prev
+func (t Trigger) fires(key string, prev, attrs map[string]interface{}) bool {
+	if ok, _ := path.Match(t.Keys, key); !ok {
+		return false
+	}
+	if t.Attr == "" {
+		return true
+	}
+	_, expected, _ := DetermineType(t.Value)
+	return attrEquals(attrs, t.Attr, expected) && !attrEquals(prev, t.Attr, expected)
+}
+
+// fireTriggers returns ops with the writes of the triggers they fire added,
+// and done, which the caller must call with the outcome of the write to
+// release the locks taken for them and the attribute types their values
+// hold. Caller must hold the stripes of every key in ops.
+func (s *Store) fireTriggers(ops []logOp) ([]logOp, func(error), error) {
+	s.triggerMutex.RLock()
+	triggers := s.triggers
+	s.triggerMutex.RUnlock()
+
+	var extra []*sync.RWMutex
+	heldTypes := make(map[string]AttributeMetadata)
+	done := func(err error) {
+		s.releaseTypes(heldTypes, err)
+		for _, stripe := range extra {
+			stripe.Unlock()
+		}
+	}
+	if len(triggers) == 0 {
+		return ops, done, nil
+	}
+
+	held := make(map[*sync.RWMutex]bool, len(ops))
+	for _, op := range ops {
+		held[s.stripeFor(op.Key)] = true
+	}
+	// Each key's attributes as of the ops so far, nil once deleted
+	state := make(map[string]map[string]interface{})
+	current := func(key string) map[string]interface{} {
+		if attrs, ok := state[key]; ok {
+			return attrs
+		}
+		if v, exists := s.data.Load(key); exists {
+			return v.(*entry).attrs
+		}
+		return nil
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	out := make([]logOp, 0, len(ops))
+	for _, op := range ops {
+		if op.Op == "del" {
+			state[op.Key] = nil
+			out = append(out, op)
+			continue
+		}
+
+		prev := current(op.Key)
+		var set [][]string
+		var copies []string
+		for _, t := range triggers {
+			if !t.fires(op.Key, prev, op.Attrs) {
+				continue
+			}
+			for _, pair := range t.Set {
+				value := pair[1]
+				if value == triggerNow {
+					value = now
+				}
+				set = append(set, []string{pair[0], value})
+			}
+			if t.CopyTo != "" {
+				copies = append(copies, strings.ReplaceAll(t.CopyTo, "{key}", op.Key))
+			}
+		}
+
+		if len(set) > 0 {
+			s.typesMutex.Lock()
+			pending := make(map[string]AttributeMetadata)
+			extraAttrs, err := s.parseAttributes(op.Key, set, pending)
+			if err == nil {
+				// An earlier op's trigger may hold some already
+				maps.DeleteFunc(pending, func(attrKey string, _ AttributeMetadata) bool {
+					_, ok := heldTypes[attrKey]
+					return ok
+				})
+				s.holdTypes(pending)
+			}
+			s.typesMutex.Unlock()
+			if err != nil {
+				err = fmt.Errorf("trigger: %w", err)
+				done(err)
+				return nil, nil, err
+			}
+			maps.Copy(heldTypes, pending)
+			op.Attrs = maps.Clone(op.Attrs)
+			maps.Copy(op.Attrs, extraAttrs)
+		}
+		state[op.Key] = op.Attrs
+		out = append(out, op)
+
+		for _, target := range copies {
+			if stripe := s.stripeFor(target); !held[stripe] {
+				if !tryLockFor(stripe, triggerLockWait) {
+					done(errTriggerBusy)
+					return nil, nil, errTriggerBusy
+				}
+				held[stripe] = true
+				extra = append(extra, stripe)
+			}
+			state[target] = op.Attrs
+			out = append(out, logOp{Op: "put", Key: target, Attrs: op.Attrs})
+		}
+	}
+	return out, done, nil
+}
+
+// tryLockFor write-locks stripe unless it stays locked for longer than wait
+func tryLockFor(stripe *sync.RWMutex, wait time.Duration) bool {
+	deadline := time.Now().Add(wait)
+	for !stripe.TryLock() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestTriggers registers a conditional trigger setting an attribute and one
+// copying the entry, and checks they fire only on puts changing the
+// condition's attribute to its value
+func TestTriggers(t *testing.T) {
+	s := NewStore()
+	for _, line := range []string{
+		"done job:* when status done set completed true",
+		"archive job:* when status done copy archive:{key}",
+	} {
+		tr, err := ParseTrigger(strings.Fields(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tr.String() != line {
+			t.Errorf("String() = %q, want %q", tr.String(), line)
+		}
+		if err := s.AddTrigger(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Put("job:1", [][]string{{"status", "pending"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Get("job:1"); !reflect.DeepEqual(got, map[string]interface{}{"status": "pending"}) || s.Get("archive:job:1") != nil {
+		t.Fatalf("the triggers fired on a pending job: %v, %v", got, s.Keys())
+	}
+	if err := s.Put("job:1", [][]string{{"status", "done"}}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"status": "done", "completed": true}
+	if got := s.Get("job:1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("job:1 = %v, want %v", got, want)
+	}
+	if got := s.Get("archive:job:1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("archive:job:1 = %v, want %v", got, want)
+	}
+
+	// A put that leaves status done doesn't change it, so nothing fires
+	if err := s.Delete("archive:job:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("job:1", [][]string{{"status", "done"}}); err != nil {
+		t.Fatal(err)
+	}
+	if s.Get("archive:job:1") != nil {
+		t.Error("the copy fired again on an unchanged status")
+	}
+
+	if !s.RemoveTrigger("done") || s.RemoveTrigger("done") {
+		t.Error("RemoveTrigger didn't remove done exactly once")
+	}
+	if names := len(s.Triggers()); names != 1 {
+		t.Errorf("%d triggers left, want 1", names)
+	}
+}
+
+// TestTriggersRejected checks incomplete triggers aren't registered, and a
+// trigger whose value breaks an attribute type fails the put that fired it
+func TestTriggersRejected(t *testing.T) {
+	s := NewStore()
+	for _, tr := range []Trigger{
+		{Keys: "*", CopyTo: "x"},
+		{Name: "t", CopyTo: "x"},
+		{Name: "t", Keys: "*"},
+		{Name: "t", Keys: "*", Attr: "status", CopyTo: "x"},
+		{Name: "t", Keys: "[", CopyTo: "x"},
+	} {
+		if err := s.AddTrigger(tr); err == nil {
+			t.Errorf("AddTrigger(%+v) succeeded", tr)
+		}
+	}
+	if _, err := ParseTrigger([]string{"t", "*", "then", "x"}); err == nil {
+		t.Error("ParseTrigger accepted then")
+	}
+
+	if err := s.Put("typed", [][]string{{"n", "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddTrigger(Trigger{Name: "t", Keys: "job:*", Set: [][]string{{"n", "many"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("job:1", [][]string{{"status", "done"}}); err == nil {
+		t.Error("a put whose trigger breaks n's type succeeded")
+	}
+	if s.Get("job:1") != nil {
+		t.Error("the put whose trigger failed was applied")
+	}
+
+	path := filepath.Join(t.TempDir(), "triggers")
+	if err := os.WriteFile(path, []byte("# comment\n\nok job:* copy x\nbad job:*\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.LoadTriggers(path); err == nil || !strings.Contains(err.Error(), ":4:") {
+		t.Errorf("LoadTriggers = %v, want an error on line 4", err)
+	}
+}
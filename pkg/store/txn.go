@@ -0,0 +1,264 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrTxnAborted is returned by Exec when a watched key was modified after it
+// was watched. Nothing queued in the transaction has been applied.
+var ErrTxnAborted = errors.New("transaction aborted: watched key modified")
+
+// ErrTxnDone is returned when a transaction is used after Exec or Discard.
+var ErrTxnDone = errors.New("transaction already finished")
+
+// txnOp is a write or read queued on a transaction until Exec
+type txnOp struct {
+	del        bool
+	get        bool
+	key        string
+	attributes [][]string
+}
+
+// Txn is an optimistic transaction guarded by WATCH, mirroring Redis
+// WATCH/MULTI/EXEC: keys are watched, writes and reads are queued, and Exec
+// applies them atomically only if none of the watched keys changed in the
+// meantime.
+// A Txn is not safe for concurrent use.
+type Txn struct {
+	store   *Store
+	watched []string
+	ops     []txnOp
+	reads   []map[string]interface{} // what the queued gets found, set by Exec
+	dirty   bool                     // guarded by store.watchMutex
+	done    bool
+}
+
+// Watch starts a transaction that aborts at Exec if any of keys is put or
+// deleted by anyone else before then
+func (s *Store) Watch(keys ...string) *Txn {
+	t := &Txn{store: s}
+	t.Watch(keys...)
+	return t
+}
+
+// Watch adds more keys to the transaction's watch set
+func (t *Txn) Watch(keys ...string) error {
+	if t.done {
+		return ErrTxnDone
+	}
+
+	s := t.store
+	s.watchMutex.Lock()
+	defer s.watchMutex.Unlock()
+
+	for _, key := range keys {
+		txns, exists := s.watchers[key]
+		if !exists {
+			txns = make(map[*Txn]struct{})
+			s.watchers[key] = txns
+		}
+		if _, exists := txns[t]; !exists {
+			txns[t] = struct{}{}
+			t.watched = append(t.watched, key)
+		}
+	}
+	return nil
+}
+
+// Put queues a put to be applied by Exec
+func (t *Txn) Put(key string, attributes [][]string) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.ops = append(t.ops, txnOp{key: key, attributes: attributes})
+	return nil
+}
+
+// Delete queues a delete to be applied by Exec
+func (t *Txn) Delete(key string) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.ops = append(t.ops, txnOp{del: true, key: key})
+	return nil
+}
+
+// Get queues a read of key, made by Exec along with the writes: it sees the
+// writes queued before it and none of those after. Reads returns what it
+// found.
+func (t *Txn) Get(key string) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.ops = append(t.ops, txnOp{get: true, key: key})
+	return nil
+}
+
+// Reads returns the attributes each queued Get found, in the order they were
+// queued, nil for a key that didn't exist. It is empty until Exec succeeds.
+func (t *Txn) Reads() []map[string]interface{} {
+	return t.reads
+}
+
+// Queued returns the number of writes and reads waiting for Exec
+func (t *Txn) Queued() int {
+	return len(t.ops)
+}
+
+// Exec applies every queued write atomically, making the queued reads
+// along with them. It returns ErrTxnAborted if a
+// watched key changed since it was watched, or the first validation error;
+// in both cases no write is applied. The transaction is finished either way.
+func (t *Txn) Exec() error {
+	return t.ExecCtx(context.Background())
+}
+
+// ExecCtx is Exec honoring ctx. If ctx is done before the writes are
+// applied, the transaction is discarded and ctx's error is returned.
+func (t *Txn) ExecCtx(ctx context.Context) (err error) {
+	if t.done {
+		return ErrTxnDone
+	}
+	_, span := t.store.startSpan(ctx, "exec", "")
+	span.SetAttributes(attribute.Int("kv.ops", len(t.ops)))
+	defer func() { endSpan(span, err) }()
+	if err := ctx.Err(); err != nil {
+		t.Discard()
+		return err
+	}
+
+	s := t.store
+	keys := append([]string(nil), t.watched...)
+	for _, op := range t.ops {
+		keys = append(keys, op.key)
+	}
+
+	// Holding the stripes of every watched key means no other writer can
+	// touch them between the dirty check and the writes below.
+	start := time.Now()
+	unlock := s.lockKeys(keys)
+	defer unlock()
+	lockWaited(span, start)
+
+	if err := ctx.Err(); err != nil {
+		t.Discard()
+		return err
+	}
+	if err := s.Writable(); err != nil {
+		t.Discard()
+		return err
+	}
+
+	s.watchMutex.Lock()
+	dirty := t.dirty
+	t.unwatchLocked()
+	s.watchMutex.Unlock()
+	t.done = true
+	if dirty {
+		return ErrTxnAborted
+	}
+
+	parsed, held, err := s.parseOps(t.ops)
+	if err != nil {
+		return err
+	}
+
+	// Deletes of keys that don't exist at that point of the transaction are
+	// no-ops and stay out of the log. Gets read the key as the queued writes
+	// before them leave it.
+	values := make(map[string]map[string]interface{})
+	ops := make([]logOp, 0, len(t.ops))
+	var reads []map[string]interface{}
+	for i, op := range t.ops {
+		value, seen := values[op.key]
+		if !seen {
+			value = s.lookup(op.key)
+		}
+		switch {
+		case op.get:
+			s.ops.get(value != nil)
+			reads = append(reads, maps.Clone(value))
+		case op.del:
+			if value != nil {
+				ops = append(ops, logOp{Op: "del", Key: op.key})
+			}
+			value = nil
+		default:
+			ops = append(ops, logOp{Op: "put", Key: op.key, Attrs: parsed[i]})
+			value = parsed[i]
+		}
+		values[op.key] = value
+	}
+	err = s.commit(setActor(ops, actorOf(ctx)), s.defaultDurability())
+	s.releaseTypes(held, err)
+	if err == nil {
+		t.reads = reads
+	}
+	return err
+}
+
+// Discard drops the queued writes and releases all watched keys
+func (t *Txn) Discard() {
+	if t.done {
+		return
+	}
+
+	s := t.store
+	s.watchMutex.Lock()
+	defer s.watchMutex.Unlock()
+
+	t.unwatchLocked()
+	t.done = true
+}
+
+// parseOps validates every queued put as a unit, holding the new attribute
+// types they bring, for the caller to release, only if all of them pass
+func (s *Store) parseOps(ops []txnOp) ([]map[string]interface{}, map[string]AttributeMetadata, error) {
+	s.typesMutex.Lock()
+	defer s.typesMutex.Unlock()
+
+	pending := make(map[string]AttributeMetadata)
+	parsed := make([]map[string]interface{}, len(ops))
+	for i, op := range ops {
+		if op.del || op.get {
+			continue
+		}
+		newData, err := s.parseAttributes(op.key, op.attributes, pending)
+		if err != nil {
+			return nil, nil, err
+		}
+		parsed[i] = newData
+	}
+
+	s.holdTypes(pending)
+	return parsed, pending, nil
+}
+
+// unwatchLocked removes t from the watch registry. Caller must hold
+// watchMutex.
+func (t *Txn) unwatchLocked() {
+	s := t.store
+	for _, key := range t.watched {
+		delete(s.watchers[key], t)
+		if len(s.watchers[key]) == 0 {
+			delete(s.watchers, key)
+		}
+	}
+	t.watched = nil
+}
+
+// touch flags every transaction watching key as dirty. Caller must hold
+// key's stripe.
+func (s *Store) touch(key string) {
+	s.watchMutex.Lock()
+	defer s.watchMutex.Unlock()
+
+	for t := range s.watchers[key] {
+		t.dirty = true
+	}
+}
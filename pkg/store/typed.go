@@ -0,0 +1,56 @@
+package store
+
+import "reflect"
+
+// A TypedStore reads and writes the entries of a store as values of a
+// struct type, mapping fields to attributes as PutStruct does (see
+// structs.go), with the struct checked once when the TypedStore is made.
+
+// TypedStore is a view of a store whose entries are all of struct type T
+type TypedStore[T any] struct {
+	s      KVStore
+	fields []structField
+}
+
+// NewTypedStore returns the TypedStore of s, a Store or a KVStore wrapping
+// one such as a Cache, for T, failing with an error matching ErrValidation
+// if T isn't a struct of supported fields
+func NewTypedStore[T any](s KVStore) (*TypedStore[T], error) {
+	fields, err := structFields(reflect.TypeFor[T]())
+	if err != nil {
+		return nil, err
+	}
+	return &TypedStore[T]{s: s, fields: fields}, nil
+}
+
+// Store returns the store t reads and writes
+func (t *TypedStore[T]) Store() KVStore {
+	return t.s
+}
+
+// Put writes v as the entry at key, replacing the whole entry: a field
+// with its zero value is written as that value
+func (t *TypedStore[T]) Put(key string, v T) error {
+	return t.s.PutValues(key, structAttributes(reflect.ValueOf(v), t.fields))
+}
+
+// Get returns the entry at key as a T, and whether there is one. Fields
+// whose attribute the entry lacks are left zero and attributes that are
+// no field are ignored; an attribute of another type than its field's is
+// a *TypeMismatchError.
+func (t *TypedStore[T]) Get(key string) (T, bool, error) {
+	var v T
+	attrs := t.s.Get(key)
+	if attrs == nil {
+		return v, false, nil
+	}
+	if err := setStructFields(reflect.ValueOf(&v).Elem(), t.fields, key, attrs); err != nil {
+		return v, true, err
+	}
+	return v, true, nil
+}
+
+// Delete removes the entry at key, if any
+func (t *TypedStore[T]) Delete(key string) error {
+	return t.s.Delete(key)
+}
@@ -0,0 +1,189 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// UpdateKeys applies fn to the named keys as one atomic step. It locks every
+// key in the canonical stripe order, so concurrent cross-key updates cannot
+// deadlock whatever order callers list their keys in.
+//
+// fn receives a map holding a copy of each existing entry among keys; absent
+// keys are simply missing from it. fn may modify, add or remove entries for
+// any of the named keys: an entry left in the map is stored, and a key
+// removed from it is deleted. Values must be string, float64 or bool and
+// follow the registered attribute types. If fn returns an error, or its
+// result touches a key that was not named or fails type checking, nothing is
+// changed.
+func (s *Store) UpdateKeys(keys []string, fn func(map[string]map[string]interface{}) error) error {
+	unlock := s.lockKeys(keys)
+	defer unlock()
+
+	if err := s.Writable(); err != nil {
+		return err
+	}
+
+	current := make(map[string]map[string]interface{}, len(keys))
+	named := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		named[key] = true
+		if attrs := s.lookup(key); attrs != nil {
+			copied := make(map[string]interface{}, len(attrs))
+			for k, v := range attrs {
+				copied[k] = v
+			}
+			current[key] = copied
+		}
+	}
+
+	if err := fn(current); err != nil {
+		return err
+	}
+
+	for key := range current {
+		if !named[key] {
+			return fmt.Errorf("key %q was not passed to UpdateKeys", key)
+		}
+	}
+	held, err := s.checkValues(current)
+	if err != nil {
+		return err
+	}
+
+	var ops []logOp
+	for key := range named {
+		attrs, keep := current[key]
+		old := s.lookup(key)
+		switch {
+		case !keep && old != nil:
+			ops = append(ops, logOp{Op: "del", Key: key})
+		case keep && !reflect.DeepEqual(attrs, old):
+			newData := make(map[string]interface{}, len(attrs))
+			for k, v := range attrs {
+				newData[k] = v
+			}
+			ops = append(ops, logOp{Op: "put", Key: key, Attrs: newData})
+		}
+	}
+	err = s.commit(ops, s.defaultDurability())
+	s.releaseTypes(held, err)
+	return err
+}
+
+// DeleteKeys deletes the entries at keys in one atomic write and returns
+// how many of the keys had one
+func (s *Store) DeleteKeys(keys []string) (int, error) {
+	s.ops.deletes.Add(uint64(len(keys)))
+	unlock := s.lockKeys(keys)
+	defer unlock()
+
+	if err := s.Writable(); err != nil {
+		return 0, err
+	}
+	var ops []logOp
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if _, exists := s.data.Load(key); exists && !seen[key] {
+			seen[key] = true
+			ops = append(ops, logOp{Op: "del", Key: key})
+		}
+	}
+	if len(ops) == 0 {
+		return 0, nil
+	}
+	if err := s.commit(ops, s.defaultDurability()); err != nil {
+		return 0, err
+	}
+	return len(ops), nil
+}
+
+// PutValues is Put with already typed values, each a string, float64 or
+// bool, so a string such as "30" stays a string
+func (s *Store) PutValues(key string, attrs map[string]interface{}) (err error) {
+	defer func() { s.ops.put(err) }()
+	stripe := s.stripeFor(key)
+	stripe.Lock()
+	defer stripe.Unlock()
+
+	if err := s.Writable(); err != nil {
+		return err
+	}
+	newData := make(map[string]interface{}, len(attrs))
+	for k, v := range attrs {
+		newData[k] = v
+	}
+	held, err := s.checkValues(map[string]map[string]interface{}{key: newData})
+	if err != nil {
+		return err
+	}
+	err = s.commit([]logOp{{Op: "put", Key: key, Attrs: newData}}, s.defaultDurability())
+	s.releaseTypes(held, err)
+	return err
+}
+
+// valueType reports the AttributeType of an already typed attribute value
+func valueType(value interface{}) (AttributeType, error) {
+	switch value.(type) {
+	case string:
+		return StringType, nil
+	case float64:
+		return FloatType, nil
+	case bool:
+		return BoolType, nil
+	}
+	return 0, invalid("unsupported attribute value %v of type %T", value, value)
+}
+
+// checkValues validates typed entries against the attribute type registry
+// and, only if every value passes, holds the new attribute types they bring
+// for the write of the entries, which must release them with releaseTypes
+func (s *Store) checkValues(entries map[string]map[string]interface{}) (map[string]AttributeMetadata, error) {
+	s.typesMutex.Lock()
+	defer s.typesMutex.Unlock()
+
+	pending, err := s.checkValuesLocked(entries)
+	if err != nil {
+		return nil, err
+	}
+	s.holdTypes(pending)
+	return pending, nil
+}
+
+// CheckValues checks typed attrs against the attribute type registry and
+// the types in pending, as a put of attrs at key would, adding to pending
+// the types the put would register. It registers nothing, so a dry run can
+// check the writes it skips.
+func (s *Store) CheckValues(key string, attrs map[string]interface{}, pending map[string]AttributeMetadata) error {
+	s.typesMutex.Lock()
+	defer s.typesMutex.Unlock()
+
+	for _, attrKey := range sortedAttributeNames(attrs) {
+		t, err := valueType(attrs[attrKey])
+		if err != nil {
+			return err
+		}
+		if err := s.checkType(typeKey(key, attrKey), t, pending); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkValuesLocked validates typed entries, returning the attribute types
+// they would add to the registry. Caller must hold typesMutex.
+func (s *Store) checkValuesLocked(entries map[string]map[string]interface{}) (map[string]AttributeMetadata, error) {
+	pending := make(map[string]AttributeMetadata)
+	for key, attrs := range entries {
+		for attrKey, value := range attrs {
+			t, err := valueType(value)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.checkType(typeKey(key, attrKey), t, pending); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// TestUpdateKeys moves a balance between two keys from goroutines naming
+// them in opposite orders, which must neither deadlock nor lose an update,
+// and deletes and adds entries by their absence from and presence in the
+// map
+func TestUpdateKeys(t *testing.T) {
+	s := NewStore()
+	for _, key := range []string{"a", "b"} {
+		if err := s.Put(key, [][]string{{"balance", "100"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	move := func(from, to string) error {
+		return s.UpdateKeys([]string{from, to}, func(entries map[string]map[string]interface{}) error {
+			entries[from]["balance"] = entries[from]["balance"].(float64) - 1
+			entries[to]["balance"] = entries[to]["balance"].(float64) + 1
+			return nil
+		})
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				from, to := "a", "b"
+				if i%2 == 1 {
+					from, to = to, from
+				}
+				if err := move(from, to); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if a, b := s.Get("a")["balance"], s.Get("b")["balance"]; a != 100.0 || b != 100.0 {
+		t.Errorf("balances = %v, %v, want 100, 100", a, b)
+	}
+
+	err := s.UpdateKeys([]string{"a", "c"}, func(entries map[string]map[string]interface{}) error {
+		delete(entries, "a")
+		entries["c"] = map[string]interface{}{"balance": 5.0}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Get("a") != nil {
+		t.Error("a survived its removal from the map")
+	}
+	if got := s.Get("c"); !reflect.DeepEqual(got, map[string]interface{}{"balance": 5.0}) {
+		t.Errorf("c = %v", got)
+	}
+}
+
+// TestUpdateKeysRejected checks an update that fails, touches a key it
+// didn't name or breaks an attribute type changes nothing
+func TestUpdateKeysRejected(t *testing.T) {
+	s := NewStore()
+	if err := s.Put("a", [][]string{{"balance", "100"}}); err != nil {
+		t.Fatal(err)
+	}
+	errStop := errors.New("stop")
+	for name, fn := range map[string]func(map[string]map[string]interface{}) error{
+		"error": func(entries map[string]map[string]interface{}) error {
+			entries["a"]["balance"] = 0.0
+			return errStop
+		},
+		"unnamed key": func(entries map[string]map[string]interface{}) error {
+			entries["a"]["balance"] = 0.0
+			entries["z"] = map[string]interface{}{"balance": 1.0}
+			return nil
+		},
+		"type": func(entries map[string]map[string]interface{}) error {
+			entries["a"]["balance"] = "none"
+			return nil
+		},
+	} {
+		if err := s.UpdateKeys([]string{"a"}, fn); err == nil {
+			t.Errorf("%s: update succeeded", name)
+		}
+		if got := s.Get("a")["balance"]; got != 100.0 {
+			t.Errorf("%s: balance = %v, want 100", name, got)
+		}
+	}
+	if err := s.UpdateKeys([]string{"a"}, func(map[string]map[string]interface{}) error { return errStop }); !errors.Is(err, errStop) {
+		t.Errorf("err = %v, want fn's error", err)
+	}
+	if s.Get("z") != nil {
+		t.Error("z was written by a rejected update")
+	}
+}
@@ -0,0 +1,552 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Durability selects how far a write must get before the call that made it
+// returns
+type Durability int
+
+const (
+	// MemoryOnly acknowledges a write once it is applied in memory. Its log
+	// record is buffered and reaches the file with a later Logged or
+	// Fsynced write, Sync, or Close.
+	MemoryOnly Durability = iota
+	// Logged acknowledges a write once its record has been handed to the
+	// operating system, so it survives a crash of the process but not of
+	// the machine
+	Logged
+	// Fsynced acknowledges a write once its record is on stable storage
+	Fsynced
+)
+
+// ErrLogFailed is matched by the errors of writes to a store whose write log
+// failed to sync. Once a sync fails, the operating system may have dropped
+// any record flushed before it, and a later sync can succeed regardless, so
+// the log takes no more writes: the store must be reopened, which replays
+// what did reach the file. A record is logged and sent to followers while
+// it syncs, so the Fsynced write whose sync failed, and any others syncing
+// then, return the error without being applied, but may be found in the log
+// and on followers.
+var ErrLogFailed = errors.New("IOERR the write log failed to sync; reopen the store")
+
+// String returns the durability's name, as ParseDurability accepts it:
+// "memory", "logged" or "fsync"
+func (d Durability) String() string {
+	switch d {
+	case MemoryOnly:
+		return "memory"
+	case Logged:
+		return "logged"
+	case Fsynced:
+		return "fsync"
+	}
+	return fmt.Sprintf("Durability(%d)", int(d))
+}
+
+// ParseDurability parses the names accepted by the -durability flag
+func ParseDurability(name string) (Durability, error) {
+	switch name {
+	case "memory":
+		return MemoryOnly, nil
+	case "logged":
+		return Logged, nil
+	case "fsync":
+		return Fsynced, nil
+	}
+	return 0, fmt.Errorf("unknown durability %q (want memory, logged or fsync)", name)
+}
+
+// logOp is one mutation inside a log record
+type logOp struct {
+	Op    string                 `json:"op"` // "put", "del", or "patch" in multi-master mode
+	Key   string                 `json:"key"`
+	Attrs map[string]interface{} `json:"attrs,omitempty"`
+
+	// Version, if set, is the entry's version after the put; otherwise the
+	// put increments it. Only snapshot records set it.
+	Version uint64 `json:"version,omitempty"`
+
+	// Stamp orders the op against other nodes' writes in multi-master
+	// mode; stamped ops are merged rather than applied as they are
+	Stamp *hlcStamp `json:"stamp,omitempty"`
+
+	// Time is when the op was committed, in Unix nanoseconds, so replaying
+	// or replicating it dates the entry as the write did. Created, if set,
+	// is when the entry was first put; only snapshot records set it.
+	Time    int64 `json:"time,omitempty"`
+	Created int64 `json:"created,omitempty"`
+
+	// actor is who made the op, for the audit log; it isn't logged
+	actor string
+}
+
+// opTime returns the time of op, or now for an op logged without one
+func opTime(op logOp) int64 {
+	if op.Time != 0 {
+		return op.Time
+	}
+	return time.Now().UnixNano()
+}
+
+// stampTime sets the time of the ops that have none to now
+func stampTime(ops []logOp) {
+	now := time.Now().UnixNano()
+	for i := range ops {
+		if ops[i].Time == 0 {
+			ops[i].Time = now
+		}
+	}
+}
+
+// logRecord is one line of the write log. All ops of a record were applied
+// atomically, so a record is replayed entirely or not at all.
+type logRecord struct {
+	Seq uint64  `json:"seq"`
+	Ops []logOp `json:"ops"`
+
+	// Snapshot marks a record holding the store's entire state at Seq in
+	// place of the history before it. It can only be the first record of
+	// a log.
+	Snapshot bool `json:"snapshot,omitempty"`
+}
+
+// writeLog is an append-only file of log records, encoded by its codec
+type writeLog struct {
+	path  string
+	file  *os.File
+	buf   *bufio.Writer
+	codec Codec
+	// empty is set while the file holds nothing, not even the codec header
+	empty bool
+	// failed is set once a sync of the file fails, wrapping ErrLogFailed
+	failed error
+}
+
+// OpenStore creates a store backed by the write log at path, replaying any
+// existing records before returning. Writes made through the store are
+// appended to the log with the store's default durability, Logged unless
+// set by WithDurability or changed with SetDurability. opts configure the
+// store as they do for NewStore. An existing log keeps the codec it was
+// written in; WithCodec picks the codec of a new one.
+func OpenStore(path string, opts ...Option) (*Store, error) {
+	c := newConfig(opts)
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewStore(opts...)
+	codec, offset, err := s.replay(file)
+	if err != nil {
+		file.Close()
+		s.logger.Error("replaying the write log failed", "path", path, "err", err)
+		return nil, fmt.Errorf("replay %s: %w", path, err)
+	}
+	if offset == 0 {
+		codec = c.codec
+	}
+
+	s.log = &writeLog{path: path, file: file, buf: bufio.NewWriter(file), codec: codec, empty: offset == 0}
+	if !c.durabilitySet {
+		s.durability = Logged
+	}
+	s.logger.Info("opened the write log", "path", path, "codec", codec.Name(), "seq", s.seq, "keys", s.Len())
+	return s, nil
+}
+
+// replay applies every complete record in file and leaves the file offset at
+// the end of the last one, which it returns with the codec the file is
+// written in. A torn final record, left by a crash in the middle of an
+// append, is truncated away.
+func (s *Store) replay(file *os.File) (Codec, int64, error) {
+	r := bufio.NewReader(file)
+	codec, n, err := readCodecHeader(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset := int64(n)
+	for {
+		data, n, err := readFrame(r, codec)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			if codec != JSONCodec || len(bytes.TrimSpace(data)) > 0 {
+				if err := file.Truncate(offset); err != nil {
+					return nil, 0, err
+				}
+				s.logger.Warn("truncated a torn record at the end of the write log", "path", file.Name(), "offset", offset)
+			}
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var rec logRecord
+		if err := codec.Decode(data, &rec); err != nil {
+			return nil, 0, fmt.Errorf("record at offset %d: %w", offset, err)
+		}
+		if err := s.replayRecord(rec); err != nil {
+			return nil, 0, fmt.Errorf("record %d: %w", rec.Seq, err)
+		}
+		offset += int64(n)
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	return codec, offset, nil
+}
+
+// replayRecord applies a record that was already validated when it was
+// written, re-registering the attribute types it implies
+func (s *Store) replayRecord(rec logRecord) error {
+	entries := make(map[string]map[string]interface{})
+	for _, op := range rec.Ops {
+		if op.Op != "del" {
+			entries[op.Key] = op.Attrs
+		}
+	}
+	held, err := s.checkValues(entries)
+	if err != nil {
+		return err
+	}
+
+	s.applyOps(rec.Ops)
+	s.releaseTypes(held, nil)
+	s.seq, s.visible = rec.Seq, rec.Seq
+	return nil
+}
+
+// SetDurability changes the durability used by writes that don't specify one
+func (s *Store) SetDurability(d Durability) {
+	s.logMutex.Lock()
+	defer s.logMutex.Unlock()
+
+	s.durability = d
+}
+
+// defaultDurability returns the durability for writes that don't specify one
+func (s *Store) defaultDurability() Durability {
+	s.logMutex.Lock()
+	defer s.logMutex.Unlock()
+
+	return s.durability
+}
+
+// commit logs ops, with the writes of any triggers they fire, as a single
+// record with durability d and then applies them in memory. Nothing is
+// applied if the record can't be logged. In Raft mode the record is proposed
+// to the cluster instead and applied once committed. Caller must hold the
+// stripes of every key in ops.
+func (s *Store) commit(ops []logOp, d Durability) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	if err := s.checkMemory(ops); err != nil {
+		return err
+	}
+	if err := s.runBeforePut(ops); err != nil {
+		return err
+	}
+	ops, done, err := s.fireTriggers(ops)
+	if err != nil {
+		return err
+	}
+	err = s.commitQuota(ops, d)
+	done(err)
+	return err
+}
+
+// commitCopied is commit for writes that copy entries in rather than make
+// them, imports, bulk loads and changefeed batches, which fire no triggers
+// but are held to the memory limit, the BeforePut hooks and the namespace
+// quotas all the same
+func (s *Store) commitCopied(ops []logOp, d Durability) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	if err := s.checkMemory(ops); err != nil {
+		return err
+	}
+	if err := s.runBeforePut(ops); err != nil {
+		return err
+	}
+	return s.commitQuota(ops, d)
+}
+
+// commitQuota logs and applies ops with commitRecord if they pass the
+// namespace quotas
+func (s *Store) commitQuota(ops []logOp, d Durability) error {
+	release, err := s.checkQuotas(ops)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return s.commitRecord(ops, d)
+}
+
+// commitRecord is commit without triggers
+func (s *Store) commitRecord(ops []logOp, d Durability) error {
+	stampTime(ops)
+	audit, before := s.auditBefore(ops)
+	if s.raftNode != nil {
+		if err := s.raftNode.propose(ops); err != nil {
+			return err
+		}
+		if audit != nil {
+			audit.record(ops, before)
+		}
+		return nil
+	}
+	if s.crdt != nil {
+		s.stampOps(ops)
+	}
+
+	s.logMutex.Lock()
+	log := s.log
+	rec := logRecord{Seq: s.seq + 1, Ops: ops}
+	if err := s.appendLocked(rec, d); err != nil {
+		s.logMutex.Unlock()
+		return err
+	}
+	// The record is only visible to WaitForSeq once applied below, so a
+	// session token never outruns what reads see
+	s.seq = rec.Seq
+	s.publishLocked(rec)
+	s.logMutex.Unlock()
+
+	// The record was flushed under logMutex; syncing outside it lets
+	// concurrent Fsynced writers share the cost instead of queueing.
+	if log != nil && d == Fsynced {
+		if err := s.syncLog(log); err != nil {
+			return err
+		}
+	}
+
+	s.applyOps(ops)
+	s.logMutex.Lock()
+	s.appliedLocked(rec.Seq)
+	s.logMutex.Unlock()
+	if audit != nil {
+		audit.record(ops, before)
+	}
+	s.evict()
+	return nil
+}
+
+// appendLocked writes rec to the log, flushing it to the operating system
+// unless d is MemoryOnly. Caller must hold logMutex.
+func (s *Store) appendLocked(rec logRecord, d Durability) error {
+	if s.log == nil {
+		return nil
+	}
+	if s.log.failed != nil {
+		return s.log.failed
+	}
+
+	if s.log.empty {
+		if err := writeCodecHeader(s.log.buf, s.log.codec); err != nil {
+			return err
+		}
+		s.log.empty = false
+	}
+	frame, err := appendFrame(nil, s.log.codec, rec)
+	if err != nil {
+		return err
+	}
+	if _, err := s.log.buf.Write(frame); err != nil {
+		return err
+	}
+	if d == MemoryOnly {
+		return nil
+	}
+	return s.log.buf.Flush()
+}
+
+// applyOps publishes ops in memory. Caller must hold the stripes of every
+// key in ops.
+func (s *Store) applyOps(ops []logOp) {
+	for _, op := range ops {
+		if op.Stamp != nil {
+			s.mergeOp(op, true)
+			continue
+		}
+		if op.Op == "del" {
+			prev, _ := s.data.LoadAndDelete(op.Key)
+			s.changed(op.Key, prev, nil)
+		} else {
+			e := &entry{attrs: op.Attrs, version: op.Version, created: op.Created, updated: opTime(op)}
+			old, exists := s.data.Load(op.Key)
+			if e.version == 0 {
+				e.version = 1
+				if exists {
+					e.version = old.(*entry).version + 1
+				}
+			}
+			if e.created == 0 {
+				e.created = e.updated
+				if exists {
+					e.created = old.(*entry).created
+				}
+			}
+			prev, _ := s.data.Swap(op.Key, e)
+			s.changed(op.Key, prev, e)
+		}
+		s.touch(op.Key)
+	}
+}
+
+// truncateLogLocked empties the write log, discarding buffered records.
+// Caller must hold logMutex.
+func (s *Store) truncateLogLocked() error {
+	if s.log == nil {
+		return nil
+	}
+	s.log.buf.Reset(s.log.file)
+	if err := s.log.file.Truncate(0); err != nil {
+		return err
+	}
+	s.log.empty = true
+	_, err := s.log.file.Seek(0, io.SeekStart)
+	return err
+}
+
+// logBase returns the sequence number of the snapshot record the log file
+// at path starts with, or 0 if it holds the complete history
+func logBase(path string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	codec, _, err := readCodecHeader(r)
+	if err != nil {
+		return 0, err
+	}
+	data, _, err := readFrame(r, codec)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var rec logRecord
+	if err := codec.Decode(data, &rec); err != nil {
+		return 0, err
+	}
+	if !rec.Snapshot {
+		return 0, nil
+	}
+	return rec.Seq, nil
+}
+
+// flush brings every buffered log record to durability d
+func (s *Store) flush(d Durability) error {
+	if d == MemoryOnly {
+		return nil
+	}
+
+	s.logMutex.Lock()
+	log := s.log
+	var err error
+	if log != nil {
+		err = log.buf.Flush()
+	}
+	s.logMutex.Unlock()
+
+	if err == nil && log != nil && d == Fsynced {
+		err = s.syncLog(log)
+	}
+	return err
+}
+
+// syncLog waits until the records flushed to log are on stable storage. It
+// fails if this or any earlier sync of log failed, failing the log.
+func (s *Store) syncLog(log *writeLog) error {
+	err := log.file.Sync()
+	s.logMutex.Lock()
+	defer s.logMutex.Unlock()
+	return s.checkSyncLocked(log, err)
+}
+
+// checkSyncLocked fails log if err, the outcome of a sync of it, is set,
+// and returns the log's failure. Caller must hold logMutex.
+func (s *Store) checkSyncLocked(log *writeLog, err error) error {
+	if err != nil && log.failed == nil {
+		log.failed = fmt.Errorf("%w: %v", ErrLogFailed, err)
+		s.logger.Error("write log failed to sync; the store takes no more writes until it is reopened", "path", log.path, "err", err)
+	}
+	return log.failed
+}
+
+// Unsaved reports whether the store holds writes a restart would lose: log
+// records still buffered in memory, or for a store with neither a log nor
+// Raft, any write at all
+func (s *Store) Unsaved() bool {
+	s.logMutex.Lock()
+	defer s.logMutex.Unlock()
+
+	if s.log == nil {
+		return s.raftNode == nil && s.seq > 0
+	}
+	return s.log.buf.Buffered() > 0
+}
+
+// Sync flushes buffered log records and waits until they are on stable
+// storage. It is a no-op for a store without a log.
+func (s *Store) Sync() error {
+	s.logMutex.Lock()
+	defer s.logMutex.Unlock()
+
+	if s.log == nil {
+		return nil
+	}
+	if err := s.log.buf.Flush(); err != nil {
+		return err
+	}
+	return s.checkSyncLocked(s.log, s.log.file.Sync())
+}
+
+// Close waits for queued async writes, then syncs and closes the write log.
+// The store must not be written to afterwards.
+func (s *Store) Close() error {
+	s.stopBatcher()
+	err := s.Sync()
+	if audit := s.audit.Load(); audit != nil {
+		if auditErr := audit.Close(); err == nil {
+			err = auditErr
+		}
+	}
+
+	s.logMutex.Lock()
+	defer s.logMutex.Unlock()
+
+	if s.log == nil {
+		return err
+	}
+	if closeErr := s.log.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		s.logger.Error("closing the write log failed", "path", s.log.path, "err", err)
+	} else {
+		s.logger.Info("closed the write log", "path", s.log.path, "seq", s.seq)
+	}
+	s.log = nil
+	return err
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLogSyncFailure fails a sync of the write log and checks the write
+// that needed it isn't applied and the log takes no more writes, then that
+// reopening the store replays what reached the file
+func TestLogSyncFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.log")
+	s, err := OpenStore(path, WithDurability(Fsynced))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("before", [][]string{{"n", "1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Records still reach the file, but syncing a pipe fails
+	file := s.log.file
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	s.log.file = w
+
+	if err := s.Put("failed", [][]string{{"n", "2"}}); !errors.Is(err, ErrLogFailed) {
+		t.Fatalf("put with a failing sync = %v, want ErrLogFailed", err)
+	}
+	if s.Get("failed") != nil {
+		t.Error("the write whose sync failed was applied")
+	}
+	s.log.file = file
+	if err := s.Put("after", [][]string{{"n", "3"}}); !errors.Is(err, ErrLogFailed) {
+		t.Errorf("put after the failure = %v, want ErrLogFailed", err)
+	}
+	if err := s.Sync(); !errors.Is(err, ErrLogFailed) {
+		t.Errorf("sync after the failure = %v, want ErrLogFailed", err)
+	}
+	s.Close()
+
+	reopened, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	for key, want := range map[string]bool{"before": true, "failed": true, "after": false} {
+		if got := reopened.Get(key) != nil; got != want {
+			t.Errorf("%s replayed: %v, want %v", key, got, want)
+		}
+	}
+}
+
+// TestPutWithDurability checks a memory-only write stays in the log's
+// buffer until a logged write flushes it, both in order, and that the
+// durability names parse
+func TestPutWithDurability(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.log")
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	logged := func() string {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if err := s.PutWithDurability("memory", [][]string{{"n", "1"}}, MemoryOnly); err != nil {
+		t.Fatal(err)
+	}
+	if s.Get("memory") == nil {
+		t.Fatal("the memory-only write wasn't applied")
+	}
+	if strings.Contains(logged(), `"memory"`) {
+		t.Error("the memory-only write reached the file before a logged one")
+	}
+	if err := s.PutWithDurability("logged", [][]string{{"n", "2"}}, Logged); err != nil {
+		t.Fatal(err)
+	}
+	data := logged()
+	if i, j := strings.Index(data, `"memory"`), strings.Index(data, `"logged"`); i < 0 || j < i {
+		t.Errorf("log after the logged write:\n%s", data)
+	}
+
+	for _, d := range []Durability{MemoryOnly, Logged, Fsynced} {
+		if parsed, err := ParseDurability(d.String()); err != nil || parsed != d {
+			t.Errorf("ParseDurability(%q) = %v, %v", d.String(), parsed, err)
+		}
+	}
+	if _, err := ParseDurability("disk"); err == nil {
+		t.Error("ParseDurability accepted disk")
+	}
+}
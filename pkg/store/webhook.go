@@ -0,0 +1,241 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A webhook POSTs every change to keys matching its patterns to an HTTP
+// endpoint, one JSON object per change, in the order the changes were
+// committed. It follows the store's change feed from the moment it starts,
+// so a webhook that falls behind catches up from the write log. A delivery
+// that fails with a network error, a 5xx or a 429 is retried with
+// exponential backoff; one the endpoint rejects with any other status, or
+// that keeps failing, is given up on so later changes aren't held back.
+
+// webhookTimeout bounds each delivery attempt
+const webhookTimeout = 10 * time.Second
+
+// webhookAttempts is how many times a change is sent before it is dropped
+const webhookAttempts = 8
+
+// webhookBackoff is the wait before the first retry; it doubles with each
+// later one, up to webhookMaxBackoff
+const (
+	webhookBackoff    = 100 * time.Millisecond
+	webhookMaxBackoff = 30 * time.Second
+)
+
+// changePayload is the JSON body POSTed for one change, also published by
+// connectors
+type changePayload struct {
+	Seq        uint64                 `json:"seq"`
+	Key        string                 `json:"key"`
+	Op         string                 `json:"op"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// Webhook sends a store's changes to an HTTP endpoint
+type Webhook struct {
+	store    *Store
+	url      string
+	patterns []string
+	client   *http.Client
+
+	mu        sync.Mutex
+	offset    uint64 // last sequence number handled
+	delivered uint64
+	dropped   uint64 // changes given up on, including any the feed skipped
+	lastErr   error
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartWebhook POSTs every change to keys matching any of patterns, or to
+// every key if there are none, to url. Patterns use path.Match syntax.
+func (s *Store) StartWebhook(url string, patterns []string) (*Webhook, error) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad key pattern %q: %w", pattern, err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &Webhook{
+		store:    s,
+		url:      url,
+		patterns: patterns,
+		client:   &http.Client{Timeout: webhookTimeout},
+		offset:   s.Seq(),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+
+	s.logMutex.Lock()
+	s.webhooks = append(s.webhooks, w)
+	s.logMutex.Unlock()
+
+	go w.run(ctx)
+	return w, nil
+}
+
+// Close stops the webhook, abandoning a delivery in progress
+func (w *Webhook) Close() {
+	w.cancel()
+	<-w.done
+}
+
+func (w *Webhook) run(ctx context.Context) {
+	defer close(w.done)
+
+	for {
+		err := w.store.TailChanges(ctx, w.Offset(), func(batch ChangeBatch) error {
+			if err := w.deliver(ctx, batch); err != nil {
+				return err
+			}
+			w.mu.Lock()
+			w.offset = batch.Seq
+			w.mu.Unlock()
+			return nil
+		})
+		if ctx.Err() != nil {
+			return
+		}
+
+		// The feed can't reach back to the changes not yet sent; skip them
+		seq := w.store.Seq()
+		w.mu.Lock()
+		w.lastErr = fmt.Errorf("skipped changes %d to %d: %w", w.offset+1, seq, err)
+		w.store.logger.Error("webhook skipped changes", "url", w.url, "from", w.offset+1, "to", seq, "err", err)
+		w.dropped += seq - w.offset
+		w.offset = seq
+		w.mu.Unlock()
+	}
+}
+
+// deliver POSTs the changes of batch that match the webhook's patterns,
+// returning an error only once ctx is done
+func (w *Webhook) deliver(ctx context.Context, batch ChangeBatch) error {
+	for _, change := range batch.Changes {
+		if !w.matches(change.Key) {
+			continue
+		}
+		body, err := json.Marshal(changePayload{Seq: batch.Seq, Key: change.Key, Op: change.Op, Attributes: change.Attributes})
+		if err != nil {
+			return err
+		}
+
+		err = w.post(ctx, body)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		w.mu.Lock()
+		if err != nil {
+			w.dropped++
+			w.lastErr = fmt.Errorf("gave up on change %d to %q: %w", batch.Seq, change.Key, err)
+			w.store.logger.Error("webhook gave up on a change", "url", w.url, "seq", batch.Seq, "key", change.Key, "err", err)
+		} else {
+			w.delivered++
+		}
+		w.mu.Unlock()
+	}
+	return nil
+}
+
+// errWebhookRejected marks a response that retrying won't change
+var errWebhookRejected = errors.New("rejected")
+
+// post sends body to the endpoint, retrying transient failures
+func (w *Webhook) post(ctx context.Context, body []byte) error {
+	backoff := webhookBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = w.postOnce(ctx, body); err == nil || errors.Is(err, errWebhookRejected) || attempt == webhookAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, webhookMaxBackoff)
+	}
+}
+
+func (w *Webhook) postOnce(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errWebhookRejected, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("endpoint replied %s", resp.Status)
+	}
+	return fmt.Errorf("%w: endpoint replied %s", errWebhookRejected, resp.Status)
+}
+
+// matches reports whether the webhook covers key
+func (w *Webhook) matches(key string) bool {
+	if len(w.patterns) == 0 {
+		return true
+	}
+	for _, pattern := range w.patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Offset returns the sequence number of the last change the webhook handled
+func (w *Webhook) Offset() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.offset
+}
+
+// info describes the webhook as a "name:value" line for WebhookInfo
+func (w *Webhook) info(i int) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	line := fmt.Sprintf("webhook%d:url=%s,offset=%d,delivered=%d,dropped=%d", i, w.url, w.offset, w.delivered, w.dropped)
+	if len(w.patterns) > 0 {
+		line += ",keys=" + strings.Join(w.patterns, " ")
+	}
+	if w.lastErr != nil {
+		line += ",last_error=" + w.lastErr.Error()
+	}
+	return line
+}
+
+// WebhookInfo reports the store's webhooks as "name:value" lines
+func (s *Store) WebhookInfo() []string {
+	s.logMutex.Lock()
+	webhooks := append([]*Webhook(nil), s.webhooks...)
+	s.logMutex.Unlock()
+
+	lines := []string{fmt.Sprintf("webhooks:%d", len(webhooks))}
+	for i, w := range webhooks {
+		lines = append(lines, w.info(i))
+	}
+	return lines
+}
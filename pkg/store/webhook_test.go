@@ -0,0 +1,88 @@
+package store
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestWebhook POSTs the changes to matching keys to an endpoint that fails
+// the first delivery with a 503 and rejects one with a 400, and checks the
+// first is retried, the second dropped and the rest delivered in order
+func TestWebhook(t *testing.T) {
+	var mu sync.Mutex
+	var received []changePayload
+	failed := false
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var change changePayload
+		if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case !failed:
+			failed = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case change.Key == "user:bad":
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			received = append(received, change)
+		}
+	}))
+	defer endpoint.Close()
+
+	s, err := OpenStore(filepath.Join(t.TempDir(), "data.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	w, err := s.StartWebhook(endpoint.URL, []string{"user:*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for _, key := range []string{"user:1", "order:1", "user:bad", "user:2"} {
+		if err := s.Put(key, [][]string{{"n", "1"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Delete("user:1"); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); w.Offset() < s.Seq(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("webhook at %d, store at %d", w.Offset(), s.Seq())
+		}
+	}
+
+	attrs := map[string]interface{}{"n": 1.0}
+	want := []changePayload{
+		{Seq: 1, Key: "user:1", Op: "put", Attributes: attrs},
+		{Seq: 4, Key: "user:2", Op: "put", Attributes: attrs},
+		{Seq: 5, Key: "user:1", Op: "delete"},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("received %+v, want %+v", received, want)
+	}
+	if info := strings.Join(s.WebhookInfo(), "\n"); !strings.Contains(info, "delivered=3,dropped=1") {
+		t.Errorf("info = %q, want 3 delivered and 1 dropped", info)
+	}
+}
+
+// TestStartWebhookRejected checks a bad key pattern is refused
+func TestStartWebhookRejected(t *testing.T) {
+	if w, err := NewStore().StartWebhook("http://127.0.0.1:1", []string{"["}); err == nil {
+		w.Close()
+		t.Error("StartWebhook accepted the pattern [")
+	}
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlImport is the document read by ImportYAML. Values are kept as nodes
+// so each is read by its attribute's type rather than YAML's guess: a
+// hand-written zip: 02134 stays the string "02134" if zip is a string.
+type yamlImport struct {
+	AttributeTypes map[string]string               `yaml:"attribute_types"`
+	Entries        map[string]map[string]yaml.Node `yaml:"entries"`
+}
+
+// ExportYAML writes the store's entries and attribute types to w as a YAML
+// document with the same layout as ExportJSON's, convenient for
+// hand-edited seed data and fixtures
+func (s *Store) ExportYAML(w io.Writer) error {
+	return s.ExportTo(w, "yaml")
+}
+
+// writeYAMLExport writes the entries under keys as ExportYAML's document,
+// one entry at a time
+func writeYAMLExport(w *bufio.Writer, st *storeState, keys []string) error {
+	var buf bytes.Buffer
+	// indented encodes value and writes it indented by one level
+	indented := func(value interface{}) error {
+		buf.Reset()
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(value); err != nil {
+			return err
+		}
+		if err := enc.Close(); err != nil {
+			return err
+		}
+		for _, line := range bytes.SplitAfter(buf.Bytes(), []byte("\n")) {
+			if len(line) > 0 {
+				w.WriteString("  ")
+				w.Write(line)
+			}
+		}
+		return nil
+	}
+
+	types := make(map[string]string, len(st.types))
+	for attrKey, metadata := range st.types {
+		types[attrKey] = metadata.dataType.String()
+	}
+	if len(types) == 0 {
+		w.WriteString("attribute_types: {}\n")
+	} else {
+		w.WriteString("attribute_types:\n")
+		if err := indented(types); err != nil {
+			return err
+		}
+	}
+	if len(keys) == 0 {
+		_, err := w.WriteString("entries: {}\n")
+		return err
+	}
+	_, err := w.WriteString("entries:\n")
+	for _, key := range keys {
+		if err != nil {
+			return err
+		}
+		if err = indented(map[string]map[string]interface{}{key: st.entries[key].attrs}); err != nil {
+			err = fmt.Errorf("entry %q: %w", key, err)
+		}
+	}
+	return err
+}
+
+// ImportYAML is ImportJSON for a document in ExportYAML's format. A value
+// is read as its attribute's type, declared in the document or registered
+// in the store, and otherwise as the type YAML gives it.
+func (s *Store) ImportYAML(r io.Reader) (int, error) {
+	set, err := s.readYAML(r)
+	if err != nil {
+		return 0, err
+	}
+	return s.importAll(set)
+}
+
+// readYAML reads a document in ExportYAML's format
+func (s *Store) readYAML(r io.Reader) (*importSet, error) {
+	var doc yamlImport
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("reading YAML export: %w", err)
+	}
+	types, err := parseDeclaredTypes(doc.AttributeTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]AttributeType, len(types))
+	s.typesMutex.Lock()
+	for attrKey, metadata := range s.attributeTypes {
+		known[attrKey] = metadata.dataType
+	}
+	s.typesMutex.Unlock()
+	for attrKey, t := range types {
+		known[attrKey] = t
+	}
+
+	entries := make(map[string]map[string]interface{}, len(doc.Entries))
+	for key, nodes := range doc.Entries {
+		attrs := make(map[string]interface{}, len(nodes))
+		for attrKey, node := range nodes {
+			if node.Tag == "!!null" {
+				continue
+			}
+			t, typed := known[attrKey]
+			value, err := yamlValue(&node, t, typed)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %q of %q: %w", node.Line, attrKey, key, err)
+			}
+			attrs[attrKey] = value
+		}
+		entries[key] = attrs
+	}
+	return &importSet{types: types, entries: entries}, nil
+}
+
+// yamlValue reads node as an attribute value of type t if typed, or as the
+// type of the YAML scalar otherwise
+func yamlValue(node *yaml.Node, t AttributeType, typed bool) (interface{}, error) {
+	if node.Kind != yaml.ScalarNode {
+		return nil, fmt.Errorf("value must be a string, number or bool")
+	}
+	if !typed {
+		switch node.Tag {
+		case "!!bool":
+			t = BoolType
+		case "!!int", "!!float":
+			t = FloatType
+		default:
+			t = StringType
+		}
+	}
+	switch t {
+	case FloatType:
+		var f float64
+		if err := node.Decode(&f); err != nil {
+			return nil, fmt.Errorf("%q is not a float", node.Value)
+		}
+		return f, nil
+	case BoolType:
+		var b bool
+		if err := node.Decode(&b); err != nil {
+			return nil, fmt.Errorf("%q is not a bool", node.Value)
+		}
+		return b, nil
+	}
+	return node.Value, nil
+}